@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/consul"
+	"github.com/rsvancara/linode-tools/internal/install"
+)
+
+// kube-consul is the same as linode-tools consul, as a binary of its own like the other tools
+func main() {
+
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		if err := install.Run(os.Args[2:], "kube-consul", nil); err != nil {
+			log.Fatal().Err(err).Msg("install failed")
+		}
+		return
+	}
+
+	if err := consul.Run(os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("kube-consul failed")
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/hosts"
+	"github.com/rsvancara/linode-tools/internal/install"
+)
+
+// kube-hosts is kept for existing deployments, it is the same as linode-tools hosts
+func main() {
+
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		if err := install.Run(os.Args[2:], "kube-hosts", nil); err != nil {
+			log.Fatal().Err(err).Msg("install failed")
+		}
+		return
+	}
+
+	if err := hosts.Run(os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("kube-hosts failed")
+	}
+}
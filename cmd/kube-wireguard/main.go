@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/install"
+	"github.com/rsvancara/linode-tools/internal/wireguard"
+)
+
+// kube-wireguard is the same as linode-tools wireguard
+func main() {
+
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		if err := install.Run(os.Args[2:], "kube-wireguard", nil); err != nil {
+			log.Fatal().Err(err).Msg("install failed")
+		}
+		return
+	}
+
+	if err := wireguard.Run(os.Args[1:]); err != nil {
+		log.Fatal().Err(err).Msg("kube-wireguard failed")
+	}
+}
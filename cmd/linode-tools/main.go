@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/agent"
+	"github.com/rsvancara/linode-tools/internal/bird"
+	"github.com/rsvancara/linode-tools/internal/cloudflare"
+	"github.com/rsvancara/linode-tools/internal/consul"
+	"github.com/rsvancara/linode-tools/internal/firewall"
+	"github.com/rsvancara/linode-tools/internal/hosts"
+	"github.com/rsvancara/linode-tools/internal/install"
+	"github.com/rsvancara/linode-tools/internal/keepalived"
+	"github.com/rsvancara/linode-tools/internal/mongo"
+	"github.com/rsvancara/linode-tools/internal/nginx"
+	"github.com/rsvancara/linode-tools/internal/prometheus"
+	"github.com/rsvancara/linode-tools/internal/sshconfig"
+	"github.com/rsvancara/linode-tools/internal/terraform"
+	"github.com/rsvancara/linode-tools/internal/update"
+	"github.com/rsvancara/linode-tools/internal/version"
+	"github.com/rsvancara/linode-tools/internal/wireguard"
+)
+
+type command struct {
+	run         func(args []string) error
+	description string
+}
+
+var commands = map[string]command{
+	"agent":      {agent.Run, "apply the outputs a command run with -push-agents renders and pushes to this host"},
+	"bird":       {bird.Run, "announce anycast prefixes through BIRD while enough nodes are healthy"},
+	"cloudflare": {cloudflare.Run, "maintain the origins of Cloudflare load balancer pools for the nodes"},
+	"consul":     {consul.Run, "register the node ports of the nodes as services in the Consul catalog"},
+	"firewall":   {firewall.Run, "maintain the inbound rules of a Linode Cloud Firewall allowing the nodes"},
+	"hosts":      {hosts.Run, "maintain node entries in a hosts file"},
+	"keepalived": {keepalived.Run, "maintain the real servers of keepalived virtual servers for the nodes"},
+	"mongo":      {mongo.Run, "maintain an iptables chain allowing the nodes to reach MongoDB"},
+	"nginx":      {nginx.Run, "maintain nginx upstreams for the nodes"},
+	"prometheus": {prometheus.Run, "maintain a Prometheus file_sd target list scraping the nodes"},
+	"ssh":        {sshconfig.Run, "maintain ssh config Host aliases and known_hosts entries for the nodes"},
+	"terraform":  {terraform.Run, "answer a terraform external data source query with the nodes and node ports"},
+	"wireguard":  {wireguard.Run, "maintain the wireguard peers of the nodes"},
+}
+
+func usage() {
+
+	name := filepath.Base(os.Args[0])
+
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", name)
+
+	var names []string
+	for k := range commands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", k, commands[k].description)
+	}
+
+	fmt.Fprintf(os.Stderr, "  %-10s %s\n", "install", "install a systemd unit running a command with the given flags")
+	fmt.Fprintf(os.Stderr, "  %-10s %s\n", "update", "replace the binary with a GitHub release and restart its services")
+	fmt.Fprintf(os.Stderr, "  %-10s %s\n", "validate", "check the configuration of nginx or mongo without touching the system")
+	fmt.Fprintf(os.Stderr, "  %-10s %s\n", "version", "print the version and exit")
+
+	fmt.Fprintf(os.Stderr, "\nrun '%s <command> -h' for the flags of a command\n", name)
+}
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	if os.Args[1] == "version" || os.Args[1] == "-version" {
+		fmt.Println(version.Get())
+		return
+	}
+
+	if os.Args[1] == "install" {
+		var names []string
+		for k := range commands {
+			names = append(names, k)
+		}
+
+		if err := install.Run(os.Args[2:], filepath.Base(os.Args[0]), names); err != nil {
+			log.Fatal().Err(err).Msg("install failed")
+		}
+		return
+	}
+
+	if os.Args[1] == "update" {
+		err := update.Run(os.Args[2:], filepath.Base(os.Args[0]))
+		if errors.Is(err, update.ErrAvailable) {
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("update failed")
+		}
+		return
+	}
+
+	if os.Args[1] == "validate" {
+		validators := map[string]func(args []string) error{
+			"mongo": mongo.Validate,
+			"nginx": nginx.Validate,
+		}
+
+		if len(os.Args) < 3 || validators[os.Args[2]] == nil {
+			fmt.Fprintf(os.Stderr, "usage: %s validate mongo|nginx [flags]\n", filepath.Base(os.Args[0]))
+			os.Exit(2)
+		}
+
+		if err := validators[os.Args[2]](os.Args[3:]); err != nil {
+			log.Fatal().Err(err).Msg("validation failed")
+		}
+		return
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "-h" && os.Args[1] != "-help" && os.Args[1] != "help" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		log.Fatal().Err(err).Msgf("%s failed", os.Args[1])
+	}
+}
@@ -0,0 +1,69 @@
+// Package admin runs the optional http server the tools expose their metrics on.
+package admin
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/internal/status"
+)
+
+var mux = http.NewServeMux()
+
+func init() {
+	mux.Handle("/metrics", metrics.Handler())
+	status.Register(mux)
+}
+
+// Handle - Register an additional handler on the admin server
+func Handle(pattern string, handler http.Handler) {
+	mux.Handle(pattern, handler)
+}
+
+// Actions enables the endpoints changing the state of the daemon, such as /reconcile and /pause, set from the
+// -admin-actions flag.  The admin server has no authentication, the control api authenticates its clients.
+var Actions bool
+
+// HandleAction - Register a handler changing the state of the daemon, refused unless Actions is set
+func HandleAction(pattern string, handler http.Handler) {
+	mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !Actions {
+			http.Error(w, pattern+" is disabled, enable it with -admin-actions", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	}))
+}
+
+// EnableDebug - Serve the pprof profiles under /debug/pprof/ and the expvar variables on /debug/vars,
+// to diagnose memory growth and goroutine leaks of a long running daemon
+func EnableDebug() {
+
+	log.Info().Msg("serving pprof and expvar debug endpoints")
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+	mux.Handle("/debug/vars", expvar.Handler())
+}
+
+// Serve - Start the admin server in the background
+func Serve(addr string) {
+
+	log.Info().Msgf("serving admin endpoints on %s", addr)
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Error().Err(err).Msgf("admin server on %s stopped", addr)
+		}
+	}()
+}
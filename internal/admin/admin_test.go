@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHandleAction checks an action is refused until Actions is set
+func TestHandleAction(t *testing.T) {
+
+	called := 0
+	HandleAction("/test-action", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called++
+	}))
+
+	defer func(actions bool) { Actions = actions }(Actions)
+
+	for _, tt := range []struct {
+		actions bool
+		status  int
+		called  int
+	}{
+		{actions: false, status: http.StatusForbidden, called: 0},
+		{actions: true, status: http.StatusOK, called: 1},
+	} {
+		Actions = tt.actions
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test-action", nil))
+
+		if w.Code != tt.status || called != tt.called {
+			t.Errorf("with Actions %v the action returned %d and was called %d times, expected %d and %d", tt.actions, w.Code, called, tt.status, tt.called)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Package agent applies the outputs a controller renders and pushes with -push-agents, so the load balancer and
+// database hosts neither query the Kubernetes API nor render anything.  Every output is written to a file,
+// checked with a command and a service reloaded.
+package agent
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/config"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/control"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/internal/hosts"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/internal/tracing"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// outputConfig is an output of the controller the agent applies
+type outputConfig struct {
+	// The name of the output on the controller, its config file for a file
+	Output string `yaml:"name" validate:"required"`
+
+	// Where the output is written, the name when empty
+	Path string `yaml:"path"`
+
+	// Only the managed block between the markers is taken from the output and merged into the file, for
+	// outputs such as a hosts file whose hand written content differs between the controller and the agent
+	BeginMarker string `yaml:"begin_marker"`
+	EndMarker   string `yaml:"end_marker"`
+
+	// Command checking the written file, for example nginx -t, the file is put back when it fails
+	Check string `yaml:"validate"`
+
+	// Service reloaded after the file changed
+	Reload string `yaml:"reload"`
+}
+
+// outputsFile is the YAML file given with -outputs
+type outputsFile struct {
+	Outputs []outputConfig `yaml:"outputs" validate:"required"`
+}
+
+// file writes the lines the controller pushed for an output
+type file struct {
+	outputConfig
+	systemctl string
+	backups   int
+	auditLog  *audit.Log
+
+	// The file from before the last Apply, written back by Rollback
+	previous []string
+	applied  bool
+}
+
+// loadOutputs - The outputs of a YAML file
+func loadOutputs(path string) ([]outputConfig, error) {
+
+	var f outputsFile
+	if err := config.Load(path, &f); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i, o := range f.Outputs {
+		if seen[o.Output] {
+			return nil, fmt.Errorf("output %s is given more than once", o.Output)
+		}
+		seen[o.Output] = true
+
+		if o.Path == "" {
+			f.Outputs[i].Path = o.Output
+		}
+
+		if o.BeginMarker != "" || o.EndMarker != "" {
+			if err := o.markers().Validate(); err != nil {
+				return nil, fmt.Errorf("output %s: %w", o.Output, err)
+			}
+		}
+	}
+
+	return f.Outputs, nil
+}
+
+// markers - The markers of the managed block, empty for a whole file
+func (o outputConfig) markers() configfile.Markers {
+	return configfile.Markers{Begin: o.BeginMarker, End: o.EndMarker}
+}
+
+// Name - The name of the output on the controller
+func (f *file) Name() string {
+	return f.Output
+}
+
+// Render - The lines the controller pushed for the output, the nodes are only the ones they were rendered for
+func (f *file) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	lines, ok := control.Pushed(f.Output)
+	if !ok {
+		return nil, fmt.Errorf("the controller pushed no output %s, check the name against the outputs of the controller", f.Output)
+	}
+
+	return lines, nil
+}
+
+// Validate - An output with markers needs a managed block, the command checks the file once written
+func (f *file) Validate(ctx context.Context, rendered []string) error {
+
+	if f.BeginMarker == "" {
+		return nil
+	}
+
+	block, err := f.markers().Block(rendered)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		return fmt.Errorf("the output %s pushed by the controller has no %s block", f.Output, f.BeginMarker)
+	}
+
+	return nil
+}
+
+// content - The file for the pushed lines: the lines, or the existing file with their managed block
+func (f *file) content(existing []string, rendered []string) ([]string, error) {
+
+	if f.BeginMarker == "" {
+		return rendered, nil
+	}
+
+	block, err := f.markers().Block(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.markers().Merge(existing, block)
+}
+
+// Apply - Write the file when it changed, check it with the command, putting the file back when it fails, and
+// reload the service
+func (f *file) Apply(ctx context.Context, rendered []string) error {
+
+	f.applied = false
+
+	existing, err := configfile.Read(f.Path)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", f.Path, err)
+	}
+
+	lines, err := f.content(existing, rendered)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Path, err)
+	}
+
+	if configfile.Equal(existing, lines) {
+		log.Info().Msgf("no changes to %s", f.Path)
+		return nil
+	}
+
+	if f.backups > 0 {
+		if err := configfile.Backup(f.Path, f.backups); err != nil {
+			return fmt.Errorf("unable to backup %s: %w", f.Path, err)
+		}
+	}
+
+	if err := configfile.Write(ctx, f.Path, lines); err != nil {
+		return fmt.Errorf("unable to write %s: %w", f.Path, err)
+	}
+
+	f.previous, f.applied = existing, true
+
+	if f.Check != "" {
+		if err := f.check(ctx); err != nil {
+			if restoreErr := configfile.Write(ctx, f.Path, existing); restoreErr != nil {
+				log.Error().Err(restoreErr).Msgf("unable to put %s back", f.Path)
+			}
+			f.applied = false
+			return err
+		}
+	}
+
+	record := audit.Record{Target: f.Path, Hash: audit.Hash(lines)}
+
+	if f.Reload != "" {
+		span := tracing.Start("reload " + f.Reload)
+		reloadErr := hosts.ServiceReload(ctx, f.systemctl, f.Reload)
+		span.End(reloadErr)
+		record.Reload = audit.Result(reloadErr)
+
+		if reloadErr != nil {
+			f.auditLog.Write(record)
+			return fmt.Errorf("%s reload failed: %w", f.Reload, reloadErr)
+		}
+	}
+
+	f.auditLog.Write(record)
+
+	log.Info().Msgf("wrote %s pushed by the controller", f.Path)
+
+	return nil
+}
+
+// check - Run the validate command on the written file
+func (f *file) check(ctx context.Context) error {
+
+	args := strings.Fields(f.Check)
+
+	result := hostexec.Run(hostexec.Command(ctx, args[0], args[1:]...))
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("%s refused %s: %w: %s", f.Check, f.Path, err, strings.TrimSpace(result.Output()))
+	}
+
+	return nil
+}
+
+// Rollback - Put the file from before the last Apply back and reload the service
+func (f *file) Rollback(ctx context.Context) error {
+
+	if !f.applied {
+		return nil
+	}
+
+	if err := configfile.Write(ctx, f.Path, f.previous); err != nil {
+		return fmt.Errorf("unable to put %s back: %w", f.Path, err)
+	}
+
+	f.applied = false
+
+	if f.Reload != "" {
+		return hosts.ServiceReload(ctx, f.systemctl, f.Reload)
+	}
+
+	return nil
+}
+
+// Run - Run the agent subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var outputs string
+	fs.StringVar(&outputs, "outputs", "", "YAML file naming the outputs of the controller to apply, with the file each is written to, the command checking it and the service reloaded")
+
+	var systemctl string
+	fs.StringVar(&systemctl, "systemctl", "/bin/systemctl", "systemctl executable command")
+
+	var backups int
+	fs.IntVar(&backups, "backups", 5, "number of timestamped backups of every file to keep, 0 disables backups")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if outputs == "" {
+		return fmt.Errorf("-outputs is required")
+	}
+
+	configs, err := loadOutputs(outputs)
+	if err != nil {
+		return err
+	}
+
+	common.Agent = true
+	common.Start(fs.Name())
+
+	auditLog := audit.Open(common.AuditLog, fs.Name())
+
+	var backends []backend.Backend
+	for _, c := range configs {
+		log.Info().Msgf("applying output %s to %s", c.Output, c.Path)
+		backends = append(backends, &file{outputConfig: c, systemctl: systemctl, backups: backups, auditLog: auditLog})
+	}
+
+	if common.Output != "" {
+		return common.Print(backends)
+	}
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+
+	backends = common.Track(backends)
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rsvancara/linode-tools/internal/configfile"
+)
+
+// TestApply checks the managed block of a pushed output is merged into the file of the agent, and a file refused
+// by the validate command is put back
+func TestApply(t *testing.T) {
+
+	markers := outputConfig{BeginMarker: "# BEGIN", EndMarker: "# END"}
+
+	tests := []struct {
+		name     string
+		output   outputConfig
+		existing []string
+		pushed   []string
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "whole file",
+			output:   outputConfig{},
+			existing: []string{"upstream old {"},
+			pushed:   []string{"upstream app {", "server 10.0.0.1:30080;", "}"},
+			expected: []string{"upstream app {", "server 10.0.0.1:30080;", "}"},
+		},
+		{
+			name:     "managed block",
+			output:   markers,
+			existing: []string{"127.0.0.1 localhost", "# BEGIN", "10.0.0.9 old", "# END", "192.0.2.1 agent"},
+			pushed:   []string{"127.0.0.1 controller", "# BEGIN", "10.0.0.1 node-1", "# END"},
+			expected: []string{"127.0.0.1 localhost", "# BEGIN", "10.0.0.1 node-1", "# END", "192.0.2.1 agent"},
+		},
+		{
+			name:     "refused by the validate command",
+			output:   outputConfig{Check: "false"},
+			existing: []string{"upstream old {"},
+			pushed:   []string{"upstream app {"},
+			expected: []string{"upstream old {"},
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), "output.conf")
+			if err := os.WriteFile(path, configfile.Content(tt.existing, true), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.output.Output, tt.output.Path = path, path
+			f := &file{outputConfig: tt.output}
+
+			if err := f.Validate(context.Background(), tt.pushed); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := f.Apply(context.Background(), tt.pushed); (err != nil) != tt.err {
+				t.Fatalf("Apply returned %v", err)
+			}
+
+			written, err := configfile.Read(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(written, tt.expected) {
+				t.Errorf("wrote %q, expected %q", written, tt.expected)
+			}
+
+			if tt.err {
+				return
+			}
+
+			if err := f.Rollback(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if restored, _ := configfile.Read(path); !reflect.DeepEqual(restored, tt.existing) {
+				t.Errorf("rolled back to %q, expected %q", restored, tt.existing)
+			}
+		})
+	}
+}
+
+// TestValidate checks an output with markers pushed without its managed block is refused
+func TestValidate(t *testing.T) {
+
+	f := &file{outputConfig: outputConfig{Output: "/etc/hosts", BeginMarker: "# BEGIN", EndMarker: "# END"}}
+
+	if err := f.Validate(context.Background(), []string{"127.0.0.1 localhost"}); err == nil {
+		t.Error("an output without its managed block was accepted")
+	}
+}
@@ -0,0 +1,130 @@
+// Package audit appends a JSON line for every reconcile that changed the managed configuration,
+// for compliance reviews of firewall and load balancer changes.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Record is a single applied change
+type Record struct {
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+	Tool    string    `json:"tool"`
+	Target  string    `json:"target"`
+	Added   []string  `json:"added"`
+	Removed []string  `json:"removed"`
+	Hash    string    `json:"hash,omitempty"`
+	Reload  string    `json:"reload,omitempty"`
+}
+
+// Log is an append-only JSON lines file.  A nil Log discards every record.
+type Log struct {
+	path string
+	tool string
+	mu   sync.Mutex
+}
+
+// Open - The audit log at path for a tool, nil when path is empty
+func Open(path string, tool string) *Log {
+
+	if path == "" {
+		return nil
+	}
+
+	return &Log{path: path, tool: tool}
+}
+
+// Write - Append a record, filling in the time, host and tool
+func (l *Log) Write(r Record) {
+
+	if l == nil {
+		return
+	}
+
+	r.Time = time.Now().UTC()
+	r.Tool = l.tool
+	r.Host, _ = os.Hostname()
+
+	if r.Added == nil {
+		r.Added = []string{}
+	}
+	if r.Removed == nil {
+		r.Removed = []string{}
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to encode audit record")
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		log.Error().Err(err).Msgf("unable to open audit log %s", l.path)
+		return
+	}
+
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Error().Err(err).Msgf("unable to write audit log %s", l.path)
+	}
+}
+
+// Diff - The addresses added and removed between two address lists
+func Diff(oldIPs []net.IP, newIPs []net.IP) ([]string, []string) {
+
+	var added, removed []string
+
+	known := make(map[string]bool)
+	for _, ip := range oldIPs {
+		known[ip.String()] = true
+	}
+
+	current := make(map[string]bool)
+	for _, ip := range newIPs {
+		current[ip.String()] = true
+		if !known[ip.String()] {
+			added = append(added, ip.String())
+		}
+	}
+
+	for _, ip := range oldIPs {
+		if !current[ip.String()] {
+			removed = append(removed, ip.String())
+		}
+	}
+
+	return added, removed
+}
+
+// Hash - The sha256 of rendered config lines
+func Hash(lines []string) string {
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n") + "\n"))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Result - The reload result as recorded in the log
+func Result(err error) string {
+
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+
+	return "ok"
+}
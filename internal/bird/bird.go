@@ -0,0 +1,439 @@
+// Package bird announces anycast prefixes through BIRD while the host has enough healthy backends, so an anycast
+// front end on Linode stops attracting traffic as soon as it has nothing left to send it to.
+//
+// The prefixes are routes of a static protocol in a file included by the BIRD config, disabled while too few nodes
+// are healthy, and BIRD is reconfigured through its control socket.  The BGP protocols export the static routes.
+package bird
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// announcer keeps the static protocols of the anycast prefixes as a backend.Backend
+type announcer struct {
+	path       string
+	protocol   string
+	prefixes   []*net.IPNet
+	route      string
+	minHealthy int
+	birdc      []string
+	backups    int
+	auditLog   *audit.Log
+	notifier   *notify.Notifier
+	health     healthCheck
+
+	// The file read by the last Render, whether it announces the prefixes and the healthy nodes it counted
+	existing  []string
+	announced bool
+	healthy   int
+
+	// Whether the applied file announces the prefixes, nil until a file is applied.  The health check reads it
+	// between the reconciles.
+	mu      sync.Mutex
+	applied *bool
+}
+
+// healthCheck tells the healthy nodes: those not cordoned and, with a port, accepting a connection on it
+type healthCheck struct {
+	port    int
+	timeout time.Duration
+}
+
+// count - The healthy nodes, checked in parallel
+func (h healthCheck) count(nodes []discovery.Node) int {
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	healthy := 0
+
+	for _, n := range nodes {
+		if n.Unschedulable {
+			continue
+		}
+
+		if h.port == 0 {
+			healthy++
+			continue
+		}
+
+		wg.Add(1)
+		go func(n discovery.Node) {
+			defer wg.Done()
+
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(n.IP.String(), strconv.Itoa(h.port)), h.timeout)
+			if err != nil {
+				log.Debug().Err(err).Msgf("node %s is not healthy", n.Name)
+				return
+			}
+			conn.Close()
+
+			mu.Lock()
+			healthy++
+			mu.Unlock()
+		}(n)
+	}
+
+	wg.Wait()
+
+	return healthy
+}
+
+// parsePrefixes - The prefixes of a comma separated list, a bare address taken as a host route
+func parsePrefixes(value string) ([]*net.IPNet, error) {
+
+	var prefixes []*net.IPNet
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() == nil {
+				item += "/128"
+			} else {
+				item += "/32"
+			}
+		}
+
+		_, prefix, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a prefix", item)
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("no prefixes given")
+	}
+
+	return prefixes, nil
+}
+
+// Name - The path of the file
+func (a *announcer) Name() string {
+	return a.path
+}
+
+// Render - A static protocol per address family with a route for every prefix, disabled unless enough nodes are
+// healthy
+func (a *announcer) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	existing, err := configfile.Read(a.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", a.path, err)
+	}
+
+	a.existing = existing
+	a.healthy = a.health.count(nodes)
+	a.announced = a.healthy >= a.minHealthy
+
+	state := "announced"
+	if !a.announced {
+		state = "withdrawn"
+	}
+
+	lines := []string{fmt.Sprintf("# written by linode-tools, %s with %d of %d backends healthy", state, a.healthy, len(nodes))}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		var routes []string
+		for _, p := range a.prefixes {
+			if (p.IP.To4() != nil) == (family == "ipv4") {
+				routes = append(routes, fmt.Sprintf("    route %s %s;", p, a.route))
+			}
+		}
+
+		if len(routes) == 0 {
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("protocol static %s_%s {", a.protocol, family))
+		lines = append(lines, fmt.Sprintf("    %s;", family))
+		if !a.announced {
+			lines = append(lines, "    disabled yes;")
+		}
+		lines = append(lines, routes...)
+		lines = append(lines, "}")
+	}
+
+	return lines, nil
+}
+
+// Validate - The prefixes are checked when they are parsed, there is nothing to check
+func (a *announcer) Validate(ctx context.Context, rendered []string) error {
+	return nil
+}
+
+// Apply - Write the file when it changed and reconfigure BIRD, which announces or withdraws the prefixes
+func (a *announcer) Apply(ctx context.Context, rendered []string) error {
+
+	labels := metrics.Labels{"protocol": a.protocol}
+	metrics.SetGauge("linode_tools_bird_healthy_backends", "Backends that passed the health check of the anycast announcement", labels, float64(a.healthy))
+
+	// The comment counting the healthy nodes changes on every render, only the protocols are compared
+	if len(a.existing) > 0 && configfile.Equal(a.existing[1:], rendered[1:]) {
+		log.Info().Msgf("no changes to %s, %d backends healthy", a.path, a.healthy)
+		a.setApplied(a.announced)
+		return nil
+	}
+
+	if a.backups > 0 {
+		if err := configfile.Backup(a.path, a.backups); err != nil {
+			return fmt.Errorf("unable to backup %s: %w", a.path, err)
+		}
+	}
+
+	if err := configfile.Write(ctx, a.path, rendered); err != nil {
+		return fmt.Errorf("unable to write %s: %w", a.path, err)
+	}
+
+	state := "announced"
+	if !a.announced {
+		state = "withdrawn"
+	}
+
+	err := a.configure(ctx)
+
+	a.auditLog.Write(audit.Record{Target: a.path, Hash: audit.Hash(rendered), Reload: fmt.Sprintf("%s, %s", state, audit.Result(err))})
+
+	if err != nil {
+		return err
+	}
+
+	if wasAnnounced, known := a.appliedState(); !a.announced && (!known || wasAnnounced) {
+		a.notifier.Alert("withdrew %s from BIRD, only %d backends are healthy and %d are needed", a.prefixList(), a.healthy, a.minHealthy)
+	} else if a.announced {
+		log.Info().Msgf("announcing %s through BIRD, %d backends are healthy", a.prefixList(), a.healthy)
+	}
+
+	a.setApplied(a.announced)
+
+	return nil
+}
+
+// setApplied - Remember whether the applied file announces the prefixes
+func (a *announcer) setApplied(announced bool) {
+
+	a.mu.Lock()
+	a.applied = &announced
+	a.mu.Unlock()
+
+	metrics.SetGauge("linode_tools_bird_announced", "Whether the anycast prefixes are announced through BIRD", metrics.Labels{"protocol": a.protocol}, boolGauge(announced))
+}
+
+// appliedState - Whether the applied file announces the prefixes, and whether a file was applied at all
+func (a *announcer) appliedState() (bool, bool) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.applied == nil {
+		return false, false
+	}
+
+	return *a.applied, true
+}
+
+// boolGauge - 1 for true, 0 for false
+func boolGauge(b bool) float64 {
+
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// prefixList - The prefixes as a comma separated list
+func (a *announcer) prefixList() string {
+
+	var prefixes []string
+	for _, p := range a.prefixes {
+		prefixes = append(prefixes, p.String())
+	}
+
+	return strings.Join(prefixes, ",")
+}
+
+// configure - Reconfigure BIRD through its control socket.  birdc exits with success on a config error as well,
+// so its answer is checked.
+func (a *announcer) configure(ctx context.Context) error {
+
+	result := hostexec.Run(hostexec.Command(ctx, a.birdc[0], append(a.birdc[1:], "configure")...))
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("bird configure failed: %w", err)
+	}
+
+	if !strings.Contains(result.Stdout, "Reconfigur") {
+		return fmt.Errorf("%s configure failed: %s", strings.Join(a.birdc, " "), result.Output())
+	}
+
+	log.Info().Msgf("bird reconfigured: %s", result.Output())
+
+	return nil
+}
+
+// Rollback - Put the previous file back and reconfigure BIRD with it
+func (a *announcer) Rollback(ctx context.Context) error {
+
+	if err := configfile.Write(ctx, a.path, a.existing); err != nil {
+		return fmt.Errorf("unable to restore %s: %w", a.path, err)
+	}
+
+	a.auditLog.Write(audit.Record{Target: a.path, Reload: "rolled back"})
+
+	if err := a.configure(ctx); err != nil {
+		return fmt.Errorf("restored the previous %s but bird still fails to reconfigure: %w", a.path, err)
+	}
+
+	return nil
+}
+
+// watchHealth - Check the health of the nodes of the last reconcile every interval and ask for a reconcile when
+// the prefixes would be announced or withdrawn, the nodes themselves may not change for a long time
+func watchHealth(a *announcer, nodes func() []discovery.Node, interval time.Duration, force chan<- struct{}) {
+
+	for range time.Tick(interval) {
+		current := nodes()
+		announced, known := a.appliedState()
+		if current == nil || !known {
+			continue
+		}
+
+		if announce := a.health.count(current) >= a.minHealthy; announce == announced {
+			continue
+		}
+
+		log.Info().Msgf("the backends of %s changed health, reconciling", a.prefixList())
+
+		select {
+		case force <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run - Run the bird subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("bird", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var path string
+	fs.StringVar(&path, "config", "/etc/bird/linode-tools.conf", "file of the static protocols, included from bird.conf")
+
+	var prefixes string
+	fs.StringVar(&prefixes, "prefixes", "", "comma separated anycast prefixes announced while enough backends are healthy, for example 203.0.113.10/32,2001:db8::10/128")
+
+	var protocol string
+	fs.StringVar(&protocol, "protocol", "linode_tools_anycast", "name of the static protocols, suffixed with _ipv4 and _ipv6, for the filters of the BGP protocols")
+
+	var route string
+	fs.StringVar(&route, "route", "blackhole", "destination of the static routes, blackhole, unreachable or via an address, the address itself is on the loopback")
+
+	var minHealthy int
+	fs.IntVar(&minHealthy, "min-healthy", 1, "healthy backends needed to announce the prefixes")
+
+	var health healthCheck
+	fs.IntVar(&health.port, "health-port", 0, "(optional) port of the nodes that must accept a connection for the node to be healthy, for example the node port of the service; only cordoned nodes are unhealthy without it")
+	fs.DurationVar(&health.timeout, "health-timeout", 2*time.Second, "connect timeout of the health check")
+
+	var healthInterval time.Duration
+	fs.DurationVar(&healthInterval, "health-interval", 10*time.Second, "how often the backends are checked between the changes of the nodes, 0 to only check them when the nodes change")
+
+	var birdc string
+	fs.StringVar(&birdc, "birdc", "birdc", "birdc command reconfiguring BIRD through its control socket, for example 'birdc -s /run/bird/bird.ctl'")
+
+	var backups int
+	fs.IntVar(&backups, "backups", 5, "number of timestamped backups of the file to keep, 0 disables backups")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	parsed, err := parsePrefixes(prefixes)
+	if err != nil {
+		return fmt.Errorf("-prefixes: %w", err)
+	}
+
+	if minHealthy < 1 {
+		return fmt.Errorf("-min-healthy must be at least 1")
+	}
+
+	if len(strings.Fields(birdc)) == 0 {
+		return fmt.Errorf("-birdc cannot be empty")
+	}
+
+	common.Start(fs.Name())
+
+	log.Info().Msgf("announcing %d prefixes through %s", len(parsed), path)
+
+	a := &announcer{
+		path:       path,
+		protocol:   protocol,
+		prefixes:   parsed,
+		route:      route,
+		minHealthy: minHealthy,
+		birdc:      strings.Fields(birdc),
+		backups:    backups,
+		health:     health,
+	}
+
+	if common.Output != "" {
+		return common.Print([]backend.Backend{a})
+	}
+
+	a.auditLog = audit.Open(common.AuditLog, fs.Name())
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+	a.notifier = notifier
+
+	backends := common.Track([]backend.Backend{a})
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	var mu sync.Mutex
+	var last []discovery.Node
+
+	if healthInterval > 0 {
+		go watchHealth(a, func() []discovery.Node {
+			mu.Lock()
+			defer mu.Unlock()
+			return last
+		}, healthInterval, force)
+	}
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		mu.Lock()
+		last = nodes
+		mu.Unlock()
+
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
@@ -0,0 +1,568 @@
+// Package cli holds the flags and plumbing shared by the linode-tools subcommands.
+package cli
+
+import (
+	"context"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/util/homedir"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/admin"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/control"
+	"github.com/rsvancara/linode-tools/internal/controller"
+	"github.com/rsvancara/linode-tools/internal/etcd"
+	"github.com/rsvancara/linode-tools/internal/events"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/internal/pause"
+	"github.com/rsvancara/linode-tools/internal/signature"
+	"github.com/rsvancara/linode-tools/internal/status"
+	"github.com/rsvancara/linode-tools/internal/tracing"
+	"github.com/rsvancara/linode-tools/internal/version"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/linode"
+)
+
+// Common are the flags every subcommand accepts
+type Common struct {
+	Kubeconfig  string
+	KubeAPIQPS  float64
+	Interval    time.Duration
+	AdminAddr   string
+	Debug       bool
+	Actions     bool
+	LinodeToken string
+	LKECluster  int
+	LinodeTag   string
+	Clusters    string
+	DNSNames    string
+	NodesFrom   string
+	Sources     string
+
+	LoadBalancers          bool
+	LoadBalancerNamespaces string
+
+	PreferPrivate      bool
+	AddressAnnotations string
+	Network            string
+
+	MinNodes         int
+	RemoveAfter      int
+	MaxRemovePercent int
+	Force            bool
+
+	AuditLog      string
+	NotifyWebhook string
+	AlertAfter    int
+	OTLPEndpoint  string
+
+	ReconcileTimeout time.Duration
+
+	ControlAddr string
+	ControlCert string
+	ControlKey  string
+	ControlCA   string
+
+	// Agent is set by the agent command, which applies the outputs pushed by a controller
+	Agent          bool
+	AgentMaxAge    time.Duration
+	AgentPublicKey string
+	SigningKey     string
+
+	PushAgents string
+	PushCert   string
+	PushKey    string
+	PushCA     string
+	PushResync time.Duration
+
+	// The agents of -push-agents, shared by the backends of every Track
+	agents []*controller.Agent
+
+	PublishConfigMap string
+	PublishKey       string
+
+	// Events is the object the failures are reported on, the recorder is shared by the backends of every Track
+	Events   string
+	recorder *events.Recorder
+
+	ObjectStorageEndpoint  string
+	ObjectStorageBucket    string
+	ObjectStorageAccessKey string
+	ObjectStorageSecretKey string
+	ObjectStoragePrefix    string
+
+	EtcdEndpoints string
+	EtcdKey       string
+	EtcdCert      string
+	EtcdCertKey   string
+	EtcdCA        string
+
+	Output    string
+	MockApply bool
+
+	// The name of the running command, set by Start
+	name string
+}
+
+// versionFlag prints the build information and exits as soon as -version is parsed
+type versionFlag struct{}
+
+func (versionFlag) IsBoolFlag() bool { return true }
+func (versionFlag) String() string   { return "false" }
+
+func (versionFlag) Set(string) error {
+	fmt.Println(version.Get())
+	os.Exit(0)
+	return nil
+}
+
+// Register - Add the common flags to the flag set of a subcommand
+func (c *Common) Register(fs *flag.FlagSet) {
+
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		// In a pod, use the service account unless a kubeconfig is given
+		fs.StringVar(&c.Kubeconfig, "kubeconfig", "", "(optional) absolute path to the kubeconfig file, the in-cluster service account when empty")
+	} else if home := homedir.HomeDir(); home != "" {
+		fs.StringVar(&c.Kubeconfig, "kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
+	} else {
+		fs.StringVar(&c.Kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file")
+	}
+
+	fs.DurationVar(&c.Interval, "interval", 5*time.Second, "how often to query kubernetes for nodes")
+	fs.Float64Var(&c.KubeAPIQPS, "kube-api-qps", 5, "requests per second each client of the kubernetes api may send, raise the -interval rather than this when many hosts poll the same cluster")
+	fs.IntVar(&discovery.ClientBurst, "kube-api-burst", 10, "requests a client of the kubernetes api may send in a burst above -kube-api-qps")
+	fs.DurationVar(&discovery.ClientTimeout, "kube-api-timeout", 30*time.Second, "timeout of every request to the kubernetes api, 0 for no timeout")
+	fs.StringVar(&c.LinodeToken, "linode-token", os.Getenv("LINODE_TOKEN"), "Linode API token, defaults to $LINODE_TOKEN")
+	fs.IntVar(&c.LKECluster, "lke-cluster", 0, "(optional) discover the nodes of this LKE cluster through the Linode API instead of the kubeconfig")
+	fs.BoolVar(&c.PreferPrivate, "prefer-private", false, "use the private Linode address (192.168.128.0/17) of the nodes when they have one")
+	fs.BoolVar(&c.LoadBalancers, "load-balancers", false, "discover the ingress addresses of the LoadBalancer services, such as NodeBalancers, instead of the nodes")
+	fs.StringVar(&c.LoadBalancerNamespaces, "load-balancer-namespaces", "", "(optional) comma separated namespaces of the -load-balancers services, every namespace when empty")
+	fs.StringVar(&c.Network, "network", "", "(optional) use the address of the nodes on this network: public, private, vlan, vpc or a CIDR, for example the VLAN addresses for upstreams and the public ones for a firewall")
+	fs.StringVar(&c.AddressAnnotations, "address-annotations", discovery.CalicoAnnotation, "comma separated node annotations holding the node address, tried in order, for example "+discovery.CiliumAnnotation)
+	fs.StringVar(&c.LinodeTag, "linode-tag", "", "(optional) discover the Linodes with this tag through the Linode API instead of the kubeconfig")
+	fs.StringVar(&c.DNSNames, "dns-names", "", "(optional) comma separated dns names whose addresses are the nodes instead of the kubeconfig, as host, a:host, aaaa:host or srv:_service._proto.domain, resolved every -interval")
+	fs.StringVar(&c.NodesFrom, "nodes-from", "", "(optional) read the nodes from this file or http(s) url instead of the kubeconfig, as the json of -output json or a line with an address, or a name and an address, per node")
+	fs.StringVar(&c.Sources, "sources", "", "(optional) combine discovery sources with + (union), & (intersection) and - (difference) applied left to right, for example \"kube - label:lke.linode.com/pool-id=1234 + dns:bastion.example.com\", see the README for the sources")
+	fs.StringVar(&c.Clusters, "clusters", "", "(optional) comma separated kubeconfig files of several clusters whose nodes are merged")
+	fs.IntVar(&c.MinNodes, "min-nodes", 1, "refuse to apply a node list with fewer nodes than this")
+	fs.IntVar(&c.RemoveAfter, "remove-after", 1, "only remove a node once it has been missing from this many queries in a row, to ride out nodes briefly losing their address annotation")
+	fs.IntVar(&c.MaxRemovePercent, "max-remove-percent", 50, "refuse to remove more than this percentage of the nodes in one reconcile, 0 for no limit")
+	fs.BoolVar(&c.Force, "force", false, "apply the node list even when it fails the -min-nodes or -max-remove-percent checks")
+	fs.StringVar(&c.AdminAddr, "admin-addr", "", "(optional) address to serve /metrics on, for example :9090")
+	fs.BoolVar(&c.Debug, "debug-endpoints", false, "also serve pprof profiles under /debug/pprof/ and expvar variables on /debug/vars on the admin server")
+	fs.BoolVar(&c.Actions, "admin-actions", false, "also accept the unauthenticated POSTs to /reconcile, /pause, /resume and /reload/reset on the admin server, only with it bound to a private address")
+	fs.StringVar(&c.ControlAddr, "control-addr", "", "(optional) address to serve the control api on for a central controller, for example :9443, needs -control-cert, -control-key and -control-ca")
+	fs.StringVar(&c.ControlCert, "control-cert", "", "certificate of the control api server")
+	fs.StringVar(&c.ControlKey, "control-key", "", "private key of the control api server")
+	fs.StringVar(&c.ControlCA, "control-ca", "", "CA the client certificates of the control api must be signed by")
+	fs.DurationVar(&c.AgentMaxAge, "agent-max-age", 5*time.Minute, "with the agent command, stop applying the pushed outputs when the controller has not pushed them for this long, 0 for no limit")
+	fs.StringVar(&c.AgentPublicKey, "agent-public-key", "", "(optional) ed25519 public key (PEM) the outputs pushed by the controller must be signed with, unsigned pushes are refused")
+	fs.StringVar(&c.PushAgents, "push-agents", "", "(optional) comma separated control api addresses of agents the rendered outputs are pushed to instead of applied here, for example lb1:9443,lb2:9443, needs -push-cert, -push-key and -push-ca")
+	fs.StringVar(&c.PushCert, "push-cert", "", "client certificate presented to the -push-agents")
+	fs.StringVar(&c.PushKey, "push-key", "", "private key of -push-cert")
+	fs.StringVar(&c.PushCA, "push-ca", "", "CA the certificates of the -push-agents are signed by")
+	fs.DurationVar(&c.PushResync, "push-resync", time.Minute, "push the outputs to every agent this often even when they did not change, so restarted agents catch up, 0 to only push changes")
+	fs.StringVar(&c.SigningKey, "signing-key", "", "(optional) ed25519 private key (PEM) signing the outputs pushed to -push-agents and the objects uploaded to -object-storage-bucket")
+	fs.StringVar(&pause.File, "pause-file", "", "(optional) pause reconciling while this file exists, for example during maintenance, its content is logged as the reason")
+	fs.DurationVar(&pause.Timeout, "pause-timeout", time.Hour, "resume reconciling automatically this long after a pause, or ignore a -pause-file older than this, 0 to stay paused until resumed")
+	fs.StringVar(&c.PublishConfigMap, "publish-configmap", "", "(optional) namespace/name of a configmap the applied nodes and the hash of every output are published to after each apply, so cluster operators can see the state of the host")
+	fs.StringVar(&c.PublishKey, "publish-key", hostname(), "key of the host in the -publish-configmap, defaults to the hostname")
+	fs.StringVar(&c.Events, "events", "", "(optional) namespace/name of a configmap the failures are reported on as kubernetes events, the nodes added and removed are reported on the node objects, for example kube-system/linode-tools")
+	fs.StringVar(&c.ObjectStorageBucket, "object-storage-bucket", "", "(optional) Object Storage bucket the applied output of every backend and the audit log are uploaded to after each change, versioning is enabled on it")
+	fs.StringVar(&c.ObjectStorageEndpoint, "object-storage-endpoint", "https://us-east-1.linodeobjects.com", "Object Storage cluster of -object-storage-bucket")
+	fs.StringVar(&c.ObjectStorageAccessKey, "object-storage-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "Object Storage access key, defaults to $AWS_ACCESS_KEY_ID")
+	fs.StringVar(&c.ObjectStorageSecretKey, "object-storage-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "Object Storage secret key, defaults to $AWS_SECRET_ACCESS_KEY")
+	fs.StringVar(&c.ObjectStoragePrefix, "object-storage-prefix", hostname(), "prefix of the uploaded objects, defaults to the hostname")
+	fs.StringVar(&c.EtcdEndpoints, "etcd-endpoints", "", "(optional) comma separated etcd endpoints the nodes are also written to as JSON in -etcd-key, for example https://etcd1:2379")
+	fs.StringVar(&c.EtcdKey, "etcd-key", "/linode-tools/nodes", "etcd key the nodes are written to")
+	fs.StringVar(&c.EtcdCert, "etcd-cert", "", "(optional) client certificate presented to etcd")
+	fs.StringVar(&c.EtcdCertKey, "etcd-cert-key", "", "private key of -etcd-cert")
+	fs.StringVar(&c.EtcdCA, "etcd-ca", "", "(optional) CA the certificates of etcd are signed by, the system roots when empty")
+	fs.StringVar(&c.AuditLog, "audit-log", "", "(optional) JSON lines file recording every applied change")
+	fs.StringVar(&c.NotifyWebhook, "notify-webhook", "", "(optional) webhook URL alerts are posted to, for example a Slack incoming webhook")
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "(optional) OTLP/HTTP endpoint of an OpenTelemetry collector every reconcile is traced to, for example http://tempo:4318, defaults to $OTEL_EXPORTER_OTLP_ENDPOINT")
+	fs.DurationVar(&c.ReconcileTimeout, "reconcile-timeout", 5*time.Minute, "deadline of a reconcile from the node query to the last reload, the commands still running then are killed and the reconcile fails, 0 for no deadline")
+	fs.IntVar(&c.AlertAfter, "alert-after", 3, "alert after this many consecutive failures to query or apply the nodes, 0 to never alert")
+	fs.StringVar(&c.Output, "output", "", "(optional) print the discovered nodes and the rendered output as json or yaml on stdout and exit, without writing anything")
+	fs.StringVar(&hostexec.Root, "host-root", "", "(optional) where the host filesystem is mounted when running in a privileged DaemonSet pod, for example /host; reload and apply commands then run in the host namespaces through nsenter")
+	fs.StringVar(&configfile.Default.Mode, "file-mode", "", "(optional) octal mode of the written config files, for example 0640, the mode of the existing file when empty")
+	fs.StringVar(&configfile.Default.Owner, "file-owner", "", "(optional) user name or id owning the written config files, unchanged when empty")
+	fs.StringVar(&configfile.Default.Group, "file-group", "", "(optional) group name or id of the written config files, unchanged when empty")
+	fs.BoolVar(&configfile.InsertMarkers, "insert-markers", true, "append the managed block between new markers to a file without them, false to fail instead so the markers are placed by hand")
+	fs.StringVar(&configfile.Restorecon, "restorecon", "", "(optional) restorecon command run on every written config file so it gets the SELinux context of its path on enforcing hosts, for example /sbin/restorecon")
+	fs.BoolVar(&c.MockApply, "mock-apply", false, "render and validate as usual but only log the changes instead of writing files, chains or reloading services, for local development")
+	fs.Var(versionFlag{}, "version", "print the version and exit")
+}
+
+// Source - Where the nodes are discovered, keeping briefly missing nodes and guarded against queries
+// removing too many nodes
+func (c *Common) Source() discovery.Source {
+
+	source := c.source()
+
+	if c.Network != "" {
+		if err := discovery.ValidNetwork(c.Network); err != nil {
+			log.Fatal().Err(err).Msg("invalid -network")
+		}
+		log.Info().Msgf("using the node addresses on %s", c.Network)
+		source = discovery.Network{Source: source, Network: c.Network}
+	}
+
+	if c.RemoveAfter > 1 {
+		log.Info().Msgf("removing nodes once they are missing from %d queries in a row", c.RemoveAfter)
+		source = &discovery.Grace{Source: source, Polls: c.RemoveAfter}
+	}
+
+	return &discovery.Guard{Source: source, MinNodes: c.MinNodes, MaxRemovePercent: c.MaxRemovePercent, Force: c.Force}
+}
+
+// source - Where the nodes are discovered: the controller for an agent, the combined sources, the LKE cluster or the Linodes with the tag when given, the
+// addresses of the dns names, the file or endpoint listing them, the load balancer services, the merged clusters, or else the kubeconfig
+func (c *Common) source() discovery.Source {
+
+	if c.Agent {
+		if c.ControlAddr == "" {
+			log.Fatal().Msg("the agent receives the outputs on the control api, it needs -control-addr")
+		}
+		log.Info().Msg("applying the outputs pushed by the controller")
+		return control.Agent{MaxAge: c.AgentMaxAge}
+	}
+
+	if c.Sources != "" {
+		source, err := c.composite(c.Sources)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid -sources")
+		}
+
+		log.Info().Msgf("discovering the nodes of %s", c.Sources)
+		return source
+	}
+
+	if (c.LKECluster != 0 || c.LinodeTag != "") && c.LinodeToken == "" {
+		log.Fatal().Msg("discovery through the Linode API needs a token in -linode-token or $LINODE_TOKEN")
+	}
+
+	if c.LKECluster != 0 {
+		log.Info().Msgf("discovering nodes of lke cluster %d", c.LKECluster)
+		return &discovery.LKE{Client: linode.NewClient(c.LinodeToken), ClusterID: c.LKECluster, PreferPrivate: c.PreferPrivate, Interfaces: discovery.NeedsInterfaces(c.Network)}
+	}
+
+	if c.LinodeTag != "" {
+		log.Info().Msgf("discovering linodes tagged %s", c.LinodeTag)
+		return discovery.LinodeTag{Client: linode.NewClient(c.LinodeToken), Tag: c.LinodeTag, PreferPrivate: c.PreferPrivate, Interfaces: discovery.NeedsInterfaces(c.Network)}
+	}
+
+	if c.DNSNames != "" {
+		names, err := discovery.ParseDNSNames(c.DNSNames)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid -dns-names")
+		}
+
+		log.Info().Msgf("discovering the addresses of %d dns names", len(names))
+		return discovery.DNS{Names: names}
+	}
+
+	if c.NodesFrom != "" {
+		log.Info().Msgf("reading the nodes from %s", c.NodesFrom)
+		return &discovery.Static{Location: c.NodesFrom}
+	}
+
+	if c.LoadBalancers {
+		var namespaces []string
+		for _, ns := range strings.Split(c.LoadBalancerNamespaces, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				namespaces = append(namespaces, ns)
+			}
+		}
+
+		log.Info().Msg("discovering the ingress addresses of load balancer services")
+		return discovery.LoadBalancers{Kubeconfig: c.Kubeconfig, Namespaces: namespaces}
+	}
+
+	if c.Clusters != "" {
+		sources := make(map[string]discovery.Source)
+		for _, kubeconfig := range strings.Split(c.Clusters, ",") {
+			kubeconfig = strings.TrimSpace(kubeconfig)
+			if kubeconfig == "" {
+				continue
+			}
+			sources[kubeconfig] = discovery.Kubernetes{Kubeconfig: kubeconfig, PreferPrivate: c.PreferPrivate, AddressAnnotations: c.annotations()}
+		}
+
+		log.Info().Msgf("discovering nodes of %d clusters", len(sources))
+
+		multi := discovery.NewMulti(sources)
+		multi.Start(context.Background(), c.Interval)
+
+		return multi
+	}
+
+	return discovery.Kubernetes{Kubeconfig: c.Kubeconfig, PreferPrivate: c.PreferPrivate, AddressAnnotations: c.annotations()}
+}
+
+// hostname - The hostname of the host, empty when unknown
+func hostname() string {
+
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+
+	return name
+}
+
+// annotations - The node annotations holding the address
+func (c *Common) annotations() []string {
+
+	var keys []string
+	for _, key := range strings.Split(c.AddressAnnotations, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Start - Log the build that is starting and start the admin server when configured
+func (c *Common) Start(name string) {
+
+	c.name = name
+
+	info := version.Get()
+
+	// Tell the hosts apart in the audit log and the priority and fairness metrics of the api server
+	discovery.ClientQPS = float32(c.KubeAPIQPS)
+	discovery.UserAgent = fmt.Sprintf("linode-tools/%s (%s; %s)", info.Version, name, hostname())
+
+	log.Info().Str("version", info.Version).Str("commit", info.Commit).Str("built", info.Date).Str("go", info.GoVersion).Msgf("Starting %s", name)
+
+	metrics.SetGauge("linode_tools_build_info", "Build information of the running binary", metrics.Labels{
+		"version":   info.Version,
+		"commit":    info.Commit,
+		"date":      info.Date,
+		"goversion": info.GoVersion,
+	}, 1)
+
+	if err := configfile.Default.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("invalid -file-mode, -file-owner or -file-group")
+	}
+
+	if c.OTLPEndpoint != "" {
+		log.Info().Msgf("tracing reconciles to %s", c.OTLPEndpoint)
+		tracing.Init(c.OTLPEndpoint, name)
+	}
+
+	if c.ControlAddr != "" {
+		if c.ControlCert == "" || c.ControlKey == "" || c.ControlCA == "" {
+			log.Fatal().Msg("the control api needs -control-cert, -control-key and -control-ca")
+		}
+		if c.AgentPublicKey != "" {
+			key, err := signature.LoadPublicKey(c.AgentPublicKey)
+			if err != nil {
+				log.Fatal().Err(err).Msg("invalid -agent-public-key")
+			}
+			control.PublicKey = key
+		}
+		if err := control.Serve(c.ControlAddr, c.ControlCert, c.ControlKey, c.ControlCA); err != nil {
+			log.Fatal().Err(err).Msg("unable to start the control api")
+		}
+	}
+
+	if c.AdminAddr != "" {
+		if c.Debug {
+			admin.EnableDebug()
+		}
+		admin.Actions = c.Actions
+		admin.Serve(c.AdminAddr)
+	}
+}
+
+// OnDemand - Send on force when the process receives SIGHUP, the admin server a POST to /reconcile or the
+// control api a ForceReconcile, so the nodes are queried and applied right away instead of at the next
+// interval.  SIGUSR1 and a POST to /pause pause reconciling, SIGUSR2 and a POST to /resume resume it.  The
+// POSTs are refused unless -admin-actions is given.
+func OnDemand(force chan<- struct{}) {
+
+	trigger := func(reason string) {
+		log.Info().Msgf("reconcile requested by %s", reason)
+		select {
+		case force <- struct{}{}:
+		default:
+			// A reconcile is already pending
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			trigger("SIGHUP")
+		}
+	}()
+
+	admin.HandleAction("/reconcile", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "use POST to request a reconcile", http.StatusMethodNotAllowed)
+			return
+		}
+		trigger("POST /reconcile from " + req.RemoteAddr)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	admin.HandleAction("/pause", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "use POST to pause reconciling", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var timeout time.Duration
+		if t := req.URL.Query().Get("timeout"); t != "" {
+			d, err := time.ParseDuration(t)
+			if err != nil {
+				http.Error(w, "invalid timeout: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			timeout = d
+		}
+
+		reason := req.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "POST /pause from " + req.RemoteAddr
+		}
+
+		pause.Pause(reason, timeout)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	admin.HandleAction("/resume", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "use POST to resume reconciling", http.StatusMethodNotAllowed)
+			return
+		}
+		pause.Resume()
+		trigger("POST /resume from " + req.RemoteAddr)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	if pauseSignal != nil {
+		maintenance := make(chan os.Signal, 1)
+		signal.Notify(maintenance, pauseSignal, resumeSignal)
+
+		go func() {
+			for s := range maintenance {
+				if s == pauseSignal {
+					pause.Pause(s.String(), 0)
+				} else {
+					pause.Resume()
+					trigger(s.String())
+				}
+			}
+		}()
+	}
+
+	control.OnReconcile(trigger)
+}
+
+// Track - The backends of a command, or the agents they are pushed to with -push-agents, and the etcd key with
+// -etcd-endpoints, reported on the status endpoints of the admin server and traced, and only logging their changes
+// with -mock-apply
+func (c *Common) Track(backends []backend.Backend) []backend.Backend {
+
+	if c.PushAgents != "" {
+		if c.agents == nil {
+			c.agents = c.dialAgents()
+		}
+		backends = controller.Backends(c.agents, backends)
+	}
+
+	if c.EtcdEndpoints != "" {
+		key, err := etcd.New(c.EtcdEndpoints, c.EtcdKey, c.EtcdCert, c.EtcdCertKey, c.EtcdCA)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid -etcd-endpoints")
+		}
+		log.Info().Msgf("writing the nodes to etcd key %s", c.EtcdKey)
+		backends = append(backends, key)
+	}
+
+	if c.MockApply {
+		backends = backend.MockApply(backends)
+	}
+
+	var wrapped []backend.Backend
+	for _, b := range backends {
+		wrapped = append(wrapped, traced{Backend: b})
+	}
+
+	if c.Events != "" {
+		if c.recorder == nil {
+			recorder, err := events.New(c.Kubeconfig, c.Events, c.name)
+			if err != nil {
+				log.Fatal().Err(err).Msg("invalid -events")
+			}
+			log.Info().Msgf("reporting the reconciles as kubernetes events, the failures on %s", c.Events)
+			c.recorder = recorder
+		}
+		wrapped = events.Track(wrapped, c.recorder)
+	}
+
+	return status.Track(wrapped)
+}
+
+// dialAgents - The agents of -push-agents, resynced every -push-resync
+func (c *Common) dialAgents() []*controller.Agent {
+
+	var key ed25519.PrivateKey
+	if c.SigningKey != "" {
+		var err error
+		if key, err = signature.LoadPrivateKey(c.SigningKey); err != nil {
+			log.Fatal().Err(err).Msg("invalid -signing-key")
+		}
+		log.Info().Msg("signing the pushed outputs")
+	}
+
+	agents, err := controller.Dial(c.PushAgents, c.PushCert, c.PushKey, c.PushCA, key)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid -push-agents")
+	}
+
+	log.Info().Msgf("pushing the outputs to %d agents", len(agents))
+
+	if c.PushResync > 0 {
+		for _, a := range agents {
+			go a.Resync(c.PushResync)
+		}
+	}
+
+	return agents
+}
+
+// WaitForSignal - Block until the process is interrupted
+func WaitForSignal() {
+
+	// Set up channel on which to send signal notifications.
+	// We must use a buffered channel or risk missing the signal
+	// if we're not ready to receive when the signal is sent.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+
+	// Block until a signal is received.
+	s := <-c
+
+	// The signal is received, you can now do the cleanup
+	fmt.Println("Got signal:", s)
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvPrefix - The prefix of the environment variables setting the flags of a command, KUBE_NGINX for nginx
+func EnvPrefix(command string) string {
+	return "KUBE_" + strings.ToUpper(strings.ReplaceAll(command, "-", "_"))
+}
+
+// EnvName - The environment variable setting a flag of a command, KUBE_NGINX_RELOAD_BUDGET for -reload-budget of nginx
+func EnvName(command string, flagName string) string {
+	return EnvPrefix(command) + "_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Parse - Parse the command line arguments of a command, then set every flag not given on the command line
+// from its environment variable, so the flags given on the command line win over the environment, which wins
+// over the defaults
+func Parse(fs *flag.FlagSet, args []string) error {
+
+	usage := fs.Usage
+	fs.Usage = func() {
+		if usage != nil {
+			usage()
+		} else {
+			fs.PrintDefaults()
+		}
+		fmt.Fprintf(fs.Output(), "\nevery flag can also be set with an environment variable, for example %s for -interval\n", EnvName(fs.Name(), "interval"))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || f.Name == "version" {
+			return
+		}
+
+		name := EnvName(fs.Name(), f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q in $%s: %w", value, name, setErr)
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/internal/objectstorage"
+	"github.com/rsvancara/linode-tools/internal/pause"
+	"github.com/rsvancara/linode-tools/internal/publish"
+	"github.com/rsvancara/linode-tools/internal/signature"
+	"github.com/rsvancara/linode-tools/internal/tracing"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// failures counts the consecutive failures of a stage of the loop, querying the nodes or applying
+// them, and alerts once when the count reaches the threshold and again when the stage recovers
+type failures struct {
+	stage     string
+	threshold int
+	alerter   backend.Alerter
+
+	mu      sync.Mutex
+	count   int
+	alerted bool
+}
+
+// failed - Count a failure, alerting when it is the threshold-th in a row
+func (f *failures) failed(err error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.count++
+	metrics.SetGauge("linode_tools_consecutive_failures", "Consecutive failures of querying or applying the nodes", metrics.Labels{"stage": f.stage}, float64(f.count))
+
+	if f.threshold > 0 && f.count >= f.threshold && !f.alerted {
+		f.alerted = true
+		if f.alerter != nil {
+			f.alerter.Alert("%s failed %d times in a row, the last known configuration is kept: %v", f.stage, f.count, err)
+		}
+	}
+}
+
+// succeeded - Reset the count, telling the alerter the stage recovered when it had alerted
+func (f *failures) succeeded() {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.alerted && f.alerter != nil {
+		f.alerter.Alert("%s recovered after %d failures", f.stage, f.count)
+	}
+
+	f.count = 0
+	f.alerted = false
+	metrics.SetGauge("linode_tools_consecutive_failures", "Consecutive failures of querying or applying the nodes", metrics.Labels{"stage": f.stage}, 0)
+}
+
+// trackedSource counts the failed queries of a source, and times the last query for the trace of the
+// reconcile applying its nodes.  A query is cancelled once the timeout of the reconcile it starts has passed.
+type trackedSource struct {
+	discovery.Source
+	failures *failures
+	timeout  time.Duration
+
+	queried *query
+}
+
+// query is the timing of a node query
+type query struct {
+	start time.Time
+	end   time.Time
+	nodes int
+}
+
+// Nodes - The nodes of the source, counting the failures
+func (s trackedSource) Nodes(ctx context.Context) ([]discovery.Node, error) {
+
+	start := time.Now()
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(s.timeout))
+		defer cancel()
+	}
+
+	nodes, err := s.Source.Nodes(ctx)
+	if err != nil {
+		// A failed query ends the reconcile, trace it on its own
+		root := tracing.Begin("reconcile", start)
+		tracing.Record("list nodes", start, time.Now(), err)
+		root.End(err)
+
+		s.failures.failed(err)
+		return nil, err
+	}
+
+	*s.queried = query{start: start, end: time.Now(), nodes: len(nodes)}
+
+	s.failures.succeeded()
+
+	return nodes, nil
+}
+
+// deadline bounds the reconciles, from the query of the nodes to the last reload, so a hung step fails the reconcile
+// instead of blocking the loop forever
+type deadline struct {
+	mu   sync.Mutex
+	busy bool
+}
+
+// run - Run apply with a context done once the timeout after start has passed, which kills the commands started
+// with it.  A reconcile that does not return when its commands are killed is left behind, and the next one is
+// refused until it returns, so the backends are never applied twice at once.
+func (d *deadline) run(start time.Time, timeout time.Duration, apply func(ctx context.Context) error) error {
+
+	if timeout <= 0 {
+		return apply(context.Background())
+	}
+
+	d.mu.Lock()
+	if d.busy {
+		d.mu.Unlock()
+		return fmt.Errorf("the previous reconcile is still running past its deadline")
+	}
+	d.busy = true
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(timeout))
+
+	done := make(chan error, 1)
+
+	go func() {
+		err := apply(ctx)
+
+		cancel()
+
+		d.mu.Lock()
+		d.busy = false
+		d.mu.Unlock()
+
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// The commands are killed, give the reconcile a moment to return their error
+	select {
+	case err := <-done:
+		if err == nil {
+			return nil
+		}
+		metrics.AddCounter("linode_tools_reconcile_timeouts_total", "Reconciles that exceeded -reconcile-timeout", nil, 1)
+		return fmt.Errorf("reconcile exceeded -reconcile-timeout %s: %w", timeout, err)
+	case <-time.After(5 * time.Second):
+	}
+
+	metrics.AddCounter("linode_tools_reconcile_timeouts_total", "Reconciles that exceeded -reconcile-timeout", nil, 1)
+	log.Error().Msgf("the reconcile is still running %s after it started, it is abandoned until it returns", timeout)
+
+	return fmt.Errorf("reconcile exceeded -reconcile-timeout %s", timeout)
+}
+
+// Poll - Run the loop of a command over the nodes of Source until the process exits.  A failed query or apply
+// never stops the loop: the last applied configuration stays live and the change is tried again at the next
+// interval.  After -alert-after consecutive failures of either, the alerter is told once, and again on recovery.
+// apply is given the context of the reconcile, done once -reconcile-timeout has passed.
+func (c *Common) Poll(force <-chan struct{}, alerter backend.Alerter, apply func(ctx context.Context, nodes []discovery.Node) error) {
+
+	queries := &failures{stage: "querying the nodes", threshold: c.AlertAfter, alerter: alerter}
+	applying := &failures{stage: "applying the nodes", threshold: c.AlertAfter, alerter: alerter}
+
+	source := trackedSource{Source: c.Source(), failures: queries, timeout: c.ReconcileTimeout, queried: &query{}}
+
+	running := &deadline{}
+
+	var publisher *publish.ConfigMap
+	if c.PublishConfigMap != "" {
+		configMap, err := publish.Parse(c.PublishConfigMap, c.Kubeconfig, c.PublishKey)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid -publish-configmap")
+		}
+		log.Info().Msgf("publishing the applied state to configmap %s key %s", c.PublishConfigMap, c.PublishKey)
+		publisher = &configMap
+	}
+
+	var bucket *objectstorage.Bucket
+	if c.ObjectStorageBucket != "" {
+		var err error
+		bucket, err = objectstorage.New(c.ObjectStorageEndpoint, c.ObjectStorageBucket, c.ObjectStorageAccessKey, c.ObjectStorageSecretKey, c.ObjectStoragePrefix)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid -object-storage-bucket")
+		}
+		if c.SigningKey != "" {
+			if bucket.SigningKey, err = signature.LoadPrivateKey(c.SigningKey); err != nil {
+				log.Fatal().Err(err).Msg("invalid -signing-key")
+			}
+		}
+		if err := bucket.EnableVersioning(); err != nil {
+			log.Warn().Err(err).Msgf("unable to enable versioning on bucket %s, the uploads replace the previous objects", c.ObjectStorageBucket)
+		}
+		log.Info().Msgf("uploading the applied outputs to bucket %s under %s", c.ObjectStorageBucket, c.ObjectStoragePrefix)
+	}
+
+	// Remind loudly that the changes are held back while paused
+	go func() {
+		for range time.Tick(c.Interval) {
+			if paused, reason, since := pause.State(); paused {
+				log.Warn().Msgf("reconciling is paused since %s: %s", since.Format(time.RFC3339), reason)
+			}
+		}
+	}()
+
+	discovery.Poll(source, c.Interval, force, func(nodes []discovery.Node) error {
+
+		// While paused the changes stay pending and are applied on resume
+		if paused, reason, _ := pause.State(); paused {
+			return fmt.Errorf("reconciling is paused: %s", reason)
+		}
+
+		// Queries that found no change are not traced, the trace starts with the query that led to the apply
+		q := *source.queried
+		root := tracing.Begin("reconcile", q.start)
+		tracing.Record("list nodes", q.start, q.end, nil).SetAttribute("nodes", q.nodes)
+
+		err := running.run(q.start, c.ReconcileTimeout, func(ctx context.Context) error {
+			return apply(ctx, nodes)
+		})
+		root.End(err)
+
+		if err != nil {
+			applying.failed(err)
+			return err
+		}
+
+		applying.succeeded()
+
+		// The apply went through even when publishing fails
+		if publisher != nil {
+			if err := publisher.Publish(c.name); err != nil {
+				log.Warn().Err(err).Msg("unable to publish the applied state")
+			}
+		}
+
+		if bucket != nil {
+			if err := bucket.Upload(c.name, c.AuditLog); err != nil {
+				log.Warn().Err(err).Msg("unable to upload the applied outputs")
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/rsvancara/linode-tools/pkg/backend"
+)
+
+// Output formats of -output
+const (
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+)
+
+// node is a discovered node as written by -output
+type node struct {
+	Name          string            `json:"name" yaml:"name"`
+	IP            string            `json:"ip" yaml:"ip"`
+	Unschedulable bool              `json:"unschedulable" yaml:"unschedulable"`
+	Taints        []string          `json:"taints,omitempty" yaml:"taints,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// artifact is the rendered output of a backend as written by -output
+type artifact struct {
+	Name  string   `json:"name" yaml:"name"`
+	Lines []string `json:"lines" yaml:"lines"`
+}
+
+// Print - Discover the nodes once, render the backends for them and write both to stdout in the -output
+// format, instead of writing any system file
+func (c *Common) Print(backends []backend.Backend) error {
+
+	if c.Output != OutputJSON && c.Output != OutputYAML {
+		return fmt.Errorf("unknown output format %s, use %s or %s", c.Output, OutputJSON, OutputYAML)
+	}
+
+	nodes, err := c.Source().Nodes(context.Background())
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Nodes     []node     `json:"nodes" yaml:"nodes"`
+		Artifacts []artifact `json:"artifacts" yaml:"artifacts"`
+	}
+
+	result.Nodes = []node{}
+	for _, n := range nodes {
+		found := node{Name: n.Name, IP: n.IP.String(), Unschedulable: n.Unschedulable, Annotations: n.Annotations}
+		for _, t := range n.Taints {
+			found.Taints = append(found.Taints, t.String())
+		}
+		result.Nodes = append(result.Nodes, found)
+	}
+
+	result.Artifacts = []artifact{}
+	for _, b := range backends {
+		rendered, err := b.Render(context.Background(), nodes)
+		if err != nil {
+			return fmt.Errorf("unable to render %s: %w", b.Name(), err)
+		}
+		if rendered == nil {
+			rendered = []string{}
+		}
+		result.Artifacts = append(result.Artifacts, artifact{Name: b.Name(), Lines: rendered})
+	}
+
+	if c.Output == OutputYAML {
+		data, err := yaml.Marshal(result)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(result)
+}
@@ -0,0 +1,9 @@
+package cli
+
+import "os"
+
+// Windows has no user signals, reconciling is paused through the admin server or the control api
+var (
+	pauseSignal  os.Signal
+	resumeSignal os.Signal
+)
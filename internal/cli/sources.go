@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/linode"
+)
+
+// composite - The source of a -sources expression: terms separated by the set operations + (union), & (intersection)
+// and - (difference), applied left to right, as in kube - label:lke.linode.com/pool-id=1234 + dns:bastion.example.com.
+// The terms are:
+//
+//	kube[:kubeconfig]       the nodes of the cluster, of -kubeconfig unless one is given
+//	label:key[=value]       the nodes of the cluster with the label
+//	cordoned                the cordoned nodes of the cluster
+//	lke:id                  the nodes of an LKE cluster, through the Linode API
+//	tag:tag                 the Linodes with a tag, through the Linode API
+//	dns:name[,name]         the addresses of dns names, as in -dns-names
+//	nodes:file-or-url       the nodes of a file or endpoint, as in -nodes-from
+//	load-balancers[:ns,ns]  the ingress addresses of the LoadBalancer services
+func (c *Common) composite(expr string) (discovery.Source, error) {
+
+	var composite discovery.Composite
+
+	// The sources by their term, so terms sharing a source query it once
+	sources := make(map[string]int)
+	source := func(key string, build func() (discovery.Source, error)) (int, error) {
+		if i, ok := sources[key]; ok {
+			return i, nil
+		}
+
+		s, err := build()
+		if err != nil {
+			return 0, err
+		}
+
+		composite.Sources = append(composite.Sources, s)
+		sources[key] = len(composite.Sources) - 1
+
+		return sources[key], nil
+	}
+
+	kube := func(kubeconfig string) func() (discovery.Source, error) {
+		return func() (discovery.Source, error) {
+			return discovery.Kubernetes{Kubeconfig: kubeconfig, PreferPrivate: c.PreferPrivate, AddressAnnotations: c.annotations()}, nil
+		}
+	}
+
+	op := byte(discovery.Union)
+	expectTerm := true
+
+	for _, field := range strings.Fields(expr) {
+		if !expectTerm {
+			if len(field) != 1 || !strings.Contains("+&-", field) {
+				return nil, fmt.Errorf("expected +, & or - before %s", field)
+			}
+			op = field[0]
+			expectTerm = true
+			continue
+		}
+
+		if len(field) == 1 && strings.Contains("+&-", field) {
+			return nil, fmt.Errorf("expected a source before %s", field)
+		}
+
+		kind, arg := field, ""
+		if i := strings.Index(field, ":"); i >= 0 {
+			kind, arg = field[:i], field[i+1:]
+		}
+
+		term := discovery.Term{Op: op, Name: field}
+		var err error
+
+		switch kind {
+		case "kube":
+			if arg == "" {
+				arg = c.Kubeconfig
+			}
+			term.Source, err = source("kube:"+arg, kube(arg))
+
+		case "label", "cordoned":
+			term.Source, err = source("kube:"+c.Kubeconfig, kube(c.Kubeconfig))
+			term.Filter = nodeFilter(kind, arg)
+			if kind == "label" && arg == "" {
+				err = fmt.Errorf("label needs a key or key=value")
+			}
+
+		case "lke", "tag":
+			if c.LinodeToken == "" {
+				return nil, fmt.Errorf("%s needs a token in -linode-token or $LINODE_TOKEN", field)
+			}
+			term.Source, err = source(field, func() (discovery.Source, error) {
+				client := linode.NewClient(c.LinodeToken)
+				interfaces := discovery.NeedsInterfaces(c.Network)
+
+				if kind == "tag" {
+					return discovery.LinodeTag{Client: client, Tag: arg, PreferPrivate: c.PreferPrivate, Interfaces: interfaces}, nil
+				}
+
+				id, err := strconv.Atoi(arg)
+				if err != nil {
+					return nil, fmt.Errorf("%s is not an lke cluster id", arg)
+				}
+				return &discovery.LKE{Client: client, ClusterID: id, PreferPrivate: c.PreferPrivate, Interfaces: interfaces}, nil
+			})
+
+		case "dns":
+			term.Source, err = source(field, func() (discovery.Source, error) {
+				names, err := discovery.ParseDNSNames(arg)
+				if err != nil {
+					return nil, err
+				}
+				return discovery.DNS{Names: names}, nil
+			})
+
+		case "nodes":
+			term.Source, err = source(field, func() (discovery.Source, error) {
+				if arg == "" {
+					return nil, fmt.Errorf("nodes needs a file or url")
+				}
+				return &discovery.Static{Location: arg}, nil
+			})
+
+		case "load-balancers":
+			term.Source, err = source(field, func() (discovery.Source, error) {
+				var namespaces []string
+				for _, ns := range strings.Split(arg, ",") {
+					if ns = strings.TrimSpace(ns); ns != "" {
+						namespaces = append(namespaces, ns)
+					}
+				}
+				return discovery.LoadBalancers{Kubeconfig: c.Kubeconfig, Namespaces: namespaces}, nil
+			})
+
+		default:
+			return nil, fmt.Errorf("unknown source %s", field)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+
+		composite.Terms = append(composite.Terms, term)
+		expectTerm = false
+	}
+
+	if len(composite.Terms) == 0 {
+		return nil, fmt.Errorf("no sources given")
+	}
+
+	if expectTerm {
+		return nil, fmt.Errorf("expected a source after the last operation")
+	}
+
+	return composite, nil
+}
+
+// nodeFilter - The filter of a label or cordoned term
+func nodeFilter(kind string, arg string) func(discovery.Node) bool {
+
+	if kind == "cordoned" {
+		return func(n discovery.Node) bool { return n.Unschedulable }
+	}
+
+	key, value, hasValue := arg, "", false
+	if i := strings.Index(arg, "="); i >= 0 {
+		key, value, hasValue = arg[:i], arg[i+1:], true
+	}
+
+	return func(n discovery.Node) bool {
+		v, ok := n.Labels[key]
+		return ok && (!hasValue || v == value)
+	}
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+	"github.com/rsvancara/linode-tools/internal/tracing"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// traced adds a span to the reconcile in progress for every call to a backend
+type traced struct {
+	backend.Backend
+}
+
+// Render - Render the backend in a span
+func (t traced) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	span := tracing.Start("render " + t.Name())
+
+	rendered, err := t.Backend.Render(ctx, nodes)
+	span.SetAttribute("lines", len(rendered))
+	span.End(err)
+
+	return rendered, err
+}
+
+// Validate - Validate the backend in a span
+func (t traced) Validate(ctx context.Context, rendered []string) error {
+
+	span := tracing.Start("validate " + t.Name())
+
+	err := t.Backend.Validate(ctx, rendered)
+	span.End(err)
+
+	return err
+}
+
+// Apply - Apply the backend in a span, the writes and reloads of the backend are spans next to it
+func (t traced) Apply(ctx context.Context, rendered []string) error {
+
+	span := tracing.Start("apply " + t.Name())
+
+	err := t.Backend.Apply(ctx, rendered)
+	span.End(err)
+
+	return err
+}
+
+// Rollback - Roll the backend back in a span
+func (t traced) Rollback(ctx context.Context) error {
+
+	span := tracing.Start("rollback " + t.Name())
+
+	err := t.Backend.Rollback(ctx)
+	span.End(err)
+
+	return err
+}
@@ -0,0 +1,242 @@
+// Package cloudflare keeps the origins of Cloudflare load balancer pools in sync with the nodes, for
+// traffic entering through Cloudflare rather than a load balancer on a Linode.
+package cloudflare
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/cloudflare"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// pool keeps the origins of a Cloudflare pool named with the prefix as a backend.Backend, the other
+// origins of the pool are left alone
+type pool struct {
+	client    *cloudflare.Client
+	accountID string
+	poolID    string
+	prefix    string
+	auditLog  *audit.Log
+
+	// The origins rendered by the last Render, and those of the pool before the last Apply
+	origins  []cloudflare.Origin
+	previous []cloudflare.Origin
+	lines    []string
+}
+
+// Name - The id of the pool
+func (p *pool) Name() string {
+	return "cloudflare pool " + p.poolID
+}
+
+// Render - An origin for every node, named after it with the prefix.  Cordoned nodes are disabled, so
+// Cloudflare stops sending them requests before they go away.
+func (p *pool) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	sorted := make([]discovery.Node, len(nodes))
+	copy(sorted, nodes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	p.origins = nil
+	var lines []string
+
+	for _, n := range sorted {
+		origin := cloudflare.Origin{Name: p.prefix + n.Name, Address: n.IP.String(), Enabled: !n.Unschedulable, Weight: 1}
+		p.origins = append(p.origins, origin)
+		lines = append(lines, originLine(origin))
+	}
+
+	p.lines = lines
+
+	return lines, nil
+}
+
+// originLine - An origin as a rendered line: name, address and whether it is enabled
+func originLine(o cloudflare.Origin) string {
+
+	state := "enabled"
+	if !o.Enabled {
+		state = "disabled"
+	}
+
+	return fmt.Sprintf("%s %s %s", o.Name, o.Address, state)
+}
+
+// Validate - A pool without an enabled origin would fail every request sent to it
+func (p *pool) Validate(ctx context.Context, rendered []string) error {
+
+	for _, o := range p.origins {
+		if o.Enabled {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s would have no enabled node origin", p.Name())
+}
+
+// Apply - Replace the origins named with the prefix, keeping the other origins of the pool
+func (p *pool) Apply(ctx context.Context, rendered []string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	current, err := p.client.Pool(ctx, p.accountID, p.poolID)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", p.Name(), err)
+	}
+
+	var origins []cloudflare.Origin
+	var before []string
+	for _, o := range current.Origins {
+		if strings.HasPrefix(o.Name, p.prefix) {
+			before = append(before, originLine(o))
+			continue
+		}
+		origins = append(origins, o)
+	}
+
+	sort.Strings(before)
+	if strings.Join(before, "\n") == strings.Join(p.lines, "\n") {
+		log.Info().Msgf("no changes to %s", p.Name())
+		return nil
+	}
+
+	origins = append(origins, p.origins...)
+
+	log.Info().Msgf("updating the %d node origins of %s", len(p.origins), p.Name())
+
+	p.previous = current.Origins
+
+	if err := p.client.SetOrigins(ctx, p.accountID, p.poolID, origins); err != nil {
+		return fmt.Errorf("updating %s: %w", p.Name(), err)
+	}
+
+	added, removed := diff(before, p.lines)
+	p.auditLog.Write(audit.Record{Target: p.Name(), Added: added, Removed: removed, Hash: audit.Hash(p.lines), Reload: "updated through the api"})
+
+	return nil
+}
+
+// diff - The lines added and removed between two sorted renders
+func diff(oldLines []string, newLines []string) ([]string, []string) {
+
+	var added, removed []string
+
+	known := make(map[string]bool)
+	for _, line := range oldLines {
+		known[line] = true
+	}
+
+	current := make(map[string]bool)
+	for _, line := range newLines {
+		current[line] = true
+		if !known[line] {
+			added = append(added, line)
+		}
+	}
+
+	for _, line := range oldLines {
+		if !current[line] {
+			removed = append(removed, line)
+		}
+	}
+
+	return added, removed
+}
+
+// Rollback - Put back the origins the pool had before the last Apply
+func (p *pool) Rollback(ctx context.Context) error {
+
+	if p.previous == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	p.auditLog.Write(audit.Record{Target: p.Name(), Reload: "rolled back"})
+
+	return p.client.SetOrigins(ctx, p.accountID, p.poolID, p.previous)
+}
+
+// Run - Run the cloudflare subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("cloudflare", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var token string
+	fs.StringVar(&token, "cloudflare-token", os.Getenv("CLOUDFLARE_API_TOKEN"), "Cloudflare API token with the Load Balancing: Monitors and Pools edit permission, defaults to $CLOUDFLARE_API_TOKEN")
+
+	var accountID string
+	fs.StringVar(&accountID, "account-id", os.Getenv("CLOUDFLARE_ACCOUNT_ID"), "Cloudflare account the pools belong to, defaults to $CLOUDFLARE_ACCOUNT_ID")
+
+	var pools string
+	fs.StringVar(&pools, "pools", "", "comma separated ids of the origin pools whose origins are the nodes")
+
+	var prefix string
+	fs.StringVar(&prefix, "origin-prefix", "kube-", "prefix of the origins named after the nodes, origins without it are left alone")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if token == "" || accountID == "" {
+		return fmt.Errorf("the cloudflare api needs -cloudflare-token and -account-id")
+	}
+
+	if prefix == "" {
+		return fmt.Errorf("-origin-prefix cannot be empty, it tells the node origins from the others")
+	}
+
+	common.Start(fs.Name())
+
+	client := cloudflare.NewClient(token)
+	auditLog := audit.Open(common.AuditLog, fs.Name())
+
+	var backends []backend.Backend
+	for _, id := range strings.Split(pools, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			backends = append(backends, &pool{client: client, accountID: accountID, poolID: id, prefix: prefix, auditLog: auditLog})
+		}
+	}
+
+	if len(backends) == 0 {
+		return fmt.Errorf("no pools given in -pools")
+	}
+
+	log.Info().Msgf("keeping the origins of %d cloudflare pools", len(backends))
+
+	if common.Output != "" {
+		return common.Print(backends)
+	}
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+
+	backends = common.Track(backends)
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
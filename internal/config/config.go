@@ -0,0 +1,367 @@
+// Package config loads the YAML files the tools are configured with into typed structs.  Every key of the file
+// is checked against the struct before it is used, so a typo or a value of the wrong type is reported with the
+// file, the line and the field instead of silently leaving the field at its zero value.
+//
+// Besides the yaml tag, the fields of the structs take two tags:
+//
+//	default:"in"                  the value of the field when the file leaves it empty
+//	validate:"required,min=1"     required, min=N and max=N for numbers and lists, oneof=a b c for strings
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a problem with a value of a config file
+type Error struct {
+	File string
+	Line int
+
+	// Field is the path of the value, for example upstreams[2].port, empty for the whole file
+	Field string
+	Msg   string
+}
+
+func (e *Error) Error() string {
+
+	where := e.File
+	if e.Line > 0 {
+		where = fmt.Sprintf("%s:%d", e.File, e.Line)
+	}
+
+	if e.Field == "" {
+		return where + ": " + e.Msg
+	}
+
+	return fmt.Sprintf("%s: %s: %s", where, e.Field, e.Msg)
+}
+
+// Errors are all the problems found in a config file, one per line
+type Errors []*Error
+
+func (e Errors) Error() string {
+
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Load - Read a YAML file into out, a pointer to a struct, see Decode
+func Load(path string, out interface{}) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return Decode(path, data, out)
+}
+
+// Decode - Decode YAML into out, a pointer to a struct, then set the defaults and check the validate tags.  The
+// problems are returned as Errors naming file, which is only used in the messages.
+func Decode(file string, data []byte, out interface{}) error {
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Errors{syntaxError(file, err)}
+	}
+
+	c := &checker{file: file, lines: make(map[string]int)}
+
+	if len(doc.Content) > 0 {
+		c.check(doc.Content[0], reflect.TypeOf(out).Elem(), "")
+	}
+
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+
+	if len(doc.Content) > 0 {
+		if err := doc.Content[0].Decode(out); err != nil {
+			return Errors{syntaxError(file, err)}
+		}
+	}
+
+	SetDefaults(out)
+	c.validate(reflect.ValueOf(out).Elem(), "")
+
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+
+	return nil
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// syntaxError - An error of the yaml package, with the line it names taken out of the message
+func syntaxError(file string, err error) *Error {
+
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+
+	if m := yamlLine.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return &Error{File: file, Line: line, Msg: msg[len(m[0]):]}
+	}
+
+	return &Error{File: file, Msg: msg}
+}
+
+// checker collects the problems of a file, and the line of every value to report the validate tags at
+type checker struct {
+	file  string
+	errs  Errors
+	lines map[string]int
+}
+
+func (c *checker) fail(line int, field string, format string, args ...interface{}) {
+	c.errs = append(c.errs, &Error{File: c.file, Line: line, Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+var (
+	unmarshalerV2 = reflect.TypeOf((*interface {
+		UnmarshalYAML(func(interface{}) error) error
+	})(nil)).Elem()
+	unmarshalerV3 = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+)
+
+// check - Compare a node of the file with the type it is decoded into
+func (c *checker) check(n *yaml.Node, t reflect.Type, field string) {
+
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	c.lines[field] = n.Line
+
+	if n.ShortTag() == "!!null" {
+		return
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types decoding themselves, such as a scalar or a list, check their own values
+	if reflect.PtrTo(t).Implements(unmarshalerV2) || reflect.PtrTo(t).Implements(unmarshalerV3) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return
+
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			c.fail(n.Line, field, "expected a mapping, got %s", describe(n))
+			return
+		}
+
+		fields := structFields(t)
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+
+			f, ok := fields[key.Value]
+			if !ok {
+				c.fail(key.Line, join(field, key.Value), "unknown field, expected one of %s", strings.Join(fieldNames(t), ", "))
+				continue
+			}
+
+			c.check(value, f.Type, join(field, key.Value))
+		}
+
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			c.fail(n.Line, field, "expected a mapping, got %s", describe(n))
+			return
+		}
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			c.check(n.Content[i+1], t.Elem(), join(field, n.Content[i].Value))
+		}
+
+	case reflect.Slice, reflect.Array:
+		if n.Kind != yaml.SequenceNode {
+			c.fail(n.Line, field, "expected a list, got %s", describe(n))
+			return
+		}
+
+		for i, item := range n.Content {
+			c.check(item, t.Elem(), fmt.Sprintf("%s[%d]", field, i))
+		}
+
+	default:
+		if n.Kind != yaml.ScalarNode {
+			c.fail(n.Line, field, "expected %s, got %s", expected(t), describe(n))
+			return
+		}
+
+		if !scalarFits(n, t) {
+			c.fail(n.Line, field, "expected %s, got %s", expected(t), describe(n))
+		}
+	}
+}
+
+// scalarFits - Whether a scalar can be decoded into a value of the kind
+func scalarFits(n *yaml.Node, t reflect.Type) bool {
+
+	tag := n.ShortTag()
+
+	switch t.Kind() {
+	case reflect.String:
+		return true
+	case reflect.Bool:
+		return tag == "!!bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// durations are written as 30s
+		if t == reflect.TypeOf(yamlDuration) {
+			return tag == "!!int" || validDuration(n.Value)
+		}
+		return tag == "!!int"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return tag == "!!int" && !strings.HasPrefix(n.Value, "-")
+	case reflect.Float32, reflect.Float64:
+		return tag == "!!int" || tag == "!!float"
+	}
+
+	return false
+}
+
+// expected - How the values of a kind are described in the problems
+func expected(t reflect.Type) string {
+
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t == reflect.TypeOf(yamlDuration) {
+			return "a duration such as 30s"
+		}
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a positive integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+
+	return t.Kind().String()
+}
+
+// describe - A node as named in the problems
+func describe(n *yaml.Node) string {
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+
+	switch n.ShortTag() {
+	case "!!int", "!!float":
+		return "the number " + n.Value
+	case "!!bool":
+		return n.Value
+	}
+
+	return strconv.Quote(n.Value)
+}
+
+// structFields - The fields of a struct by their key in the file, with the fields of inlined structs
+func structFields(t reflect.Type) map[string]reflect.StructField {
+
+	fields := make(map[string]reflect.StructField)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, inline := yamlKey(f)
+		if name == "-" {
+			continue
+		}
+
+		if inline {
+			for k, v := range structFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+
+		fields[name] = f
+	}
+
+	return fields
+}
+
+// fieldNames - The keys of a struct in the order of its fields
+func fieldNames(t reflect.Type) []string {
+
+	var names []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, inline := yamlKey(f)
+		if name == "-" {
+			continue
+		}
+
+		if inline {
+			names = append(names, fieldNames(f.Type)...)
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// yamlKey - The key of a field in the file, the lowercased field name unless the yaml tag names it, and
+// whether the field is inlined
+func yamlKey(f reflect.StructField) (string, bool) {
+
+	parts := strings.Split(f.Tag.Get("yaml"), ",")
+
+	inline := false
+	for _, flag := range parts[1:] {
+		if flag == "inline" {
+			inline = true
+		}
+	}
+
+	if parts[0] == "" {
+		return strings.ToLower(f.Name), inline
+	}
+
+	return parts[0], inline
+}
+
+// join - The path of a key under a field
+func join(field string, key string) string {
+
+	if field == "" {
+		return key
+	}
+
+	return field + "." + key
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// yamlDuration is the type of the fields written as durations, such as 30s
+var yamlDuration time.Duration
+
+func validDuration(s string) bool {
+	_, err := time.ParseDuration(s)
+	return err == nil
+}
+
+// SetDefaults - Set the empty fields of a pointer to a struct, and of the structs it holds, to their default tag
+func SetDefaults(v interface{}) {
+	setDefaults(reflect.ValueOf(v))
+}
+
+func setDefaults(v reflect.Value) {
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			setDefaults(v.Elem())
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			setDefaults(v.Index(i))
+		}
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			if def, ok := f.Tag.Lookup("default"); ok && v.Field(i).IsZero() && v.Field(i).CanSet() {
+				if err := setScalar(v.Field(i), def); err != nil {
+					panic(fmt.Sprintf("invalid default of %s.%s: %v", t.Name(), f.Name, err))
+				}
+			}
+
+			setDefaults(v.Field(i))
+		}
+	}
+}
+
+// setScalar - Set a string, number, bool or duration field from the text of a default tag
+func setScalar(v reflect.Value, text string) error {
+
+	if v.Type() == reflect.TypeOf(yamlDuration) {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("a %s cannot have a default", v.Kind())
+	}
+
+	return nil
+}
+
+// validate - Check the validate tags of the fields of a value decoded from the file
+func (c *checker) validate(v reflect.Value, field string) {
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			c.validate(v.Elem(), field)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			c.validate(v.Index(i), fmt.Sprintf("%s[%d]", field, i))
+		}
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			name, inline := yamlKey(f)
+			if name == "-" {
+				continue
+			}
+
+			path := join(field, name)
+			if inline {
+				path = field
+			}
+
+			if rules := f.Tag.Get("validate"); rules != "" {
+				for _, rule := range strings.Split(rules, ",") {
+					if msg := check(v.Field(i), rule); msg != "" {
+						c.fail(c.line(path), path, "%s", msg)
+					}
+				}
+			}
+
+			c.validate(v.Field(i), path)
+		}
+	}
+}
+
+// line - The line of a value, or of the closest value holding it when the file leaves it out
+func (c *checker) line(field string) int {
+
+	for {
+		if line, ok := c.lines[field]; ok {
+			return line
+		}
+
+		i := strings.LastIndexAny(field, ".[")
+		if i < 0 {
+			return c.lines[""]
+		}
+		field = field[:i]
+	}
+}
+
+// check - The problem of a value with a rule of a validate tag, empty when it passes.  Only required applies to
+// a value left empty.
+func check(v reflect.Value, rule string) string {
+
+	name, arg := rule, ""
+	if i := strings.Index(rule, "="); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	if name == "required" {
+		if v.IsZero() {
+			return "is required"
+		}
+		return ""
+	}
+
+	if v.IsZero() {
+		return ""
+	}
+
+	switch name {
+	case "oneof":
+		allowed := strings.Fields(arg)
+		for _, a := range allowed {
+			if fmt.Sprint(v.Interface()) == a {
+				return ""
+			}
+		}
+		return fmt.Sprintf("expected one of %s, got %q", strings.Join(allowed, ", "), fmt.Sprint(v.Interface()))
+
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid validate rule %s", rule))
+		}
+
+		n, what := number(v)
+		if name == "min" && n < limit {
+			return fmt.Sprintf("expected %s of at least %s, got %v", what, arg, n)
+		}
+		if name == "max" && n > limit {
+			return fmt.Sprintf("expected %s of at most %s, got %v", what, arg, n)
+		}
+		return ""
+	}
+
+	panic(fmt.Sprintf("unknown validate rule %s", rule))
+}
+
+// number - The value of a number, or the length of a string or a list, compared by min and max
+func number(v reflect.Value) (float64, string) {
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "a value"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), "a value"
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "a value"
+	}
+
+	return float64(v.Len()), "a length"
+}
@@ -0,0 +1,269 @@
+// Package configfile reads and writes the line based configuration files managed by the linode tools.
+package configfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// unterminated holds the files read without a line feed after their last line, so Write leaves it out too
+var unterminated sync.Map
+
+// Read - Read the lines of a config file, an absent file is treated as empty.  See Lines for how the
+// file is split.  Whether the last line ended with a line feed is recorded for the path and kept by Write.
+func Read(path string) ([]string, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			unterminated.Delete(path)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if Terminated(data) {
+		unterminated.Delete(path)
+	} else {
+		unterminated.Store(path, true)
+	}
+
+	return Lines(data), nil
+}
+
+// Lines - The lines of the content of a file, split on the line feeds alone so a line of a file with CRLF line
+// endings keeps its carriage return, and the file is written back byte for byte with Write.  Lines have no
+// length limit.  The line feed at the end of the last line is not part of the lines, see Terminated.
+func Lines(data []byte) []string {
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// Terminated - Whether the content is empty or its last line ends with a line feed
+func Terminated(data []byte) bool {
+	return len(data) == 0 || data[len(data)-1] == '\n'
+}
+
+// Content - The lines joined into the content of a file, a line feed after every line but the last one when
+// terminated is false
+func Content(lines []string, terminated bool) []byte {
+
+	var content bytes.Buffer
+	for i, line := range lines {
+		content.WriteString(line)
+		if terminated || i < len(lines)-1 {
+			content.WriteByte('\n')
+		}
+	}
+
+	return content.Bytes()
+}
+
+// Write - Replace the config file with the given lines, giving it the Default permissions
+func Write(ctx context.Context, path string, lines []string) error {
+	return WriteWith(ctx, path, lines, Default)
+}
+
+// WriteWith - Write the lines of a config file with the mode and ownership of the permissions, then relabel
+// it with Restorecon.  The last line ends with a line feed unless the file was read without one.  The file is
+// replaced at once, see replace.
+func WriteWith(ctx context.Context, path string, lines []string, perm Permissions) error {
+
+	_, unterminatedFile := unterminated.Load(path)
+
+	if err := replace(path, Content(lines, !unterminatedFile), 0666, perm); err != nil {
+		return err
+	}
+
+	return relabel(ctx, path)
+}
+
+// replace - Write the data to a temporary file in the directory of the file, sync it and rename it over the
+// file, so a crash or a full disk never leaves a half written config for nginx to load.  The temporary file
+// takes the mode and ownership of the file it replaces, or mode when there is none, then the permissions.  A
+// link is followed and the file it points to replaced.
+func replace(path string, data []byte, mode os.FileMode, perm Permissions) error {
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	existing, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d.tmp", filepath.Base(path), time.Now().UnixNano()))
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmp)
+		}
+	}()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing %s: %w", tmp, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", tmp, err)
+	}
+
+	if existing != nil {
+		if err := os.Chmod(tmp, existing.Mode().Perm()); err != nil {
+			return fmt.Errorf("keeping the mode of %s: %w", path, err)
+		}
+		if err := chownLike(tmp, existing); err != nil {
+			return fmt.Errorf("keeping the ownership of %s: %w", path, err)
+		}
+	}
+
+	if err := perm.apply(tmp); err != nil {
+		return fmt.Errorf("setting the permissions of %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	renamed = true
+
+	return nil
+}
+
+// backupLayout is the timestamp appended to backups, it sorts in the order the backups were taken
+const backupLayout = "20060102T150405.000000000Z"
+
+// Backup - Copy the config file aside with a timestamp before it is overwritten, keeping only the
+// newest keep backups.  Nothing is done when the config file does not exist yet.
+func Backup(path string, keep int) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().UTC().Format(backupLayout))
+	if err := os.WriteFile(backup, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("saved backup of %s to %s", path, backup)
+
+	backups, err := Backups(path)
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > keep {
+		oldest := backups[len(backups)-1]
+		if err := os.Remove(oldest); err != nil {
+			return err
+		}
+		backups = backups[:len(backups)-1]
+	}
+
+	return nil
+}
+
+// Backups - The backups of a config file, newest first
+func Backups(path string) ([]string, error) {
+
+	backups, err := filepath.Glob(path + ".*.bak")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	return backups, nil
+}
+
+// Rollback - Restore the newest backup over the config file.  The restored backup is removed so
+// rolling back again restores the version before it.
+func Rollback(ctx context.Context, path string) (string, error) {
+
+	backups, err := Backups(path)
+	if err != nil {
+		return "", err
+	}
+
+	if len(backups) == 0 {
+		return "", fmt.Errorf("no backups of %s found", path)
+	}
+
+	data, err := os.ReadFile(backups[0])
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(backups[0])
+	if err != nil {
+		return "", err
+	}
+
+	if err := replace(path, data, info.Mode().Perm(), Permissions{}); err != nil {
+		return "", err
+	}
+
+	if err := relabel(ctx, path); err != nil {
+		return "", err
+	}
+
+	log.Info().Msgf("restored %s from %s", path, backups[0])
+
+	return backups[0], os.Remove(backups[0])
+}
+
+// Equal - Check if the lines of two configs are the same
+func Equal(a []string, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
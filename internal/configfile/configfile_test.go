@@ -0,0 +1,75 @@
+package configfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWrite checks the file is replaced keeping its mode, through a link, without a temporary file left behind
+func TestWrite(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nginx.conf")
+	if err := os.WriteFile(path, []byte("old\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(dir, "enabled.conf")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Write(context.Background(), link, []string{"new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "new\n" {
+		t.Errorf("%s holds %q, %v", path, data, err)
+	}
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s is no longer a link: %v", link, err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("%s has mode %v, expected 0640: %v", path, info.Mode().Perm(), err)
+	}
+
+	if err := WriteWith(context.Background(), path, []string{"newer"}, Permissions{Mode: "0600"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("%s has mode %v, expected 0600: %v", path, info.Mode().Perm(), err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		for _, e := range entries {
+			t.Log(e.Name())
+		}
+		t.Errorf("%d files in %s, expected the config file and the link", len(entries), dir)
+	}
+}
+
+// TestWriteFails checks a failed write leaves the file as it was
+func TestWriteFails(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "nginx.conf")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteWith(context.Background(), path, []string{"new"}, Permissions{Owner: "no-such-user-of-the-tests"}); err == nil {
+		t.Fatal("the write succeeded with an unknown owner")
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "old\n" {
+		t.Errorf("%s holds %q after a failed write, %v", path, data, err)
+	}
+}
@@ -0,0 +1,143 @@
+package configfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// InsertMarkers appends the managed block between new markers to a file without them, set from the
+// -insert-markers flag.  When false such a file is an error, so the markers are placed by hand.
+var InsertMarkers = true
+
+// Markers are the lines around the managed block of a file, the rest of the file is written by hand
+type Markers struct {
+	Begin string
+	End   string
+}
+
+// Validate - Both markers are given and tell apart
+func (m Markers) Validate() error {
+
+	if strings.TrimSpace(m.Begin) == "" || strings.TrimSpace(m.End) == "" {
+		return fmt.Errorf("the begin and end markers must not be empty")
+	}
+
+	if strings.TrimSpace(m.Begin) == strings.TrimSpace(m.End) {
+		return fmt.Errorf("the begin and end markers must differ, both are %q", m.Begin)
+	}
+
+	return nil
+}
+
+// find - The lines of the begin and end markers, -1 when the file has no managed block.  A marker is a line
+// holding the marker alone, give or take surrounding blanks and a carriage return, so a marker commented out or
+// quoted inside another line is content.  A begin marker without an end marker after it, an end marker without a
+// begin marker before it, a block inside the block or a second block are errors: the block cannot be told from
+// the hand written content.
+func (m Markers) find(existing []string) (int, int, error) {
+
+	begin, end := -1, -1
+
+	for i, line := range existing {
+		switch strings.TrimSpace(line) {
+		case strings.TrimSpace(m.Begin):
+			switch {
+			case begin >= 0 && end < 0:
+				return -1, -1, fmt.Errorf("line %d has %s inside the block started on line %d", i+1, m.Begin, begin+1)
+			case begin >= 0:
+				return -1, -1, fmt.Errorf("line %d starts a second block, the first is on lines %d to %d", i+1, begin+1, end+1)
+			}
+			begin = i
+		case strings.TrimSpace(m.End):
+			switch {
+			case begin < 0:
+				return -1, -1, fmt.Errorf("line %d has %s without %s before it", i+1, m.End, m.Begin)
+			case end >= 0:
+				return -1, -1, fmt.Errorf("line %d has %s after the block ended on line %d", i+1, m.End, end+1)
+			}
+			end = i
+		}
+	}
+
+	if begin >= 0 && end < 0 {
+		return -1, -1, fmt.Errorf("line %d has %s but %s cannot be found after it", begin+1, m.Begin, m.End)
+	}
+
+	return begin, end, nil
+}
+
+// lineEnding - The carriage return ending the lines of a file with CRLF line endings, told by its first line,
+// empty otherwise
+func lineEnding(existing []string) string {
+
+	if len(existing) > 0 && strings.HasSuffix(existing[0], "\r") {
+		return "\r"
+	}
+
+	return ""
+}
+
+// Merge - Replace the block between the markers with the generated lines, preserving the hand written content
+// around it line for line, and the markers too when they are there.  The block takes the line endings of the
+// file.  A file without the markers gets the block appended with new markers when it is empty or InsertMarkers
+// is set, and is an error otherwise.
+func (m Markers) Merge(existing []string, generated []string) ([]string, error) {
+
+	begin, end, err := m.find(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	eol := lineEnding(existing)
+
+	var block []string
+	if begin >= 0 {
+		block = append(block, existing[begin])
+	} else {
+		block = append(block, m.Begin+eol)
+	}
+	for _, line := range generated {
+		block = append(block, line+eol)
+	}
+	if end >= 0 {
+		block = append(block, existing[end])
+	} else {
+		block = append(block, m.End+eol)
+	}
+
+	var merged []string
+
+	if begin < 0 {
+		if len(existing) > 0 && !InsertMarkers {
+			return nil, fmt.Errorf("%s and %s cannot be found, add them where the managed block goes or give -insert-markers", m.Begin, m.End)
+		}
+		if len(existing) > 0 {
+			log.Warn().Msgf("%s cannot be found, appending the managed block between new markers", m.Begin)
+		}
+		merged = append(merged, existing...)
+		return append(merged, block...), nil
+	}
+
+	merged = append(merged, existing[:begin]...)
+	merged = append(merged, block...)
+
+	return append(merged, existing[end+1:]...), nil
+}
+
+// Block - The lines between the markers, nil when the file has no managed block
+func (m Markers) Block(existing []string) ([]string, error) {
+
+	begin, end, err := m.find(existing)
+	if err != nil || begin < 0 {
+		return nil, err
+	}
+
+	var block []string
+	for _, line := range existing[begin+1 : end] {
+		block = append(block, strings.TrimSuffix(line, "\r"))
+	}
+
+	return block, nil
+}
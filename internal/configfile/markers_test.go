@@ -0,0 +1,204 @@
+package configfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var markers = Markers{Begin: "### RULES ###", End: "### END RULES ###"}
+
+// roundTrip - Read the content from a file, merge the generated lines into it and write it back
+func roundTrip(t *testing.T, content string, generated []string) string {
+
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "user.rules")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := markers.Merge(existing, generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Write(context.Background(), path, merged); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// TestRoundTrip checks the content around the block is written back byte for byte when the block is unchanged
+func TestRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"block", "# hand written\n\n### RULES ###\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\n### END RULES ###\n\nCOMMIT\n"},
+		{"no final newline", "*filter\n### RULES ###\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\n### END RULES ###\nCOMMIT"},
+		{"block at the end without final newline", "*filter\n### RULES ###\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\n### END RULES ###"},
+		{"crlf", "*filter\r\n### RULES ###\r\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\r\n### END RULES ###\r\nCOMMIT\r\n"},
+		{"crlf without final newline", "*filter\r\n### RULES ###\r\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\r\n### END RULES ###\r\nCOMMIT\r"},
+		{"blank lines and trailing spaces", "\n\n*filter  \n\t### RULES ###  \n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\n### END RULES ###\n\n\n"},
+		{"markers in comments", "# keep ### RULES ### here\n### RULES ###\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\n### END RULES ###\n# ### END RULES ### is the end\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := roundTrip(t, tt.content, []string{"-A ufw-user-input -s 10.0.0.1 -j ACCEPT"}); got != tt.content {
+				t.Errorf("wrote %q, expected %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+
+	generated := []string{"-A ufw-user-input -s 10.0.0.1 -j ACCEPT", "-A ufw-user-input -s 10.0.0.2 -j ACCEPT"}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		insert  bool
+		err     string
+	}{
+		{
+			name:    "replaces the block",
+			content: "*filter\n### RULES ###\n-A ufw-user-input -s 10.0.0.9 -j ACCEPT\n### END RULES ###\nCOMMIT\n",
+			want:    "*filter\n### RULES ###\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\n-A ufw-user-input -s 10.0.0.2 -j ACCEPT\n### END RULES ###\nCOMMIT\n",
+		},
+		{
+			name:    "crlf block takes the line endings of the file",
+			content: "*filter\r\n### RULES ###\r\n### END RULES ###\r\nCOMMIT\r\n",
+			want:    "*filter\r\n### RULES ###\r\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\r\n-A ufw-user-input -s 10.0.0.2 -j ACCEPT\r\n### END RULES ###\r\nCOMMIT\r\n",
+		},
+		{
+			name:    "commented markers are content",
+			content: "# ### RULES ###\n### RULES ###\n### END RULES ###\n# ### END RULES ###\n",
+			want:    "# ### RULES ###\n### RULES ###\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\n-A ufw-user-input -s 10.0.0.2 -j ACCEPT\n### END RULES ###\n# ### END RULES ###\n",
+		},
+		{
+			name:    "empty file gets the block",
+			content: "",
+			want:    "### RULES ###\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\n-A ufw-user-input -s 10.0.0.2 -j ACCEPT\n### END RULES ###\n",
+		},
+		{
+			name:    "missing markers are inserted",
+			content: "*filter\nCOMMIT\n",
+			insert:  true,
+			want:    "*filter\nCOMMIT\n### RULES ###\n-A ufw-user-input -s 10.0.0.1 -j ACCEPT\n-A ufw-user-input -s 10.0.0.2 -j ACCEPT\n### END RULES ###\n",
+		},
+		{
+			name:    "missing markers",
+			content: "*filter\nCOMMIT\n",
+			err:     "cannot be found",
+		},
+		{
+			name:    "missing end marker",
+			content: "*filter\n### RULES ###\n-A hand written\nCOMMIT\n",
+			insert:  true,
+			err:     "line 2 has ### RULES ### but ### END RULES ### cannot be found after it",
+		},
+		{
+			name:    "missing begin marker",
+			content: "*filter\n-A hand written\n### END RULES ###\nCOMMIT\n",
+			insert:  true,
+			err:     "line 3 has ### END RULES ### without ### RULES ### before it",
+		},
+		{
+			name:    "nested block",
+			content: "### RULES ###\n### RULES ###\n### END RULES ###\n### END RULES ###\n",
+			err:     "line 2 has ### RULES ### inside the block started on line 1",
+		},
+		{
+			name:    "second block",
+			content: "### RULES ###\n### END RULES ###\n### RULES ###\n### END RULES ###\n",
+			err:     "line 3 starts a second block, the first is on lines 1 to 2",
+		},
+		{
+			name:    "second end marker",
+			content: "### RULES ###\n### END RULES ###\n### END RULES ###\n",
+			err:     "line 3 has ### END RULES ### after the block ended on line 2",
+		},
+	}
+
+	defer func(insert bool) { InsertMarkers = insert }(InsertMarkers)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			InsertMarkers = tt.insert
+
+			merged, err := markers.Merge(Lines([]byte(tt.content)), generated)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Merge returned %v, expected an error with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := string(Content(merged, true)); got != tt.want {
+				t.Errorf("merged %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlock(t *testing.T) {
+
+	block, err := markers.Block(Lines([]byte("*filter\r\n### RULES ###\r\n-A one\r\n# comment\r\n### END RULES ###\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"-A one", "# comment"}; !reflect.DeepEqual(block, want) {
+		t.Errorf("block %q, expected %q", block, want)
+	}
+
+	if block, err := markers.Block(Lines([]byte("*filter\n"))); err != nil || block != nil {
+		t.Errorf("Block of a file without markers returned %q, %v", block, err)
+	}
+}
+
+func TestLines(t *testing.T) {
+
+	for _, content := range []string{"", "\n", "a", "a\n", "a\nb", "a\nb\n", "a\r\nb\r\n", "a\n\n", "\n\na"} {
+		if got := string(Content(Lines([]byte(content)), Terminated([]byte(content)))); got != content {
+			t.Errorf("%q came back as %q", content, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	for _, m := range []Markers{{Begin: "", End: "# END"}, {Begin: "# BEGIN", End: " "}, {Begin: "# SAME", End: "# SAME "}} {
+		if err := m.Validate(); err == nil {
+			t.Errorf("%+v is valid", m)
+		}
+	}
+
+	if err := markers.Validate(); err != nil {
+		t.Error(err)
+	}
+}
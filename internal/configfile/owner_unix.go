@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package configfile
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike - Give a file the owner and group of another file, left alone when they already match
+func chownLike(path string, like os.FileInfo) error {
+
+	want, ok := like.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if have, ok := info.Sys().(*syscall.Stat_t); ok && have.Uid == want.Uid && have.Gid == want.Gid {
+		return nil
+	}
+
+	return os.Chown(path, int(want.Uid), int(want.Gid))
+}
@@ -0,0 +1,8 @@
+package configfile
+
+import "os"
+
+// chownLike - Windows files have no owner and group to copy
+func chownLike(path string, like os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,107 @@
+package configfile
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// Permissions are the mode and ownership config files are given after they are written.  A zero
+// Mode keeps the mode of the file, and an empty Owner or Group keeps the user or group.
+type Permissions struct {
+	Mode  string `yaml:"mode"`
+	Owner string `yaml:"owner"`
+	Group string `yaml:"group"`
+}
+
+// Default are the permissions of the files written without their own, set from the -file-mode,
+// -file-owner and -file-group flags
+var Default Permissions
+
+// Or - The permissions, with the fields that are not set taken from other
+func (p Permissions) Or(other Permissions) Permissions {
+
+	if p.Mode == "" {
+		p.Mode = other.Mode
+	}
+	if p.Owner == "" {
+		p.Owner = other.Owner
+	}
+	if p.Group == "" {
+		p.Group = other.Group
+	}
+
+	return p
+}
+
+// Validate - Check the mode is octal and the owner and group exist
+func (p Permissions) Validate() error {
+
+	_, _, _, err := p.resolve()
+	return err
+}
+
+// apply - Set the mode and ownership of a file
+func (p Permissions) apply(path string) error {
+
+	mode, uid, gid, err := p.resolve()
+	if err != nil {
+		return err
+	}
+
+	if p.Mode != "" {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+
+	if uid >= 0 || gid >= 0 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolve - The mode, and the uid and gid of the owner and group, -1 for those not set
+func (p Permissions) resolve() (os.FileMode, int, int, error) {
+
+	var mode os.FileMode
+	if p.Mode != "" {
+		m, err := strconv.ParseUint(p.Mode, 8, 32)
+		if err != nil || m > 0777 {
+			return 0, -1, -1, fmt.Errorf("file mode %s is not an octal mode such as 0640", p.Mode)
+		}
+		mode = os.FileMode(m)
+	}
+
+	uid, gid := -1, -1
+
+	if p.Owner != "" {
+		id, err := strconv.Atoi(p.Owner)
+		if err != nil {
+			u, lookupErr := user.Lookup(p.Owner)
+			if lookupErr != nil {
+				return 0, -1, -1, fmt.Errorf("unknown file owner %s: %w", p.Owner, lookupErr)
+			}
+			id, _ = strconv.Atoi(u.Uid)
+		}
+		uid = id
+	}
+
+	if p.Group != "" {
+		id, err := strconv.Atoi(p.Group)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(p.Group)
+			if lookupErr != nil {
+				return 0, -1, -1, fmt.Errorf("unknown file group %s: %w", p.Group, lookupErr)
+			}
+			id, _ = strconv.Atoi(g.Gid)
+		}
+		gid = id
+	}
+
+	return mode, uid, gid, nil
+}
@@ -0,0 +1,30 @@
+package configfile
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+)
+
+// Restorecon is the restorecon command run on every written config file so it gets the SELinux context
+// of its path, set from the -restorecon flag.  Empty leaves the context alone.
+var Restorecon string
+
+// relabel - Reset the SELinux context of a written file with restorecon, when configured
+func relabel(ctx context.Context, path string) error {
+
+	if Restorecon == "" {
+		return nil
+	}
+
+	if err := hostexec.Run(hostexec.Command(ctx, Restorecon, hostexec.Path(path))).Err(); err != nil {
+		return fmt.Errorf("restoring the selinux context of %s failed: %w", path, err)
+	}
+
+	log.Debug().Msgf("restored the selinux context of %s", path)
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package configfile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// versionHashLength is the number of hex digits of the content hash in the name of a version
+const versionHashLength = 12
+
+// versionName - The version of a config file holding the lines, named after their hash: upstreams.conf has its
+// versions next to it as upstreams-<hash>.conf
+func versionName(path string, lines []string) string {
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n") + "\n"))
+
+	ext := filepath.Ext(path)
+
+	return strings.TrimSuffix(path, ext) + "-" + hex.EncodeToString(sum[:])[:versionHashLength] + ext
+}
+
+// isVersion - Whether a file is a version of the config file
+func isVersion(path string, candidate string) bool {
+
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + "-"
+
+	if !strings.HasPrefix(candidate, prefix) || !strings.HasSuffix(candidate, ext) {
+		return false
+	}
+
+	hash := strings.TrimSuffix(strings.TrimPrefix(candidate, prefix), ext)
+	if len(hash) != versionHashLength {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+
+	return err == nil
+}
+
+// WriteVersion - Write the lines to the version of the config file named after their hash and return its path.  A
+// version that already exists is not written again, only marked as the newest.
+func WriteVersion(ctx context.Context, path string, lines []string, perm Permissions) (string, error) {
+
+	version := versionName(path, lines)
+
+	if _, err := os.Stat(version); err == nil {
+		now := time.Now()
+		return version, os.Chtimes(version, now, now)
+	}
+
+	if err := WriteWith(ctx, version, lines, perm); err != nil {
+		return "", err
+	}
+
+	return version, nil
+}
+
+// Current - The version the config file links to, empty when it is not a link
+func Current(path string) string {
+
+	target, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+
+	return target
+}
+
+// Link - Point the config file at a version, atomically: a link is created next to it and renamed over it, so
+// the config file is never missing or half written.  The link is relative so it resolves under a host root too.
+// Returns the version linked before, empty when the config file was a regular file or missing.
+func Link(path string, version string) (string, error) {
+
+	previous := Current(path)
+
+	tmp := fmt.Sprintf("%s.%d.link", path, os.Getpid())
+	os.Remove(tmp)
+
+	if err := os.Symlink(filepath.Base(version), tmp); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	log.Info().Msgf("linked %s to %s", path, filepath.Base(version))
+
+	return previous, nil
+}
+
+// Versions - The versions of a config file, newest first
+func Versions(path string) ([]string, error) {
+
+	ext := filepath.Ext(path)
+
+	candidates, err := filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+
+	type version struct {
+		path     string
+		modified time.Time
+	}
+
+	var versions []version
+	for _, c := range candidates {
+		if !isVersion(path, c) {
+			continue
+		}
+
+		info, err := os.Lstat(c)
+		if err != nil {
+			continue
+		}
+
+		versions = append(versions, version{path: c, modified: info.ModTime()})
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].modified.After(versions[j].modified) })
+
+	paths := make([]string, len(versions))
+	for i, v := range versions {
+		paths[i] = v.path
+	}
+
+	return paths, nil
+}
+
+// PruneVersions - Remove all but the newest keep versions of a config file, never the one it links to
+func PruneVersions(path string, keep int) error {
+
+	versions, err := Versions(path)
+	if err != nil {
+		return err
+	}
+
+	current := Current(path)
+
+	for i, v := range versions {
+		if i < keep || v == current {
+			continue
+		}
+
+		if err := os.Remove(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RollbackVersion - Link the config file to the version written before the one it links to and return it.
+// Rolling back again links the version before that one.
+func RollbackVersion(path string) (string, error) {
+
+	versions, err := Versions(path)
+	if err != nil {
+		return "", err
+	}
+
+	current := Current(path)
+
+	for i, v := range versions {
+		if v != current {
+			continue
+		}
+
+		if i+1 >= len(versions) {
+			break
+		}
+
+		if _, err := Link(path, versions[i+1]); err != nil {
+			return "", err
+		}
+
+		// The version rolled back from is removed, so rolling back again goes further back
+		return versions[i+1], os.Remove(current)
+	}
+
+	return "", fmt.Errorf("no version of %s before %s found", path, filepath.Base(current))
+}
@@ -0,0 +1,45 @@
+package configfile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Watch - Check the file every interval and call changed when its content changed, so configuration
+// edits are picked up without restarting the daemon.  Polling the content also catches editors and
+// config management replacing the file instead of writing it in place.  Watch does not return.
+func Watch(path string, interval time.Duration, changed func()) {
+
+	last := sum(path)
+
+	for {
+		time.Sleep(interval)
+
+		current := sum(path)
+		if current == nil || bytes.Equal(current, last) {
+			continue
+		}
+
+		log.Info().Msgf("%s changed", path)
+
+		last = current
+		changed()
+	}
+}
+
+// sum - The sha256 of the content of the file, nil when it cannot be read
+func sum(path string) []byte {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	h := sha256.Sum256(data)
+
+	return h[:]
+}
@@ -0,0 +1,507 @@
+// Package consul registers the node ports of the cluster nodes as services of the Consul catalog, so
+// consumers using Consul DNS or consul-template follow the nodes without talking to the Kubernetes API.
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// The service annotations that register a service in Consul
+const (
+	serviceAnnotation = "kube-consul/service"
+	portAnnotation    = "kube-consul/port"
+)
+
+// source is the node meta marking the catalog nodes registered by kube-consul, so nodes that left the
+// cluster are found and deregistered even after a restart
+const source = "kube-consul"
+
+// service is a Consul service registered on the node port of every node
+type service struct {
+	Name string
+	Port int
+}
+
+// registration is a line of the rendered output: a service on a node
+type registration struct {
+	node    string
+	address string
+	service service
+}
+
+// catalog keeps the services of the nodes in the Consul catalog, kept as a backend.Backend
+type catalog struct {
+	addr       string
+	token      string
+	datacenter string
+	interval   string
+	http       *http.Client
+	auditLog   *audit.Log
+
+	// The services given with -services, and those discovered from the annotated services
+	mu         sync.Mutex
+	static     []service
+	discovered []service
+
+	// The registrations of the last successful Apply, put back by Rollback
+	applied []string
+}
+
+// Name - The Consul agent the catalog is updated through
+func (c *catalog) Name() string {
+	return "consul " + c.addr
+}
+
+// services - The services to register, those given with -services first
+func (c *catalog) services() []service {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	services := append([]service(nil), c.static...)
+
+	known := make(map[string]bool)
+	for _, s := range services {
+		known[s.Name] = true
+	}
+	for _, s := range c.discovered {
+		if !known[s.Name] {
+			services = append(services, s)
+		}
+	}
+
+	return services
+}
+
+// Render - A line for every service of every node: node, address, service and port
+func (c *catalog) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	var lines []string
+	for _, n := range nodes {
+		for _, s := range c.services() {
+			lines = append(lines, fmt.Sprintf("%s %s %s %d", n.Name, n.IP, s.Name, s.Port))
+		}
+	}
+
+	sort.Strings(lines)
+
+	return lines, nil
+}
+
+// Validate - Every line is a node, an address, a service and a port
+func (c *catalog) Validate(ctx context.Context, rendered []string) error {
+
+	_, err := parseRegistrations(rendered)
+	return err
+}
+
+// parseRegistrations - The registrations of the rendered lines
+func parseRegistrations(rendered []string) ([]registration, error) {
+
+	var registrations []registration
+
+	for _, line := range rendered {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid registration %q", line)
+		}
+
+		port, err := strconv.Atoi(fields[3])
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("registration %q has an invalid port", line)
+		}
+
+		registrations = append(registrations, registration{node: fields[0], address: fields[1], service: service{Name: fields[2], Port: port}})
+	}
+
+	return registrations, nil
+}
+
+// Apply - Register the services of every node and deregister the nodes and services registered by
+// kube-consul that are gone
+func (c *catalog) Apply(ctx context.Context, rendered []string) error {
+
+	registrations, err := parseRegistrations(rendered)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string][]registration)
+	for _, r := range registrations {
+		wanted[r.node] = append(wanted[r.node], r)
+	}
+
+	// Nodes registered by an earlier run that left the cluster
+	var owned []struct{ Node string }
+	if err := c.call(http.MethodGet, "/v1/catalog/nodes", url.Values{"node-meta": {"external-source:" + source}}, nil, &owned); err != nil {
+		return err
+	}
+
+	for _, n := range owned {
+		if _, ok := wanted[n.Node]; ok {
+			continue
+		}
+		log.Info().Msgf("deregistering node %s from consul", n.Node)
+		if err := c.call(http.MethodPut, "/v1/catalog/deregister", nil, map[string]string{"Node": n.Node}, nil); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for name := range wanted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := c.registerNode(name, wanted[name]); err != nil {
+			return err
+		}
+	}
+
+	record := audit.Record{Target: c.Name(), Hash: audit.Hash(rendered), Reload: "registered"}
+	record.Added, record.Removed = diffLines(c.applied, rendered)
+	c.auditLog.Write(record)
+
+	c.applied = rendered
+
+	return nil
+}
+
+// registerNode - Register the services of a node, with a TCP check for new services, and deregister the
+// services it no longer has
+func (c *catalog) registerNode(name string, registrations []registration) error {
+
+	// The services the node already has, nil when the node is not registered yet
+	var existing struct {
+		Services map[string]struct{ ID string }
+	}
+	if err := c.call(http.MethodGet, "/v1/catalog/node/"+url.PathEscape(name), nil, nil, &existing); err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+
+	for _, r := range registrations {
+		id := fmt.Sprintf("%s-%s-%d", source, r.service.Name, r.service.Port)
+		keep[id] = true
+
+		body := map[string]interface{}{
+			"Node":     name,
+			"Address":  r.address,
+			"NodeMeta": map[string]string{"external-node": "true", "external-probe": "true", "external-source": source},
+			"Service": map[string]interface{}{
+				"ID":      id,
+				"Service": r.service.Name,
+				"Port":    r.service.Port,
+				"Address": r.address,
+			},
+		}
+
+		// The check is only added with the service, so the status consul-esm found is not reset
+		if _, ok := existing.Services[id]; !ok {
+			body["Check"] = map[string]interface{}{
+				"CheckID":   "service:" + id,
+				"Name":      r.service.Name + " on " + name,
+				"ServiceID": id,
+				"Status":    "passing",
+				"Definition": map[string]string{
+					"TCP":      fmt.Sprintf("%s:%d", r.address, r.service.Port),
+					"Interval": c.interval,
+					"Timeout":  "5s",
+				},
+			}
+			log.Info().Msgf("registering service %s on node %s in consul", r.service.Name, name)
+		}
+
+		if err := c.call(http.MethodPut, "/v1/catalog/register", nil, body, nil); err != nil {
+			return err
+		}
+	}
+
+	for id := range existing.Services {
+		if keep[id] || !strings.HasPrefix(id, source+"-") {
+			continue
+		}
+		log.Info().Msgf("deregistering service %s of node %s from consul", id, name)
+		if err := c.call(http.MethodPut, "/v1/catalog/deregister", nil, map[string]string{"Node": name, "ServiceID": id}, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rollback - Register the services of the last applied nodes again
+func (c *catalog) Rollback(ctx context.Context) error {
+
+	c.auditLog.Write(audit.Record{Target: c.Name(), Reload: "rolled back"})
+
+	return c.Apply(ctx, c.applied)
+}
+
+// diffLines - The lines added and removed between two renders
+func diffLines(oldLines []string, newLines []string) ([]string, []string) {
+
+	var added, removed []string
+
+	known := make(map[string]bool)
+	for _, line := range oldLines {
+		known[line] = true
+	}
+
+	current := make(map[string]bool)
+	for _, line := range newLines {
+		current[line] = true
+		if !known[line] {
+			added = append(added, line)
+		}
+	}
+
+	for _, line := range oldLines {
+		if !current[line] {
+			removed = append(removed, line)
+		}
+	}
+
+	return added, removed
+}
+
+// call - Call the Consul HTTP API, decoding the JSON answer into result when given
+func (c *catalog) call(method string, path string, query url.Values, body interface{}, result interface{}) error {
+
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.datacenter != "" {
+		query.Set("dc", c.datacenter)
+	}
+
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	target := c.addr + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, target, payload)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("consul %s %s: %w", method, path, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("consul %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// parseServices - Parse a comma separated list of name:port services
+func parseServices(spec string) ([]service, error) {
+
+	var services []service
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("service %q is not name:port", item)
+		}
+
+		port, err := strconv.Atoi(parts[1])
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("service %q has an invalid port", item)
+		}
+
+		services = append(services, service{Name: parts[0], Port: port})
+	}
+
+	return services, nil
+}
+
+// annotatedServices - A Consul service for every Kubernetes service with the service annotation, on the
+// node port named or numbered by the port annotation, or the first one
+func annotatedServices(found []discovery.Service) []service {
+
+	var services []service
+
+	for _, svc := range found {
+		name, ok := svc.Annotations[serviceAnnotation]
+		if !ok {
+			continue
+		}
+		if name == "" || name == "true" {
+			name = svc.Namespace + "-" + svc.Name
+		}
+
+		port := svc.NodePorts[0].NodePort
+		if want, ok := svc.Annotations[portAnnotation]; ok {
+			port = 0
+			for _, p := range svc.NodePorts {
+				if p.Name == want || strconv.Itoa(p.Port) == want {
+					port = p.NodePort
+				}
+			}
+			if port == 0 {
+				log.Warn().Msgf("skipping service %s/%s, it has no node port for port %s", svc.Namespace, svc.Name, want)
+				continue
+			}
+		}
+
+		services = append(services, service{Name: name, Port: port})
+	}
+
+	return services
+}
+
+// Run - Run the consul subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("consul", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	c := &catalog{http: &http.Client{Timeout: 30 * time.Second}}
+
+	fs.StringVar(&c.addr, "consul-addr", "http://127.0.0.1:8500", "address of the Consul HTTP API")
+	fs.StringVar(&c.token, "consul-token", os.Getenv("CONSUL_HTTP_TOKEN"), "(optional) Consul ACL token, defaults to $CONSUL_HTTP_TOKEN")
+	fs.StringVar(&c.datacenter, "datacenter", "", "(optional) Consul datacenter the nodes are registered in, the one of the agent when empty")
+	fs.StringVar(&c.interval, "check-interval", "10s", "interval of the TCP check of every registered service, run by consul-esm")
+
+	var services string
+	fs.StringVar(&services, "services", "", "comma separated name:port services registered on the node port of every node, for example shop:30080")
+
+	var discoverServices bool
+	fs.BoolVar(&discoverServices, "discover-services", false, "also register every service annotated with "+serviceAnnotation+", on its node port")
+
+	var namespaces string
+	fs.StringVar(&namespaces, "namespaces", "", "(optional) comma separated namespaces services are discovered in, every namespace when empty")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	var err error
+	c.static, err = parseServices(services)
+	if err != nil {
+		return err
+	}
+
+	if len(c.static) == 0 && !discoverServices {
+		return fmt.Errorf("no services given in -services or -discover-services")
+	}
+
+	c.addr = strings.TrimSuffix(c.addr, "/")
+
+	var serviceNamespaces []string
+	for _, ns := range strings.Split(namespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			serviceNamespaces = append(serviceNamespaces, ns)
+		}
+	}
+
+	common.Start(fs.Name())
+
+	log.Info().Msgf("registering the nodes in consul at %s", c.addr)
+
+	if discoverServices && common.Output != "" {
+		found, err := discovery.Services(context.Background(), common.Kubeconfig, serviceNamespaces)
+		if err != nil {
+			return err
+		}
+		c.discovered = annotatedServices(found)
+	}
+
+	if common.Output != "" {
+		return common.Print([]backend.Backend{c})
+	}
+
+	c.auditLog = audit.Open(common.AuditLog, fs.Name())
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+
+	backends := common.Track([]backend.Backend{c})
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	if discoverServices {
+		go func() {
+			err := discovery.WatchServices(context.Background(), common.Kubeconfig, serviceNamespaces, func(found []discovery.Service) {
+				discovered := annotatedServices(found)
+
+				c.mu.Lock()
+				changed := fmt.Sprint(c.discovered) != fmt.Sprint(discovered)
+				c.discovered = discovered
+				c.mu.Unlock()
+
+				if changed {
+					log.Info().Msgf("discovered services changed, now %d", len(discovered))
+					select {
+					case force <- struct{}{}:
+					default:
+					}
+				}
+			})
+			if err != nil {
+				log.Error().Err(err).Msg("service discovery stopped")
+			}
+		}()
+	}
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package control
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"github.com/rsvancara/linode-tools/internal/control/controlpb"
+	"github.com/rsvancara/linode-tools/internal/signature"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// PublicKey verifies the signature of the outputs pushed by the controller when set, unsigned pushes are refused
+var PublicKey ed25519.PublicKey
+
+// Push is what a controller pushes to its agents: the nodes and the rendered lines of every output by name
+type Push struct {
+	Nodes   []discovery.Node    `json:"nodes"`
+	Configs map[string][]string `json:"configs"`
+}
+
+// pushed is what the controller pushed last
+var pushed struct {
+	mu sync.Mutex
+	Push
+	at time.Time
+}
+
+// Pushed - The lines the controller rendered for an output, false until it pushed them
+func Pushed(name string) ([]string, bool) {
+
+	pushed.mu.Lock()
+	defer pushed.mu.Unlock()
+
+	lines, ok := pushed.Configs[name]
+
+	return lines, ok
+}
+
+// Agent is the source of an agent: the nodes pushed by a controller through PushConfigs along with the
+// outputs it rendered for them, instead of every host querying the Kubernetes API itself
+type Agent struct {
+	// MaxAge is how long the pushed outputs are applied without a new push, 0 for ever
+	MaxAge time.Duration
+}
+
+// Nodes - The nodes pushed last, an error until the controller pushed them or when they are too old
+func (a Agent) Nodes(ctx context.Context) ([]discovery.Node, error) {
+
+	pushed.mu.Lock()
+	defer pushed.mu.Unlock()
+
+	if pushed.at.IsZero() {
+		return nil, fmt.Errorf("the controller has not pushed the outputs yet")
+	}
+
+	if a.MaxAge > 0 && time.Since(pushed.at) > a.MaxAge {
+		return nil, fmt.Errorf("the controller last pushed the outputs %s ago", time.Since(pushed.at).Round(time.Second))
+	}
+
+	return pushed.Nodes, nil
+}
+
+// PushConfigs - Keep the outputs pushed by the controller, reconciling right away when they changed
+func (server) PushConfigs(ctx context.Context, req *controlpb.PushConfigsRequest) (*controlpb.PushConfigsResponse, error) {
+
+	if PublicKey != nil {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if err := signature.VerifyRequest(PublicKey, req.Push, first(md.Get(signature.Header)), first(md.Get(signature.TimeHeader))); err != nil {
+			return nil, grpcstatus.Errorf(codes.PermissionDenied, "refusing the pushed outputs: %v", err)
+		}
+	}
+
+	var push Push
+	if err := json.Unmarshal(req.Push, &push); err != nil {
+		return nil, grpcstatus.Errorf(codes.InvalidArgument, "invalid push: %v", err)
+	}
+
+	pushed.mu.Lock()
+	changed := pushed.at.IsZero() || discovery.Changed(pushed.Nodes, push.Nodes) || !reflect.DeepEqual(pushed.Configs, push.Configs)
+	pushed.Push = push
+	pushed.at = time.Now()
+	pushed.mu.Unlock()
+
+	if changed {
+		mu.Lock()
+		request := reconcile
+		mu.Unlock()
+
+		if request != nil {
+			request("outputs pushed by the controller")
+		}
+	}
+
+	return &controlpb.PushConfigsResponse{}, nil
+}
+
+// first - The first value of a metadata key, empty when it has none
+func first(values []string) string {
+
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
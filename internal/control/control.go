@@ -0,0 +1,234 @@
+// Package control serves the control API a central controller manages a fleet of the tools with: the
+// status, the rendered configs, reconciles on demand, pausing and resuming, and the outputs pushed to agents.
+// The API is the gRPC service of controlpb and only accepts clients with a certificate signed by the
+// configured CA.
+package control
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/rsvancara/linode-tools/internal/control/controlpb"
+	"github.com/rsvancara/linode-tools/internal/pause"
+	"github.com/rsvancara/linode-tools/internal/status"
+)
+
+var (
+	mu        sync.Mutex
+	reconcile func(reason string)
+)
+
+// OnReconcile - Set how the loop of the running command is asked for a reconcile
+func OnReconcile(request func(reason string)) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	reconcile = request
+}
+
+// server implements the methods of the API
+type server struct {
+	controlpb.UnimplementedControlServer
+}
+
+// Register - Add the control API, and the reflection service grpcurl lists its methods with, to a gRPC server
+func Register(s *grpc.Server) {
+	controlpb.RegisterControlServer(s, server{})
+	reflection.Register(s)
+}
+
+// Serve - Start the API on addr in the background, with the certificate and key of the server and the CA
+// the client certificates must be signed by
+func Serve(addr string, certFile string, keyFile string, clientCA string) error {
+
+	s, err := newServer(certFile, keyFile, clientCA)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s for the control api: %w", addr, err)
+	}
+
+	log.Info().Msgf("serving the control api on %s", addr)
+
+	go func() {
+		if err := s.Serve(listener); err != nil {
+			log.Error().Err(err).Msgf("control api on %s stopped", addr)
+		}
+	}()
+
+	return nil
+}
+
+// newServer - A gRPC server of the API only accepting clients with a certificate signed by clientCA
+func newServer(certFile string, keyFile string, clientCA string) (*grpc.Server, error) {
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the control certificate: %w", err)
+	}
+
+	ca, err := os.ReadFile(clientCA)
+	if err != nil {
+		return nil, fmt.Errorf("loading the control client CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", clientCA)
+	}
+
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	})
+
+	s := grpc.NewServer(grpc.Creds(creds), grpc.UnaryInterceptor(logCalls))
+	Register(s)
+
+	return s, nil
+}
+
+// logCalls - Log every call with the common name of the certificate of the client
+func logCalls(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	client := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		client = p.Addr.String()
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+			client = tlsInfo.State.PeerCertificates[0].Subject.CommonName
+		}
+	}
+
+	log.Info().Msgf("control api %s called by %s", info.FullMethod, client)
+
+	return handler(ctx, req)
+}
+
+// GetStatus - The current nodes, the last reconcile of every backend and whether reconciling is paused
+func (server) GetStatus(ctx context.Context, req *controlpb.GetStatusRequest) (*controlpb.Status, error) {
+
+	summary := status.Current()
+	paused, reason, since := pause.State()
+
+	s := &controlpb.Status{
+		Updated:     timestamppb.New(summary.Updated),
+		Backends:    make(map[string]*controlpb.Reconcile),
+		Paused:      paused,
+		PauseReason: reason,
+	}
+	if paused {
+		s.PausedSince = timestamppb.New(since)
+	}
+
+	for _, n := range summary.Nodes {
+		s.Nodes = append(s.Nodes, &controlpb.Node{Name: n.Name, Ip: n.IP})
+	}
+
+	for name, r := range summary.Backends {
+		s.Backends[name] = &controlpb.Reconcile{
+			Time:    timestamppb.New(r.Time),
+			Backend: r.Backend,
+			Nodes:   int32(r.Nodes),
+			Result:  r.Result,
+			Error:   r.Error,
+			Hash:    r.Hash,
+		}
+	}
+
+	return s, nil
+}
+
+// ForceReconcile - Query the nodes and apply every backend right away
+func (server) ForceReconcile(ctx context.Context, req *controlpb.ForceReconcileRequest) (*controlpb.ForceReconcileResponse, error) {
+
+	mu.Lock()
+	request := reconcile
+	mu.Unlock()
+
+	if request == nil {
+		return nil, grpcstatus.Error(codes.Unavailable, "this command does not reconcile")
+	}
+
+	request("the control api")
+
+	return &controlpb.ForceReconcileResponse{}, nil
+}
+
+// Pause - Hold back the changes to the nodes until Resume
+func (server) Pause(ctx context.Context, req *controlpb.PauseRequest) (*controlpb.PauseResponse, error) {
+
+	reason := req.Reason
+	if reason == "" {
+		reason = "paused through the control api"
+	}
+
+	if req.Timeout != nil {
+		if err := req.Timeout.CheckValid(); err != nil || req.Timeout.AsDuration() < 0 {
+			return nil, grpcstatus.Errorf(codes.InvalidArgument, "invalid timeout %v", req.Timeout)
+		}
+	}
+
+	pause.Pause(reason, req.Timeout.AsDuration())
+
+	return &controlpb.PauseResponse{}, nil
+}
+
+// Resume - Apply the changes to the nodes again, right away
+func (server) Resume(ctx context.Context, req *controlpb.ResumeRequest) (*controlpb.ResumeResponse, error) {
+
+	pause.Resume()
+
+	mu.Lock()
+	request := reconcile
+	mu.Unlock()
+
+	if request != nil {
+		request("resuming through the control api")
+	}
+
+	return &controlpb.ResumeResponse{}, nil
+}
+
+// GetRenderedConfig - The applied output of a backend, or of every backend
+func (server) GetRenderedConfig(ctx context.Context, req *controlpb.GetRenderedConfigRequest) (*controlpb.GetRenderedConfigResponse, error) {
+
+	configs := status.Configs()
+
+	resp := &controlpb.GetRenderedConfigResponse{Configs: make(map[string]*controlpb.RenderedConfig)}
+
+	if req.Backend == "" {
+		for name, lines := range configs {
+			resp.Configs[name] = &controlpb.RenderedConfig{Lines: lines}
+		}
+		return resp, nil
+	}
+
+	lines, ok := configs[req.Backend]
+	if !ok {
+		return nil, grpcstatus.Errorf(codes.NotFound, "no applied config for %s", req.Backend)
+	}
+
+	resp.Configs[req.Backend] = &controlpb.RenderedConfig{Lines: lines}
+
+	return resp, nil
+}
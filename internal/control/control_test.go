@@ -0,0 +1,251 @@
+package control
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"github.com/rsvancara/linode-tools/internal/control/controlpb"
+	"github.com/rsvancara/linode-tools/internal/pause"
+	"github.com/rsvancara/linode-tools/internal/signature"
+)
+
+// issue - A certificate for name signed by parent, self signed when parent is nil
+func issue(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// keyPair - The TLS certificate of a certificate and its key
+func keyPair(t *testing.T, cert *x509.Certificate, key *ecdsa.PrivateKey) tls.Certificate {
+
+	t.Helper()
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair, err := tls.X509KeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pair
+}
+
+// writePEM - Write a PEM block to a file of dir
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// serve - Serve the API with mutual TLS on a local port, and the client certificate and pool of the CA it
+// accepts
+func serve(t *testing.T) (string, tls.Certificate, *x509.CertPool) {
+
+	t.Helper()
+
+	ca, caKey := issue(t, "ca", nil, nil)
+	serverCert, serverKey := issue(t, "agent", ca, caKey)
+	clientCert, clientKey := issue(t, "controller", ca, caKey)
+
+	keyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	s, err := newServer(
+		writePEM(t, dir, "server.crt", "CERTIFICATE", serverCert.Raw),
+		writePEM(t, dir, "server.key", "EC PRIVATE KEY", keyDER),
+		writePEM(t, dir, "ca.crt", "CERTIFICATE", ca.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return listener.Addr().String(), keyPair(t, clientCert, clientKey), pool
+}
+
+// dial - A client of the API at addr with the TLS config
+func dial(t *testing.T, addr string, config *tls.Config) controlpb.ControlClient {
+
+	t.Helper()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return controlpb.NewControlClient(conn)
+}
+
+// TestAPI checks a client with a certificate of the CA can pause, resume and read the status
+func TestAPI(t *testing.T) {
+
+	addr, cert, pool := serve(t)
+	client := dial(t, addr, &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := client.Pause(ctx, &controlpb.PauseRequest{Reason: "kernel upgrade"}); err != nil {
+		t.Fatal(err)
+	}
+	defer pause.Resume()
+
+	s, err := client.GetStatus(ctx, &controlpb.GetStatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Paused || s.PauseReason != "kernel upgrade" {
+		t.Errorf("paused %t for %q, expected paused for kernel upgrade", s.Paused, s.PauseReason)
+	}
+
+	if _, err := client.Resume(ctx, &controlpb.ResumeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if paused, _, _ := pause.State(); paused {
+		t.Error("still paused after Resume")
+	}
+
+	_, err = client.GetRenderedConfig(ctx, &controlpb.GetRenderedConfigRequest{Backend: "missing"})
+	if grpcstatus.Code(err) != codes.NotFound {
+		t.Errorf("got %v for a backend without a config, expected NotFound", err)
+	}
+}
+
+// TestClientCertificate checks a client without a certificate of the CA is refused
+func TestClientCertificate(t *testing.T) {
+
+	addr, _, pool := serve(t)
+	client := dial(t, addr, &tls.Config{RootCAs: pool})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := client.GetStatus(ctx, &controlpb.GetStatusRequest{}); err == nil {
+		t.Fatal("a client without a certificate was served")
+	}
+}
+
+// TestPushConfigs checks only the outputs signed with the key of PublicKey are kept
+func TestPushConfigs(t *testing.T) {
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	PublicKey = public
+	defer func() { PublicKey = nil }()
+
+	addr, cert, pool := serve(t)
+	client := dial(t, addr, &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	push := []byte(`{"nodes": [{"name": "node-1", "ip": "10.0.0.1"}], "configs": {"upstreams.conf": ["server 10.0.0.1:30080;"]}}`)
+
+	_, err = client.PushConfigs(ctx, &controlpb.PushConfigsRequest{Push: push})
+	if grpcstatus.Code(err) != codes.PermissionDenied {
+		t.Fatalf("got %v for an unsigned push, expected PermissionDenied", err)
+	}
+
+	sig, at := signature.SignRequest(private, push)
+	signed := metadata.AppendToOutgoingContext(ctx, signature.Header, sig, signature.TimeHeader, at)
+
+	if _, err := client.PushConfigs(signed, &controlpb.PushConfigsRequest{Push: []byte(`{}`)}); grpcstatus.Code(err) != codes.PermissionDenied {
+		t.Fatalf("got %v for a push other than the signed one, expected PermissionDenied", err)
+	}
+
+	if _, err := client.PushConfigs(signed, &controlpb.PushConfigsRequest{Push: push}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Agent{}.Nodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "node-1" {
+		t.Errorf("pushed %v, expected node-1", got)
+	}
+
+	lines, ok := Pushed("upstreams.conf")
+	if !ok || len(lines) != 1 || lines[0] != "server 10.0.0.1:30080;" {
+		t.Errorf("pushed %q for upstreams.conf, expected its server", lines)
+	}
+	if _, ok := Pushed("missing.conf"); ok {
+		t.Error("an output that was not pushed was found")
+	}
+}
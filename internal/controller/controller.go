@@ -0,0 +1,203 @@
+// Package controller pushes the outputs a command renders for the nodes it discovers to the agents on the
+// load balancer and database hosts, so dozens of hosts do not each query the Kubernetes API for the node list.
+// The agents only validate and apply the pushed lines.
+package controller
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/rsvancara/linode-tools/internal/control"
+	"github.com/rsvancara/linode-tools/internal/control/controlpb"
+	"github.com/rsvancara/linode-tools/internal/signature"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// Agent is the control API of an agent the outputs are pushed to
+type Agent struct {
+	addr   string
+	client controlpb.ControlClient
+
+	// key signs the pushes when set, for agents verifying them with -agent-public-key
+	key ed25519.PrivateKey
+
+	// The last push, sent again by Resync
+	mu   sync.Mutex
+	last []byte
+}
+
+// Dial - The agents of a comma separated list of control api addresses, with the certificate presented to them,
+// the CA of their certificates and the key signing the pushes when not nil
+func Dial(addrs string, certFile string, keyFile string, caFile string, key ed25519.PrivateKey) ([]*Agent, error) {
+
+	creds, err := newCredentials(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var agents []*Agent
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+
+		// The connection is made in the background and made again after a failure
+		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("invalid agent %s: %w", addr, err)
+		}
+
+		agents = append(agents, &Agent{addr: addr, client: controlpb.NewControlClient(conn), key: key})
+	}
+
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("no agents given")
+	}
+
+	return agents, nil
+}
+
+// push - Send the JSON of a push to the PushConfigs method of the agent, signed with the key
+func (a *Agent) push(ctx context.Context, body []byte) error {
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if a.key != nil {
+		sig, at := signature.SignRequest(a.key, body)
+		ctx = metadata.AppendToOutgoingContext(ctx, signature.Header, sig, signature.TimeHeader, at)
+	}
+
+	if _, err := a.client.PushConfigs(ctx, &controlpb.PushConfigsRequest{Push: body}); err != nil {
+		return fmt.Errorf("pushing the outputs to %s: %w", a.addr, err)
+	}
+
+	a.mu.Lock()
+	a.last = body
+	a.mu.Unlock()
+
+	return nil
+}
+
+// Resync - Send the last push again every interval even when nothing changed, so restarted agents catch up
+func (a *Agent) Resync(interval time.Duration) {
+
+	for range time.Tick(interval) {
+		a.mu.Lock()
+		body := a.last
+		a.mu.Unlock()
+
+		if body == nil {
+			continue
+		}
+
+		if err := a.push(context.Background(), body); err != nil {
+			log.Warn().Err(err).Msg("resync failed")
+		}
+	}
+}
+
+// output pushes the outputs of the backends of the command to an agent instead of applying them
+type output struct {
+	agent    *Agent
+	backends []backend.Backend
+}
+
+// Backends - A backend for every agent, pushing the lines the backends render to it
+func Backends(agents []*Agent, backends []backend.Backend) []backend.Backend {
+
+	var outputs []backend.Backend
+	for _, a := range agents {
+		outputs = append(outputs, &output{agent: a, backends: backends})
+	}
+
+	return outputs
+}
+
+// Name - The address of the agent
+func (o *output) Name() string {
+	return o.agent.addr
+}
+
+// Render - A single line with the JSON of the nodes and the lines of every backend by name
+func (o *output) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	push := control.Push{Nodes: nodes, Configs: make(map[string][]string)}
+
+	for _, b := range o.backends {
+		lines, err := b.Render(ctx, nodes)
+		if err != nil {
+			return nil, fmt.Errorf("rendering %s: %w", b.Name(), err)
+		}
+		push.Configs[b.Name()] = lines
+	}
+
+	data, err := json.Marshal(push)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{string(data)}, nil
+}
+
+// Validate - The agents validate the lines before applying them, the controller may lack the tools to
+func (o *output) Validate(ctx context.Context, rendered []string) error {
+	return nil
+}
+
+// Apply - Push the outputs to the agent
+func (o *output) Apply(ctx context.Context, rendered []string) error {
+
+	if err := o.agent.push(ctx, []byte(strings.Join(rendered, ""))); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("pushed %d outputs to %s", len(o.backends), o.agent.addr)
+
+	return nil
+}
+
+// Rollback - The agent keeps the outputs it was pushed last
+func (o *output) Rollback(ctx context.Context) error {
+	return nil
+}
+
+// newCredentials - The TLS credentials presenting the certificate to the agents and trusting the CA of their
+// certificates
+func newCredentials(certFile string, keyFile string, caFile string) (credentials.TransportCredentials, error) {
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the client certificate: %w", err)
+	}
+
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the agent CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
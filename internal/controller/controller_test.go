@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/rsvancara/linode-tools/internal/control"
+	"github.com/rsvancara/linode-tools/internal/control/controlpb"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// fakeClient records the pushes of the controller
+type fakeClient struct {
+	controlpb.ControlClient
+	pushes [][]byte
+}
+
+func (f *fakeClient) PushConfigs(ctx context.Context, req *controlpb.PushConfigsRequest, opts ...grpc.CallOption) (*controlpb.PushConfigsResponse, error) {
+	f.pushes = append(f.pushes, req.Push)
+	return &controlpb.PushConfigsResponse{}, nil
+}
+
+// TestBackends checks every agent is pushed the lines of every backend by name, and the backends are not applied on
+// the controller
+func TestBackends(t *testing.T) {
+
+	clients := []*fakeClient{{}, {}}
+	agents := []*Agent{{addr: "lb1:9443", client: clients[0]}, {addr: "lb2:9443", client: clients[1]}}
+
+	upstreams := &backend.Mock{Label: "/etc/nginx/conf.d/upstreams.conf"}
+	hosts := &backend.Mock{Label: "/etc/hosts"}
+
+	nodes := []discovery.Node{{Name: "node-1", IP: net.ParseIP("10.0.0.1")}}
+
+	if err := backend.Reconcile(context.Background(), Backends(agents, []backend.Backend{upstreams, hosts}), nodes, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range clients {
+		if len(c.pushes) != 1 {
+			t.Fatalf("agent %d was pushed %d times, expected once", i, len(c.pushes))
+		}
+
+		var push control.Push
+		if err := json.Unmarshal(c.pushes[0], &push); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string][]string{"/etc/nginx/conf.d/upstreams.conf": {"10.0.0.1"}, "/etc/hosts": {"10.0.0.1"}}
+		if !reflect.DeepEqual(push.Configs, expected) {
+			t.Errorf("agent %d was pushed %q, expected %q", i, push.Configs, expected)
+		}
+		if len(push.Nodes) != 1 || push.Nodes[0].Name != "node-1" {
+			t.Errorf("agent %d was pushed the nodes %v, expected node-1", i, push.Nodes)
+		}
+
+		if string(agents[i].last) != string(c.pushes[0]) {
+			t.Errorf("agent %d keeps %s to resync, expected the last push", i, agents[i].last)
+		}
+	}
+
+	if len(upstreams.Applied) > 0 || len(hosts.Applied) > 0 {
+		t.Error("the backends were applied on the controller")
+	}
+}
@@ -0,0 +1,147 @@
+// Package diff renders the difference between two versions of a config file as a unified diff.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// op is a single line of the edit script: kept, deleted from a or inserted from b
+type op struct {
+	kind byte
+	line string
+	a    int
+	b    int
+}
+
+// Unified - The unified diff turning a into b with the given lines of context, empty when the
+// lines are the same
+func Unified(aName string, bName string, a []string, b []string, context int) string {
+
+	ops := edits(a, b)
+
+	var changes []int
+	for i, o := range ops {
+		if o.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n", aName)
+	fmt.Fprintf(&out, "+++ %s\n", bName)
+
+	// Changes closer together than twice the context share a hunk
+	for i := 0; i < len(changes); {
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*context {
+			j++
+		}
+
+		start := changes[i] - context
+		if start < 0 {
+			start = 0
+		}
+		end := changes[j] + context + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		writeHunk(&out, ops[start:end])
+
+		i = j + 1
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, ops []op) {
+
+	aLen, bLen := 0, 0
+	for _, o := range ops {
+		if o.kind != '+' {
+			aLen++
+		}
+		if o.kind != '-' {
+			bLen++
+		}
+	}
+
+	// Line numbers are 1 based, an empty range points at the line before it
+	aStart, bStart := ops[0].a, ops[0].b
+	if aLen > 0 {
+		aStart++
+	}
+	if bLen > 0 {
+		bStart++
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+	for _, o := range ops {
+		fmt.Fprintf(out, "%c%s\n", o.kind, o.line)
+	}
+}
+
+// hunkRange - The start and length of a hunk, the length left out when it is 1 as diff -u does
+func hunkRange(start int, length int) string {
+
+	if length == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, length)
+}
+
+// edits - The shortest edit script from a to b, based on their longest common subsequence
+func edits(a []string, b []string) []op {
+
+	n, m := len(a), len(b)
+
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []op
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{' ', a[i], i, j})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	for ; i < n; i++ {
+		ops = append(ops, op{'-', a[i], i, j})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, op{'+', b[j], i, j})
+	}
+
+	return ops
+}
@@ -0,0 +1,63 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestUnified checks the hunks match the ones of diff -u
+func TestUnified(t *testing.T) {
+
+	lines := strings.Split("a b c d e f g h", " ")
+
+	tests := []struct {
+		name     string
+		a        []string
+		b        []string
+		context  int
+		expected string
+	}{
+		{
+			name: "same lines",
+			a:    lines,
+			b:    lines,
+		},
+		{
+			name:    "changes far apart",
+			a:       lines,
+			b:       strings.Split("a B c d e f g h i", " "),
+			context: 1,
+			expected: "--- a\n+++ b\n" +
+				"@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n" +
+				"@@ -8 +8,2 @@\n h\n+i\n",
+		},
+		{
+			name:    "changes sharing a hunk",
+			a:       lines,
+			b:       strings.Split("a B c D e f g h", " "),
+			context: 1,
+			expected: "--- a\n+++ b\n" +
+				"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n-d\n+D\n e\n",
+		},
+		{
+			name:     "new file",
+			b:        []string{"a", "b"},
+			context:  3,
+			expected: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "emptied file",
+			a:        []string{"a", "b"},
+			context:  3,
+			expected: "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified("a", "b", tt.a, tt.b, tt.context); got != tt.expected {
+				t.Errorf("got\n%s\nexpected\n%s", got, tt.expected)
+			}
+		})
+	}
+}
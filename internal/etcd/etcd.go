@@ -0,0 +1,271 @@
+// Package etcd writes the node addresses to a single etcd key as JSON, so other tooling can watch the key
+// instead of talking to the Kubernetes API.
+//
+// The key is written through the JSON gateway of the etcd v3 API, in a transaction comparing the revision
+// read just before, so concurrent writers never overwrite a value they have not seen.
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// attempts is how often the key is read and written again when another writer changed it in between
+const attempts = 3
+
+// errConflict is returned by put when the key changed since it was read
+var errConflict = errors.New("the key was changed by another writer")
+
+// Key is an etcd key holding the nodes, kept as a backend.Backend
+type Key struct {
+	endpoints []string
+	key       string
+	client    *http.Client
+}
+
+// value is the JSON value of the key
+type value struct {
+	// IPs are the sorted addresses of the nodes
+	IPs []string `json:"ips"`
+
+	// Nodes maps the node names to their address
+	Nodes map[string]string `json:"nodes"`
+}
+
+// New - The key at the given comma separated endpoints, the TLS files are optional
+func New(endpoints string, key string, certFile string, keyFile string, caFile string) (*Key, error) {
+
+	k := &Key{key: key, client: &http.Client{Timeout: 10 * time.Second}}
+
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		if endpoint = strings.TrimSuffix(strings.TrimSpace(endpoint), "/"); endpoint != "" {
+			k.endpoints = append(k.endpoints, endpoint)
+		}
+	}
+
+	if len(k.endpoints) == 0 {
+		return nil, fmt.Errorf("no etcd endpoints given")
+	}
+
+	if key == "" {
+		return nil, fmt.Errorf("no etcd key given")
+	}
+
+	if certFile == "" && caFile == "" {
+		return k, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading the etcd client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading the etcd CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	k.client.Transport = &http.Transport{TLSClientConfig: config}
+
+	return k, nil
+}
+
+// Name - The key and the first endpoint
+func (k *Key) Name() string {
+	return "etcd " + k.endpoints[0] + " " + k.key
+}
+
+// Render - The JSON value of the key, indented over several lines
+func (k *Key) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	v := value{IPs: []string{}, Nodes: make(map[string]string)}
+
+	for _, n := range nodes {
+		v.IPs = append(v.IPs, n.IP.String())
+		v.Nodes[n.Name] = n.IP.String()
+	}
+
+	sort.Strings(v.IPs)
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(string(data), "\n"), nil
+}
+
+// Validate - The rendered value is a list of addresses and a map of nodes
+func (k *Key) Validate(ctx context.Context, rendered []string) error {
+
+	var v value
+	if err := json.Unmarshal([]byte(strings.Join(rendered, "\n")), &v); err != nil {
+		return err
+	}
+
+	for _, ip := range v.IPs {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid address %q", ip)
+		}
+	}
+
+	return nil
+}
+
+// Apply - Write the value when it changed, reading the key again when another writer changed it in between
+func (k *Key) Apply(ctx context.Context, rendered []string) error {
+
+	data := []byte(strings.Join(rendered, "\n"))
+
+	for i := 0; i < attempts; i++ {
+		current, revision, err := k.get()
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(current, data) {
+			return nil
+		}
+
+		err = k.put(data, revision)
+		if err == nil {
+			log.Info().Msgf("wrote the nodes to etcd key %s", k.key)
+			return nil
+		}
+		if !errors.Is(err, errConflict) {
+			return err
+		}
+
+		log.Warn().Msgf("etcd key %s changed while writing it, retrying", k.key)
+	}
+
+	return fmt.Errorf("writing etcd key %s: %w", k.key, errConflict)
+}
+
+// Rollback - The value is written in a single transaction, a failed Apply left it unchanged
+func (k *Key) Rollback(ctx context.Context) error {
+	return nil
+}
+
+// keyValue is a key of a range response, the revisions are int64 encoded as strings
+type keyValue struct {
+	ModRevision string `json:"mod_revision"`
+	Value       []byte `json:"value"`
+}
+
+// get - The value of the key and its mod revision, 0 when the key does not exist
+func (k *Key) get() ([]byte, string, error) {
+
+	var resp struct {
+		Kvs []keyValue `json:"kvs"`
+	}
+
+	if err := k.call("/v3/kv/range", map[string]interface{}{"key": []byte(k.key)}, &resp); err != nil {
+		return nil, "", err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, "0", nil
+	}
+
+	return resp.Kvs[0].Value, resp.Kvs[0].ModRevision, nil
+}
+
+// put - Write the value if the key still has the given mod revision
+func (k *Key) put(data []byte, revision string) error {
+
+	compare := map[string]interface{}{"key": []byte(k.key), "result": "EQUAL"}
+	if revision == "0" {
+		// The key did not exist, it must not have been created since
+		compare["target"] = "CREATE"
+		compare["create_revision"] = "0"
+	} else {
+		compare["target"] = "MOD"
+		compare["mod_revision"] = revision
+	}
+
+	txn := map[string]interface{}{
+		"compare": []interface{}{compare},
+		"success": []interface{}{
+			map[string]interface{}{"request_put": map[string]interface{}{"key": []byte(k.key), "value": data}},
+		},
+	}
+
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+
+	if err := k.call("/v3/kv/txn", txn, &resp); err != nil {
+		return err
+	}
+
+	if !resp.Succeeded {
+		return errConflict
+	}
+
+	return nil
+}
+
+// call - POST a request to the JSON gateway, trying the endpoints in turn until one answers
+func (k *Key) call(path string, body interface{}, result interface{}) error {
+
+	// []byte values are base64 encoded by encoding/json, as the gateway expects
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+
+	for _, endpoint := range k.endpoints {
+		resp, err := k.client.Post(endpoint+path, "application/json", bytes.NewReader(data))
+		if err != nil {
+			lastErr = fmt.Errorf("etcd %s: %w", endpoint, err)
+			continue
+		}
+
+		msg, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("etcd %s: %w", endpoint, err)
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("etcd %s%s: %s: %s", endpoint, path, resp.Status, strings.TrimSpace(string(msg)))
+		}
+
+		return json.Unmarshal(msg, result)
+	}
+
+	return lastErr
+}
@@ -0,0 +1,246 @@
+// Package events reports the reconciles of the running tool as Kubernetes Events, so kubectl get events shows the
+// cluster operators when a load balancer or firewall outside the cluster picked up a node, and when it failed to.
+//
+// The changes of the nodes of an output are reported on the Node objects, the failures on an object of the
+// operator's choosing, for example the ConfigMap the state is published to.
+package events
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// queueSize is the number of events waiting to be sent, more are dropped rather than slowing the reconciles down
+const queueSize = 100
+
+// Recorder sends the events of a tool in the background
+type Recorder struct {
+	clientset kubernetes.Interface
+	tool      string
+	host      string
+
+	// The object the failures are reported on
+	object corev1.ObjectReference
+
+	queue chan *corev1.Event
+}
+
+// New - A recorder reporting the failures of the tool on the ConfigMap namespace/name of the cluster of the
+// kubeconfig, which does not need to exist
+func New(kubeconfig string, ref string, tool string) (*Recorder, error) {
+
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("events object %s is not namespace/name", ref)
+	}
+
+	config, err := discovery.RESTConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFor(clientset, corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: parts[0], Name: parts[1]}, tool), nil
+}
+
+// NewFor - A recorder sending the events through a clientset that is already built
+func NewFor(clientset kubernetes.Interface, object corev1.ObjectReference, tool string) *Recorder {
+
+	host, _ := os.Hostname()
+
+	r := &Recorder{
+		clientset: clientset,
+		tool:      tool,
+		host:      host,
+		object:    object,
+		queue:     make(chan *corev1.Event, queueSize),
+	}
+
+	go r.send()
+
+	return r
+}
+
+// send - Create the queued events one at a time
+func (r *Recorder) send() {
+
+	for event := range r.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err := r.clientset.CoreV1().Events(event.Namespace).Create(ctx, event, metav1.CreateOptions{})
+		cancel()
+
+		if err != nil {
+			log.Warn().Err(err).Msgf("unable to report %s %s on %s/%s", event.Type, event.Reason, event.InvolvedObject.Kind, event.InvolvedObject.Name)
+		}
+	}
+}
+
+// emit - Queue an event about an object, dropping it when the queue is full
+func (r *Recorder) emit(object corev1.ObjectReference, eventType string, reason string, message string) {
+
+	// Node events live in the default namespace, like those of the kubelet
+	namespace := object.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	now := metav1.Now()
+
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", object.Name, time.Now().UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject:      object,
+		Reason:              reason,
+		Message:             message,
+		Type:                eventType,
+		Source:              corev1.EventSource{Component: "linode-tools-" + r.tool, Host: r.host},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		ReportingController: "linode-tools/" + r.tool,
+		ReportingInstance:   r.host,
+	}
+
+	select {
+	case r.queue <- event:
+	default:
+		log.Warn().Msgf("dropping the %s event of %s, too many events are waiting", reason, object.Name)
+	}
+}
+
+// nodeRef - The reference of a Node object, with the node name as uid as the kubelet does so kubectl describe
+// node lists the events
+func nodeRef(name string) corev1.ObjectReference {
+	return corev1.ObjectReference{APIVersion: "v1", Kind: "Node", Name: name, UID: types.UID(name)}
+}
+
+// Track - Wrap the backends so the changes of their nodes and their failures are reported
+func Track(backends []backend.Backend, r *Recorder) []backend.Backend {
+
+	var tracked []backend.Backend
+	for _, b := range backends {
+		tracked = append(tracked, &tracker{Backend: b, recorder: r})
+	}
+
+	return tracked
+}
+
+// tracker reports the outcome of the steps of a backend
+type tracker struct {
+	backend.Backend
+	recorder *Recorder
+
+	// The nodes rendered by the last Render, and those of the last successful Apply.  The first Apply only
+	// remembers its nodes, every node would be reported as added on each restart otherwise.
+	rendered []string
+	applied  []string
+	started  bool
+}
+
+// Render - Remember the nodes the backend is rendered for
+func (t *tracker) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	t.rendered = nil
+	for _, n := range nodes {
+		t.rendered = append(t.rendered, n.Name)
+	}
+	sort.Strings(t.rendered)
+
+	return t.Backend.Render(ctx, nodes)
+}
+
+// Validate - Report a rendered output that is refused
+func (t *tracker) Validate(ctx context.Context, rendered []string) error {
+
+	err := t.Backend.Validate(ctx, rendered)
+	if err != nil {
+		t.recorder.emit(t.recorder.object, corev1.EventTypeWarning, "Invalid", fmt.Sprintf("%s on %s was not applied: %v", t.Name(), t.recorder.host, err))
+	}
+
+	return err
+}
+
+// Apply - Report the nodes added and removed, or the failure
+func (t *tracker) Apply(ctx context.Context, rendered []string) error {
+
+	err := t.Backend.Apply(ctx, rendered)
+	if err != nil {
+		t.recorder.emit(t.recorder.object, corev1.EventTypeWarning, "ApplyFailed", fmt.Sprintf("applying %s on %s failed: %v", t.Name(), t.recorder.host, err))
+		return err
+	}
+
+	if t.started {
+		added, removed := diff(t.applied, t.rendered)
+
+		for _, name := range added {
+			t.recorder.emit(nodeRef(name), corev1.EventTypeNormal, "Added", fmt.Sprintf("added to %s on %s", t.Name(), t.recorder.host))
+		}
+		for _, name := range removed {
+			t.recorder.emit(nodeRef(name), corev1.EventTypeNormal, "Removed", fmt.Sprintf("removed from %s on %s", t.Name(), t.recorder.host))
+		}
+	}
+
+	t.applied = t.rendered
+	t.started = true
+
+	return nil
+}
+
+// Rollback - Report the rollback after a failed apply
+func (t *tracker) Rollback(ctx context.Context) error {
+
+	err := t.Backend.Rollback(ctx)
+	if err != nil {
+		t.recorder.emit(t.recorder.object, corev1.EventTypeWarning, "RollbackFailed", fmt.Sprintf("rolling %s on %s back failed: %v", t.Name(), t.recorder.host, err))
+	} else {
+		t.recorder.emit(t.recorder.object, corev1.EventTypeWarning, "RolledBack", fmt.Sprintf("%s on %s was rolled back to the previous version", t.Name(), t.recorder.host))
+	}
+
+	return err
+}
+
+// diff - The names added and removed between two sorted lists
+func diff(before []string, after []string) ([]string, []string) {
+
+	var added, removed []string
+
+	known := make(map[string]bool)
+	for _, name := range before {
+		known[name] = true
+	}
+
+	current := make(map[string]bool)
+	for _, name := range after {
+		current[name] = true
+		if !known[name] {
+			added = append(added, name)
+		}
+	}
+
+	for _, name := range before {
+		if !current[name] {
+			removed = append(removed, name)
+		}
+	}
+
+	return added, removed
+}
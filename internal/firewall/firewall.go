@@ -0,0 +1,475 @@
+// Package firewall keeps inbound rules of a Linode Cloud Firewall allowing the nodes, for services on Linodes
+// outside the cluster that only the nodes should reach.  The rules are owned through their label: those
+// labelled with the prefix are kept in sync with the nodes, the rules managed by hand are left alone.
+//
+// The rules are read back on an interval, so a rule changed or removed in Cloud Manager is noticed: the drift
+// is reported, or repaired when the drift mode is enforce.
+package firewall
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/linode"
+)
+
+const (
+	// maxAddresses is the number of addresses the Linode API accepts in a rule, more nodes are spread over
+	// several rules
+	maxAddresses = 255
+
+	// maxLabel is the length of the longest rule label the Linode API accepts
+	maxLabel = 32
+
+	// description is set on the owned rules, so Cloud Manager tells who maintains them
+	description = "managed by linode-tools, changes are reverted"
+
+	driftReport  = "report"
+	driftEnforce = "enforce"
+)
+
+// rule is a service of the -rules flag the nodes are allowed to reach
+type rule struct {
+	name     string
+	ports    string
+	protocol string
+}
+
+// parseRules - The rules of the -rules flag, name:ports[:protocol] separated by commas
+func parseRules(s string, prefix string) ([]rule, error) {
+
+	var rules []rule
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("rule %s is not name:ports[:protocol]", item)
+		}
+
+		r := rule{name: parts[0], ports: parts[1], protocol: "TCP"}
+		if len(parts) == 3 {
+			r.protocol = strings.ToUpper(parts[2])
+		}
+
+		if r.protocol != "TCP" && r.protocol != "UDP" {
+			return nil, fmt.Errorf("rule %s has protocol %s, expected TCP or UDP", item, r.protocol)
+		}
+
+		// Room is left for the number of the rule when the nodes do not fit in one
+		if len(prefix)+len(r.name)+3 > maxLabel {
+			return nil, fmt.Errorf("the label %s%s of rule %s is longer than %d characters", prefix, r.name, item, maxLabel-3)
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// cloudFirewall keeps the rules of a Cloud Firewall labelled with the prefix as a backend.Backend.  The drift
+// check runs alongside the reconciles, so the rules applied are guarded by a mutex.
+type cloudFirewall struct {
+	client   *linode.Client
+	id       int
+	prefix   string
+	rules    []rule
+	auditLog *audit.Log
+	notifier *notify.Notifier
+
+	mu sync.Mutex
+
+	// The owned rules rendered by the last Render, and those of the last successful Apply the drift is
+	// measured against
+	desired      []linode.FirewallRule
+	lines        []string
+	applied      []linode.FirewallRule
+	appliedLines []string
+
+	// The rules of the firewall before the last Apply
+	previous *linode.FirewallRules
+
+	// The drift last reported, so an unchanged drift is not alerted on every check
+	reported string
+}
+
+// Name - The id of the firewall
+func (f *cloudFirewall) Name() string {
+	return fmt.Sprintf("cloud firewall %d", f.id)
+}
+
+// owned - Whether a rule is maintained by the tool rather than by hand
+func (f *cloudFirewall) owned(r linode.FirewallRule) bool {
+	return strings.HasPrefix(r.Label, f.prefix)
+}
+
+// Render - An inbound rule for every service accepting the addresses of the nodes, spread over several
+// rules numbered after the first when the nodes do not fit in one
+func (f *cloudFirewall) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	var ipv4, ipv6 []string
+	for _, n := range nodes {
+		if n.IP.To4() != nil {
+			ipv4 = append(ipv4, n.IP.String()+"/32")
+		} else {
+			ipv6 = append(ipv6, n.IP.String()+"/128")
+		}
+	}
+	sort.Strings(ipv4)
+	sort.Strings(ipv6)
+
+	addresses := append(append([]string{}, ipv4...), ipv6...)
+
+	var desired []linode.FirewallRule
+	var lines []string
+
+	for _, r := range f.rules {
+		for i := 0; i == 0 || i*maxAddresses < len(addresses); i++ {
+			end := (i + 1) * maxAddresses
+			if end > len(addresses) {
+				end = len(addresses)
+			}
+
+			label := f.prefix + r.name
+			if i > 0 {
+				label += "-" + strconv.Itoa(i+1)
+			}
+
+			fr := linode.FirewallRule{Action: "ACCEPT", Protocol: r.protocol, Ports: r.ports, Label: label, Description: description}
+			for _, a := range addresses[i*maxAddresses : end] {
+				if strings.HasSuffix(a, "/32") {
+					fr.Addresses.IPv4 = append(fr.Addresses.IPv4, a)
+				} else {
+					fr.Addresses.IPv6 = append(fr.Addresses.IPv6, a)
+				}
+			}
+
+			desired = append(desired, fr)
+			lines = append(lines, ruleLine(fr))
+		}
+	}
+
+	sort.Strings(lines)
+
+	f.mu.Lock()
+	f.desired = desired
+	f.lines = lines
+	f.mu.Unlock()
+
+	return lines, nil
+}
+
+// ruleLine - A rule as a rendered line: label, action, protocol, ports and addresses
+func ruleLine(r linode.FirewallRule) string {
+
+	addresses := append(append([]string{}, r.Addresses.IPv4...), r.Addresses.IPv6...)
+	sort.Strings(addresses)
+
+	return fmt.Sprintf("%s %s %s %s %s", r.Label, r.Action, r.Protocol, r.Ports, strings.Join(addresses, ","))
+}
+
+// Validate - The Linode API refuses a rule without addresses
+func (f *cloudFirewall) Validate(ctx context.Context, rendered []string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, r := range f.desired {
+		if len(r.Addresses.IPv4) == 0 && len(r.Addresses.IPv6) == 0 {
+			return fmt.Errorf("rule %s of %s would allow no node", r.Label, f.Name())
+		}
+	}
+
+	return nil
+}
+
+// split - The rules of the firewall maintained by hand, and the lines of the owned ones
+func (f *cloudFirewall) split(rules []linode.FirewallRule) ([]linode.FirewallRule, []string) {
+
+	var kept []linode.FirewallRule
+	var lines []string
+
+	for _, r := range rules {
+		if f.owned(r) {
+			lines = append(lines, ruleLine(r))
+			continue
+		}
+		kept = append(kept, r)
+	}
+
+	sort.Strings(lines)
+
+	return kept, lines
+}
+
+// Apply - Replace the inbound rules labelled with the prefix, keeping the rules maintained by hand
+func (f *cloudFirewall) Apply(ctx context.Context, rendered []string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	current, err := f.client.FirewallRules(ctx, f.id)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", f.Name(), err)
+	}
+
+	kept, before := f.split(current.Inbound)
+
+	if strings.Join(before, "\n") == strings.Join(f.lines, "\n") {
+		log.Info().Msgf("no changes to %s", f.Name())
+		f.applied, f.appliedLines = f.desired, f.lines
+		return nil
+	}
+
+	updated := current
+	updated.Inbound = append(kept, f.desired...)
+
+	log.Info().Msgf("updating the %d node rules of %s", len(f.desired), f.Name())
+
+	f.previous = &current
+
+	if err := f.client.SetFirewallRules(ctx, f.id, updated); err != nil {
+		return fmt.Errorf("updating %s: %w", f.Name(), err)
+	}
+
+	f.applied, f.appliedLines = f.desired, f.lines
+
+	added, removed := diff(before, f.lines)
+	f.auditLog.Write(audit.Record{Target: f.Name(), Added: added, Removed: removed, Hash: audit.Hash(f.lines), Reload: "updated through the api"})
+
+	return nil
+}
+
+// diff - The lines added and removed between two sorted renders
+func diff(oldLines []string, newLines []string) ([]string, []string) {
+
+	var added, removed []string
+
+	known := make(map[string]bool)
+	for _, line := range oldLines {
+		known[line] = true
+	}
+
+	current := make(map[string]bool)
+	for _, line := range newLines {
+		current[line] = true
+		if !known[line] {
+			added = append(added, line)
+		}
+	}
+
+	for _, line := range oldLines {
+		if !current[line] {
+			removed = append(removed, line)
+		}
+	}
+
+	return added, removed
+}
+
+// Rollback - Put back the rules the firewall had before the last Apply
+func (f *cloudFirewall) Rollback(ctx context.Context) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.previous == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	f.auditLog.Write(audit.Record{Target: f.Name(), Reload: "rolled back"})
+
+	return f.client.SetFirewallRules(ctx, f.id, *f.previous)
+}
+
+// watchDrift - Check the firewall for drift on every tick of the interval
+func (f *cloudFirewall) watchDrift(interval time.Duration, mode string) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := f.checkDrift(mode); err != nil {
+			log.Warn().Err(err).Msgf("unable to check %s for drift", f.Name())
+		}
+	}
+}
+
+// checkDrift - Compare the owned rules of the firewall with those last applied.  A rule changed or removed out
+// of band, or a rule added with the prefix, is reported, and put back when the mode is enforce.
+func (f *cloudFirewall) checkDrift(mode string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Nothing was applied yet, the next reconcile sets the rules anyway
+	if f.appliedLines == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	current, err := f.client.FirewallRules(ctx, f.id)
+	if err != nil {
+		return err
+	}
+
+	labels := metrics.Labels{"firewall": strconv.Itoa(f.id)}
+
+	kept, live := f.split(current.Inbound)
+
+	// Out of band means the rules found that were not applied, and the applied rules that are gone
+	added, removed := diff(f.appliedLines, live)
+	if len(added) == 0 && len(removed) == 0 {
+		metrics.SetGauge("linode_tools_firewall_drift", "Whether the owned rules of the Cloud Firewall differ from those applied", labels, 0)
+		f.reported = ""
+		return nil
+	}
+
+	metrics.SetGauge("linode_tools_firewall_drift", "Whether the owned rules of the Cloud Firewall differ from those applied", labels, 1)
+
+	if mode == driftEnforce {
+		log.Warn().Msgf("repairing %s, %d rules were added and %d removed out of band", f.Name(), len(added), len(removed))
+
+		updated := current
+		updated.Inbound = append(kept, f.applied...)
+
+		if err := f.client.SetFirewallRules(ctx, f.id, updated); err != nil {
+			return fmt.Errorf("repairing %s: %w", f.Name(), err)
+		}
+
+		metrics.AddCounter("linode_tools_firewall_drift_repairs_total", "Repairs of the owned rules of the Cloud Firewall", labels, 1)
+		metrics.SetGauge("linode_tools_firewall_drift", "Whether the owned rules of the Cloud Firewall differ from those applied", labels, 0)
+
+		// The lines are those of the firewall, so added were removed by the repair and the other way around
+		f.auditLog.Write(audit.Record{Target: f.Name(), Added: removed, Removed: added, Hash: audit.Hash(f.appliedLines), Reload: "drift repaired"})
+		f.reported = ""
+
+		return nil
+	}
+
+	drift := strings.Join(live, "\n")
+	if drift == f.reported {
+		return nil
+	}
+	f.reported = drift
+
+	f.notifier.Alert("%s drifted, %d node rules were added and %d removed out of band", f.Name(), len(added), len(removed))
+	f.auditLog.Write(audit.Record{Target: f.Name(), Added: added, Removed: removed, Hash: audit.Hash(live), Reload: "drift detected"})
+
+	return nil
+}
+
+// Run - Run the firewall subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("firewall", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var id int
+	fs.IntVar(&id, "firewall-id", 0, "id of the Cloud Firewall whose inbound rules allow the nodes")
+
+	var rules string
+	fs.StringVar(&rules, "rules", "", "comma separated services the nodes are allowed to reach, as name:ports[:protocol], for example mongodb:27017,dns:53:udp")
+
+	var prefix string
+	fs.StringVar(&prefix, "rule-prefix", "kube-", "prefix of the labels of the rules allowing the nodes, rules without it are left alone")
+
+	var driftInterval time.Duration
+	fs.DurationVar(&driftInterval, "drift-interval", 5*time.Minute, "interval the rules of the firewall are checked for changes made out of band, 0 disables the check")
+
+	var driftMode string
+	fs.StringVar(&driftMode, "drift-mode", driftReport, "what to do with rules changed out of band: report alerts on them, enforce puts the applied rules back")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if id == 0 {
+		return fmt.Errorf("no firewall given in -firewall-id")
+	}
+
+	if common.LinodeToken == "" {
+		return fmt.Errorf("the linode api needs a token in -linode-token or $LINODE_TOKEN")
+	}
+
+	if prefix == "" {
+		return fmt.Errorf("-rule-prefix cannot be empty, it tells the node rules from the others")
+	}
+
+	if driftMode != driftReport && driftMode != driftEnforce {
+		return fmt.Errorf("-drift-mode is %s, expected %s or %s", driftMode, driftReport, driftEnforce)
+	}
+
+	parsed, err := parseRules(rules, prefix)
+	if err != nil {
+		return err
+	}
+
+	if len(parsed) == 0 {
+		return fmt.Errorf("no rules given in -rules")
+	}
+
+	common.Start(fs.Name())
+
+	f := &cloudFirewall{
+		client:   linode.NewClient(common.LinodeToken),
+		id:       id,
+		prefix:   prefix,
+		rules:    parsed,
+		auditLog: audit.Open(common.AuditLog, fs.Name()),
+	}
+
+	log.Info().Msgf("keeping %d rules of %s", len(parsed), f.Name())
+
+	if common.Output != "" {
+		return common.Print([]backend.Backend{f})
+	}
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+	f.notifier = notifier
+
+	backends := common.Track([]backend.Backend{f})
+
+	if driftInterval > 0 {
+		go f.watchDrift(driftInterval, driftMode)
+	}
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/linode"
+)
+
+// fakeAPI serves the rules of a Cloud Firewall, recording the updates
+type fakeAPI struct {
+	mu      sync.Mutex
+	rules   linode.FirewallRules
+	updates int
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if req.URL.Path != "/networking/firewalls/7/rules" {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(a.rules)
+	case http.MethodPut:
+		var rules linode.FirewallRules
+		if err := json.NewDecoder(req.Body).Decode(&rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.rules = rules
+		a.updates++
+		w.Write([]byte("{}"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// labels - The labels of the inbound rules of the firewall
+func (a *fakeAPI) labels() []string {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var labels []string
+	for _, r := range a.rules.Inbound {
+		labels = append(labels, r.Label)
+	}
+
+	return labels
+}
+
+// newFirewall - A firewall allowing mongodb to the nodes, through the fake API with a rule maintained by hand
+func newFirewall(t *testing.T) (*cloudFirewall, *fakeAPI) {
+
+	t.Helper()
+
+	api := &fakeAPI{rules: linode.FirewallRules{
+		InboundPolicy: "DROP",
+		Inbound: []linode.FirewallRule{
+			{Action: "ACCEPT", Protocol: "TCP", Ports: "22", Label: "ssh-office", Addresses: linode.FirewallAddresses{IPv4: []string{"203.0.113.0/24"}}},
+			{Action: "ACCEPT", Protocol: "TCP", Ports: "27017", Label: "kube-mongodb", Addresses: linode.FirewallAddresses{IPv4: []string{"10.0.0.9/32"}}},
+		},
+	}}
+
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	client := linode.NewClient("token")
+	client.BaseURL = server.URL
+
+	return &cloudFirewall{client: client, id: 7, prefix: "kube-", rules: []rule{{name: "mongodb", ports: "27017", protocol: "TCP"}}}, api
+}
+
+// apply - Render, validate and apply the firewall for the nodes
+func apply(t *testing.T, f *cloudFirewall, nodes []discovery.Node) {
+
+	t.Helper()
+
+	lines, err := f.Render(context.Background(), nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate(context.Background(), lines); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Apply(context.Background(), lines); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestApply checks the rules with the prefix are replaced, the rules maintained by hand kept, an unchanged render
+// does not update the firewall and Rollback puts the rules back
+func TestApply(t *testing.T) {
+
+	f, api := newFirewall(t)
+
+	nodes := []discovery.Node{{Name: "node-1", IP: net.ParseIP("10.0.0.1")}, {Name: "node-2", IP: net.ParseIP("10.0.0.2")}}
+
+	apply(t, f, nodes)
+
+	if api.updates != 1 {
+		t.Fatalf("the firewall was updated %d times, expected once", api.updates)
+	}
+	if got := api.labels(); !reflect.DeepEqual(got, []string{"ssh-office", "kube-mongodb"}) {
+		t.Errorf("the firewall has the rules %q, expected the hand rule and kube-mongodb", got)
+	}
+	if got := api.rules.Inbound[1].Addresses.IPv4; !reflect.DeepEqual(got, []string{"10.0.0.1/32", "10.0.0.2/32"}) {
+		t.Errorf("kube-mongodb allows %q, expected the nodes", got)
+	}
+
+	apply(t, f, nodes)
+
+	if api.updates != 1 {
+		t.Errorf("the firewall was updated again without a change")
+	}
+
+	if err := f.Rollback(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.rules.Inbound[1].Addresses.IPv4; !reflect.DeepEqual(got, []string{"10.0.0.9/32"}) {
+		t.Errorf("kube-mongodb allows %q after the rollback, expected the address from before", got)
+	}
+}
+
+// TestDrift checks an owned rule removed out of band is only reported in report mode and put back in enforce mode
+func TestDrift(t *testing.T) {
+
+	f, api := newFirewall(t)
+
+	apply(t, f, []discovery.Node{{Name: "node-1", IP: net.ParseIP("10.0.0.1")}})
+
+	// Removed in Cloud Manager
+	api.mu.Lock()
+	api.rules.Inbound = api.rules.Inbound[:1]
+	api.mu.Unlock()
+
+	if err := f.checkDrift(driftReport); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.labels(); !reflect.DeepEqual(got, []string{"ssh-office"}) {
+		t.Errorf("the firewall has the rules %q after a report, expected it untouched", got)
+	}
+
+	if err := f.checkDrift(driftEnforce); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.labels(); !reflect.DeepEqual(got, []string{"ssh-office", "kube-mongodb"}) {
+		t.Errorf("the firewall has the rules %q after enforcing, expected kube-mongodb back", got)
+	}
+}
+
+// TestRender checks the nodes are spread over numbered rules when they do not fit in one
+func TestRender(t *testing.T) {
+
+	f := &cloudFirewall{prefix: "kube-", rules: []rule{{name: "mongodb", ports: "27017", protocol: "TCP"}}}
+
+	var nodes []discovery.Node
+	for i := 0; i < maxAddresses+1; i++ {
+		nodes = append(nodes, discovery.Node{Name: fmt.Sprintf("node-%d", i), IP: net.IPv4(10, 0, byte(i/250), byte(i%250+1))})
+	}
+	nodes = append(nodes, discovery.Node{Name: "node-v6", IP: net.ParseIP("2600:3c00::1")})
+
+	if _, err := f.Render(context.Background(), nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.desired) != 2 || f.desired[0].Label != "kube-mongodb" || f.desired[1].Label != "kube-mongodb-2" {
+		t.Fatalf("rendered %d rules, expected kube-mongodb and kube-mongodb-2", len(f.desired))
+	}
+	if n := len(f.desired[0].Addresses.IPv4); n != maxAddresses {
+		t.Errorf("the first rule has %d addresses, expected %d", n, maxAddresses)
+	}
+	if got := f.desired[1].Addresses; len(got.IPv4) != 1 || !reflect.DeepEqual(got.IPv6, []string{"2600:3c00::1/128"}) {
+		t.Errorf("the second rule has %v, expected the last IPv4 node and the IPv6 node", got)
+	}
+}
+
+// TestParseRules checks the name:ports[:protocol] rules and the length of their labels
+func TestParseRules(t *testing.T) {
+
+	tests := []struct {
+		rules    string
+		expected []rule
+		err      bool
+	}{
+		{rules: "mongodb:27017, dns:53:udp", expected: []rule{{"mongodb", "27017", "TCP"}, {"dns", "53", "UDP"}}},
+		{rules: "mongodb", err: true},
+		{rules: "dns:53:icmp", err: true},
+		{rules: "a-service-with-a-very-long-name:443", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rules, func(t *testing.T) {
+
+			rules, err := parseRules(tt.rules, "kube-")
+			if (err != nil) != tt.err {
+				t.Fatalf("parseRules returned %v", err)
+			}
+			if !reflect.DeepEqual(rules, tt.expected) {
+				t.Errorf("parsed %v, expected %v", rules, tt.expected)
+			}
+		})
+	}
+}
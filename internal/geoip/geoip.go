@@ -0,0 +1,182 @@
+// Package geoip downloads the CIDRs allocated to countries, so traffic from them can be denied next to
+// the rules allowing the nodes.
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultURL is the list of aggregated CIDRs of a country, %s is the lower case country code
+const DefaultURL = "https://www.ipdeny.com/ipblocks/data/aggregated/%s-aggregated.zone"
+
+// Source downloads the CIDR lists, keeping a copy of every list in Cache so a failed download falls
+// back to the last list downloaded
+type Source struct {
+	URL   string
+	Cache string
+	HTTP  *http.Client
+}
+
+// NewSource - A source downloading from the url, with %s replaced by the country code
+func NewSource(url string, cache string) *Source {
+	return &Source{URL: url, Cache: cache, HTTP: &http.Client{Timeout: time.Minute}}
+}
+
+// CIDRs - The IPv4 CIDRs of the countries, sorted and without duplicates
+func (s *Source) CIDRs(ctx context.Context, countries []string) ([]string, error) {
+
+	seen := make(map[string]bool)
+	var cidrs []string
+
+	for _, country := range countries {
+		list, err := s.country(ctx, strings.ToLower(country))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cidr := range list {
+			if !seen[cidr] {
+				seen[cidr] = true
+				cidrs = append(cidrs, cidr)
+			}
+		}
+	}
+
+	sort.Strings(cidrs)
+
+	return cidrs, nil
+}
+
+// country - The CIDRs of a country, from the cache when the download fails
+func (s *Source) country(ctx context.Context, country string) ([]string, error) {
+
+	cache := filepath.Join(s.Cache, country+".zone")
+
+	data, err := s.download(ctx, fmt.Sprintf(s.URL, country))
+	if err == nil {
+		var cidrs []string
+		cidrs, err = parse(data)
+		if err == nil {
+			if s.Cache != "" {
+				if err := os.MkdirAll(s.Cache, 0755); err == nil {
+					os.WriteFile(cache, []byte(data), 0644)
+				}
+			}
+			log.Info().Msgf("downloaded %d cidrs of %s", len(cidrs), country)
+			return cidrs, nil
+		}
+	}
+
+	if s.Cache == "" {
+		return nil, fmt.Errorf("unable to download the cidrs of %s: %w", country, err)
+	}
+
+	cached, readErr := os.ReadFile(cache)
+	if readErr != nil {
+		return nil, fmt.Errorf("unable to download the cidrs of %s and no cached list: %w", country, err)
+	}
+
+	log.Warn().Err(err).Msgf("unable to download the cidrs of %s, using the cached list", country)
+
+	return parse(string(cached))
+}
+
+// download - The body of the list
+func (s *Source) download(ctx context.Context, url string) (string, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// parse - The CIDRs of a list with one CIDR per line, skipping blank lines and # comments
+func parse(data string) ([]string, error) {
+
+	var cidrs []string
+
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ip, ipnet, err := net.ParseCIDR(line)
+		if err != nil || ip.To4() == nil {
+			return nil, fmt.Errorf("%q is not an IPv4 CIDR", line)
+		}
+
+		cidrs = append(cidrs, ipnet.String())
+	}
+
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("the list is empty")
+	}
+
+	return cidrs, nil
+}
+
+// List holds the current CIDRs of a set of countries, refreshed in the background
+type List struct {
+	Countries []string
+
+	mu    sync.Mutex
+	cidrs []string
+}
+
+// Get - The current CIDRs, empty until the first refresh succeeded
+func (l *List) Get() []string {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.cidrs
+}
+
+// Refresh - Download the CIDRs of the countries, keeping the current CIDRs when it fails.  Whether the
+// CIDRs changed is returned.
+func (l *List) Refresh(ctx context.Context, source *Source) (bool, error) {
+
+	cidrs, err := source.CIDRs(ctx, l.Countries)
+	if err != nil {
+		return false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	changed := strings.Join(cidrs, ",") != strings.Join(l.cidrs, ",")
+	l.cidrs = cidrs
+
+	return changed, nil
+}
@@ -0,0 +1,49 @@
+// Package hostexec runs the apply and reload commands on the host.  When the tools run in a privileged
+// DaemonSet pod with the host filesystem mounted, the commands enter the namespaces of the host so they
+// reload the services of the host and not of the pod.
+package hostexec
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Root is where the host filesystem is mounted in the pod, such as /host, empty when the tools run on the host
+var Root string
+
+// Command - Like exec.CommandContext, but run through nsenter in the mount, UTS, IPC, network and PID namespaces
+// of the init process of the host when Root is set.  The pod needs hostPID and to be privileged.  The command is
+// killed when the context is done, such as the context of the reconcile it runs in.
+func Command(ctx context.Context, name string, arg ...string) *exec.Cmd {
+
+	if Root == "" {
+		return exec.CommandContext(ctx, name, arg...)
+	}
+
+	args := append([]string{"--target", "1", "--mount", "--uts", "--ipc", "--net", "--pid", "--", name}, arg...)
+
+	return exec.CommandContext(ctx, "nsenter", args...)
+}
+
+// Path - A path of the pod as the host sees it, without the Root prefix, for the arguments of a Command
+func Path(path string) string {
+
+	if Root == "" {
+		return path
+	}
+
+	root := filepath.Clean(Root)
+	path = filepath.Clean(path)
+
+	if path == root {
+		return "/"
+	}
+
+	if strings.HasPrefix(path, root+"/") {
+		return strings.TrimPrefix(path, root)
+	}
+
+	return path
+}
@@ -0,0 +1,177 @@
+package hostexec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/metrics"
+)
+
+// HistorySize is the number of command results kept for Recent
+const HistorySize = 50
+
+// outputLimit is the number of bytes of stdout and stderr kept in a Result, the end of the output
+const outputLimit = 4096
+
+// Result is the outcome of a command run by Run
+type Result struct {
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command"`
+	ExitCode int       `json:"exit_code"`
+	Stdout   string    `json:"stdout,omitempty"`
+	Stderr   string    `json:"stderr,omitempty"`
+	Seconds  float64   `json:"seconds"`
+	Error    string    `json:"error,omitempty"`
+
+	// program is the base name of the executable, the label of the metrics
+	program string
+	err     error
+}
+
+var (
+	resultsMu sync.Mutex
+	results   []Result
+)
+
+// Run - Run a command of Command to completion, capturing its stdout and stderr apart unless they are set, and
+// log, count and remember the outcome.  ExitCode is -1 when the command did not start or was killed.
+func Run(cmd *exec.Cmd) Result {
+
+	var stdout, stderr bytes.Buffer
+	if cmd.Stdout == nil {
+		cmd.Stdout = &stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = &stderr
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+
+	r := Result{
+		Time:    start.UTC(),
+		Command: strings.Join(cmd.Args, " "),
+		Stdout:  tail(stdout.String()),
+		Stderr:  tail(stderr.String()),
+		Seconds: time.Since(start).Seconds(),
+		program: program(cmd.Args),
+		err:     err,
+	}
+
+	if err != nil {
+		r.Error = err.Error()
+		r.ExitCode = -1
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			r.ExitCode = exitErr.ExitCode()
+		}
+	}
+
+	r.record()
+
+	return r
+}
+
+// Output - The stdout of the command, or its stderr when it printed nothing on stdout, for the logs
+func (r Result) Output() string {
+
+	if out := strings.TrimSpace(r.Stdout); out != "" {
+		return out
+	}
+
+	return strings.TrimSpace(r.Stderr)
+}
+
+// Err - Nil when the command exited with 0, otherwise an error with the exit status and the stderr of the
+// command, or its stdout when it printed nothing on stderr
+func (r Result) Err() error {
+
+	if r.err == nil {
+		return nil
+	}
+
+	out := strings.TrimSpace(r.Stderr)
+	if out == "" {
+		out = strings.TrimSpace(r.Stdout)
+	}
+
+	if out == "" {
+		return fmt.Errorf("%s: %w", r.program, r.err)
+	}
+
+	return fmt.Errorf("%s: %w: %s", r.program, r.err, out)
+}
+
+// record - Log the result, update the metrics of the program and add the result to the history
+func (r Result) record() {
+
+	if r.err != nil {
+		log.Error().Str("command", r.Command).Int("exit_code", r.ExitCode).Str("stderr", strings.TrimSpace(r.Stderr)).Str("stdout", strings.TrimSpace(r.Stdout)).Err(r.err).Msgf("%s failed", r.program)
+		metrics.AddCounter("linode_tools_commands_total", "Commands run on the host by their result", metrics.Labels{"command": r.program, "result": "failed"}, 1)
+	} else {
+		log.Debug().Str("command", r.Command).Float64("seconds", r.Seconds).Msgf("%s completed", r.program)
+		metrics.AddCounter("linode_tools_commands_total", "Commands run on the host by their result", metrics.Labels{"command": r.program, "result": "ok"}, 1)
+	}
+
+	metrics.SetGauge("linode_tools_command_duration_seconds", "Duration of the last run of a command on the host", metrics.Labels{"command": r.program}, r.Seconds)
+	metrics.SetGauge("linode_tools_command_exit_code", "Exit code of the last run of a command on the host, -1 when it did not start or was killed", metrics.Labels{"command": r.program}, float64(r.ExitCode))
+
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	results = append(results, r)
+	if len(results) > HistorySize {
+		results = results[len(results)-HistorySize:]
+	}
+}
+
+// Recent - The results of the last commands, newest first
+func Recent() []Result {
+
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	recent := make([]Result, 0, len(results))
+	for i := len(results) - 1; i >= 0; i-- {
+		recent = append(recent, results[i])
+	}
+
+	return recent
+}
+
+// program - The base name of the executable of the arguments of a command, the one nsenter runs when it is nsenter
+func program(args []string) string {
+
+	if len(args) == 0 {
+		return ""
+	}
+
+	if filepath.Base(args[0]) == "nsenter" {
+		for i, arg := range args {
+			if arg == "--" && i+1 < len(args) {
+				return filepath.Base(args[i+1])
+			}
+		}
+	}
+
+	return filepath.Base(args[0])
+}
+
+// tail - The end of an output longer than outputLimit
+func tail(output string) string {
+
+	if len(output) <= outputLimit {
+		return output
+	}
+
+	return "..." + output[len(output)-outputLimit:]
+}
@@ -0,0 +1,155 @@
+// Package hosts keeps a managed block of a hosts file in sync with the cluster node names.
+package hosts
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/diff"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+const (
+	beginMarker = "### BEGIN kube-hosts ###"
+	endMarker   = "### END kube-hosts ###"
+)
+
+// ServiceReload - Reload a service such as dnsmasq after updating its hosts file
+func ServiceReload(ctx context.Context, systemctlcmd string, service string) error {
+
+	log.Info().Msgf("reloading %s using command: %s reload %s", service, systemctlcmd, service)
+
+	result := hostexec.Run(hostexec.Command(ctx, systemctlcmd, "reload", service))
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("%s reload completed in %.1fs %s", service, result.Seconds, result.Output())
+
+	return nil
+}
+
+// buildHosts - Build a hosts entry for every node, optionally adding the fully qualified name
+func buildHosts(nodes []discovery.Node, domain string) []string {
+
+	log.Info().Msg("building new hosts entries for new list of nodes")
+
+	var hosts []render.Host
+	for _, n := range nodes {
+		hosts = append(hosts, render.Host{Name: n.Name, IP: n.IP})
+	}
+
+	return render.Hosts(hosts, domain)
+}
+
+// Run - Run the hosts subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("hosts", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var hostsfile string
+	fs.StringVar(&hostsfile, "hosts", "/etc/hosts", "hosts file to maintain, for example /etc/hosts or a dnsmasq addn-hosts file")
+
+	var domain string
+	fs.StringVar(&domain, "domain", "", "(optional) domain appended to the node names")
+
+	var systemctl string
+	fs.StringVar(&systemctl, "systemctl", "/bin/systemctl", "systemctl executable command")
+
+	var reload string
+	fs.StringVar(&reload, "reload", "", "(optional) service to reload after the hosts file changes, for example dnsmasq")
+
+	var backups int
+	fs.IntVar(&backups, "backups", 5, "number of timestamped backups of the hosts file to keep, 0 disables backups")
+
+	var rollback bool
+	fs.BoolVar(&rollback, "rollback", false, "restore the newest backup of the hosts file, reload the service and exit")
+
+	markers := configfile.Markers{Begin: beginMarker, End: endMarker}
+	fs.StringVar(&markers.Begin, "begin-marker", beginMarker, "line starting the managed block of the hosts file")
+	fs.StringVar(&markers.End, "end-marker", endMarker, "line ending the managed block of the hosts file")
+
+	var dryRun bool
+	fs.BoolVar(&dryRun, "dry-run", false, "print a unified diff of the changes to the hosts file and exit without writing it")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := markers.Validate(); err != nil {
+		return err
+	}
+
+	common.Start(fs.Name())
+
+	log.Info().Msgf("using hosts file %s", hostsfile)
+
+	if rollback {
+		if _, err := configfile.Rollback(context.Background(), hostsfile); err != nil {
+			return err
+		}
+		if reload != "" {
+			ServiceReload(context.Background(), systemctl, reload)
+		}
+		return nil
+	}
+
+	h := &hostsFile{
+		tool:      fs.Name(),
+		path:      hostsfile,
+		domain:    domain,
+		systemctl: systemctl,
+		reload:    reload,
+		backups:   backups,
+		markers:   markers,
+	}
+
+	if common.Output != "" {
+		return common.Print([]backend.Backend{h})
+	}
+
+	if dryRun {
+		nodes, err := common.Source().Nodes(context.Background())
+		if err != nil {
+			return err
+		}
+
+		rendered, err := h.Render(context.Background(), nodes)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(diff.Unified(hostsfile, hostsfile+" (rendered)", h.existing, rendered, 3))
+		return nil
+	}
+
+	h.auditLog = audit.Open(common.AuditLog, fs.Name())
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+
+	backends := common.Track([]backend.Backend{h})
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package hosts
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/internal/tracing"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// hostsFile keeps the managed block of a hosts file as a backend.Backend
+type hostsFile struct {
+	tool      string
+	path      string
+	domain    string
+	systemctl string
+	reload    string
+	backups   int
+	markers   configfile.Markers
+	auditLog  *audit.Log
+
+	// The hosts file, entries and nodes read and rendered by the last Render
+	existing []string
+	entries  []string
+	ips      []net.IP
+
+	// The nodes of the last applied hosts file, to record what changed
+	applied []net.IP
+}
+
+// Name - The path of the hosts file
+func (h *hostsFile) Name() string {
+	return h.path
+}
+
+// Render - The hosts file with the managed block holding an entry for every node
+func (h *hostsFile) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	existing, err := configfile.Read(h.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", h.path, err)
+	}
+
+	h.existing = existing
+	h.entries = buildHosts(nodes, h.domain)
+	h.ips = discovery.IPs(nodes)
+
+	merged, err := h.markers.Merge(existing, h.entries)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", h.path, err)
+	}
+
+	return merged, nil
+}
+
+// Validate - The managed block is rendered from the nodes alone, there is nothing to check
+func (h *hostsFile) Validate(ctx context.Context, rendered []string) error {
+	return nil
+}
+
+// Apply - Write the hosts file and reload the service reading it
+func (h *hostsFile) Apply(ctx context.Context, rendered []string) error {
+
+	if h.backups > 0 {
+		if err := configfile.Backup(h.path, h.backups); err != nil {
+			return fmt.Errorf("unable to backup %s: %w", h.path, err)
+		}
+	}
+
+	if err := configfile.Write(ctx, h.path, rendered); err != nil {
+		return fmt.Errorf("unable to write %s: %w", h.path, err)
+	}
+
+	record := audit.Record{Target: h.path, Hash: audit.Hash(h.entries)}
+
+	if h.reload != "" {
+		span := tracing.Start("reload " + h.reload)
+		reloadErr := ServiceReload(ctx, h.systemctl, h.reload)
+		span.End(reloadErr)
+		record.Reload = audit.Result(reloadErr)
+
+		if reloadErr != nil {
+			metrics.AddCounter("linode_tools_reload_failures_total", "Reloads that exited with an error", metrics.Labels{"tool": h.tool}, 1)
+			h.auditLog.Write(record)
+			return fmt.Errorf("%s reload failed: %w", h.reload, reloadErr)
+		}
+	}
+
+	record.Added, record.Removed = audit.Diff(h.applied, h.ips)
+	h.auditLog.Write(record)
+
+	h.applied = h.ips
+
+	return nil
+}
+
+// Rollback - Put the previous hosts file back so the service keeps running with a file it accepts
+func (h *hostsFile) Rollback(ctx context.Context) error {
+
+	if err := configfile.Write(ctx, h.path, h.existing); err != nil {
+		return fmt.Errorf("unable to restore %s: %w", h.path, err)
+	}
+
+	h.auditLog.Write(audit.Record{Target: h.path, Reload: "rolled back"})
+
+	if h.reload != "" {
+		if err := ServiceReload(ctx, h.systemctl, h.reload); err != nil {
+			return fmt.Errorf("restored the previous %s but the reload still fails: %w", h.path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+// Package install writes a systemd unit running a tool with the flags it is installed with.
+package install
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// quote - Quote an argument of ExecStart when it needs it, systemd splits on spaces and expands % and $
+func quote(arg string) string {
+
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+
+	return `"` + arg + `"`
+}
+
+// Unit - The systemd unit running the executable with the arguments, from the state directory
+func Unit(name string, executable string, args []string, stateDir string, user string) string {
+
+	command := []string{quote(executable)}
+	for _, a := range args {
+		command = append(command, quote(a))
+	}
+
+	var unit strings.Builder
+
+	fmt.Fprintln(&unit, "[Unit]")
+	fmt.Fprintf(&unit, "Description=%s\n", name)
+	fmt.Fprintln(&unit, "Wants=network-online.target")
+	fmt.Fprintln(&unit, "After=network-online.target")
+	fmt.Fprintln(&unit)
+	fmt.Fprintln(&unit, "[Service]")
+	fmt.Fprintf(&unit, "ExecStart=%s\n", strings.Join(command, " "))
+	fmt.Fprintf(&unit, "WorkingDirectory=%s\n", stateDir)
+	if user != "" {
+		fmt.Fprintf(&unit, "User=%s\n", user)
+	}
+	fmt.Fprintln(&unit, "Restart=always")
+	fmt.Fprintln(&unit, "RestartSec=5")
+	fmt.Fprintln(&unit)
+	fmt.Fprintln(&unit, "[Install]")
+	fmt.Fprintln(&unit, "WantedBy=multi-user.target")
+
+	return unit.String()
+}
+
+// Run - Run the install command.  The flags of the install command come first, the remaining arguments
+// are baked into the unit.  When commands is given the first remaining argument must be one of them,
+// as for the subcommands of linode-tools, and it is added to the unit name.
+func Run(args []string, tool string, commands []string) error {
+
+	fs := flag.NewFlagSet(tool+" install", flag.ExitOnError)
+
+	fs.Usage = func() {
+		if commands != nil {
+			fmt.Fprintf(fs.Output(), "usage: %s install [install flags] <command> [flags]\n\n", tool)
+		} else {
+			fmt.Fprintf(fs.Output(), "usage: %s install [install flags] -- [flags]\n\n", tool)
+		}
+		fs.PrintDefaults()
+	}
+
+	var name string
+	fs.StringVar(&name, "name", "", "name of the systemd unit, defaults to the tool and command")
+
+	var unitDir string
+	fs.StringVar(&unitDir, "unit-dir", "/etc/systemd/system", "directory the unit file is written to")
+
+	var stateDir string
+	fs.StringVar(&stateDir, "state-dir", "", "state directory the service runs in, defaults to /var/lib/<name>")
+
+	var user string
+	fs.StringVar(&user, "user", "", "(optional) user the service runs as, root when empty")
+
+	var systemctl string
+	fs.StringVar(&systemctl, "systemctl", "/bin/systemctl", "systemctl executable command")
+
+	var enable bool
+	fs.BoolVar(&enable, "enable", true, "enable and start the service after writing the unit")
+
+	var printUnit bool
+	fs.BoolVar(&printUnit, "print", false, "print the unit and exit without writing anything")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rest := fs.Args()
+
+	unitName := tool
+
+	if commands != nil {
+		if len(rest) == 0 {
+			fs.Usage()
+			return fmt.Errorf("no command given")
+		}
+
+		known := false
+		for _, c := range commands {
+			known = known || c == rest[0]
+		}
+		if !known {
+			return fmt.Errorf("unknown command %q", rest[0])
+		}
+
+		unitName = tool + "-" + rest[0]
+	}
+
+	if name == "" {
+		name = unitName
+	}
+
+	if stateDir == "" {
+		stateDir = filepath.Join("/var/lib", name)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	unit := Unit(name, executable, rest, stateDir, user)
+
+	if printUnit {
+		fmt.Print(unit)
+		return nil
+	}
+
+	if err := os.MkdirAll(stateDir, 0750); err != nil {
+		return fmt.Errorf("unable to create %s: %w", stateDir, err)
+	}
+
+	path := filepath.Join(unitDir, name+".service")
+
+	log.Info().Msgf("writing %s", path)
+
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+
+	if !enable {
+		return nil
+	}
+
+	for _, command := range [][]string{{"daemon-reload"}, {"enable", "--now", name + ".service"}} {
+		log.Info().Msgf("running %s %s", systemctl, strings.Join(command, " "))
+
+		out, err := exec.Command(systemctl, command...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s %s failed: %w: %s", systemctl, strings.Join(command, " "), err, out)
+		}
+	}
+
+	return nil
+}
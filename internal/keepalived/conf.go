@@ -0,0 +1,234 @@
+package keepalived
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/hosts"
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/internal/tracing"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// virtualServer is a virtual_server block of the config whose real servers are the nodes
+type virtualServer struct {
+	// Key is what follows virtual_server, such as 203.0.113.10 80 or fwmark 1
+	Key      string
+	NodePort int
+}
+
+// conf keeps the real_server entries of the virtual_server blocks of a keepalived config as a backend.Backend
+type conf struct {
+	tool           string
+	path           string
+	servers        []virtualServer
+	connectTimeout time.Duration
+	systemctl      string
+	backups        int
+	auditLog       *audit.Log
+
+	// The config file and nodes read by the last Render
+	existing []string
+	ips      []net.IP
+
+	// The nodes of the last applied config, to record what changed
+	applied []net.IP
+}
+
+// Name - The path of the keepalived config
+func (c *conf) Name() string {
+	return c.path
+}
+
+// Render - The config with the real servers of the managed virtual servers replaced by the nodes
+func (c *conf) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	existing, err := configfile.Read(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", c.path, err)
+	}
+
+	c.existing = existing
+	c.ips = render.SortIPs(discovery.IPs(nodes))
+
+	return c.merge(existing, c.ips)
+}
+
+// merge - Replace every real_server block of the managed virtual_server blocks with one per address,
+// leaving the rest of the config as it is
+func (c *conf) merge(existing []string, ips []net.IP) ([]string, error) {
+
+	wanted := make(map[string]virtualServer)
+	for _, vs := range c.servers {
+		wanted[vs.Key] = vs
+	}
+	found := make(map[string]bool)
+
+	var merged []string
+
+	depth := 0
+	var current *virtualServer
+	indent := ""
+	skipUntil := -1
+
+	for _, line := range existing {
+		code := withoutComment(line)
+		opens, closes := strings.Count(code, "{"), strings.Count(code, "}")
+		fields := strings.Fields(code)
+
+		// A virtual_server block at the top level
+		if depth == 0 && len(fields) > 1 && fields[0] == "virtual_server" && opens > 0 {
+			key := strings.TrimSpace(strings.TrimSuffix(strings.Join(fields[1:], " "), "{"))
+			if vs, ok := wanted[key]; ok {
+				current = &vs
+				found[key] = true
+				indent = leading(line) + "    "
+			}
+		}
+
+		// A real_server block of a managed virtual server is dropped along with everything inside it
+		if current != nil && skipUntil < 0 && depth == 1 && len(fields) > 0 && fields[0] == "real_server" {
+			skipUntil = depth
+		}
+
+		// The closing brace of a managed virtual server, the nodes are added right before it
+		closing := current != nil && skipUntil < 0 && depth+opens-closes == 0 && depth > 0
+		if closing {
+			merged = append(merged, c.realServers(indent, current.NodePort, ips)...)
+			current = nil
+		}
+
+		depth += opens - closes
+		if depth < 0 {
+			return nil, fmt.Errorf("%s has an unbalanced closing brace", c.path)
+		}
+
+		if skipUntil >= 0 {
+			if depth <= skipUntil {
+				skipUntil = -1
+			}
+			continue
+		}
+
+		merged = append(merged, line)
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("%s has an unclosed block", c.path)
+	}
+
+	for _, vs := range c.servers {
+		if !found[vs.Key] {
+			return nil, fmt.Errorf("%s has no virtual_server %s block", c.path, vs.Key)
+		}
+	}
+
+	return merged, nil
+}
+
+// realServers - A real_server block for every address on the node port, with a TCP check when a connect
+// timeout is set
+func (c *conf) realServers(indent string, port int, ips []net.IP) []string {
+
+	var lines []string
+
+	for _, ip := range ips {
+		lines = append(lines,
+			fmt.Sprintf("%sreal_server %s %d {", indent, ip, port),
+			fmt.Sprintf("%s    weight 1", indent),
+		)
+		if c.connectTimeout > 0 {
+			lines = append(lines,
+				fmt.Sprintf("%s    TCP_CHECK {", indent),
+				fmt.Sprintf("%s        connect_timeout %d", indent, int(c.connectTimeout.Seconds())),
+				fmt.Sprintf("%s    }", indent),
+			)
+		}
+		lines = append(lines, indent+"}")
+	}
+
+	return lines
+}
+
+// withoutComment - A line without its # or ! comment
+func withoutComment(line string) string {
+
+	if i := strings.IndexAny(line, "#!"); i >= 0 {
+		return line[:i]
+	}
+
+	return line
+}
+
+// leading - The indentation of a line
+func leading(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// Validate - keepalived would keep a virtual server without real servers, but it would refuse every connection
+func (c *conf) Validate(ctx context.Context, rendered []string) error {
+
+	if len(c.ips) == 0 {
+		return fmt.Errorf("no real servers for %s", c.path)
+	}
+
+	return nil
+}
+
+// Apply - Write the config when it changed and reload keepalived
+func (c *conf) Apply(ctx context.Context, rendered []string) error {
+
+	if configfile.Equal(c.existing, rendered) {
+		return nil
+	}
+
+	if c.backups > 0 {
+		if err := configfile.Backup(c.path, c.backups); err != nil {
+			return fmt.Errorf("unable to backup %s: %w", c.path, err)
+		}
+	}
+
+	if err := configfile.Write(ctx, c.path, rendered); err != nil {
+		return fmt.Errorf("unable to write %s: %w", c.path, err)
+	}
+
+	span := tracing.Start("reload keepalived")
+	reloadErr := hosts.ServiceReload(ctx, c.systemctl, "keepalived")
+	span.End(reloadErr)
+
+	record := audit.Record{Target: c.path, Hash: audit.Hash(rendered), Reload: audit.Result(reloadErr)}
+	if reloadErr != nil {
+		metrics.AddCounter("linode_tools_reload_failures_total", "Reloads that exited with an error", metrics.Labels{"tool": c.tool}, 1)
+		c.auditLog.Write(record)
+		return fmt.Errorf("keepalived reload failed: %w", reloadErr)
+	}
+
+	record.Added, record.Removed = audit.Diff(c.applied, c.ips)
+	c.auditLog.Write(record)
+
+	c.applied = c.ips
+
+	return nil
+}
+
+// Rollback - Put the previous config back and reload keepalived with it
+func (c *conf) Rollback(ctx context.Context) error {
+
+	if err := configfile.Write(ctx, c.path, c.existing); err != nil {
+		return fmt.Errorf("unable to restore %s: %w", c.path, err)
+	}
+
+	c.auditLog.Write(audit.Record{Target: c.path, Reload: "rolled back"})
+
+	if err := hosts.ServiceReload(ctx, c.systemctl, "keepalived"); err != nil {
+		return fmt.Errorf("restored the previous %s but the reload still fails: %w", c.path, err)
+	}
+
+	return nil
+}
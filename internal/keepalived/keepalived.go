@@ -0,0 +1,154 @@
+// Package keepalived keeps the real_server entries of keepalived virtual_server blocks in sync with the
+// nodes, so IPVS load balancing onto the node ports follows the cluster.
+package keepalived
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/diff"
+	"github.com/rsvancara/linode-tools/internal/hosts"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// parseVirtualServers - Parse a comma separated list of virtual servers and their node port, such as
+// 203.0.113.10 80=30080,fwmark 1=30443
+func parseVirtualServers(spec string) ([]virtualServer, error) {
+
+	var servers []virtualServer
+
+	for _, item := range strings.Split(spec, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("virtual server %q is not of the form address port=nodeport", item)
+		}
+
+		port, err := strconv.Atoi(strings.TrimSpace(item[i+1:]))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("virtual server %q has an invalid node port", item)
+		}
+
+		key := strings.Join(strings.Fields(item[:i]), " ")
+		if key == "" {
+			return nil, fmt.Errorf("virtual server %q has no address", item)
+		}
+
+		servers = append(servers, virtualServer{Key: key, NodePort: port})
+	}
+
+	return servers, nil
+}
+
+// Run - Run the keepalived subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("keepalived", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var config string
+	fs.StringVar(&config, "config", "/etc/keepalived/keepalived.conf", "keepalived config file holding the virtual_server blocks")
+
+	var virtualServers string
+	fs.StringVar(&virtualServers, "virtual-servers", "", "comma separated virtual_server blocks whose real servers are the nodes, each with the node port, for example '203.0.113.10 80=30080,203.0.113.10 443=30443'")
+
+	var connectTimeout time.Duration
+	fs.DurationVar(&connectTimeout, "connect-timeout", 3*time.Second, "connect timeout of the TCP_CHECK of every real server, 0 for no health check")
+
+	var systemctl string
+	fs.StringVar(&systemctl, "systemctl", "/bin/systemctl", "systemctl executable command")
+
+	var backups int
+	fs.IntVar(&backups, "backups", 5, "number of timestamped backups of the config file to keep, 0 disables backups")
+
+	var rollback bool
+	fs.BoolVar(&rollback, "rollback", false, "restore the newest backup of the config file, reload keepalived and exit")
+
+	var dryRun bool
+	fs.BoolVar(&dryRun, "dry-run", false, "print a unified diff of the changes to the config file and exit without writing it")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	servers, err := parseVirtualServers(virtualServers)
+	if err != nil {
+		return err
+	}
+
+	if len(servers) == 0 {
+		return fmt.Errorf("no virtual servers given in -virtual-servers")
+	}
+
+	common.Start(fs.Name())
+
+	log.Info().Msgf("using keepalived config file %s", config)
+
+	if rollback {
+		if _, err := configfile.Rollback(context.Background(), config); err != nil {
+			return err
+		}
+		return hosts.ServiceReload(context.Background(), systemctl, "keepalived")
+	}
+
+	c := &conf{
+		tool:           fs.Name(),
+		path:           config,
+		servers:        servers,
+		connectTimeout: connectTimeout,
+		systemctl:      systemctl,
+		backups:        backups,
+	}
+
+	if common.Output != "" {
+		return common.Print([]backend.Backend{c})
+	}
+
+	if dryRun {
+		nodes, err := common.Source().Nodes(context.Background())
+		if err != nil {
+			return err
+		}
+
+		rendered, err := c.Render(context.Background(), nodes)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(diff.Unified(config, config+" (rendered)", c.existing, rendered, 3))
+		return nil
+	}
+
+	c.auditLog = audit.Open(common.AuditLog, fs.Name())
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+
+	backends := common.Track([]backend.Backend{c})
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
@@ -0,0 +1,109 @@
+// Package metrics keeps the counters and gauges of the running tool and exposes them in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Labels are the label names and values of a single series
+type Labels map[string]string
+
+type metric struct {
+	help   string
+	kind   string
+	series map[string]float64
+}
+
+var (
+	mu      sync.Mutex
+	metrics = make(map[string]*metric)
+)
+
+// SetGauge - Set the value of a gauge series
+func SetGauge(name string, help string, labels Labels, value float64) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	lookup(name, help, "gauge").series[labels.String()] = value
+}
+
+// AddCounter - Increase a counter series by delta
+func AddCounter(name string, help string, labels Labels, delta float64) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	lookup(name, help, "counter").series[labels.String()] += delta
+}
+
+func lookup(name string, help string, kind string) *metric {
+
+	m, ok := metrics[name]
+	if !ok {
+		m = &metric{help: help, kind: kind, series: make(map[string]float64)}
+		metrics[name] = m
+	}
+
+	return m
+}
+
+// String - The labels in the Prometheus text format, sorted by name
+func (l Labels) String() string {
+
+	if len(l) == 0 {
+		return ""
+	}
+
+	var names []string
+	for k := range l {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, k := range names {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(l[k])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, v))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Handler - Serve all metrics in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		var names []string
+		for k := range metrics {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		for _, name := range names {
+			m := metrics[name]
+			fmt.Fprintf(w, "# HELP %s %s\n", name, m.help)
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, m.kind)
+
+			var series []string
+			for k := range m.series {
+				series = append(series, k)
+			}
+			sort.Strings(series)
+
+			for _, labels := range series {
+				fmt.Fprintf(w, "%s%s %g\n", name, labels, m.series[labels])
+			}
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// blocklist keeps a chain dropping the addresses of abuse feeds, such as the Spamhaus DROP list or an
+// AbuseIPDB export.  It is refreshed on its own interval, independent of the nodes, and jumped to first
+// from INPUT so a blocked address is dropped before any rule accepts it.
+type blocklist struct {
+	name     string
+	sources  []string
+	ipset    string
+	http     *http.Client
+	auditLog *audit.Log
+
+	// The CIDRs of the last applied chain
+	applied []string
+}
+
+// fetch - The CIDRs of every source, sorted and without duplicates.  A source failing to load fails
+// the whole refresh, so the chain is never applied with part of the list missing.
+func (b *blocklist) fetch(ctx context.Context) ([]string, error) {
+
+	seen := make(map[string]bool)
+	var cidrs []string
+
+	for _, source := range b.sources {
+		data, err := b.read(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the blocklist %s: %w", source, err)
+		}
+
+		entries := parseBlocklist(data)
+		log.Info().Msgf("read %d entries from blocklist %s", len(entries), source)
+
+		for _, cidr := range entries {
+			if !seen[cidr] {
+				seen[cidr] = true
+				cidrs = append(cidrs, cidr)
+			}
+		}
+	}
+
+	sort.Strings(cidrs)
+
+	return cidrs, nil
+}
+
+// read - The content of a file, or of an http or https URL
+func (b *blocklist) read(ctx context.Context, source string) (string, error) {
+
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		data, err := os.ReadFile(source)
+		return string(data), err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+
+	return string(data), err
+}
+
+// parseBlocklist - The IPv4 addresses and CIDRs of a feed with one entry per line.  Anything after a ; or
+// # is a comment, as in the Spamhaus DROP list, and lines that are not an address are skipped.
+func parseBlocklist(data string) []string {
+
+	var cidrs []string
+
+	for _, line := range strings.Split(data, "\n") {
+		if i := strings.IndexAny(line, ";#"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry := fields[0]
+		if !strings.Contains(entry, "/") {
+			entry += "/32"
+		}
+
+		ip, ipnet, err := net.ParseCIDR(entry)
+		if err != nil || ip.To4() == nil {
+			continue
+		}
+
+		cidrs = append(cidrs, ipnet.String())
+	}
+
+	return cidrs
+}
+
+// apply - Load the CIDRs into the hash:net set of the chain, matched by a single DROP rule
+func (b *blocklist) apply(ctx context.Context, cidrs []string) error {
+
+	cmd := hostexec.Command(ctx, b.ipset, "restore")
+	cmd.Stdin = strings.NewReader(render.IPSetRestore(b.name, "hash:net", cidrs))
+
+	if err := hostexec.Run(cmd).Err(); err != nil {
+		return fmt.Errorf("ipset restore of %s failed: %w", b.name, err)
+	}
+
+	rules := tagRules(b.name, "block", [][]string{{"-m", "set", "--match-set", b.name, "src", "-j", "DROP"}})
+
+	if err := b.jumpFirst(); err != nil {
+		return err
+	}
+
+	if err := writeChain(b.name, "INPUT", rules); err != nil {
+		return err
+	}
+
+	added, removed := diffStrings(b.applied, cidrs)
+	b.auditLog.Write(audit.Record{Target: "filter/" + b.name, Added: added, Removed: removed})
+
+	b.applied = cidrs
+
+	return nil
+}
+
+// jumpFirst - Create the chain and jump to it from the top of INPUT, ahead of any accepting rule.  The jump is
+// checked on every call, INPUT may have been flushed since the chain was created.
+func (b *blocklist) jumpFirst() error {
+
+	ipt, err := newIPTables()
+	if err != nil {
+		return err
+	}
+
+	ok, err := ipt.ChainExists("filter", b.name)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		if err := ipt.NewChain("filter", b.name); err != nil {
+			return fmt.Errorf("unable to create chain %s: %w", b.name, err)
+		}
+	}
+
+	jumps, err := ipt.Exists("filter", "INPUT", "-j", b.name)
+	if err != nil {
+		return fmt.Errorf("unable to check INPUT for the jump to %s: %w", b.name, err)
+	}
+
+	if jumps {
+		return nil
+	}
+
+	if err := ipt.Insert("filter", "INPUT", 1, "-j", b.name); err != nil {
+		return fmt.Errorf("unable to add chain %s to INPUT: %w", b.name, err)
+	}
+
+	return nil
+}
+
+// run - Refresh the chain every interval, keeping the current chain when a refresh fails.  A refresh has its own
+// deadline, apart from the reconciles of the chains.
+func (b *blocklist) run(interval time.Duration) {
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+
+		cidrs, err := b.fetch(ctx)
+		if err != nil {
+			log.Error().Err(err).Msgf("keeping the current %s chain", b.name)
+		} else if strings.Join(cidrs, ",") == strings.Join(b.applied, ",") {
+			if err := b.jumpFirst(); err != nil {
+				log.Error().Err(err).Msgf("unable to check the jump to the %s chain", b.name)
+			}
+		} else if strings.Join(cidrs, ",") != strings.Join(b.applied, ",") {
+			log.Info().Msgf("building %s chain with %d entries", b.name, len(cidrs))
+			if err := b.apply(ctx, cidrs); err != nil {
+				log.Error().Err(err).Msgf("unable to apply the %s chain", b.name)
+			}
+		}
+
+		cancel()
+
+		time.Sleep(interval)
+	}
+}
+
+// diffStrings - The entries added to and removed from a sorted list
+func diffStrings(old []string, new []string) (added []string, removed []string) {
+
+	known := make(map[string]bool)
+	for _, s := range old {
+		known[s] = true
+	}
+
+	current := make(map[string]bool)
+	for _, s := range new {
+		current[s] = true
+		if !known[s] {
+			added = append(added, s)
+		}
+	}
+
+	for _, s := range old {
+		if !current[s] {
+			removed = append(removed, s)
+		}
+	}
+
+	return added, removed
+}
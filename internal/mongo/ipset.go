@@ -0,0 +1,211 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// ipsetChain keeps the nodes in an ipset referenced by a static rule in the chain, so a node change
+// only swaps the members of the set instead of rewriting the chain
+type ipsetChain struct {
+	*chain
+	ipset string
+
+	// The members of the set before the last Apply, put back by Rollback
+	previousMembers []string
+
+	// The static rules last written to the chain, and whether the last Apply rewrote them
+	written   [][]string
+	rewritten bool
+
+	// The country CIDRs last loaded into the geo set
+	geoApplied []string
+}
+
+// set - The name of the ipset holding the nodes of the chain
+func (c *ipsetChain) set() string {
+	return c.name + "-nodes"
+}
+
+// geoSet - The name of the ipset holding the CIDRs of the denied countries
+func (c *ipsetChain) geoSet() string {
+	return c.name + "-geo"
+}
+
+// Render - One member of the set per node with an IPv4 address
+func (c *ipsetChain) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	c.ips = ipv4(c.name, nodes)
+
+	// The countries are matched by a set as well, their lists run into thousands of CIDRs
+	rules := tagRules(c.name, "deny", render.IPTablesCIDRRules(c.deny, 0, c.match, "DROP"))
+	if len(c.geoCIDRs()) > 0 {
+		rules = append(rules, tagRules(c.name, "geo", render.IPTablesSetRules(c.geoSet(), 0, c.match, "DROP"))...)
+	}
+	rules = append(rules, tagRules(c.name, "nodes", render.IPTablesSetRules(c.set(), 0, c.match, "ACCEPT"))...)
+	rules = append(rules, tagRules(c.name, "allow", render.IPTablesCIDRRules(c.allow, 0, c.match, "ACCEPT"))...)
+	c.rules = rules
+
+	var lines []string
+	for _, ip := range render.SortIPs(c.ips) {
+		lines = append(lines, fmt.Sprintf("add %s %s", c.set(), ip))
+	}
+
+	return lines, nil
+}
+
+// Validate - Every member must be an IPv4 address, hash:ip sets hold a single family
+func (c *ipsetChain) Validate(ctx context.Context, rendered []string) error {
+
+	for _, ip := range c.ips {
+		if ip.To4() == nil {
+			return fmt.Errorf("node address %s is not an IPv4 address", ip)
+		}
+	}
+
+	if c.iptablesRestore != "" {
+		return testRules(ctx, c.iptablesRestore, c.name, c.rules)
+	}
+
+	return nil
+}
+
+// Apply - Swap the members of the sets, writing the static rules to the chain when they changed
+func (c *ipsetChain) Apply(ctx context.Context, rendered []string) error {
+
+	c.rewritten = false
+
+	previous, err := c.members(ctx)
+	if err != nil {
+		return err
+	}
+	c.previousMembers = previous
+
+	log.Info().Msgf("updating the %s set with %d nodes", c.set(), len(c.ips))
+
+	var members []string
+	for _, ip := range render.SortIPs(c.ips) {
+		members = append(members, ip.String())
+	}
+
+	if err := c.restore(ctx, c.set(), "hash:ip", members); err != nil {
+		return err
+	}
+
+	// The set exists before the rule matching it is written
+	if geo := c.geoCIDRs(); len(geo) > 0 && strings.Join(geo, ",") != strings.Join(c.geoApplied, ",") {
+		log.Info().Msgf("updating the %s set with %d cidrs", c.geoSet(), len(geo))
+
+		if err := c.restore(ctx, c.geoSet(), "hash:net", geo); err != nil {
+			return err
+		}
+		c.geoApplied = geo
+	}
+
+	if !equalRules(c.written, c.rules) {
+		log.Info().Msgf("building %s chain", c.name)
+
+		rules, err := listRules(c.name)
+		if err != nil {
+			return err
+		}
+		c.previous = rules
+		c.rewritten = true
+
+		if err := writeChain(c.name, c.parent(), c.rules); err != nil {
+			return err
+		}
+		c.written = c.rules
+	}
+
+	added, removed := audit.Diff(c.applied, c.ips)
+	c.auditLog.Write(audit.Record{Target: c.set(), Added: added, Removed: removed, Hash: audit.Hash(rendered)})
+
+	c.applied = c.ips
+
+	return nil
+}
+
+// Rollback - Put the members from before the last Apply back, and the chain when it was rewritten
+func (c *ipsetChain) Rollback(ctx context.Context) error {
+
+	log.Info().Msgf("restoring the previous %s set", c.set())
+
+	c.auditLog.Write(audit.Record{Target: c.set(), Reload: "rolled back"})
+
+	if err := c.restore(ctx, c.set(), "hash:ip", c.previousMembers); err != nil {
+		return err
+	}
+
+	if c.rewritten && c.previous != nil {
+		return writeChain(c.name, c.parent(), c.previous)
+	}
+
+	return nil
+}
+
+// restore - Replace the members of a set with ipset restore
+func (c *ipsetChain) restore(ctx context.Context, set string, kind string, members []string) error {
+
+	cmd := hostexec.Command(ctx, c.ipset, "restore")
+	cmd.Stdin = strings.NewReader(render.IPSetRestore(set, kind, members))
+
+	if err := hostexec.Run(cmd).Err(); err != nil {
+		return fmt.Errorf("ipset restore of %s failed: %w", set, err)
+	}
+
+	return nil
+}
+
+// members - The current members of the node set, empty when the set does not exist
+func (c *ipsetChain) members(ctx context.Context) ([]string, error) {
+
+	// The members are read from a buffer of their own, the output kept in the result is cut short
+	var out bytes.Buffer
+	cmd := hostexec.Command(ctx, c.ipset, "list", c.set(), "-output", "save")
+	cmd.Stdout = &out
+
+	result := hostexec.Run(cmd)
+	if err := result.Err(); err != nil {
+		// ipset exits with 1 and says so for a set that does not exist yet
+		if result.ExitCode == 1 && strings.Contains(result.Stderr, "does not exist") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listing the members of %s failed: %w", c.set(), err)
+	}
+
+	var members []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "add" {
+			members = append(members, fields[2])
+		}
+	}
+
+	return members, nil
+}
+
+// equalRules - Whether two lists of rule specs are the same
+func equalRules(a [][]string, b [][]string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if strings.Join(a[i], " ") != strings.Join(b[i], " ") {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,18 @@
+package mongo
+
+// newIPTables - The iptables the chains are written to, replaced by the tests
+var newIPTables = hostIPTables
+
+// ipTables - The iptables operations the chains use, provided by go-iptables on Linux and
+// failing on other platforms
+type ipTables interface {
+	ChainExists(table, chain string) (bool, error)
+	NewChain(table, chain string) error
+	ClearChain(table, chain string) error
+	DeleteChain(table, chain string) error
+	Append(table, chain string, rulespec ...string) error
+	Insert(table, chain string, pos int, rulespec ...string) error
+	Delete(table, chain string, rulespec ...string) error
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	List(table, chain string) ([]string, error)
+}
@@ -0,0 +1,17 @@
+//go:build linux
+// +build linux
+
+package mongo
+
+import "github.com/coreos/go-iptables/iptables"
+
+// hostIPTables - The iptables of the host
+func hostIPTables() (ipTables, error) {
+
+	ipt, err := iptables.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return ipt, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package mongo
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// hostIPTables - iptables only exists on Linux, elsewhere the chains can only be rendered or
+// applied with -mock-apply
+func hostIPTables() (ipTables, error) {
+	return nil, fmt.Errorf("iptables is not available on %s, use -mock-apply", runtime.GOOS)
+}
@@ -0,0 +1,146 @@
+package mongo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeIPTables keeps the rules of the filter table in memory, in the order iptables would
+type fakeIPTables struct {
+	chains map[string][][]string
+}
+
+// useFakeIPTables - Write the chains of the test to a fake iptables with the built in chains
+func useFakeIPTables(t *testing.T) *fakeIPTables {
+
+	t.Helper()
+
+	fake := &fakeIPTables{chains: map[string][][]string{"INPUT": nil, "OUTPUT": nil, "FORWARD": nil}}
+
+	newIPTables = func() (ipTables, error) { return fake, nil }
+	t.Cleanup(func() { newIPTables = hostIPTables })
+
+	return fake
+}
+
+func (f *fakeIPTables) ChainExists(table, chain string) (bool, error) {
+	_, ok := f.chains[chain]
+	return ok, nil
+}
+
+func (f *fakeIPTables) NewChain(table, chain string) error {
+
+	if _, ok := f.chains[chain]; ok {
+		return fmt.Errorf("chain %s already exists", chain)
+	}
+	f.chains[chain] = nil
+
+	return nil
+}
+
+func (f *fakeIPTables) ClearChain(table, chain string) error {
+	f.chains[chain] = nil
+	return nil
+}
+
+func (f *fakeIPTables) DeleteChain(table, chain string) error {
+
+	if len(f.chains[chain]) > 0 {
+		return fmt.Errorf("chain %s is not empty", chain)
+	}
+	for name, rules := range f.chains {
+		for _, rule := range rules {
+			if strings.Join(rule, " ") == "-j "+chain {
+				return fmt.Errorf("chain %s is still jumped to from %s", chain, name)
+			}
+		}
+	}
+	delete(f.chains, chain)
+
+	return nil
+}
+
+func (f *fakeIPTables) Append(table, chain string, rulespec ...string) error {
+
+	if _, ok := f.chains[chain]; !ok {
+		return fmt.Errorf("chain %s does not exist", chain)
+	}
+	f.chains[chain] = append(f.chains[chain], rulespec)
+
+	return nil
+}
+
+func (f *fakeIPTables) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	rules, ok := f.chains[chain]
+	if !ok {
+		return fmt.Errorf("chain %s does not exist", chain)
+	}
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("index %d of chain %s is out of range", pos, chain)
+	}
+
+	rules = append(rules[:pos-1], append([][]string{rulespec}, rules[pos-1:]...)...)
+	f.chains[chain] = rules
+
+	return nil
+}
+
+func (f *fakeIPTables) Delete(table, chain string, rulespec ...string) error {
+
+	for i, rule := range f.chains[chain] {
+		if strings.Join(rule, "\x00") == strings.Join(rulespec, "\x00") {
+			f.chains[chain] = append(f.chains[chain][:i:i], f.chains[chain][i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("rule %q is not in chain %s", rulespec, chain)
+}
+
+func (f *fakeIPTables) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	for _, rule := range f.chains[chain] {
+		if strings.Join(rule, "\x00") == strings.Join(rulespec, "\x00") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (f *fakeIPTables) List(table, chain string) ([]string, error) {
+
+	rules, ok := f.chains[chain]
+	if !ok {
+		return nil, fmt.Errorf("chain %s does not exist", chain)
+	}
+
+	lines := []string{"-N " + chain}
+	for _, rule := range rules {
+		var fields []string
+		for _, field := range rule {
+			if strings.Contains(field, " ") {
+				field = fmt.Sprintf("%q", field)
+			}
+			fields = append(fields, field)
+		}
+		lines = append(lines, "-A "+chain+" "+strings.Join(fields, " "))
+	}
+
+	return lines, nil
+}
+
+// jumps - The chains a chain jumps to, in order
+func (f *fakeIPTables) jumps(chain string) []string {
+
+	var targets []string
+	for _, rule := range f.chains[chain] {
+		if len(rule) == 2 && rule[0] == "-j" {
+			targets = append(targets, rule[1])
+		}
+	}
+
+	return targets
+}
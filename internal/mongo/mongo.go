@@ -0,0 +1,994 @@
+// Package mongo keeps an iptables chain allowing the cluster nodes to reach MongoDB.
+package mongo
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/config"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/geoip"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// validateRules - Sanity check the rules before touching the chain: every node address must be an
+// IPv4 address and no rule may appear twice
+func validateRules(rules [][]string) error {
+
+	seen := make(map[string]bool)
+
+	for _, rule := range rules {
+		if len(rule) < 2 || (rule[0] != "-s" && rule[0] != "-d") {
+			return fmt.Errorf("rule %q has no node address", strings.Join(rule, " "))
+		}
+		if ip := ruleAddress(rule[1]); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("rule %q has an invalid node address", strings.Join(rule, " "))
+		}
+
+		key := strings.Join(rule, " ")
+		if seen[key] {
+			return fmt.Errorf("rule %q is duplicated", key)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// ruleAddress - The address of a rule, either an address or a CIDR
+func ruleAddress(value string) net.IP {
+
+	if ip, _, err := net.ParseCIDR(value); err == nil {
+		return ip
+	}
+
+	return net.ParseIP(value)
+}
+
+// testRules - Render the chain in the iptables-restore format and let iptables-restore check it
+// without committing anything
+func testRules(ctx context.Context, iptablesRestore string, chain string, rules [][]string) error {
+
+	cmd := hostexec.Command(ctx, iptablesRestore, "--test", "--noflush")
+	cmd.Stdin = strings.NewReader(render.IPTablesRestore(chain, rules))
+
+	if err := hostexec.Run(cmd).Err(); err != nil {
+		return fmt.Errorf("iptables-restore rejected the %s chain: %w", chain, err)
+	}
+
+	return nil
+}
+
+// chain is an iptables chain allowing the nodes to reach a port, kept as a backend.Backend
+type chain struct {
+	name            string
+	match           render.IPTablesMatch
+	parentChain     string
+	iptablesRestore string
+	auditLog        *audit.Log
+
+	// Static CIDRs allowed along with the nodes, and CIDRs dropped before any allow rule
+	allow []string
+	deny  []string
+
+	// The CIDRs of the denied countries, nil when the chain denies no country.  Only an ipsetChain drops them.
+	geo *geoip.List
+
+	// The rules and nodes rendered by the last Render
+	rules [][]string
+	ips   []net.IP
+
+	// The rules in the chain before the last Apply, put back by Rollback
+	previous [][]string
+
+	// The nodes of the last applied chain, to record what changed
+	applied []net.IP
+}
+
+// parsePorts - Parse a comma separated list of ports and first:last ranges.  Lists are matched with
+// the multiport match, which takes at most 15 ports with a range counting as two.
+func parsePorts(spec string) ([]string, error) {
+
+	var ports []string
+	count := 0
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+
+		bounds := strings.Split(item, ":")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("invalid port range %q", item)
+		}
+
+		var values []int
+		for _, b := range bounds {
+			p, err := strconv.Atoi(b)
+			if err != nil || p < 1 || p > 65535 {
+				return nil, fmt.Errorf("invalid port %q", item)
+			}
+			values = append(values, p)
+		}
+
+		if len(values) == 2 && values[0] >= values[1] {
+			return nil, fmt.Errorf("port range %q does not go up", item)
+		}
+
+		ports = append(ports, item)
+		count += len(values)
+	}
+
+	if len(ports) > 1 && count > 15 {
+		return nil, fmt.Errorf("%s has more than the 15 ports a multiport match takes", spec)
+	}
+
+	return ports, nil
+}
+
+// portList is a port, range or list of them in the chains file, written as a string, a number or a list
+type portList string
+
+// UnmarshalYAML - Accept a scalar or a list of scalars
+func (p *portList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+
+	var list []interface{}
+	if err := unmarshal(&list); err == nil {
+		var items []string
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		*p = portList(strings.Join(items, ","))
+		return nil
+	}
+
+	var scalar interface{}
+	if err := unmarshal(&scalar); err != nil {
+		return err
+	}
+	*p = portList(fmt.Sprint(scalar))
+
+	return nil
+}
+
+// parseChains - Parse a comma separated list of name:port chains, the port may be a first:last range
+// and be followed by /udp or /both
+func parseChains(value string, iptablesRestore string) ([]*chain, error) {
+
+	var chains []*chain
+	seen := make(map[string]bool)
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("chain %q is not of the form name:port", item)
+		}
+		name := parts[0]
+
+		// The protocol follows the ports, as in dns:53/both
+		spec, protocol := parts[1], ""
+		if i := strings.Index(spec, "/"); i >= 0 {
+			spec, protocol = spec[:i], spec[i+1:]
+			if protocol != "tcp" && protocol != "udp" && protocol != "both" {
+				return nil, fmt.Errorf("chain %q has an unknown protocol, use tcp, udp or both", item)
+			}
+		}
+
+		ports, err := parsePorts(spec)
+		if err != nil {
+			return nil, fmt.Errorf("chain %q: %w", item, err)
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("chain %s is given more than once", name)
+		}
+		seen[name] = true
+
+		chains = append(chains, &chain{name: name, match: render.IPTablesMatch{Ports: ports, Protocol: protocol}, iptablesRestore: iptablesRestore})
+	}
+
+	if len(chains) == 0 {
+		return nil, fmt.Errorf("no chains given")
+	}
+
+	return chains, nil
+}
+
+// chainConfig is a chain in the chains file
+type chainConfig struct {
+	Name      string   `yaml:"name"`
+	Port      portList `yaml:"port"`
+	Ports     portList `yaml:"ports"`
+	Direction string   `yaml:"direction" default:"in" validate:"oneof=in out forward"`
+	Protocol  string   `yaml:"protocol" validate:"oneof=tcp udp both"`
+	Interface string   `yaml:"interface"`
+	Address   string   `yaml:"address"`
+
+	// The interface forwarded traffic leaves through, and the chain jumping to the chain instead of the
+	// built in chain of the direction, for example ufw-user-forward so the rules sit with the ufw route rules
+	OutInterface string `yaml:"out_interface"`
+	Parent       string `yaml:"parent"`
+
+	// Static CIDRs allowed along with the nodes, for example an office VPN, and CIDRs always dropped
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+
+	// Country codes whose CIDRs are dropped, for example [cn, ru]
+	DenyCountries []string `yaml:"deny_countries"`
+}
+
+// chainsFile is the YAML file given with -chains-file
+type chainsFile struct {
+	Chains []chainConfig `yaml:"chains" validate:"required"`
+}
+
+// The built in chain the chains of each direction are attached to
+var parentChains = map[string]string{
+	"in":      "INPUT",
+	"out":     "OUTPUT",
+	"forward": "FORWARD",
+}
+
+// loadChains - Load the chains from a YAML file
+func loadChains(path string, iptablesRestore string) ([]*chain, error) {
+
+	var file chainsFile
+	if err := config.Load(path, &file); err != nil {
+		return nil, err
+	}
+
+	chains, err := configChains(file.Chains, iptablesRestore)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return chains, nil
+}
+
+// configChains - The chains of the chain configs of a chains file or a profile
+func configChains(configs []chainConfig, iptablesRestore string) ([]*chain, error) {
+
+	var chains []*chain
+	seen := make(map[string]bool)
+
+	for _, c := range configs {
+		if c.Ports == "" {
+			c.Ports = c.Port
+		}
+		if c.Name == "" || c.Ports == "" {
+			return nil, fmt.Errorf("every chain needs a name and ports")
+		}
+
+		ports, err := parsePorts(string(c.Ports))
+		if err != nil {
+			return nil, fmt.Errorf("chain %s: %w", c.Name, err)
+		}
+
+		if seen[c.Name] {
+			return nil, fmt.Errorf("chain %s is given more than once", c.Name)
+		}
+		seen[c.Name] = true
+
+		config.SetDefaults(&c)
+
+		if _, ok := parentChains[c.Direction]; !ok {
+			return nil, fmt.Errorf("chain %s has an unknown direction %s, use in, out or forward", c.Name, c.Direction)
+		}
+		if c.Protocol != "" && c.Protocol != "tcp" && c.Protocol != "udp" && c.Protocol != "both" {
+			return nil, fmt.Errorf("chain %s has an unknown protocol %s, use tcp, udp or both", c.Name, c.Protocol)
+		}
+		if c.Address != "" && net.ParseIP(strings.Split(c.Address, "/")[0]) == nil {
+			return nil, fmt.Errorf("chain %s has an invalid address %s", c.Name, c.Address)
+		}
+		if c.OutInterface != "" && c.Direction != "forward" {
+			return nil, fmt.Errorf("chain %s has an out_interface, which only forward chains take", c.Name)
+		}
+
+		allow, err := parseCIDRs(c.Allow)
+		if err != nil {
+			return nil, fmt.Errorf("chain %s allows %w", c.Name, err)
+		}
+		deny, err := parseCIDRs(c.Deny)
+		if err != nil {
+			return nil, fmt.Errorf("chain %s denies %w", c.Name, err)
+		}
+
+		chains = append(chains, &chain{
+			name:            c.Name,
+			match:           render.IPTablesMatch{Direction: c.Direction, Interface: c.Interface, OutInterface: c.OutInterface, Address: c.Address, Ports: ports, Protocol: c.Protocol},
+			parentChain:     c.Parent,
+			iptablesRestore: iptablesRestore,
+			allow:           allow,
+			deny:            deny,
+		})
+
+		if len(c.DenyCountries) > 0 {
+			chains[len(chains)-1].geo = &geoip.List{Countries: c.DenyCountries}
+		}
+	}
+
+	if len(chains) == 0 {
+		return nil, fmt.Errorf("no chains given")
+	}
+
+	return chains, nil
+}
+
+// withCIDRs - The node rules between the deny rules, which come first so they win over every allow
+// rule, and the rules of the static allowed CIDRs, each tagged with its owner
+func (c *chain) withCIDRs(nodeRules [][]string) [][]string {
+
+	rules := tagRules(c.name, "deny", render.IPTablesCIDRRules(c.deny, 0, c.match, "DROP"))
+	rules = append(rules, tagRules(c.name, "node", nodeRules)...)
+	rules = append(rules, tagRules(c.name, "allow", render.IPTablesCIDRRules(c.allow, 0, c.match, "ACCEPT"))...)
+
+	return rules
+}
+
+// geoCIDRs - The current CIDRs of the denied countries
+func (c *chain) geoCIDRs() []string {
+
+	if c.geo == nil {
+		return nil
+	}
+
+	return c.geo.Get()
+}
+
+// needIPSet - Check the chains denying countries are kept with -ipset.  A country list holds thousands of CIDRs,
+// as a rule per CIDR every write would run thousands of iptables commands.
+func needIPSet(chains []*chain, useIPSet bool) error {
+
+	if useIPSet {
+		return nil
+	}
+
+	for _, c := range chains {
+		if c.geo != nil {
+			return fmt.Errorf("chain %s has deny_countries, which needs -ipset", c.name)
+		}
+	}
+
+	return nil
+}
+
+// parseCIDRs - Check a list of addresses and CIDRs, a bare address is taken as a /32
+func parseCIDRs(cidrs []string) ([]string, error) {
+
+	var results []string
+
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			cidr += "/32"
+		}
+
+		ip, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil || ip.To4() == nil {
+			return nil, fmt.Errorf("%s is not an IPv4 address or CIDR", cidr)
+		}
+
+		results = append(results, ipnet.String())
+	}
+
+	return results, nil
+}
+
+// parent - The chain the chain is attached to, the built in chain of its direction unless it names one
+func (c *chain) parent() string {
+
+	if c.parentChain != "" {
+		return c.parentChain
+	}
+
+	if parent, ok := parentChains[c.match.Direction]; ok {
+		return parent
+	}
+
+	return "INPUT"
+}
+
+// Name - The table and name of the chain
+func (c *chain) Name() string {
+	return "filter/" + c.name
+}
+
+// ipv4 - The addresses of the nodes with an IPv4 address.  The chains are iptables chains and their sets
+// hold a single family, a node with an IPv6 address is skipped with a warning instead of failing the chain.
+func ipv4(name string, nodes []discovery.Node) []net.IP {
+
+	var ips []net.IP
+	for _, n := range nodes {
+		if n.IP.To4() == nil {
+			log.Warn().Msgf("node %s has the address %s which is not IPv4, skipping it in %s", n.Name, n.IP, name)
+			continue
+		}
+		ips = append(ips, n.IP)
+	}
+
+	return ips
+}
+
+// Render - One rule per node with an IPv4 address
+func (c *chain) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	c.ips = ipv4(c.name, nodes)
+	c.rules = c.withCIDRs(render.IPTablesRulesMatching(c.ips, 0, c.match))
+
+	var lines []string
+	for _, rule := range c.rules {
+		lines = append(lines, strings.Join(rule, " "))
+	}
+
+	return lines, nil
+}
+
+// Validate - Check the rendered rules, and test them with iptables-restore when given
+func (c *chain) Validate(ctx context.Context, rendered []string) error {
+
+	if err := validateRules(c.rules); err != nil {
+		return err
+	}
+
+	if c.iptablesRestore != "" {
+		return testRules(ctx, c.iptablesRestore, c.name, c.rules)
+	}
+
+	return nil
+}
+
+// Apply - Replace the rules of the chain with the rendered rules
+func (c *chain) Apply(ctx context.Context, rendered []string) error {
+
+	log.Info().Msgf("building %s chain", c.name)
+
+	previous, err := listRules(c.name)
+	if err != nil {
+		return err
+	}
+	c.previous = previous
+
+	if err := writeChain(c.name, c.parent(), c.rules); err != nil {
+		return err
+	}
+
+	added, removed := audit.Diff(c.applied, c.ips)
+	c.auditLog.Write(audit.Record{Target: c.Name(), Added: added, Removed: removed, Hash: audit.Hash(rendered)})
+
+	c.applied = c.ips
+
+	return nil
+}
+
+// Rollback - Put the rules from before the last Apply back
+func (c *chain) Rollback(ctx context.Context) error {
+
+	log.Info().Msgf("restoring the previous %s chain", c.name)
+
+	c.auditLog.Write(audit.Record{Target: c.Name(), Reload: "rolled back"})
+
+	return writeChain(c.name, c.parent(), c.previous)
+}
+
+// listRules - The rules in a chain, empty when the chain does not exist
+func listRules(name string) ([][]string, error) {
+
+	ipt, err := newIPTables()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := ipt.ChainExists("filter", name)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	lines, err := ipt.List("filter", name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rules are listed as -A name followed by the rule specification
+	var rules [][]string
+	for _, line := range lines {
+		fields := splitRule(line)
+		if len(fields) > 2 && fields[0] == "-A" && fields[1] == name {
+			rules = append(rules, fields[2:])
+		}
+	}
+
+	return rules, nil
+}
+
+// BuildMongoChain - Replace the rules of the mongodb chain with one rule per node.  The rules are
+// validated first, and tested with iptables-restore when given, leaving the chain untouched on failure.
+func BuildMongoChain(ctx context.Context, ipList []net.IP, iptablesRestore string) error {
+
+	var nodes []discovery.Node
+	for _, ip := range ipList {
+		nodes = append(nodes, discovery.Node{IP: ip})
+	}
+
+	c := &chain{name: "mongodb", match: render.IPTablesMatch{Ports: []string{"27017"}}, iptablesRestore: iptablesRestore}
+
+	return backend.Reconcile(ctx, []backend.Backend{c}, nodes, nil)
+}
+
+// writeChain - Bring the rules of a chain tagged with their owner in line with the new rules, creating the
+// chain and adding it to the parent chain, such as INPUT, when missing.  The rules are matched on their tag:
+// the tagged rules that are gone are deleted, the new ones added, and the rules added by hand are left where
+// they are.  Any iptables failure is returned so the chain can be rolled back.
+func writeChain(name string, parent string, newRules [][]string) error {
+
+	ipt, err := newIPTables()
+	if err != nil {
+		return err
+	}
+
+	// Check if we have the chain
+	ok, err := ipt.ChainExists("filter", name)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string][]string)
+
+	if ok {
+
+		rules, err := listRules(name)
+		if err != nil {
+			return err
+		}
+
+		for _, rule := range rules {
+			if tag := ruleTag(rule); tag != "" {
+				current[tag] = rule
+			}
+		}
+
+		// A chain without a tagged rule was written by a version that did not tag its rules, it is
+		// replaced whole once
+		if len(current) == 0 && len(rules) > 0 {
+			log.Warn().Msgf("chain %s has no tagged rules, replacing its %d rules", name, len(rules))
+
+			if err := ipt.ClearChain("filter", name); err != nil {
+				return fmt.Errorf("unable to clear chain %s: %w", name, err)
+			}
+		}
+
+	} else {
+
+		if err := ipt.NewChain("filter", name); err != nil {
+			return fmt.Errorf("unable to create chain %s: %w", name, err)
+		}
+	}
+
+	// The jump is checked on every write, a parent such as ufw-user-forward is flushed by a ufw reload
+	jumps, err := ipt.Exists("filter", parent, "-j", name)
+	if err != nil {
+		return fmt.Errorf("unable to check chain %s for the jump to %s: %w", parent, name, err)
+	}
+
+	if !jumps {
+		if err := ipt.Append("filter", parent, "-j", name); err != nil {
+			return fmt.Errorf("unable to add chain %s to %s: %w", name, parent, err)
+		}
+	}
+
+	wanted := make(map[string]bool)
+	for _, rule := range newRules {
+		wanted[ruleTag(rule)] = true
+	}
+
+	for tag, rule := range current {
+		if wanted[tag] {
+			continue
+		}
+
+		if err := ipt.Delete("filter", name, rule...); err != nil {
+			return fmt.Errorf("unable to delete %q from chain %s: %w", strings.Join(rule, " "), name, err)
+		}
+	}
+
+	// The DROP rules go to the top of the chain, in order, so they win over the rules accepting traffic,
+	// the others go to the end
+	drops := 0
+
+	for _, rule := range newRules {
+		tag := ruleTag(rule)
+		drop := ruleTarget(rule) == "DROP"
+
+		// A rule without a tag was added by hand, it is only found in the rules rolled back to
+		if _, found := current[tag]; found || tag == "" {
+			if drop && found {
+				drops++
+			}
+			continue
+		}
+
+		if drop {
+			drops++
+			err = ipt.Insert("filter", name, drops, rule...)
+		} else {
+			err = ipt.Append("filter", name, rule...)
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to add %q to chain %s: %w", strings.Join(rule, " "), name, err)
+		}
+	}
+
+	rules, err := ipt.List("filter", name)
+	if err != nil {
+		log.Error().Err(err).Msgf("unable to list chain %s", name)
+	}
+
+	for _, v := range rules {
+		log.Info().Msgf("configure rule: %s", v)
+	}
+
+	return nil
+}
+
+// removedChains - The chains of old that are not in chains, or are attached to another parent there
+func removedChains(old []*chain, chains []*chain) []*chain {
+
+	kept := make(map[string]string)
+	for _, c := range chains {
+		kept[c.name] = c.parent()
+	}
+
+	var removed []*chain
+	for _, c := range old {
+		if parent, ok := kept[c.name]; !ok || parent != c.parent() {
+			removed = append(removed, c)
+		}
+	}
+
+	return removed
+}
+
+// removeChain - Delete the jump from the parent to a chain, and the chain itself unless it is still kept under
+// another parent, so the nodes lose the access the chain gave them
+func removeChain(c *chain, kept bool) error {
+
+	ipt, err := newIPTables()
+	if err != nil {
+		return err
+	}
+
+	for {
+		jumps, err := ipt.Exists("filter", c.parent(), "-j", c.name)
+		if err != nil {
+			return fmt.Errorf("unable to check chain %s for the jump to %s: %w", c.parent(), c.name, err)
+		}
+		if !jumps {
+			break
+		}
+		if err := ipt.Delete("filter", c.parent(), "-j", c.name); err != nil {
+			return fmt.Errorf("unable to remove chain %s from %s: %w", c.name, c.parent(), err)
+		}
+	}
+
+	if kept {
+		log.Info().Msgf("removed chain %s from %s", c.name, c.parent())
+		return nil
+	}
+
+	exists, err := ipt.ChainExists("filter", c.name)
+	if err != nil || !exists {
+		return err
+	}
+
+	if err := ipt.ClearChain("filter", c.name); err != nil {
+		return fmt.Errorf("unable to clear chain %s: %w", c.name, err)
+	}
+
+	if err := ipt.DeleteChain("filter", c.name); err != nil {
+		return fmt.Errorf("unable to delete chain %s: %w", c.name, err)
+	}
+
+	log.Info().Msgf("deleted chain %s", c.name)
+
+	return nil
+}
+
+// removeChains - Remove the chains of old that are no longer in chains, with their sets when ipset is given.
+// A chain that fails to be removed is alerted on, its rules still allow the nodes.
+func removeChains(old []*chain, chains []*chain, ipset string, alerter backend.Alerter) {
+
+	names := make(map[string]bool)
+	for _, c := range chains {
+		names[c.name] = true
+	}
+
+	for _, c := range removedChains(old, chains) {
+		kept := names[c.name]
+
+		if err := removeChain(c, kept); err != nil {
+			if alerter != nil {
+				alerter.Alert("chain %s was removed from the configuration but is still active: %v", c.name, err)
+			}
+			continue
+		}
+
+		if ipset == "" || kept {
+			continue
+		}
+
+		// The sets can only be destroyed once no rule matches them
+		s := &ipsetChain{chain: c, ipset: ipset}
+		for _, set := range []string{s.set(), s.geoSet()} {
+			result := hostexec.Run(hostexec.Command(context.Background(), ipset, "destroy", set))
+			if err := result.Err(); err != nil && !strings.Contains(result.Stderr, "does not exist") {
+				log.Warn().Err(err).Msgf("unable to destroy the set %s of the removed chain %s", set, c.name)
+			}
+		}
+	}
+}
+
+// refreshGeo - Download the country lists of the chains denying countries, returning whether any changed.
+// A chain keeps its current list when the download fails.
+func refreshGeo(source *geoip.Source, chains []*chain) bool {
+
+	changed := false
+
+	for _, c := range chains {
+		if c.geo == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		updated, err := c.geo.Refresh(ctx, source)
+		cancel()
+
+		if err != nil {
+			log.Error().Err(err).Msgf("unable to refresh the denied countries of chain %s", c.name)
+			continue
+		}
+		changed = changed || updated
+	}
+
+	return changed
+}
+
+// Run - Run the mongo subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("mongo", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var iptablesRestore string
+	fs.StringVar(&iptablesRestore, "iptables-restore", "", "(optional) iptables-restore command used to test the chain with --test before it is applied, for example /sbin/iptables-restore")
+
+	var chainList string
+	fs.StringVar(&chainList, "chains", "mongodb:27017", "comma separated name:port chains, each allowing the nodes to reach its port")
+
+	var useIPSet bool
+	fs.BoolVar(&useIPSet, "ipset", false, "keep the nodes in an ipset named <chain>-nodes matched by a static rule, updating the set in place instead of rewriting the chain")
+
+	var ipsetCmd string
+	fs.StringVar(&ipsetCmd, "ipset-cmd", "ipset", "ipset executable command")
+
+	var chainsFile string
+	fs.StringVar(&chainsFile, "chains-file", "", "(optional) YAML file describing the chains with their direction, interface and local address, instead of -chains")
+
+	var profile string
+	fs.StringVar(&profile, "profile", "", "(optional) built in chains used instead of -chains: peer-nodes allows the nodes to reach each other on the Kubernetes ports")
+
+	var policies bool
+	fs.BoolVar(&policies, "firewall-policies", false, "keep a chain for every FirewallPolicy resource of the kube-linode.io custom resource definition in deploy/crds.yaml, instead of -chains")
+
+	var blocklistSources string
+	fs.StringVar(&blocklistSources, "blocklist", "", "(optional) comma separated files or URLs of abuse feeds, such as the Spamhaus DROP list, whose addresses are dropped")
+
+	var blocklistChain string
+	fs.StringVar(&blocklistChain, "blocklist-chain", "blocklist", "chain dropping the addresses of the -blocklist feeds, jumped to first from INPUT")
+
+	var blocklistRefresh time.Duration
+	fs.DurationVar(&blocklistRefresh, "blocklist-refresh", time.Hour, "how often the -blocklist feeds are read again")
+
+	var geoURL string
+	fs.StringVar(&geoURL, "geoip-url", geoip.DefaultURL, "URL of the CIDR list of a country denied with deny_countries, %s is the lower case country code")
+
+	var geoCache string
+	fs.StringVar(&geoCache, "geoip-cache", "/var/lib/linode-tools/geoip", "directory keeping the last downloaded country lists, used when a download fails")
+
+	var geoRefresh time.Duration
+	fs.DurationVar(&geoRefresh, "geoip-refresh", 24*time.Hour, "how often the country lists are downloaded again")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if profile != "" && chainsFile != "" {
+		return fmt.Errorf("-profile and -chains-file cannot be used together")
+	}
+
+	if policies && (profile != "" || chainsFile != "") {
+		return fmt.Errorf("-firewall-policies cannot be used with -profile or -chains-file")
+	}
+
+	var chains []*chain
+	var err error
+	if policies {
+		var found []discovery.Resource
+		found, err = discovery.Resources(context.Background(), common.Kubeconfig, discovery.FirewallPolicies, nil)
+		if err == nil {
+			chains, err = policyChains(found, iptablesRestore)
+		}
+	} else if chainsFile != "" {
+		chains, err = loadChains(chainsFile, iptablesRestore)
+	} else if profile != "" {
+		chains, err = profileChains(profile, iptablesRestore)
+	} else {
+		chains, err = parseChains(chainList, iptablesRestore)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := needIPSet(chains, useIPSet); err != nil {
+		return err
+	}
+
+	if blocklistSources != "" && !useIPSet {
+		return fmt.Errorf("-blocklist needs -ipset, the feeds hold too many addresses for a rule each")
+	}
+
+	common.Start(fs.Name())
+
+	auditLog := audit.Open(common.AuditLog, fs.Name())
+
+	geoSource := geoip.NewSource(geoURL, geoCache)
+
+	// build - The backends of the chains.  The country lists of the chains denying countries are refreshed
+	// before, the downloads can take minutes.
+	build := func(chains []*chain) []backend.Backend {
+
+		var backends []backend.Backend
+		for _, c := range chains {
+			c.auditLog = auditLog
+			if useIPSet {
+				backends = append(backends, &ipsetChain{chain: c, ipset: ipsetCmd})
+			} else {
+				backends = append(backends, c)
+			}
+		}
+
+		return common.Track(backends)
+	}
+
+	refreshGeo(geoSource, chains)
+	backends := build(chains)
+
+	if common.Output != "" {
+		return common.Print(backends)
+	}
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+
+	if blocklistSources != "" && common.MockApply {
+		log.Info().Msgf("not maintaining the %s chain with -mock-apply", blocklistChain)
+	} else if blocklistSources != "" {
+		b := &blocklist{name: blocklistChain, ipset: ipsetCmd, http: &http.Client{Timeout: time.Minute}, auditLog: auditLog}
+		for _, source := range strings.Split(blocklistSources, ",") {
+			if source = strings.TrimSpace(source); source != "" {
+				b.sources = append(b.sources, source)
+			}
+		}
+
+		go b.run(blocklistRefresh)
+	}
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	// The chains are replaced when the chains file changes
+	var mu sync.Mutex
+	current := chains
+
+	go func() {
+		for {
+			time.Sleep(geoRefresh)
+
+			mu.Lock()
+			chains := current
+			mu.Unlock()
+
+			if refreshGeo(geoSource, chains) {
+				select {
+				case force <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	// replace - Apply new chains right away, removing the chains that are gone
+	replace := func(reloaded []*chain) {
+
+		if err := needIPSet(reloaded, useIPSet); err != nil {
+			log.Error().Err(err).Msg("keeping the current chains")
+			return
+		}
+
+		// The downloads happen before the lock, the reconciles go on with the current chains meanwhile
+		refreshGeo(geoSource, reloaded)
+
+		mu.Lock()
+		previous := current
+		backends = build(reloaded)
+		current = reloaded
+		mu.Unlock()
+
+		if common.MockApply {
+			for _, c := range removedChains(previous, reloaded) {
+				log.Info().Msgf("mock apply: not removing chain %s", c.name)
+			}
+		} else {
+			sets := ""
+			if useIPSet {
+				sets = ipsetCmd
+			}
+			removeChains(previous, reloaded, sets, notifier)
+		}
+
+		select {
+		case force <- struct{}{}:
+		default:
+		}
+	}
+
+	if chainsFile != "" {
+		go configfile.Watch(chainsFile, common.Interval, func() {
+
+			reloaded, err := loadChains(chainsFile, iptablesRestore)
+			if err != nil {
+				log.Error().Err(err).Msgf("keeping the current chains, %s is not valid", chainsFile)
+				return
+			}
+
+			log.Info().Msgf("reloaded %s", chainsFile)
+
+			replace(reloaded)
+		})
+	}
+
+	if policies {
+		go watchPolicies(common.Kubeconfig, iptablesRestore, replace)
+	}
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+
+		mu.Lock()
+		backends := backends
+		mu.Unlock()
+
+		// Every chain is applied in the same pass, a failing chain does not stop the others
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// TestIPv6Nodes checks a node with an IPv6 address is left out of the chain instead of failing it
+func TestIPv6Nodes(t *testing.T) {
+
+	nodes := []discovery.Node{
+		{Name: "node-1", IP: net.ParseIP("10.0.0.1")},
+		{Name: "node-2", IP: net.ParseIP("2001:db8::2")},
+		{Name: "node-3", IP: net.ParseIP("10.0.0.3")},
+	}
+
+	c := &chain{name: "mongodb", match: render.IPTablesMatch{Ports: []string{"27017"}}}
+	s := &ipsetChain{chain: &chain{name: "mongodb", match: render.IPTablesMatch{Ports: []string{"27017"}}}}
+
+	for _, b := range []backend.Backend{c, s} {
+		rendered, err := b.Render(context.Background(), nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(rendered) != 2 {
+			t.Errorf("rendered %q, expected the two IPv4 nodes", rendered)
+		}
+
+		if err := b.Validate(context.Background(), rendered); err != nil {
+			t.Errorf("%T does not validate: %v", b, err)
+		}
+	}
+}
+
+// fakeIPSet - An ipset script printing the output and exiting with the status
+func fakeIPSet(t *testing.T, output string, status int) string {
+
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ipset")
+	script := fmt.Sprintf("#!/bin/sh\nprintf '%%s' '%s'\nexit %d\n", output, status)
+	if status != 0 {
+		script = fmt.Sprintf("#!/bin/sh\nprintf '%%s' '%s' >&2\nexit %d\n", output, status)
+	}
+
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// TestMembers checks a set that does not exist has no members and any other ipset failure is returned
+func TestMembers(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		output  string
+		status  int
+		members []string
+		err     bool
+	}{
+		{
+			name:    "members",
+			output:  "create mongodb-nodes hash:ip family inet\nadd mongodb-nodes 10.0.0.1\nadd mongodb-nodes 10.0.0.2\n",
+			members: []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:   "set does not exist",
+			output: "ipset v7.15: The set with the given name does not exist",
+			status: 1,
+		},
+		{
+			name:   "permission denied",
+			output: "ipset v7.15: Kernel error received: Operation not permitted",
+			status: 1,
+			err:    true,
+		},
+		{
+			name:   "other failure",
+			output: "ipset v7.15: unknown argument",
+			status: 2,
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			c := &ipsetChain{chain: &chain{name: "mongodb"}, ipset: fakeIPSet(t, tt.output, tt.status)}
+
+			members, err := c.members(context.Background())
+			if (err != nil) != tt.err {
+				t.Fatalf("members returned %v", err)
+			}
+			if !reflect.DeepEqual(members, tt.members) {
+				t.Errorf("members %q, expected %q", members, tt.members)
+			}
+		})
+	}
+}
+
+// TestRemoveChains checks a chain dropped from the chains file is detached and deleted, so its rules no longer
+// allow the nodes, while the other chains are kept
+func TestRemoveChains(t *testing.T) {
+
+	fake := useFakeIPTables(t)
+
+	path := filepath.Join(t.TempDir(), "chains.yaml")
+	write := func(content string) []*chain {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		chains, err := loadChains(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chains
+	}
+
+	nodes := []discovery.Node{{Name: "node-1", IP: net.ParseIP("10.0.0.1")}}
+
+	old := write("chains:\n  - name: mongodb\n    port: 27017\n  - name: redis\n    port: 6379\n")
+	if err := backend.Reconcile(context.Background(), []backend.Backend{old[0], old[1]}, nodes, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.jumps("INPUT"); !reflect.DeepEqual(got, []string{"mongodb", "redis"}) {
+		t.Fatalf("INPUT jumps to %q, expected both chains", got)
+	}
+
+	reloaded := write("chains:\n  - name: mongodb\n    port: 27017\n")
+	removeChains(old, reloaded, "", nil)
+
+	if got := fake.jumps("INPUT"); !reflect.DeepEqual(got, []string{"mongodb"}) {
+		t.Errorf("INPUT jumps to %q after the reload, expected mongodb only", got)
+	}
+	if _, ok := fake.chains["redis"]; ok {
+		t.Error("the removed redis chain still exists")
+	}
+	if len(fake.chains["mongodb"]) == 0 {
+		t.Error("the kept mongodb chain lost its rules")
+	}
+}
+
+// TestBlocklistJump checks the jump to the blocklist is put back at the top of INPUT when INPUT was flushed after
+// the chain was created, and is not added twice
+func TestBlocklistJump(t *testing.T) {
+
+	fake := useFakeIPTables(t)
+	fake.chains["INPUT"] = [][]string{{"-j", "ACCEPT"}}
+
+	b := &blocklist{name: "blocklist", ipset: fakeIPSet(t, "", 0)}
+	cidrs := []string{"192.0.2.0/24"}
+
+	if err := b.apply(context.Background(), cidrs); err != nil {
+		t.Fatal(err)
+	}
+
+	// A flush of INPUT keeps the chain but drops the jump
+	fake.chains["INPUT"] = [][]string{{"-j", "ACCEPT"}}
+
+	for i := 0; i < 2; i++ {
+		if err := b.apply(context.Background(), cidrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := [][]string{{"-j", "blocklist"}, {"-j", "ACCEPT"}}
+	if !reflect.DeepEqual(fake.chains["INPUT"], expected) {
+		t.Errorf("INPUT is %q, expected %q", fake.chains["INPUT"], expected)
+	}
+}
+
+// TestNeedIPSet checks a chain denying countries is refused without -ipset
+func TestNeedIPSet(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		configs  []chainConfig
+		useIPSet bool
+		err      bool
+	}{
+		{
+			name:    "no countries",
+			configs: []chainConfig{{Name: "mongodb", Port: "27017"}},
+		},
+		{
+			name:    "countries without ipset",
+			configs: []chainConfig{{Name: "mongodb", Port: "27017"}, {Name: "ssh", Port: "22", DenyCountries: []string{"cn"}}},
+			err:     true,
+		},
+		{
+			name:     "countries with ipset",
+			configs:  []chainConfig{{Name: "ssh", Port: "22", DenyCountries: []string{"cn"}}},
+			useIPSet: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			chains, err := configChains(tt.configs, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := needIPSet(chains, tt.useIPSet); (err != nil) != tt.err {
+				t.Errorf("needIPSet returned %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ownerTag starts the comment of every rule written by the tool, the rules without it were added by hand and
+// are left alone
+const ownerTag = "managed-by: linode-tools"
+
+// tagRules - Tag the rules of a service with their owner, the kind and value of the address they match, such as
+// node=1.2.3.4, and a hash of the rule so a rule that changes in any other way gets a new tag.  The comment goes
+// before the target, as iptables lists it.
+func tagRules(service string, kind string, rules [][]string) [][]string {
+
+	var tagged [][]string
+
+	for _, rule := range rules {
+		sum := sha256.Sum256([]byte(strings.Join(rule, " ")))
+		comment := fmt.Sprintf("%s service=%s %s=%s spec=%s", ownerTag, service, kind, ruleValue(rule), hex.EncodeToString(sum[:4]))
+
+		n := len(rule)
+		if n >= 2 && rule[n-2] == "-j" {
+			n -= 2
+		}
+
+		t := append([]string{}, rule[:n]...)
+		t = append(t, "-m", "comment", "--comment", comment)
+		t = append(t, rule[n:]...)
+
+		tagged = append(tagged, t)
+	}
+
+	return tagged
+}
+
+// ruleValue - The address or set a rule matches
+func ruleValue(rule []string) string {
+
+	for i := 0; i+1 < len(rule); i++ {
+		switch rule[i] {
+		case "-s", "-d", "--match-set":
+			return rule[i+1]
+		}
+	}
+
+	return "-"
+}
+
+// ruleTag - The owner tag of a rule, empty when the rule was added by hand
+func ruleTag(rule []string) string {
+
+	for i := 0; i+1 < len(rule); i++ {
+		if rule[i] == "--comment" && strings.HasPrefix(rule[i+1], ownerTag+" ") {
+			return rule[i+1]
+		}
+	}
+
+	return ""
+}
+
+// ruleTarget - The target a rule jumps to
+func ruleTarget(rule []string) string {
+
+	for i := 0; i+1 < len(rule); i++ {
+		if rule[i] == "-j" {
+			return rule[i+1]
+		}
+	}
+
+	return ""
+}
+
+// splitRule - The fields of a rule listed by iptables, which quotes the comments holding spaces
+func splitRule(line string) []string {
+
+	var fields []string
+	var field strings.Builder
+
+	quoted, started := false, false
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+
+		switch {
+		case ch == '\\' && quoted && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case ch == '"':
+			quoted = !quoted
+			started = true
+		case ch == ' ' && !quoted:
+			if started {
+				fields = append(fields, field.String())
+				field.Reset()
+				started = false
+			}
+		default:
+			field.WriteByte(ch)
+			started = true
+		}
+	}
+
+	if started {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
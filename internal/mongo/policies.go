@@ -0,0 +1,64 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/rsvancara/linode-tools/internal/config"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// policyChains - A chain for every FirewallPolicy resource, whose spec has the keys of a chain of the
+// chains file.  The chain is named after the resource unless the spec names it.  No policies are no chains.
+func policyChains(policies []discovery.Resource, iptablesRestore string) ([]*chain, error) {
+
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	var configs []chainConfig
+
+	for _, p := range policies {
+		data, err := yaml.Marshal(p.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("firewall policy %s: %w", p.Name, err)
+		}
+
+		var c chainConfig
+		if err := config.Decode("firewall policy "+p.Namespace+"/"+p.Name, data, &c); err != nil {
+			return nil, err
+		}
+
+		if c.Name == "" {
+			c.Name = p.Name
+		}
+
+		configs = append(configs, c)
+	}
+
+	return configChains(configs, iptablesRestore)
+}
+
+// watchPolicies - Call replace with the chains of the FirewallPolicy resources whenever they change.  Invalid
+// policies are logged and the current chains kept.
+func watchPolicies(kubeconfig string, iptablesRestore string, replace func([]*chain)) {
+
+	err := discovery.WatchResources(context.Background(), kubeconfig, discovery.FirewallPolicies, nil, func(policies []discovery.Resource) {
+
+		chains, err := policyChains(policies, iptablesRestore)
+		if err != nil {
+			log.Error().Err(err).Msg("keeping the current chains, the firewall policies are not valid")
+			return
+		}
+
+		log.Info().Msgf("firewall policies changed, now %d chains", len(chains))
+
+		replace(chains)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("watching the firewall policies stopped")
+	}
+}
@@ -0,0 +1,34 @@
+package mongo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profiles are the built in chains selected with -profile instead of -chains
+var profiles = map[string][]chainConfig{
+	// The nodes of a self-managed cluster reaching each other on the ports Kubernetes needs: the API
+	// server, etcd, the kubelet, flannel or calico VXLAN and the NodePort range
+	"peer-nodes": {
+		{Name: "k8s-peers", Ports: "6443,2379:2380,10250,30000:32767", Protocol: "tcp"},
+		{Name: "k8s-peers-udp", Ports: "8472,30000:32767", Protocol: "udp"},
+	},
+}
+
+// profileChains - The chains of a built in profile
+func profileChains(name string, iptablesRestore string) ([]*chain, error) {
+
+	configs, ok := profiles[name]
+	if !ok {
+		var names []string
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("unknown profile %s, use one of %s", name, strings.Join(names, ", "))
+	}
+
+	return configChains(configs, iptablesRestore)
+}
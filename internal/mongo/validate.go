@@ -0,0 +1,84 @@
+package mongo
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/rsvancara/linode-tools/internal/config"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// exampleNode is the node the chains are rendered for when validating, from the documentation range
+var exampleNode = net.ParseIP("192.0.2.1")
+
+// Validate - Run the validate subcommand: parse the chains and render their rules for an example node
+// without touching iptables, reporting every problem found.  Meant as a pre-deploy check in CI.
+func Validate(args []string) error {
+
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+
+	var chainList string
+	fs.StringVar(&chainList, "chains", "mongodb:27017", "comma separated name:port chains, each allowing the nodes to reach its port")
+
+	var chainsFile string
+	fs.StringVar(&chainsFile, "chains-file", "", "(optional) YAML file describing the chains, instead of -chains")
+
+	var profile string
+	fs.StringVar(&profile, "profile", "", "(optional) built in chains used instead of -chains, such as peer-nodes")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var problems []string
+
+	var chains []*chain
+	var err error
+	if chainsFile != "" {
+		chains, err = loadChains(chainsFile, "")
+
+		// Every problem of the file is printed, not only the first
+		var errs config.Errors
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				fmt.Println(e)
+			}
+			return fmt.Errorf("found %d problems", len(errs))
+		}
+	} else if profile != "" {
+		chains, err = profileChains(profile, "")
+	} else {
+		chains, err = parseChains(chainList, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range chains {
+		if seen[c.name] {
+			problems = append(problems, fmt.Sprintf("chain %s is defined more than once", c.name))
+		}
+		seen[c.name] = true
+
+		if err := validateRules(render.IPTablesRulesMatching([]net.IP{exampleNode}, 0, c.match)); err != nil {
+			problems = append(problems, fmt.Sprintf("chain %s: %v", c.name, err))
+		}
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems", len(problems))
+	}
+
+	for _, c := range chains {
+		fmt.Printf("%s: attached to %s ok\n", c.name, c.parent())
+	}
+
+	return nil
+}
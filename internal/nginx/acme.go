@@ -0,0 +1,125 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+)
+
+// certbotTimeout bounds the certificate requests and renewals of a renewal interval
+const certbotTimeout = 10 * time.Minute
+
+// certbot stores every certificate under the live directory using the certificate name
+const letsencryptLive = "/etc/letsencrypt/live"
+
+// acmeDefaults - Point ACME upstreams without explicit paths at the certbot live directory.
+// The certificate is named after the first server name.
+func acmeDefaults(k *upstream) {
+
+	if k.TLS == nil || !k.TLS.ACME || len(k.ServerName) == 0 {
+		return
+	}
+
+	if k.TLS.Certificate == "" {
+		k.TLS.Certificate = filepath.Join(letsencryptLive, k.ServerName[0], "fullchain.pem")
+	}
+	if k.TLS.Key == "" {
+		k.TLS.Key = filepath.Join(letsencryptLive, k.ServerName[0], "privkey.pem")
+	}
+}
+
+// usesACME - Check if any upstream wants its certificate from ACME
+func usesACME(upstreams []upstream) bool {
+	for _, k := range upstreams {
+		if k.TLS != nil && k.TLS.ACME {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateReady - Check if the certificate of an upstream exists on disk
+func certificateReady(k upstream) bool {
+	_, err := os.Stat(k.TLS.Certificate)
+	return err == nil
+}
+
+// certbotIssue - Request certificates for ACME upstreams that do not have one yet using the webroot
+// challenge.  Returns true when at least one certificate was issued and the config needs to be rendered again.
+// certbot is killed when ctx is done.
+func certbotIssue(ctx context.Context, certbot string, webroot string, email string, upstreams []upstream) bool {
+
+	issued := false
+
+	for _, k := range upstreams {
+		if k.TLS == nil || !k.TLS.ACME || certificateReady(k) {
+			continue
+		}
+
+		log.Info().Msgf("requesting certificate for %v", k.ServerName)
+
+		args := []string{"certonly", "--webroot", "-w", hostexec.Path(webroot), "--non-interactive", "--agree-tos", "--cert-name", k.ServerName[0]}
+		if email != "" {
+			args = append(args, "-m", email)
+		} else {
+			args = append(args, "--register-unsafely-without-email")
+		}
+		for _, name := range k.ServerName {
+			args = append(args, "-d", name)
+		}
+
+		if err := hostexec.Run(hostexec.Command(ctx, certbot, args...)).Err(); err != nil {
+			log.Error().Err(err).Msgf("certbot failed to issue certificate for %v", k.ServerName)
+			continue
+		}
+
+		log.Info().Msgf("issued certificate %s", k.TLS.Certificate)
+		issued = true
+	}
+
+	return issued
+}
+
+// certbotRenew - Renew certificates close to expiry.  Returns true when a certificate used by
+// an upstream changed on disk and nginx needs a reload.  certbot is killed when ctx is done.
+func certbotRenew(ctx context.Context, certbot string, upstreams []upstream) (bool, error) {
+
+	before := certificateTimes(upstreams)
+
+	if err := hostexec.Run(hostexec.Command(ctx, certbot, "renew", "--non-interactive")).Err(); err != nil {
+		return false, fmt.Errorf("certbot renew failed: %w", err)
+	}
+
+	after := certificateTimes(upstreams)
+	for cert, t := range after {
+		if !t.Equal(before[cert]) {
+			log.Info().Msgf("certificate %s was renewed", cert)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// certificateTimes - Modification times of the ACME certificates, following the certbot symlinks
+func certificateTimes(upstreams []upstream) map[string]time.Time {
+
+	times := make(map[string]time.Time)
+
+	for _, k := range upstreams {
+		if k.TLS == nil || !k.TLS.ACME {
+			continue
+		}
+		if info, err := os.Stat(k.TLS.Certificate); err == nil {
+			times[k.TLS.Certificate] = info.ModTime()
+		}
+	}
+
+	return times
+}
@@ -0,0 +1,41 @@
+package nginx
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeCertbot - A certbot script running the shell commands
+func fakeCertbot(t *testing.T, commands string) string {
+
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "certbot")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+commands+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// TestCertbotRenew checks a failed renewal is returned and a hung one is killed with its context
+func TestCertbotRenew(t *testing.T) {
+
+	if _, err := certbotRenew(context.Background(), fakeCertbot(t, "echo rate limited >&2; exit 1"), nil); err == nil {
+		t.Error("a failed renewal returned no error")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := certbotRenew(ctx, fakeCertbot(t, "exec sleep 60"), nil); err == nil {
+		t.Error("a killed renewal returned no error")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("the renewal returned after %s, expected it to be killed", elapsed)
+	}
+}
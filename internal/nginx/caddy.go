@@ -0,0 +1,150 @@
+package nginx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/pkg/caddy"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// caddyBackend replaces the upstreams of the Caddy reverse_proxy handlers through the admin API, each
+// upstream updates the handler whose @id is the upstream name
+type caddyBackend struct {
+	client   *caddy.Client
+	targets  []*target
+	drain    *drainer
+	auditLog *audit.Log
+
+	// The upstreams rendered by the last Render, and those in Caddy before the last Apply
+	upstreams []upstream
+	rendered  map[string][]caddy.Upstream
+	previous  map[string][]caddy.Upstream
+	lines     []string
+	ips       []net.IP
+	hosts     []render.Server
+
+	// The nodes of the last applied upstreams, to record what changed
+	applied []net.IP
+}
+
+// Name - The address of the admin API
+func (b *caddyBackend) Name() string {
+	return b.client.BaseURL
+}
+
+// Render - The dial addresses of every upstream, one JSON line per upstream
+func (b *caddyBackend) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	hosts := b.drain.backends(nodes)
+	b.hosts = hosts
+
+	b.ips = nil
+	for _, h := range hosts {
+		b.ips = append(b.ips, h.IP)
+	}
+
+	b.upstreams = allUpstreams(b.targets)
+	b.rendered = make(map[string][]caddy.Upstream)
+
+	var lines []string
+	for _, k := range b.upstreams {
+		var dials []caddy.Upstream
+		// Caddy has no backup or down upstreams
+		for _, s := range render.Active(render.Subset(render.InPools(hosts, k.Pools), k.Upstream, k.MaxServers)) {
+			dials = append(dials, caddy.Upstream{Dial: fmt.Sprintf("%s:%d", s.IP, k.Port)})
+		}
+		for _, s := range k.static() {
+			if !s.Backup {
+				dials = append(dials, caddy.Upstream{Dial: render.StaticAddress(s, k.Port)})
+			}
+		}
+		b.rendered[k.Upstream] = dials
+
+		line, err := json.Marshal(map[string]interface{}{"id": k.Upstream, "upstreams": dials})
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, string(line))
+	}
+
+	return lines, nil
+}
+
+// Validate - Caddy would answer every request of an upstream without dial addresses with a 502
+func (b *caddyBackend) Validate(ctx context.Context, lines []string) error {
+
+	for _, k := range b.upstreams {
+		if len(b.rendered[k.Upstream]) == 0 {
+			return fmt.Errorf("upstream %s has no servers", k.Upstream)
+		}
+	}
+
+	return nil
+}
+
+// Apply - Replace the upstreams of every handler when they changed
+func (b *caddyBackend) Apply(ctx context.Context, lines []string) error {
+
+	if configfile.Equal(b.lines, lines) {
+		log.Info().Msg("no changes to the caddy upstreams")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	b.previous = make(map[string][]caddy.Upstream)
+
+	for _, k := range b.upstreams {
+		current, err := b.client.Upstreams(ctx, k.Upstream)
+		if err != nil {
+			return fmt.Errorf("unable to get the upstreams of %s: %w", k.Upstream, err)
+		}
+		b.previous[k.Upstream] = current
+
+		log.Info().Msgf("updating the caddy upstreams of %s", k.Upstream)
+
+		if err := b.client.SetUpstreams(ctx, k.Upstream, b.rendered[k.Upstream]); err != nil {
+			return fmt.Errorf("unable to update the upstreams of %s: %w", k.Upstream, err)
+		}
+	}
+
+	b.lines = lines
+
+	added, removed := audit.Diff(b.applied, b.ips)
+	b.auditLog.Write(audit.Record{Target: b.Name(), Added: added, Removed: removed, Hash: audit.Hash(lines)})
+
+	b.applied = b.ips
+
+	recordMembership(b.upstreams, b.hosts)
+
+	return nil
+}
+
+// Rollback - Put back the upstreams of the handlers updated by the last Apply
+func (b *caddyBackend) Rollback(ctx context.Context) error {
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	b.lines = nil
+
+	for id, upstreams := range b.previous {
+		if err := b.client.SetUpstreams(ctx, id, upstreams); err != nil {
+			return fmt.Errorf("unable to restore the upstreams of %s: %w", id, err)
+		}
+	}
+
+	b.auditLog.Write(audit.Record{Target: b.Name(), Reload: "rolled back"})
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package nginx
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// canary gives the nodes carrying a label, such as the nodes of a new pool during an upgrade, a lower
+// weight so they only get a share of the traffic
+type canary struct {
+	key   string
+	value string
+
+	// weight is the weight of every canary node, unless percent is set: the share of the traffic
+	// the canary nodes get together, from which their weight is worked out
+	weight  int
+	percent int
+}
+
+// newCanary - The canary for a key=value label, nil when no label is given
+func newCanary(label string, weight int, percent int) (*canary, error) {
+
+	if label == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, fmt.Errorf("canary label %s is not of the form key=value", label)
+	}
+
+	if weight < 1 {
+		return nil, fmt.Errorf("canary weight must be at least 1")
+	}
+
+	if percent < 0 || percent >= 100 {
+		return nil, fmt.Errorf("canary percent must be between 0 and 99")
+	}
+
+	return &canary{key: parts[0], value: parts[1], weight: weight, percent: percent}, nil
+}
+
+// matches - Whether the node carries the canary label
+func (c *canary) matches(n discovery.Node) bool {
+	return c != nil && n.Labels[c.key] == c.value
+}
+
+// weigh - Set the weight of the canary servers.  With a percent, the weight makes the canary servers
+// get that share of the weight of the servers in use, so the share holds as nodes join and leave.
+func (c *canary) weigh(servers []render.Server, canaries []bool) {
+
+	if c == nil {
+		return
+	}
+
+	count, stable := 0, 0
+	for i, s := range servers {
+		if s.Down || s.Backup {
+			continue
+		}
+		if canaries[i] {
+			count++
+		} else {
+			stable += s.Weight
+		}
+	}
+
+	if count == 0 {
+		return
+	}
+
+	weight := c.weight
+	if c.percent > 0 && stable > 0 {
+		weight = int(math.Round(float64(c.percent) * float64(stable) / float64((100-c.percent)*count)))
+		if weight < 1 {
+			weight = 1
+		}
+	}
+
+	log.Info().Msgf("%d canary nodes labeled %s=%s get weight %d", count, c.key, c.value, weight)
+
+	for i := range servers {
+		if canaries[i] {
+			servers[i].Weight = weight
+		}
+	}
+}
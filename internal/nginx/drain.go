@@ -0,0 +1,185 @@
+package nginx
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// drainer tracks when nodes went into maintenance, so they are first marked down and only
+// removed from the upstreams once in-flight connections had the drain delay to finish
+type drainer struct {
+	enabled  bool
+	delay    time.Duration
+	taints   map[string]bool
+	since    map[string]time.Time
+	rerender chan<- struct{}
+
+	// removed keeps the nodes that left the cluster marked down for one more reconcile, interval
+	// apart, so nginx stops sending them new requests before the servers disappear from the upstreams
+	removed  bool
+	interval time.Duration
+	rendered map[string]render.Server
+	last     map[string]render.Server
+	gone     []render.Server
+
+	// canary lowers the weight of the labeled nodes, nil when there is no canary
+	canary *canary
+}
+
+func newDrainer(enabled bool, delay time.Duration, taints string, rerender chan<- struct{}) *drainer {
+
+	d := &drainer{
+		enabled:  enabled,
+		delay:    delay,
+		taints:   make(map[string]bool),
+		since:    make(map[string]time.Time),
+		rerender: rerender,
+		rendered: make(map[string]render.Server),
+	}
+
+	for _, key := range strings.Split(taints, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			d.taints[key] = true
+		}
+	}
+
+	return d
+}
+
+// draining - Check if a node is cordoned or has one of the maintenance taints
+func (d *drainer) draining(n discovery.Node) bool {
+
+	if n.Unschedulable {
+		return true
+	}
+
+	for _, t := range n.Taints {
+		if d.taints[t.Key] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cycle - Start a reconcile of the nodes.  The servers of the last applied reconcile whose node left
+// the cluster are rendered down in this reconcile, and another reconcile is triggered an interval
+// later to remove them.
+func (d *drainer) cycle(nodes []discovery.Node) {
+
+	if !d.removed {
+		return
+	}
+
+	present := make(map[string]bool)
+	for _, n := range nodes {
+		present[n.Name] = true
+	}
+
+	d.gone = nil
+	for name, b := range d.last {
+		if !present[name] {
+			b.Down = true
+			d.gone = append(d.gone, b)
+		}
+	}
+
+	sort.Slice(d.gone, func(i, j int) bool { return d.gone[i].Name < d.gone[j].Name })
+
+	d.rendered = make(map[string]render.Server)
+
+	if len(d.gone) == 0 {
+		return
+	}
+
+	for _, b := range d.gone {
+		log.Info().Msgf("node %s left the cluster, marking it down until the next reconcile", b.Name)
+	}
+
+	time.AfterFunc(d.interval, func() {
+		select {
+		case d.rerender <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// applied - Remember the servers of the reconcile started by cycle once it is applied, a failed
+// reconcile keeps the nodes that left marked down on the retry
+func (d *drainer) applied() {
+
+	if d.removed {
+		d.last = d.rendered
+	}
+}
+
+// backends - The backends for the nodes.  Draining nodes are marked down until the drain delay
+// has passed and left out after that, nodes that left the cluster are marked down for a reconcile.
+func (d *drainer) backends(nodes []discovery.Node) []render.Server {
+
+	var backends []render.Server
+	var canaries []bool
+
+	now := time.Now()
+	present := make(map[string]bool)
+
+	for _, n := range nodes {
+		present[n.Name] = true
+
+		if !d.enabled || !d.draining(n) {
+			delete(d.since, n.Name)
+			backends = append(backends, newBackend(n))
+			canaries = append(canaries, d.canary.matches(n))
+			continue
+		}
+
+		since, ok := d.since[n.Name]
+		if !ok {
+			log.Info().Msgf("node %s is in maintenance, marking it down for %s", n.Name, d.delay)
+			since = now
+			d.since[n.Name] = now
+
+			// Render again once the delay passed to remove the node
+			time.AfterFunc(d.delay, func() {
+				select {
+				case d.rerender <- struct{}{}:
+				default:
+				}
+			})
+		}
+
+		if now.Sub(since) >= d.delay {
+			log.Info().Msgf("node %s drained, removing it from the upstreams", n.Name)
+			continue
+		}
+
+		b := newBackend(n)
+		b.Down = true
+		backends = append(backends, b)
+		canaries = append(canaries, d.canary.matches(n))
+	}
+
+	// Forget nodes that left the cluster
+	for name := range d.since {
+		if !present[name] {
+			delete(d.since, name)
+		}
+	}
+
+	d.canary.weigh(backends, canaries)
+
+	if d.removed {
+		for _, b := range backends {
+			d.rendered[b.Name] = b
+		}
+		backends = append(backends, d.gone...)
+	}
+
+	return backends
+}
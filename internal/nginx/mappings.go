@@ -0,0 +1,65 @@
+package nginx
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// mappingUpstreamList - An upstream for every UpstreamMapping resource, whose spec has the keys of an
+// upstream of the services file.  The upstream is named namespace-name unless the spec names it.
+func mappingUpstreamList(mappings []discovery.Resource) []upstream {
+
+	var upstreams []upstream
+
+	for _, m := range mappings {
+		data, err := yaml.Marshal(m.Spec)
+		if err != nil {
+			log.Warn().Err(err).Msgf("skipping upstream mapping %s/%s", m.Namespace, m.Name)
+			continue
+		}
+
+		var k upstream
+		if err := yaml.UnmarshalStrict(data, &k); err != nil {
+			log.Warn().Err(err).Msgf("skipping upstream mapping %s/%s", m.Namespace, m.Name)
+			continue
+		}
+
+		if k.Upstream == "" {
+			k.Upstream = m.Namespace + "-" + m.Name
+		}
+		if k.Port < 1 || k.Port > 65535 {
+			log.Warn().Msgf("skipping upstream mapping %s/%s, it has no valid port", m.Namespace, m.Name)
+			continue
+		}
+		if k.API != "" {
+			log.Warn().Msgf("skipping upstream mapping %s/%s, the api of an upstream is set in the services file", m.Namespace, m.Name)
+			continue
+		}
+
+		upstreams = append(upstreams, k)
+	}
+
+	return upstreams
+}
+
+// watchMappings - Keep the upstreams in sync with the UpstreamMapping resources, signalling rerender on every change
+func (s *serviceUpstreams) watchMappings(kubeconfig string, namespaces []string, rerender chan<- struct{}) {
+
+	err := discovery.WatchResources(context.Background(), kubeconfig, discovery.UpstreamMappings, namespaces, func(mappings []discovery.Resource) {
+		if s.set(mappingUpstreamList(mappings)) {
+			log.Info().Msgf("upstream mappings changed, now %d", len(s.get()))
+
+			select {
+			case rerender <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("watching the upstream mappings stopped")
+	}
+}
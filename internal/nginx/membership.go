@@ -0,0 +1,67 @@
+package nginx
+
+import (
+	"sync"
+
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+var (
+	membersMu sync.Mutex
+
+	// The serving servers of every upstream at the last apply, by upstream name
+	members = make(map[string]map[string]bool)
+)
+
+// recordMembership - Export the number of servers serving each upstream, and count the servers added
+// to and removed from it since the last apply, so backend churn can be graphed against error rates
+func recordMembership(upstreams []upstream, hosts []render.Server) {
+
+	membersMu.Lock()
+	defer membersMu.Unlock()
+
+	for _, k := range upstreams {
+		labels := metrics.Labels{"upstream": k.Upstream}
+
+		// An upstream with max_servers only has a subset of the nodes
+		servers := render.Subset(render.InPools(hosts, k.Pools), k.Upstream, k.MaxServers)
+
+		current := make(map[string]bool)
+		for _, s := range render.Active(servers) {
+			current[s.IP.String()] = true
+		}
+
+		down := 0
+		for _, s := range servers {
+			if s.Down {
+				down++
+			}
+		}
+
+		previous, known := members[k.Upstream]
+
+		added, removed := 0, 0
+		for ip := range current {
+			if !previous[ip] {
+				added++
+			}
+		}
+		for ip := range previous {
+			if !current[ip] {
+				removed++
+			}
+		}
+
+		metrics.SetGauge("linode_tools_upstream_servers", "Servers serving the upstream", labels, float64(len(current)))
+		metrics.SetGauge("linode_tools_upstream_servers_down", "Servers of the upstream marked down while they drain", labels, float64(down))
+
+		// The first apply fills the upstream, it is not churn
+		if known {
+			metrics.AddCounter("linode_tools_upstream_servers_added_total", "Servers added to the upstream", labels, float64(added))
+			metrics.AddCounter("linode_tools_upstream_servers_removed_total", "Servers removed from the upstream", labels, float64(removed))
+		}
+
+		members[k.Upstream] = current
+	}
+}
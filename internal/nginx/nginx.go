@@ -0,0 +1,1086 @@
+// Package nginx keeps the upstreams of an nginx configuration in sync with the cluster nodes.
+package nginx
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/admin"
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/config"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/diff"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/internal/throttle"
+	"github.com/rsvancara/linode-tools/internal/xds"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/caddy"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/nginxapi"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+const (
+	beginMarker = "### BEGIN kube-nginx ###"
+	endMarker   = "### END kube-nginx ###"
+)
+
+type tlsConfig struct {
+	Certificate string `yaml:"certificate"`
+	Key         string `yaml:"key"`
+	ACME        bool   `yaml:"acme"`
+}
+
+type upstream struct {
+	Upstream   string     `yaml:"upstream" validate:"required"`
+	API        string     `yaml:"api" validate:"oneof=plus dynamic"`
+	Port       int        `yaml:"port" validate:"required,min=1,max=65535"`
+	ServerName []string   `yaml:"server_name"`
+	Listen     string     `yaml:"listen"`
+	TLS        *tlsConfig `yaml:"tls"`
+
+	// Local addresses or interface names, for load balancers with more than one address
+	ListenAddresses []string `yaml:"listen_addresses"`
+	ProxyBind       string   `yaml:"proxy_bind"`
+
+	// Connection parameters of the upstream block
+	Balance           string `yaml:"balance"`
+	Keepalive         int    `yaml:"keepalive" validate:"min=0"`
+	KeepaliveRequests int    `yaml:"keepalive_requests" validate:"min=0"`
+	SlowStart         string `yaml:"slow_start"`
+
+	// MaxServers caps the servers of the upstream to a stable subset of the nodes
+	MaxServers int `yaml:"max_servers" validate:"min=0"`
+
+	// Pools keeps the servers to the nodes of these node pools, and PerPool adds an upstream per node pool
+	// named <upstream>-pool-<pool> with the nodes of that pool alone
+	Pools   []string `yaml:"pools"`
+	PerPool bool     `yaml:"per_pool"`
+
+	// Servers outside the cluster, such as legacy VMs, balanced to along with the nodes
+	Servers []staticServer `yaml:"servers"`
+}
+
+// staticServer is a server outside the cluster added to an upstream
+type staticServer struct {
+	Address string `yaml:"address" validate:"required"`
+	Weight  int    `yaml:"weight" validate:"min=0"`
+	Backup  bool   `yaml:"backup"`
+}
+
+// static - The static servers of the upstream, weighted like the nodes unless given a weight
+func (k upstream) static() []render.Static {
+
+	var static []render.Static
+	for _, s := range k.Servers {
+		weight := s.Weight
+		if weight == 0 {
+			weight = defaultWeight
+		}
+		static = append(static, render.Static{Address: s.Address, Weight: weight, Backup: s.Backup})
+	}
+
+	return static
+}
+
+// The node annotations that tune the server directives of a node
+const (
+	weightAnnotation = "kube-nginx/weight"
+	backupAnnotation = "kube-nginx/backup"
+)
+
+const defaultWeight = 100
+
+// poolLabel is the node label naming the node pool of a node, set from the -pool-label flag
+var poolLabel = "lke.linode.com/pool-id"
+
+// newBackend - The server directive for a node, using the weight and backup annotations when present
+func newBackend(n discovery.Node) render.Server {
+
+	b := render.Server{IP: n.IP, Weight: defaultWeight, Name: n.Name}
+	if poolLabel != "" {
+		b.Pool = n.Labels[poolLabel]
+	}
+
+	if v, ok := n.Annotations[weightAnnotation]; ok {
+		weight, err := strconv.Atoi(v)
+		if err != nil || weight < 1 {
+			log.Warn().Msgf("node %s has an invalid %s annotation %q, using weight %d", n.Name, weightAnnotation, v, defaultWeight)
+		} else {
+			b.Weight = weight
+		}
+	}
+
+	if v, ok := n.Annotations[backupAnnotation]; ok {
+		backup, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Warn().Msgf("node %s has an invalid %s annotation %q", n.Name, backupAnnotation, v)
+		}
+		b.Backup = backup
+	}
+
+	return b
+}
+
+// The formats a target can be rendered in
+const (
+	formatNginx   = "nginx"
+	formatTraefik = "traefik"
+
+	// formatStream is an nginx file included from the stream context, proxying TCP or UDP
+	formatStream = "stream"
+
+	// formatVarnish is a VCL file of backends and directors included from the main VCL of Varnish
+	formatVarnish = "varnish"
+)
+
+// knownFormat - Whether a target can be rendered in the format
+func knownFormat(format string) bool {
+	return format == formatNginx || format == formatTraefik || format == formatStream || format == formatVarnish
+}
+
+// The apis an upstream can be updated through without a reload
+const (
+	apiPlus    = "plus"
+	apiDynamic = "dynamic"
+)
+
+// target is a config file written from the nodes, with its own upstreams and reload command
+type target struct {
+	Config     string     `yaml:"config" validate:"required"`
+	Format     string     `yaml:"format" validate:"oneof=nginx stream traefik varnish"`
+	Reload     string     `yaml:"reload"`
+	RemotePath string     `yaml:"ssh_path"`
+	Upstreams  []upstream `yaml:"upstreams"`
+
+	// Split writes every upstream to <upstream>.conf in the directory of the config file, which becomes an
+	// index including them when Includes is set
+	Split    bool `yaml:"split"`
+	Includes bool `yaml:"includes"`
+
+	// The mode and ownership of the config file, overriding -file-mode, -file-owner and -file-group
+	Permissions configfile.Permissions `yaml:",inline"`
+
+	// The servers of the last applied config, to record what changed
+	applied []net.IP
+
+	// The config nginx last loaded, put back on rollback, and whether a reload was deferred
+	loaded  []string
+	pending bool
+
+	// The version of the config file nginx last loaded, with -versions
+	loadedVersion string
+
+	// The upstreams discovered from services and from UpstreamMapping resources, only set on the -config target
+	services *serviceUpstreams
+	mappings *serviceUpstreams
+}
+
+type serviceConfig struct {
+	Upstreams []upstream `yaml:"upstreams"`
+	Targets   []*target  `yaml:"targets"`
+}
+
+// defaultUpstreams - The upstreams used when no service configuration file is given
+func defaultUpstreams() []upstream {
+	return []upstream{
+		{Upstream: "diy", Port: 32016},
+		{Upstream: "dockerui", Port: 32018},
+		{Upstream: "tryingadventure", Port: 32020},
+		{Upstream: "devops", Port: 32021},
+		{Upstream: "monitor", Port: 32699},
+	}
+}
+
+// loadServices - Load the targets from the service configuration file.  The top level upstreams
+// are written to the config file given on the command line, each of the targets to its own file.
+func loadServices(path string, nginxconfig string) ([]*target, error) {
+
+	if path == "" {
+		return []*target{{Config: nginxconfig, Upstreams: defaultUpstreams()}}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var services serviceConfig
+	if err := config.Decode(path, data, &services); err != nil {
+		return nil, err
+	}
+
+	var targets []*target
+	if len(services.Upstreams) > 0 || len(services.Targets) == 0 {
+		targets = append(targets, &target{Config: nginxconfig, Upstreams: services.Upstreams})
+	}
+	targets = append(targets, services.Targets...)
+
+	seen := make(map[string]bool)
+
+	for _, t := range targets {
+		if seen[t.Config] {
+			return nil, fmt.Errorf("parsing %s: config file %s is used by more than one target", path, t.Config)
+		}
+		seen[t.Config] = true
+
+		if t.Includes && !t.Split {
+			return nil, fmt.Errorf("parsing %s: target %s generates includes only with split", path, t.Config)
+		}
+		if t.Split && t.Format != "" && t.Format != formatNginx && t.Format != formatStream {
+			return nil, fmt.Errorf("parsing %s: target %s can only be split in the nginx or stream format", path, t.Config)
+		}
+
+		if err := t.Permissions.Validate(); err != nil {
+			return nil, fmt.Errorf("parsing %s: target %s: %w", path, t.Config, err)
+		}
+
+		for i := range t.Upstreams {
+			acmeDefaults(&t.Upstreams[i])
+		}
+
+		for i := range t.Upstreams {
+			if err := resolveAddresses(&t.Upstreams[i]); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", path, err)
+			}
+		}
+
+		for _, k := range t.Upstreams {
+			if err := validateBalance(k.Balance); err != nil {
+				return nil, fmt.Errorf("parsing %s: upstream %s: %w", path, k.Upstream, err)
+			}
+			if len(k.Servers) > 0 && k.API != "" {
+				return nil, fmt.Errorf("parsing %s: upstream %s is updated through an api and cannot have static servers", path, k.Upstream)
+			}
+			if k.TLS != nil && (k.TLS.Certificate == "" || k.TLS.Key == "") {
+				return nil, fmt.Errorf("parsing %s: upstream %s needs both a tls certificate and key", path, k.Upstream)
+			}
+			if k.TLS != nil && k.TLS.ACME && len(k.ServerName) == 0 {
+				return nil, fmt.Errorf("parsing %s: upstream %s needs a server_name to request an acme certificate", path, k.Upstream)
+			}
+			if t.Format == formatStream && (len(k.ServerName) > 0 || k.TLS != nil || k.API != "" || k.Keepalive > 0 || k.SlowStart != "") {
+				return nil, fmt.Errorf("parsing %s: stream upstream %s only takes a port, listen, listen_addresses, proxy_bind, balance, max_servers and servers", path, k.Upstream)
+			}
+			if t.Format == formatVarnish && (len(k.ServerName) > 0 || k.Listen != "" || len(k.ListenAddresses) > 0 || k.ProxyBind != "" || k.TLS != nil || k.API != "" || k.Keepalive > 0 || k.SlowStart != "") {
+				return nil, fmt.Errorf("parsing %s: varnish upstream %s only takes a port, balance, max_servers and servers", path, k.Upstream)
+			}
+			if t.Format == formatVarnish && k.Balance != "" && k.Balance != "random" {
+				return nil, fmt.Errorf("parsing %s: varnish upstream %s is balanced round robin, or weighted with balance random", path, k.Upstream)
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// The load balancing methods of an upstream block, round robin is the default
+var balanceMethods = map[string]bool{
+	"least_conn": true,
+	"ip_hash":    true,
+	"hash":       true,
+	"random":     true,
+	"least_time": true,
+}
+
+// validateBalance - Check the load balancing method of an upstream, hash needs a key
+func validateBalance(balance string) error {
+
+	fields := strings.Fields(balance)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if !balanceMethods[fields[0]] {
+		return fmt.Errorf("unknown balance method %s", fields[0])
+	}
+
+	if fields[0] == "hash" && len(fields) < 2 {
+		return fmt.Errorf("balance method hash needs a key, for example hash $remote_addr consistent")
+	}
+
+	return nil
+}
+
+// resolveAddresses - Replace the interface names in the listen addresses and proxy_bind of an upstream
+// with the first IPv4 address of the interface
+func resolveAddresses(k *upstream) error {
+
+	for i, address := range k.ListenAddresses {
+		ip, err := interfaceAddress(address)
+		if err != nil {
+			return fmt.Errorf("upstream %s: %w", k.Upstream, err)
+		}
+		k.ListenAddresses[i] = ip
+	}
+
+	if k.ProxyBind != "" {
+		ip, err := interfaceAddress(k.ProxyBind)
+		if err != nil {
+			return fmt.Errorf("upstream %s: %w", k.Upstream, err)
+		}
+		k.ProxyBind = ip
+	}
+
+	return nil
+}
+
+// staticAddresses - Check the static servers of an upstream are given by address, Envoy does not resolve the
+// endpoints it is sent
+func staticAddresses(k upstream) error {
+
+	for _, s := range k.static() {
+		host, _, err := net.SplitHostPort(render.StaticAddress(s, k.Port))
+		if err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("upstream %s has the static server %s, the envoy endpoints of -xds-addr need an address", k.Upstream, s.Address)
+		}
+	}
+
+	return nil
+}
+
+// interfaceAddress - The address itself, or the first IPv4 address of the interface with that name
+func interfaceAddress(value string) (string, error) {
+
+	if net.ParseIP(value) != nil {
+		return value, nil
+	}
+
+	iface, err := net.InterfaceByName(value)
+	if err != nil {
+		return "", fmt.Errorf("%s is neither an address nor an interface: %w", value, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("interface %s has no IPv4 address", value)
+}
+
+// allUpstreams - The upstreams of every target
+func allUpstreams(targets []*target) []upstream {
+
+	var upstreams []upstream
+	for _, t := range targets {
+		upstreams = append(upstreams, t.all()...)
+	}
+
+	return upstreams
+}
+
+// NginxReload - Reload nginx after updating its config files
+func NginxReload(ctx context.Context, systemctlcmd string) error {
+
+	log.Info().Msgf("reloading nginx using command: %s reload", systemctlcmd)
+	result := hostexec.Run(hostexec.Command(ctx, systemctlcmd, "reload", "nginx"))
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("nginx reload completed in %.1fs %s", result.Seconds, result.Output())
+
+	return nil
+}
+
+// perPool - The upstreams with an upstream per node pool of the servers added after every upstream with per_pool.
+// The upstreams of the pools only have servers, they are proxied to from hand written server blocks.
+func perPool(upstreams []upstream, backends []render.Server) []upstream {
+
+	var pools []string
+	seen := make(map[string]bool)
+	for _, b := range backends {
+		if b.Pool != "" && !seen[b.Pool] {
+			seen[b.Pool] = true
+			pools = append(pools, b.Pool)
+		}
+	}
+	sort.Strings(pools)
+
+	var expanded []upstream
+
+	for _, k := range upstreams {
+		expanded = append(expanded, k)
+
+		if !k.PerPool {
+			continue
+		}
+
+		for _, p := range pools {
+			if len(k.Pools) > 0 && !contains(k.Pools, p) {
+				continue
+			}
+			expanded = append(expanded, upstream{
+				Upstream:          k.Upstream + "-pool-" + p,
+				Port:              k.Port,
+				Balance:           k.Balance,
+				Keepalive:         k.Keepalive,
+				KeepaliveRequests: k.KeepaliveRequests,
+				SlowStart:         k.SlowStart,
+				MaxServers:        k.MaxServers,
+				Pools:             []string{p},
+			})
+		}
+	}
+
+	return expanded
+}
+
+// contains - Whether the values hold the value
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func buildNginx(backends []render.Server, upstreams []upstream, servers bool, webroot string) []string {
+
+	log.Info().Msg("building new rules file for new list of IP addresses")
+
+	var rendered []render.Upstream
+
+	for _, k := range perPool(upstreams, backends) {
+
+		u := render.Upstream{
+			Name:            k.Upstream,
+			Port:            k.Port,
+			ServerName:      k.ServerName,
+			Listen:          k.Listen,
+			ListenAddresses: k.ListenAddresses,
+			ProxyBind:       k.ProxyBind,
+
+			Balance:           k.Balance,
+			Keepalive:         k.Keepalive,
+			KeepaliveRequests: k.KeepaliveRequests,
+			SlowStart:         k.SlowStart,
+			MaxServers:        k.MaxServers,
+			Pools:             k.Pools,
+			Static:            k.static(),
+		}
+
+		// The servers of upstreams updated through an api live in a shared memory zone
+		if k.API != "" {
+			u.Zone = k.Upstream
+		}
+
+		// ACME upstreams are served over plain http until their certificate has been issued
+		acme := k.TLS != nil && k.TLS.ACME
+		if acme {
+			u.ACMEWebroot = webroot
+		}
+		if k.TLS != nil && (!acme || certificateReady(k)) {
+			u.TLS = &render.TLS{Certificate: k.TLS.Certificate, Key: k.TLS.Key}
+		}
+
+		rendered = append(rendered, u)
+	}
+
+	return render.Nginx(rendered, backends, servers)
+}
+
+// buildStream - Render the upstreams for the nginx stream module, with a server block for the upstreams
+// with a listen port
+func buildStream(backends []render.Server, upstreams []upstream) []string {
+
+	log.Info().Msg("building new stream file for new list of IP addresses")
+
+	var rendered []render.Upstream
+
+	for _, k := range perPool(upstreams, backends) {
+		rendered = append(rendered, render.Upstream{
+			Name:            k.Upstream,
+			Port:            k.Port,
+			Balance:         k.Balance,
+			Listen:          k.Listen,
+			ListenAddresses: k.ListenAddresses,
+			ProxyBind:       k.ProxyBind,
+			MaxServers:      k.MaxServers,
+			Pools:           k.Pools,
+			Static:          k.static(),
+		})
+	}
+
+	return render.NginxStream(rendered, backends)
+}
+
+// buildTraefik - Render the upstreams as Traefik services, with routers for the upstreams with a
+// server_name when servers is set.  ACME upstreams are served over TLS without a certificate, leaving it
+// to the certificate resolver configured in Traefik.
+func buildTraefik(backends []render.Server, upstreams []upstream, servers bool) []string {
+
+	log.Info().Msg("building new traefik file for new list of IP addresses")
+
+	var rendered []render.Upstream
+
+	for _, k := range upstreams {
+
+		u := render.Upstream{Name: k.Upstream, Port: k.Port, ServerName: k.ServerName, MaxServers: k.MaxServers, Pools: k.Pools, Static: k.static()}
+		if k.TLS != nil && !k.TLS.ACME {
+			u.TLS = &render.TLS{Certificate: k.TLS.Certificate, Key: k.TLS.Key}
+		} else if k.TLS != nil {
+			u.TLS = &render.TLS{}
+		}
+
+		rendered = append(rendered, u)
+	}
+
+	return render.Traefik(rendered, backends, servers)
+}
+
+// buildVarnish - Render the upstreams as Varnish backends and a director per upstream
+func buildVarnish(backends []render.Server, upstreams []upstream) []string {
+
+	log.Info().Msg("building new varnish file for new list of IP addresses")
+
+	var rendered []render.Upstream
+
+	for _, k := range upstreams {
+		rendered = append(rendered, render.Upstream{Name: k.Upstream, Port: k.Port, Balance: k.Balance, MaxServers: k.MaxServers, Pools: k.Pools, Static: k.static()})
+	}
+
+	return render.Varnish(rendered, backends)
+}
+
+// Run - Run the nginx subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("nginx", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var nginxconfig string
+	fs.StringVar(&nginxconfig, "config", "/etc/nginx/upstreams/upstreams.conf", "Nginx upstream file")
+
+	var servicesconfig string
+	fs.StringVar(&servicesconfig, "services", "", "(optional) YAML file describing the upstreams, their server blocks and additional target files")
+
+	r := reconciler{tool: fs.Name()}
+
+	fs.StringVar(&r.systemctl, "systemctl", "/bin/systemctl", "systemctl executable command")
+	fs.BoolVar(&r.managed, "managed", false, "only manage the block between the kube-nginx markers, preserving the rest of the config file")
+	fs.StringVar(&r.markers.Begin, "begin-marker", beginMarker, "line starting the block managed with -managed")
+	fs.StringVar(&r.markers.End, "end-marker", endMarker, "line ending the block managed with -managed")
+	fs.BoolVar(&r.servers, "servers", false, "also generate server blocks for upstreams with a server_name")
+	fs.StringVar(&poolLabel, "pool-label", poolLabel, "node label naming the node pool of a node, noted next to its server directive and matched by the pools and per_pool of the upstreams, empty to ignore the pools")
+	fs.StringVar(&r.webroot, "acme-webroot", "/var/www/letsencrypt", "webroot used to answer acme http-01 challenges")
+	fs.IntVar(&r.backups, "backups", 5, "number of timestamped backups of the config file to keep, 0 disables backups")
+	fs.IntVar(&r.versions, "versions", 0, "(optional) write every config to a version named after its hash next to the config file, such as upstreams-<hash>.conf, and atomically flip the config file, a link, to it once nginx -t passes, keeping this many versions for instant rollback")
+	fs.StringVar(&r.nginxTest, "nginx-test", "nginx -t", "command testing the nginx config with -versions before it is reloaded, empty to skip the test")
+
+	var certbot string
+	fs.StringVar(&certbot, "certbot", "/usr/bin/certbot", "certbot executable command used for acme certificates")
+
+	var email string
+	fs.StringVar(&email, "acme-email", "", "(optional) email address registered with the acme account")
+
+	var renew time.Duration
+	fs.DurationVar(&renew, "acme-renew", 12*time.Hour, "how often to attempt certificate renewal")
+
+	var rollback bool
+	fs.BoolVar(&rollback, "rollback", false, "restore the newest backup of the config file, reload nginx and exit")
+
+	var dryRun bool
+	fs.BoolVar(&dryRun, "dry-run", false, "print a unified diff of the changes to the config file and exit without writing it or reloading nginx")
+
+	var sshHosts string
+	fs.StringVar(&sshHosts, "ssh-hosts", "", "(optional) comma separated hosts the config is copied to and nginx reloaded on over ssh, instead of reloading locally")
+
+	fs.StringVar(&r.ssh.User, "ssh-user", "", "(optional) user to log in to the ssh hosts as")
+	fs.StringVar(&r.ssh.Key, "ssh-key", "", "(optional) private key used to log in to the ssh hosts")
+	fs.StringVar(&r.ssh.SSH, "ssh", "ssh", "ssh executable command")
+	fs.StringVar(&r.ssh.SCP, "scp", "scp", "scp executable command")
+
+	var remotePath string
+	fs.StringVar(&remotePath, "ssh-path", "", "path of the config file on the ssh hosts, defaults to -config")
+
+	fs.StringVar(&r.sshReload, "ssh-reload", "systemctl reload nginx", "command reloading nginx on the ssh hosts")
+
+	var split bool
+	fs.BoolVar(&split, "split", false, "write every upstream to its own <upstream>.conf in the directory of -config instead of a single file, include them with include /etc/nginx/upstreams/*.conf or -includes")
+
+	var includes bool
+	fs.BoolVar(&includes, "includes", false, "with -split, write -config as an index including the file of every upstream")
+
+	var format string
+	fs.StringVar(&format, "format", formatNginx, "format of the -config file, nginx, stream for a file included from the nginx stream context, traefik for a Traefik file provider configuration, or varnish for a VCL file included from the main VCL")
+
+	fs.StringVar(&r.varnishadm, "varnishadm", "varnishadm", "varnishadm command, with its -T and -S arguments when needed, loading the VCL of varnish targets")
+	fs.StringVar(&r.varnishVCL, "varnish-vcl", "/etc/varnish/default.vcl", "main VCL file including the varnish targets, loaded with vcl.load after each change")
+
+	var xdsAddr string
+	fs.StringVar(&xdsAddr, "xds-addr", "", "(optional) address to serve the upstreams to Envoy on as a grpc xds endpoint discovery service, instead of writing nginx config files, for example :18000")
+
+	var plusAPI string
+	fs.StringVar(&plusAPI, "nginx-plus-api", "", "(optional) nginx Plus API used for upstreams with api: plus, for example http://127.0.0.1:8080/api/8")
+
+	var dynamicAPI string
+	fs.StringVar(&dynamicAPI, "nginx-dynamic-api", "", "(optional) location of the dynamic upstream module used for upstreams with api: dynamic, for example http://127.0.0.1:8080/dynamic")
+
+	var caddyAdmin string
+	fs.StringVar(&caddyAdmin, "caddy-admin", "", "(optional) address of a Caddy admin API to update the reverse_proxy upstreams through, instead of writing nginx config files, for example "+caddy.DefaultURL)
+
+	r.throttle = &throttle.Throttle{}
+	fs.IntVar(&r.throttle.Budget, "reload-budget", 0, "(optional) maximum number of reloads per -reload-window, changes beyond it are written and reloaded together once allowed")
+	fs.DurationVar(&r.throttle.Window, "reload-window", 10*time.Minute, "window of the reload budget")
+	fs.IntVar(&r.throttle.Failures, "breaker-failures", 0, "(optional) number of failed reloads in a row that pause reloading, the config files are still written")
+	fs.DurationVar(&r.throttle.Cooldown, "breaker-cooldown", 10*time.Minute, "how long reloads stay paused, 0 waits for a POST to /reload/reset on the admin server")
+
+	var discoverServices bool
+	fs.BoolVar(&discoverServices, "discover-services", false, "add an upstream for every service annotated with "+upstreamAnnotation+", on its node port")
+
+	var watchMappings bool
+	fs.BoolVar(&watchMappings, "upstream-mappings", false, "add an upstream for every UpstreamMapping resource of the kube-nginx.io custom resource definition in deploy/crds.yaml")
+
+	var namespaces string
+	fs.StringVar(&namespaces, "namespaces", "", "(optional) comma separated namespaces services and upstream mappings are discovered in, every namespace when empty")
+
+	var excludeNamespaces string
+	fs.StringVar(&excludeNamespaces, "exclude-namespaces", "", "(optional) comma separated namespaces whose services are not discovered, for example kube-system")
+
+	var namespacePrefix bool
+	fs.BoolVar(&namespacePrefix, "namespace-prefix", false, "prefix the upstreams named by the "+upstreamAnnotation+" annotation with the namespace of their service, so namespaces asking for the same name do not collide")
+
+	var printRBAC bool
+	fs.BoolVar(&printRBAC, "print-rbac", false, "print the least privileged RBAC objects for the flags given and exit")
+
+	var rbacSubject string
+	fs.StringVar(&rbacSubject, "rbac-subject", "kube-system/kube-nginx", "namespace/name of the service account the printed RBAC objects bind to")
+
+	var drainEnabled bool
+	fs.BoolVar(&drainEnabled, "drain", false, "mark cordoned or maintenance tainted nodes down and remove them after -drain-delay")
+
+	var drainDelay time.Duration
+	fs.DurationVar(&drainDelay, "drain-delay", time.Minute, "how long a draining node stays marked down before it is removed")
+
+	var drainRemoved bool
+	fs.BoolVar(&drainRemoved, "drain-removed", false, "keep the servers of nodes that left the cluster marked down for one reconcile, -interval long, before removing them")
+
+	var drainTaints string
+	fs.StringVar(&drainTaints, "drain-taints", "node.kubernetes.io/unschedulable", "comma separated taint keys that put a node in maintenance")
+
+	var canaryLabel string
+	fs.StringVar(&canaryLabel, "canary-label", "", "(optional) key=value label of the canary nodes, for example canary=true, given a lower weight in the upstreams")
+
+	var canaryWeight int
+	fs.IntVar(&canaryWeight, "canary-weight", 10, "weight of the canary nodes, the other nodes have weight 100 unless annotated")
+
+	var canaryPercent int
+	fs.IntVar(&canaryPercent, "canary-percent", 0, "(optional) share of the traffic in percent the canary nodes get together, their weight is worked out from the other nodes instead of -canary-weight")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if r.managed {
+		if err := r.markers.Validate(); err != nil {
+			return err
+		}
+	}
+
+	canary, err := newCanary(canaryLabel, canaryWeight, canaryPercent)
+	if err != nil {
+		return err
+	}
+
+	var serviceNamespaces []string
+	for _, ns := range strings.Split(namespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			serviceNamespaces = append(serviceNamespaces, ns)
+		}
+	}
+
+	if printRBAC {
+		parts := strings.SplitN(rbacSubject, "/", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("-rbac-subject %s is not of the form namespace/name", rbacSubject)
+		}
+
+		fmt.Print(discovery.RBAC("kube-nginx", parts[1], parts[0], discoverServices, serviceNamespaces))
+		return nil
+	}
+
+	common.Start(fs.Name())
+
+	if !knownFormat(format) {
+		return fmt.Errorf("unknown format %s", format)
+	}
+
+	if xdsAddr != "" && caddyAdmin != "" {
+		return fmt.Errorf("-xds-addr and -caddy-admin cannot be used together")
+	}
+
+	r.apis = make(map[string]nginxapi.Updater)
+	if plusAPI != "" {
+		r.apis[apiPlus] = nginxapi.NewPlus(plusAPI)
+	}
+	if dynamicAPI != "" {
+		r.apis[apiDynamic] = nginxapi.NewDynamic(dynamicAPI)
+	}
+
+	var services *serviceUpstreams
+	if discoverServices {
+		services = &serviceUpstreams{prefix: namespacePrefix, exclude: make(map[string]bool)}
+		for _, ns := range strings.Split(excludeNamespaces, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				services.exclude[ns] = true
+			}
+		}
+	}
+
+	var mappings *serviceUpstreams
+	if watchMappings {
+		mappings = &serviceUpstreams{}
+	}
+
+	// load - The targets of the services file with the defaults of the flags, also used when the
+	// services file changes
+	load := func() ([]*target, error) {
+
+		targets, err := loadServices(servicesconfig, nginxconfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load service configuration: %w", err)
+		}
+
+		for _, k := range allUpstreams(targets) {
+			if k.API != "" && r.apis[k.API] == nil {
+				return nil, fmt.Errorf("upstream %s uses the %s api, but no address was given for it", k.Upstream, k.API)
+			}
+			if xdsAddr != "" {
+				if err := staticAddresses(k); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for _, t := range targets {
+			log.Info().Msgf("using nginx config file %s", t.Config)
+			if t.Config == nginxconfig && t.RemotePath == "" {
+				t.RemotePath = remotePath
+			}
+			if t.Config == nginxconfig && t.Format == "" {
+				t.Format = format
+			}
+			if t.Config == nginxconfig && split {
+				t.Split = true
+				t.Includes = includes
+			}
+			if t.Split && t.Format != formatNginx && t.Format != formatStream {
+				return nil, fmt.Errorf("%s can only be split in the nginx or stream format", t.Config)
+			}
+			if t.Split && len(r.sshHosts) > 0 {
+				return nil, fmt.Errorf("%s is split into a file per upstream, the ssh hosts are only sent a single file", t.Config)
+			}
+			if t.Split && r.versions > 0 {
+				return nil, fmt.Errorf("%s is split into a file per upstream, -versions only applies to single config files", t.Config)
+			}
+			if t.Split && r.managed {
+				return nil, fmt.Errorf("%s is split into a file per upstream, the files are written whole and cannot be -managed", t.Config)
+			}
+			if t.Split && !t.Includes {
+				if lines, _ := configfile.Read(t.Config); len(lines) > 0 {
+					log.Warn().Msgf("%s is split into a file per upstream, remove %s so its upstreams are not defined twice", t.Config, t.Config)
+				}
+			}
+		}
+
+		// Discovered upstreams go to the -config target, or the first target when the services file has no top level upstreams
+		if services != nil || mappings != nil {
+			t := targets[0]
+			for _, candidate := range targets {
+				if candidate.Config == nginxconfig {
+					t = candidate
+				}
+			}
+			t.services = services
+			t.mappings = mappings
+		}
+
+		return targets, nil
+	}
+
+	for _, host := range strings.Split(sshHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			r.sshHosts = append(r.sshHosts, host)
+		}
+	}
+
+	if includes && !split {
+		return fmt.Errorf("-includes needs -split")
+	}
+
+	targets, err := load()
+	if err != nil {
+		return err
+	}
+
+	if rollback {
+		ctx := context.Background()
+		for _, t := range targets {
+			if t.Split {
+				if err := rollbackSplit(ctx, t); err != nil {
+					return err
+				}
+				r.reload(ctx, t)
+				continue
+			}
+			if r.versions > 0 {
+				if _, err := configfile.RollbackVersion(t.Config); err != nil {
+					return err
+				}
+				r.reload(ctx, t)
+				continue
+			}
+			if _, err := configfile.Rollback(ctx, t.Config); err != nil {
+				return err
+			}
+			r.reload(ctx, t)
+		}
+		return nil
+	}
+
+	r.auditLog = audit.Open(common.AuditLog, fs.Name())
+	r.notifier = notify.New(common.NotifyWebhook, fs.Name())
+
+	// ACME needs nginx server blocks, the certificates are not used by the xDS and Caddy backends
+	acmeAllowed := r.servers && xdsAddr == "" && caddyAdmin == ""
+
+	// Signals the loop to render again once a pending certificate has been issued
+	// or the drain delay of a node has passed
+	rerender := make(chan struct{}, 1)
+
+	r.drain = newDrainer(drainEnabled, drainDelay, drainTaints, rerender)
+	r.drain.canary = canary
+	r.drain.removed = drainRemoved
+	r.drain.interval = common.Interval
+	r.rerender = rerender
+
+	// The reloads deferred while the breaker was open run right after a reset
+	r.throttle.OnReset = func() {
+		select {
+		case rerender <- struct{}{}:
+		default:
+		}
+	}
+	admin.HandleAction("/reload/reset", r.throttle)
+
+	var server *xds.Server
+	if xdsAddr != "" {
+		server = xds.New()
+
+		if !dryRun && common.Output == "" {
+			server.Serve(xdsAddr)
+		}
+	}
+
+	// build - The backends of the targets
+	build := func(targets []*target) []backend.Backend {
+
+		if server != nil {
+			return []backend.Backend{&xdsBackend{server: server, targets: targets, drain: r.drain, auditLog: r.auditLog}}
+		}
+
+		if caddyAdmin != "" {
+			return []backend.Backend{&caddyBackend{client: caddy.NewClient(caddyAdmin), targets: targets, drain: r.drain, auditLog: r.auditLog}}
+		}
+
+		return r.backends(targets)
+	}
+
+	backends := build(targets)
+
+	if common.Output != "" {
+		if services != nil {
+			found, err := discovery.Services(context.Background(), common.Kubeconfig, serviceNamespaces)
+			if err != nil {
+				return err
+			}
+			services.set(services.list(found))
+		}
+
+		if mappings != nil {
+			found, err := discovery.Resources(context.Background(), common.Kubeconfig, discovery.UpstreamMappings, serviceNamespaces)
+			if err != nil {
+				return err
+			}
+			mappings.set(mappingUpstreamList(found))
+		}
+
+		return common.Print(backends)
+	}
+
+	if dryRun {
+		nodes, err := common.Source().Nodes(context.Background())
+		if err != nil {
+			return err
+		}
+
+		if services != nil {
+			found, err := discovery.Services(context.Background(), common.Kubeconfig, serviceNamespaces)
+			if err != nil {
+				return err
+			}
+			services.set(services.list(found))
+		}
+
+		if mappings != nil {
+			found, err := discovery.Resources(context.Background(), common.Kubeconfig, discovery.UpstreamMappings, serviceNamespaces)
+			if err != nil {
+				return err
+			}
+			mappings.set(mappingUpstreamList(found))
+		}
+
+		for _, b := range backends {
+			configs, err := b.Render(context.Background(), nodes)
+			if err != nil {
+				return err
+			}
+
+			var existing []string
+			switch t := b.(type) {
+			case *targetBackend:
+				existing = t.existing
+			case *splitBackend:
+				existing = joinFiles(t.existing)
+			}
+
+			fmt.Print(diff.Unified(b.Name(), b.Name()+" (rendered)", existing, configs, 3))
+		}
+		return nil
+	}
+
+	// The backends, and the upstreams certificates are requested for, are replaced when the services file changes
+	var mu sync.Mutex
+	upstreams := allUpstreams(targets)
+	acme := acmeAllowed && usesACME(upstreams)
+
+	if acmeAllowed {
+		go func() {
+			for {
+				time.Sleep(renew)
+
+				mu.Lock()
+				upstreams, acme := upstreams, acme
+				mu.Unlock()
+
+				if !acme {
+					continue
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), certbotTimeout)
+
+				if certbotIssue(ctx, certbot, r.webroot, email, upstreams) {
+					select {
+					case rerender <- struct{}{}:
+					default:
+					}
+				}
+
+				r.lock.Lock()
+				renewed, err := certbotRenew(ctx, certbot, upstreams)
+				if err != nil {
+					log.Error().Err(err).Msg("certificate renewal failed")
+				}
+				if renewed {
+					reloadCtx, cancelReload := context.WithTimeout(context.Background(), time.Minute)
+					if err := NginxReload(reloadCtx, r.systemctl); err != nil {
+						r.notifier.Alert("certificates were renewed but reloading nginx failed, the old certificates are still served: %v", err)
+					}
+					cancelReload()
+				}
+				r.lock.Unlock()
+
+				cancel()
+			}
+		}()
+	}
+
+	if services != nil {
+		go services.watch(common.Kubeconfig, serviceNamespaces, rerender)
+	}
+
+	if mappings != nil {
+		go mappings.watchMappings(common.Kubeconfig, serviceNamespaces, rerender)
+	}
+
+	backends = common.Track(backends)
+
+	cli.OnDemand(rerender)
+
+	if servicesconfig != "" {
+		go configfile.Watch(servicesconfig, common.Interval, func() {
+
+			reloaded, err := load()
+			if err != nil {
+				log.Error().Err(err).Msgf("keeping the current configuration, %s is not valid", servicesconfig)
+				return
+			}
+
+			mu.Lock()
+			backends = common.Track(build(reloaded))
+			upstreams = allUpstreams(reloaded)
+			acme = acmeAllowed && usesACME(upstreams)
+			mu.Unlock()
+
+			log.Info().Msgf("reloaded %s", servicesconfig)
+
+			select {
+			case rerender <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	go common.Poll(rerender, r.notifier, func(ctx context.Context, nodes []discovery.Node) error {
+
+		mu.Lock()
+		backends, upstreams, acme := backends, upstreams, acme
+		mu.Unlock()
+
+		r.drain.cycle(nodes)
+
+		if err := r.reconcile(ctx, backends, nodes); err != nil {
+			return err
+		}
+
+		// The challenge locations are live now, request any missing certificates
+		// and switch those server blocks over to TLS
+		if acme && certbotIssue(ctx, certbot, r.webroot, email, upstreams) {
+			if err := r.reconcile(ctx, backends, nodes); err != nil {
+				return err
+			}
+		}
+
+		r.drain.applied()
+
+		return nil
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
@@ -0,0 +1,518 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/internal/remote"
+	"github.com/rsvancara/linode-tools/internal/throttle"
+	"github.com/rsvancara/linode-tools/internal/tracing"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/nginxapi"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// reconciler writes the rendered config of every target and reloads nginx, locally or on the ssh hosts
+type reconciler struct {
+	tool      string
+	systemctl string
+	managed   bool
+	markers   configfile.Markers
+	servers   bool
+	webroot   string
+	backups   int
+
+	// versions is the number of versions of a config file kept when it is a link flipped to the newest one,
+	// tested with nginxTest first.  The file is overwritten in place when 0.
+	versions  int
+	nginxTest string
+
+	// varnishadm loads the main VCL after a varnish target changed, the name of the last loaded one is discarded
+	varnishadm string
+	varnishVCL string
+	vclLoaded  string
+
+	ssh       remote.SSH
+	sshHosts  []string
+	sshReload string
+
+	drain *drainer
+
+	// The apis upstreams can be updated through without a reload, by name
+	apis map[string]nginxapi.Updater
+
+	// Limits the reloads, a deferred reload is retried through rerender
+	throttle *throttle.Throttle
+	rerender chan<- struct{}
+	retry    *time.Timer
+
+	auditLog *audit.Log
+	notifier *notify.Notifier
+
+	// Certificate renewal and config updates both reload nginx, only one of them at a time
+	lock sync.Mutex
+}
+
+// render - The current config file of a target and the config rendered for the servers
+func (r *reconciler) render(t *target, hosts []render.Server) ([]string, []string, error) {
+
+	existing, err := configfile.Read(t.Config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read %s: %w", t.Config, err)
+	}
+
+	// Traefik watches its file provider, the whole file is owned by the target
+	if t.Format == formatTraefik {
+		return existing, buildTraefik(hosts, t.all(), r.servers), nil
+	}
+
+	// The VCL file is included from the main VCL, the whole file is owned by the target
+	if t.Format == formatVarnish {
+		return existing, buildVarnish(hosts, t.all()), nil
+	}
+
+	var configs []string
+	if t.Format == formatStream {
+		configs = buildStream(hosts, t.all())
+	} else {
+		configs = buildNginx(hosts, t.all(), r.servers, r.webroot)
+	}
+
+	if r.managed {
+		merged, err := r.markers.Merge(existing, configs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", t.Config, err)
+		}
+		configs = merged
+	}
+
+	return existing, configs, nil
+}
+
+// reload - Run the reload command of a target, systemctl reload nginx unless it has its own
+func (r *reconciler) reload(ctx context.Context, t *target) error {
+
+	if t.Reload == "" && t.Format == formatTraefik {
+		log.Info().Msgf("traefik picks up %s without a reload", t.Config)
+		return nil
+	}
+
+	if t.Reload == "" && t.Format == formatVarnish {
+		return r.varnishReload(ctx)
+	}
+
+	if t.Reload == "" {
+		return NginxReload(ctx, r.systemctl)
+	}
+
+	command := strings.Fields(t.Reload)
+
+	log.Info().Msgf("reloading %s using command: %s", t.Config, t.Reload)
+
+	result := hostexec.Run(hostexec.Command(ctx, command[0], command[1:]...))
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("reload of %s completed in %.1fs %s", t.Config, result.Seconds, result.Output())
+
+	return nil
+}
+
+// reconcile - Apply every backend for the nodes, holding the lock so certificate renewal does not
+// reload nginx in between
+func (r *reconciler) reconcile(ctx context.Context, backends []backend.Backend, nodes []discovery.Node) error {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return backend.Reconcile(ctx, backends, nodes, r.notifier)
+}
+
+// retryAt - Render again once a deferred reload is allowed, coalescing every change deferred until then
+func (r *reconciler) retryAt(at time.Time) {
+
+	if at.IsZero() || r.retry != nil {
+		return
+	}
+
+	r.retry = time.AfterFunc(time.Until(at), func() {
+		r.lock.Lock()
+		r.retry = nil
+		r.lock.Unlock()
+
+		select {
+		case r.rerender <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func boolGauge(b bool) float64 {
+
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// backends - A backend for every target
+func (r *reconciler) backends(targets []*target) []backend.Backend {
+
+	var backends []backend.Backend
+	for _, t := range targets {
+		if t.Split {
+			backends = append(backends, &splitBackend{r: r, t: t})
+			continue
+		}
+		backends = append(backends, &targetBackend{r: r, t: t})
+	}
+
+	return backends
+}
+
+// targetBackend keeps the config file of a target as a backend.Backend
+type targetBackend struct {
+	r *reconciler
+	t *target
+
+	// The config file and servers read and rendered by the last Render
+	existing []string
+	hosts    []render.Server
+	ips      []net.IP
+}
+
+// Name - The config file of the target
+func (b *targetBackend) Name() string {
+	return b.t.Config
+}
+
+// Render - The config file of the target for the nodes
+func (b *targetBackend) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	hosts := b.r.drain.backends(nodes)
+
+	existing, configs, err := b.r.render(b.t, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	b.existing = existing
+	b.hosts = hosts
+
+	// The file found at startup is taken to be the one nginx runs with
+	if b.t.loaded == nil {
+		b.t.loaded = existing
+	}
+
+	b.ips = nil
+	for _, h := range hosts {
+		b.ips = append(b.ips, h.IP)
+	}
+
+	return configs, nil
+}
+
+// Validate - nginx refuses to load an upstream block without servers
+func (b *targetBackend) Validate(ctx context.Context, configs []string) error {
+
+	if b.t.Format == formatTraefik {
+		return nil
+	}
+
+	if b.t.Format == formatVarnish {
+		return validateVarnish(configs)
+	}
+
+	return validateUpstreams(configs)
+}
+
+// validateUpstreams - Check every upstream block of a rendered nginx config has servers
+func validateUpstreams(configs []string) error {
+
+	name := ""
+	servers := 0
+
+	for _, line := range configs {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "upstream ") && strings.HasSuffix(line, "{"):
+			name = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "upstream "), "{"))
+			servers = 0
+		case name != "" && strings.HasPrefix(line, "server "):
+			servers++
+		case name != "" && line == "}":
+			if servers == 0 {
+				return fmt.Errorf("upstream %s has no servers", name)
+			}
+			name = ""
+		}
+	}
+
+	return nil
+}
+
+// Apply - Write the config of the target when it changed and reload nginx, locally or on the ssh hosts
+func (b *targetBackend) Apply(ctx context.Context, configs []string) error {
+
+	r, t := b.r, b.t
+
+	changed := !configfile.Equal(b.existing, configs)
+
+	// Node changes that do not affect the rendered config do not need a reload, unless
+	// the reload of an earlier change was deferred
+	if !changed && !t.pending {
+		log.Info().Msgf("no changes to %s", t.Config)
+		return nil
+	}
+
+	if changed && r.versions > 0 {
+		span := tracing.Start("write " + t.Config)
+		err := b.writeVersion(ctx, configs)
+		span.End(err)
+		if err != nil {
+			return err
+		}
+	} else if changed {
+		if r.backups > 0 {
+			if err := configfile.Backup(t.Config, r.backups); err != nil {
+				return fmt.Errorf("unable to backup %s: %w", t.Config, err)
+			}
+		}
+
+		span := tracing.Start("write " + t.Config)
+		err := configfile.WriteWith(ctx, t.Config, configs, t.Permissions.Or(configfile.Default))
+		span.End(err)
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %w", t.Config, err)
+		}
+	}
+
+	var reloadErr error
+
+	if len(r.sshHosts) == 0 && b.apiOnly(configs) {
+		// Only upstreams updated through an api changed, the file is kept for the next reload.  When
+		// the api fails, for example because nginx has not loaded the zone yet, nginx is reloaded instead.
+		err := b.sync(ctx)
+		if err == nil {
+			added, removed := audit.Diff(t.applied, b.ips)
+			r.auditLog.Write(audit.Record{Target: t.Config, Added: added, Removed: removed, Hash: audit.Hash(configs), Reload: "updated through the api"})
+
+			t.applied = b.ips
+			t.loaded = configs
+			b.recordVersion()
+
+			recordMembership(t.all(), b.hosts)
+
+			return nil
+		}
+
+		log.Warn().Err(err).Msgf("unable to update %s through the api, reloading nginx instead", t.Config)
+	}
+
+	// The file is written either way, a deferred reload picks it up once it is allowed again
+	throttled := len(r.sshHosts) > 0 || t.Format != formatTraefik || t.Reload != ""
+	if throttled {
+		if ok, retry, reason := r.throttle.Allow(); !ok {
+			log.Warn().Msgf("deferring the reload of %s: %s", t.Config, reason)
+			metrics.AddCounter("linode_tools_reloads_deferred_total", "Reloads deferred by the reload budget or circuit breaker", metrics.Labels{"tool": r.tool}, 1)
+
+			if !t.pending {
+				r.auditLog.Write(audit.Record{Target: t.Config, Hash: audit.Hash(configs), Reload: "deferred: " + reason})
+			}
+			t.pending = true
+
+			r.retryAt(retry)
+
+			return nil
+		}
+	}
+
+	if len(r.sshHosts) > 0 {
+		// nginx runs on other hosts, push the config there instead of reloading locally
+		reloadErr = b.push(ctx)
+	} else {
+		if t.Format != formatTraefik {
+			time.Sleep(5 * time.Second)
+		}
+
+		span := tracing.Start("reload " + t.Config)
+		reloadErr = r.reload(ctx, t)
+		span.End(reloadErr)
+		if reloadErr != nil {
+			metrics.AddCounter("linode_tools_reload_failures_total", "Reloads that exited with an error", metrics.Labels{"tool": r.tool}, 1)
+		}
+	}
+
+	if throttled {
+		r.throttle.Done(reloadErr)
+		metrics.SetGauge("linode_tools_reload_breaker_open", "Whether the circuit breaker pausing reloads is open", metrics.Labels{"tool": r.tool}, boolGauge(r.throttle.Open()))
+	}
+
+	if reloadErr != nil {
+		r.auditLog.Write(audit.Record{Target: t.Config, Hash: audit.Hash(configs), Reload: audit.Result(reloadErr)})
+		return reloadErr
+	}
+
+	added, removed := audit.Diff(t.applied, b.ips)
+	r.auditLog.Write(audit.Record{Target: t.Config, Added: added, Removed: removed, Hash: audit.Hash(configs), Reload: audit.Result(nil)})
+
+	t.applied = b.ips
+	t.loaded = configs
+	t.pending = false
+	b.recordVersion()
+
+	recordMembership(t.all(), b.hosts)
+
+	return nil
+}
+
+// apiOnly - Whether the rendered config only differs from the file in upstreams updated through an api
+func (b *targetBackend) apiOnly(configs []string) bool {
+
+	// A deferred reload has other changes pending
+	if b.t.pending {
+		return false
+	}
+
+	names := make(map[string]bool)
+	for _, k := range b.t.all() {
+		if k.API != "" {
+			names[k.Upstream] = true
+		}
+	}
+
+	if len(names) == 0 {
+		return false
+	}
+
+	return configfile.Equal(withoutUpstreams(b.existing, names), withoutUpstreams(configs, names))
+}
+
+// sync - Replace the servers of the upstreams updated through an api
+func (b *targetBackend) sync(ctx context.Context) error {
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	for _, k := range b.t.all() {
+		if k.API == "" {
+			continue
+		}
+
+		var servers []nginxapi.Server
+		for _, h := range render.Subset(render.InPools(b.hosts, k.Pools), k.Upstream, k.MaxServers) {
+			servers = append(servers, nginxapi.Server{Addr: fmt.Sprintf("%s:%d", h.IP, k.Port), Weight: h.Weight, Backup: h.Backup, Down: h.Down})
+		}
+
+		log.Info().Msgf("updating the servers of %s through the %s api", k.Upstream, k.API)
+
+		if err := b.r.apis[k.API].Sync(ctx, k.Upstream, servers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withoutUpstreams - The config without the server directives of the named upstreams, the other
+// parameters of their blocks still need a reload when they change
+func withoutUpstreams(configs []string, names map[string]bool) []string {
+
+	var kept []string
+	skipping := false
+
+	for _, line := range configs {
+		trimmed := strings.TrimSpace(line)
+
+		if !skipping && strings.HasPrefix(trimmed, "upstream ") && strings.HasSuffix(trimmed, "{") {
+			name := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(trimmed, "upstream "), "{"))
+			if names[name] {
+				skipping = true
+			}
+		}
+
+		if skipping {
+			if trimmed == "}" {
+				skipping = false
+			}
+			if strings.HasPrefix(trimmed, "server ") {
+				continue
+			}
+		}
+
+		kept = append(kept, line)
+	}
+
+	return kept
+}
+
+// push - Copy the config of the target to the ssh hosts and reload nginx there
+func (b *targetBackend) push(ctx context.Context) error {
+
+	r, t := b.r, b.t
+
+	remotePath := t.RemotePath
+	if remotePath == "" {
+		remotePath = t.Config
+	}
+
+	results := r.ssh.Apply(ctx, r.sshHosts, t.Config, remotePath, r.sshReload)
+
+	summary, ok := remote.Summary(results)
+	for _, res := range results {
+		if res.Err != nil {
+			metrics.AddCounter("linode_tools_remote_apply_failures_total", "Failed applies of the config on a remote host", metrics.Labels{"tool": r.tool, "host": res.Host}, 1)
+			log.Error().Err(res.Err).Msgf("applying %s on %s failed: %s", remotePath, res.Host, res.Output)
+		}
+	}
+
+	if !ok {
+		return fmt.Errorf("applying %s failed on some hosts: %s", remotePath, summary)
+	}
+
+	return nil
+}
+
+// Rollback - Put the previous config back so nginx keeps running with a config it accepts.  The
+// ssh hosts restore their own copy, the local copy is put back so the next poll pushes again.
+func (b *targetBackend) Rollback(ctx context.Context) error {
+
+	r, t := b.r, b.t
+
+	if r.versions > 0 {
+		if err := b.rollbackVersion(ctx); err != nil {
+			return err
+		}
+	} else if err := configfile.WriteWith(ctx, t.Config, t.loaded, t.Permissions.Or(configfile.Default)); err != nil {
+		return fmt.Errorf("unable to restore %s: %w", t.Config, err)
+	}
+
+	t.pending = false
+
+	r.auditLog.Write(audit.Record{Target: t.Config, Hash: audit.Hash(t.loaded), Reload: "rolled back"})
+
+	if len(r.sshHosts) > 0 {
+		return nil
+	}
+
+	if err := r.reload(ctx, t); err != nil {
+		return fmt.Errorf("restored the previous %s but the reload still fails: %w", t.Config, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package nginx
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+var managedMarkers = configfile.Markers{Begin: "### BEGIN kube-nginx ###", End: "### END kube-nginx ###"}
+
+// TestRenderManaged checks the managed block is replaced and the hand written config around it kept
+func TestRenderManaged(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "nginx.conf")
+	content := "# hand written\n### BEGIN kube-nginx ###\nupstream old {\n}\n### END kube-nginx ###\nserver {\nlisten 8080;\n}\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &reconciler{managed: true, markers: managedMarkers}
+	tgt := &target{Config: path, Upstreams: []upstream{{Upstream: "web", Port: 30080}}}
+
+	_, configs, err := r.render(tgt, []render.Server{{IP: net.ParseIP("10.0.0.1"), Weight: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# hand written\n### BEGIN kube-nginx ###\nupstream web {\nserver 10.0.0.1:30080 weight=1;\n}\n### END kube-nginx ###\nserver {\nlisten 8080;\n}"
+	if got := strings.Join(configs, "\n"); got != want {
+		t.Errorf("rendered %q, expected %q", got, want)
+	}
+}
+
+// TestRenderManagedUnmatched checks a begin marker without its end marker fails instead of replacing the hand
+// written config after it
+func TestRenderManagedUnmatched(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "nginx.conf")
+	content := "### BEGIN kube-nginx ###\nupstream old {\n}\nserver {\nlisten 8080;\n}\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &reconciler{managed: true, markers: managedMarkers}
+	tgt := &target{Config: path, Upstreams: []upstream{{Upstream: "web", Port: 30080}}}
+
+	if _, configs, err := r.render(tgt, []render.Server{{IP: net.ParseIP("10.0.0.1"), Weight: 1}}); err == nil {
+		t.Fatalf("rendered %q for a begin marker without an end marker", configs)
+	}
+}
+
+// TestStaticAddresses checks static servers given by name are refused for the Envoy endpoints
+func TestStaticAddresses(t *testing.T) {
+
+	if err := staticAddresses(upstream{Upstream: "shop", Port: 30080, Servers: []staticServer{{Address: "192.168.1.5"}, {Address: "[fd00::5]:8080"}}}); err != nil {
+		t.Error(err)
+	}
+
+	if err := staticAddresses(upstream{Upstream: "shop", Port: 30080, Servers: []staticServer{{Address: "legacy.example.com:8080"}}}); err == nil {
+		t.Error("a static server given by name was accepted")
+	}
+}
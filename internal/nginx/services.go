@@ -0,0 +1,165 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// The service annotations that turn a service into an upstream
+const (
+	upstreamAnnotation   = "kube-nginx/upstream"
+	portAnnotation       = "kube-nginx/port"
+	serverNameAnnotation = "kube-nginx/server-name"
+)
+
+// serviceUpstreams holds the upstreams discovered from the annotated services
+type serviceUpstreams struct {
+	mu        sync.Mutex
+	upstreams []upstream
+
+	// Prefix the upstreams named by the annotation with the namespace of their service, and skip the
+	// services in the excluded namespaces
+	prefix  bool
+	exclude map[string]bool
+}
+
+// get - The discovered upstreams, nil is none
+func (s *serviceUpstreams) get() []upstream {
+
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.upstreams
+}
+
+// set - Replace the discovered upstreams, reporting whether they changed
+func (s *serviceUpstreams) set(upstreams []upstream) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fmt.Sprint(s.upstreams) == fmt.Sprint(upstreams) {
+		return false
+	}
+
+	s.upstreams = upstreams
+
+	return true
+}
+
+// watch - Keep the upstreams in sync with the services, signalling rerender on every change
+func (s *serviceUpstreams) watch(kubeconfig string, namespaces []string, rerender chan<- struct{}) {
+
+	err := discovery.WatchServices(context.Background(), kubeconfig, namespaces, func(services []discovery.Service) {
+		if s.set(s.list(services)) {
+			log.Info().Msgf("discovered upstreams changed, now %d", len(s.get()))
+
+			select {
+			case rerender <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("service discovery stopped")
+	}
+}
+
+// list - An upstream for every service with the upstream annotation outside the excluded namespaces.  When
+// services of different namespaces ask for the same upstream name, the first by namespace and name keeps it.
+func (s *serviceUpstreams) list(services []discovery.Service) []upstream {
+
+	var upstreams []upstream
+
+	owners := make(map[string]string)
+
+	for _, svc := range services {
+		name, ok := svc.Annotations[upstreamAnnotation]
+		if !ok || s.exclude[svc.Namespace] {
+			continue
+		}
+		if name == "" || name == "true" {
+			name = svc.Namespace + "-" + svc.Name
+		} else if s.prefix {
+			name = svc.Namespace + "-" + name
+		}
+
+		if owner, taken := owners[name]; taken {
+			log.Warn().Msgf("skipping service %s/%s, upstream %s is already discovered from %s", svc.Namespace, svc.Name, name, owner)
+			continue
+		}
+		owners[name] = svc.Namespace + "/" + svc.Name
+
+		port, err := servicePort(svc)
+		if err != nil {
+			log.Warn().Err(err).Msgf("skipping service %s/%s", svc.Namespace, svc.Name)
+			continue
+		}
+
+		k := upstream{Upstream: name, Port: port}
+		for _, n := range strings.Split(svc.Annotations[serverNameAnnotation], ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				k.ServerName = append(k.ServerName, n)
+			}
+		}
+
+		upstreams = append(upstreams, k)
+	}
+
+	return upstreams
+}
+
+// servicePort - The node port of the port named, or numbered, by the port annotation, or of the first port
+func servicePort(svc discovery.Service) (int, error) {
+
+	want, ok := svc.Annotations[portAnnotation]
+	if !ok {
+		return svc.NodePorts[0].NodePort, nil
+	}
+
+	for _, p := range svc.NodePorts {
+		if p.Name == want || strconv.Itoa(p.Port) == want {
+			return p.NodePort, nil
+		}
+	}
+
+	return 0, fmt.Errorf("service has no node port for port %s", want)
+}
+
+// all - The upstreams of the target including the mapped and discovered ones
+func (t *target) all() []upstream {
+
+	mapped := t.mappings.get()
+	discovered := t.services.get()
+	if len(mapped) == 0 && len(discovered) == 0 {
+		return t.Upstreams
+	}
+
+	upstreams := make([]upstream, 0, len(t.Upstreams)+len(mapped)+len(discovered))
+	upstreams = append(upstreams, t.Upstreams...)
+
+	// Upstreams from the services file win over mapped ones with the same name, which win over discovered ones
+	known := make(map[string]bool)
+	for _, k := range t.Upstreams {
+		known[k.Upstream] = true
+	}
+	for _, k := range append(append([]upstream(nil), mapped...), discovered...) {
+		if !known[k.Upstream] {
+			known[k.Upstream] = true
+			upstreams = append(upstreams, k)
+		}
+	}
+
+	return upstreams
+}
@@ -0,0 +1,368 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/metrics"
+	"github.com/rsvancara/linode-tools/internal/tracing"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// The first line of the files written by a split target, telling the files of the upstreams that are gone
+// from the other files of the directory
+const (
+	splitHeader   = "# upstream %s, written by kube-nginx"
+	includeHeader = "# includes of the upstreams, written by kube-nginx"
+)
+
+// splitBackend keeps the upstreams of a split target as a backend.Backend, each in its own file in the
+// directory of the config file, so a change to one upstream leaves the files of the others untouched.  The
+// config file is the includes index of the upstream files when the target has includes.
+type splitBackend struct {
+	r *reconciler
+	t *target
+
+	// The files rendered and found by the last Render, by path.  A file found without being rendered
+	// belongs to an upstream that is gone and is removed.
+	files    map[string][]string
+	existing map[string][]string
+
+	// The content of the files changed by the last Apply, nil for the files that did not exist
+	previous map[string][]string
+
+	hosts []render.Server
+	ips   []net.IP
+}
+
+// dir - The directory the upstream files are written to
+func (b *splitBackend) dir() string {
+	return filepath.Dir(b.t.Config)
+}
+
+// Name - The config file of the target
+func (b *splitBackend) Name() string {
+	return b.t.Config
+}
+
+// Render - A file for every upstream of the target, and the includes index when the target has one,
+// returned one after the other each under a comment naming it
+func (b *splitBackend) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	t := b.t
+
+	hosts := b.r.drain.backends(nodes)
+
+	files := make(map[string][]string)
+	var includes []string
+
+	for _, k := range t.all() {
+		path := filepath.Join(b.dir(), k.Upstream+".conf")
+
+		var lines []string
+		if t.Format == formatStream {
+			lines = buildStream(hosts, []upstream{k})
+		} else {
+			lines = buildNginx(hosts, []upstream{k}, b.r.servers, b.r.webroot)
+		}
+
+		files[path] = append([]string{fmt.Sprintf(splitHeader, k.Upstream)}, lines...)
+		includes = append(includes, fmt.Sprintf("include %s;", path))
+	}
+
+	if t.Includes {
+		sort.Strings(includes)
+		files[t.Config] = append([]string{includeHeader}, includes...)
+	}
+
+	existing, err := b.read(files)
+	if err != nil {
+		return nil, err
+	}
+
+	b.files = files
+	b.existing = existing
+	b.hosts = hosts
+
+	b.ips = nil
+	for _, h := range hosts {
+		b.ips = append(b.ips, h.IP)
+	}
+
+	return joinFiles(files), nil
+}
+
+// read - The current content of the rendered files and of the upstream files of the directory that were
+// not rendered
+func (b *splitBackend) read(files map[string][]string) (map[string][]string, error) {
+
+	existing := make(map[string][]string)
+
+	paths, err := filepath.Glob(filepath.Join(b.dir(), "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+
+	for path := range files {
+		paths = append(paths, path)
+	}
+
+	for _, path := range paths {
+		if _, ok := existing[path]; ok {
+			continue
+		}
+
+		lines, err := configfile.Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", path, err)
+		}
+
+		// Only the files written for an upstream are removed, not the other files of the directory
+		if _, rendered := files[path]; !rendered && !ownedFile(lines) {
+			continue
+		}
+
+		existing[path] = lines
+	}
+
+	return existing, nil
+}
+
+// ownedFile - Whether a file was written for an upstream of a split target
+func ownedFile(lines []string) bool {
+	return len(lines) > 0 && strings.HasPrefix(lines[0], "# upstream ") && strings.HasSuffix(lines[0], ", written by kube-nginx")
+}
+
+// joinFiles - The files one after the other in the order of their path, each under a comment naming it
+func joinFiles(files map[string][]string) []string {
+
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var lines []string
+	for _, path := range paths {
+		lines = append(lines, "# "+path)
+		lines = append(lines, files[path]...)
+	}
+
+	return lines
+}
+
+// Validate - nginx refuses to load an upstream block without servers
+func (b *splitBackend) Validate(ctx context.Context, configs []string) error {
+	return validateUpstreams(configs)
+}
+
+// changed - The paths of the files to write or remove, in order
+func (b *splitBackend) changed() []string {
+
+	var paths []string
+
+	for path, lines := range b.files {
+		if current, ok := b.existing[path]; !ok || !configfile.Equal(current, lines) {
+			paths = append(paths, path)
+		}
+	}
+
+	for path := range b.existing {
+		if _, ok := b.files[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+// Apply - Write the files that changed, remove those of the upstreams that are gone and reload nginx once
+func (b *splitBackend) Apply(ctx context.Context, configs []string) error {
+
+	r, t := b.r, b.t
+
+	paths := b.changed()
+
+	if len(paths) == 0 && !t.pending {
+		log.Info().Msgf("no changes to the upstreams of %s", t.Config)
+		return nil
+	}
+
+	// Kept before anything is written, so a failed write is rolled back as well
+	previous := make(map[string][]string)
+	b.previous = previous
+
+	for _, path := range paths {
+		if lines, ok := b.existing[path]; ok {
+			previous[path] = lines
+		} else {
+			previous[path] = nil
+		}
+
+		if r.backups > 0 {
+			if err := configfile.Backup(path, r.backups); err != nil {
+				return fmt.Errorf("unable to backup %s: %w", path, err)
+			}
+		}
+
+		lines, rendered := b.files[path]
+		if !rendered {
+			log.Info().Msgf("removing %s, its upstream is gone", path)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("unable to remove %s: %w", path, err)
+			}
+			continue
+		}
+
+		span := tracing.Start("write " + path)
+		err := configfile.WriteWith(ctx, path, lines, t.Permissions.Or(configfile.Default))
+		span.End(err)
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %w", path, err)
+		}
+	}
+
+	// The files are written either way, a deferred reload picks them up once it is allowed again
+	if ok, retry, reason := r.throttle.Allow(); !ok {
+		log.Warn().Msgf("deferring the reload of %s: %s", t.Config, reason)
+		metrics.AddCounter("linode_tools_reloads_deferred_total", "Reloads deferred by the reload budget or circuit breaker", metrics.Labels{"tool": r.tool}, 1)
+
+		if !t.pending {
+			r.auditLog.Write(audit.Record{Target: t.Config, Hash: audit.Hash(configs), Reload: "deferred: " + reason})
+		}
+		t.pending = true
+
+		r.retryAt(retry)
+
+		return nil
+	}
+
+	span := tracing.Start("reload " + t.Config)
+	reloadErr := r.reload(ctx, t)
+	span.End(reloadErr)
+	if reloadErr != nil {
+		metrics.AddCounter("linode_tools_reload_failures_total", "Reloads that exited with an error", metrics.Labels{"tool": r.tool}, 1)
+	}
+
+	r.throttle.Done(reloadErr)
+	metrics.SetGauge("linode_tools_reload_breaker_open", "Whether the circuit breaker pausing reloads is open", metrics.Labels{"tool": r.tool}, boolGauge(r.throttle.Open()))
+
+	if reloadErr != nil {
+		r.auditLog.Write(audit.Record{Target: t.Config, Hash: audit.Hash(configs), Reload: audit.Result(reloadErr)})
+		return reloadErr
+	}
+
+	// A record for every file, with the lines that changed in it
+	for _, path := range paths {
+		added, removed := diffLines(previous[path], b.files[path])
+		r.auditLog.Write(audit.Record{Target: path, Added: added, Removed: removed, Hash: audit.Hash(b.files[path]), Reload: audit.Result(nil)})
+	}
+
+	t.applied = b.ips
+	t.pending = false
+
+	recordMembership(t.all(), b.hosts)
+
+	return nil
+}
+
+// diffLines - The lines added and removed between two versions of a file
+func diffLines(oldLines []string, newLines []string) ([]string, []string) {
+
+	var added, removed []string
+
+	known := make(map[string]bool)
+	for _, line := range oldLines {
+		known[line] = true
+	}
+
+	current := make(map[string]bool)
+	for _, line := range newLines {
+		current[line] = true
+		if !known[line] {
+			added = append(added, line)
+		}
+	}
+
+	for _, line := range oldLines {
+		if !current[line] {
+			removed = append(removed, line)
+		}
+	}
+
+	return added, removed
+}
+
+// Rollback - Put back the files changed by the last Apply, removing those it created, and reload nginx
+func (b *splitBackend) Rollback(ctx context.Context) error {
+
+	r, t := b.r, b.t
+
+	for path, lines := range b.previous {
+		if lines == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("unable to remove %s: %w", path, err)
+			}
+			continue
+		}
+
+		if err := configfile.WriteWith(ctx, path, lines, t.Permissions.Or(configfile.Default)); err != nil {
+			return fmt.Errorf("unable to restore %s: %w", path, err)
+		}
+	}
+
+	b.previous = nil
+	t.pending = false
+
+	r.auditLog.Write(audit.Record{Target: t.Config, Reload: "rolled back"})
+
+	if err := r.reload(ctx, t); err != nil {
+		return fmt.Errorf("restored the previous upstreams of %s but the reload still fails: %w", t.Config, err)
+	}
+
+	return nil
+}
+
+// rollbackSplit - Restore the newest backup of every file of a split target that has one
+func rollbackSplit(ctx context.Context, t *target) error {
+
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(t.Config), "*.conf"))
+	if err != nil {
+		return err
+	}
+
+	restored := 0
+
+	for _, path := range paths {
+		backups, err := configfile.Backups(path)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			continue
+		}
+
+		if _, err := configfile.Rollback(ctx, path); err != nil {
+			return err
+		}
+		restored++
+	}
+
+	if restored == 0 {
+		return fmt.Errorf("no backups found in %s", filepath.Dir(t.Config))
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package nginx
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/rsvancara/linode-tools/internal/config"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// exampleServer is the node the targets are rendered for when validating, from the documentation range
+var exampleServer = render.Server{IP: net.ParseIP("192.0.2.1")}
+
+// Validate - Run the validate subcommand: load the services file and render every target for an example
+// node without writing any file or reloading anything, reporting every problem found.  Meant as a
+// pre-deploy check in CI.
+func Validate(args []string) error {
+
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+
+	var nginxconfig string
+	fs.StringVar(&nginxconfig, "config", "/etc/nginx/upstreams/upstreams.conf", "Nginx upstream file")
+
+	var servicesconfig string
+	fs.StringVar(&servicesconfig, "services", "", "(optional) YAML file describing the upstreams, their server blocks and additional target files")
+
+	var format string
+	fs.StringVar(&format, "format", formatNginx, "format of the -config file, nginx, stream, traefik or varnish")
+
+	var servers bool
+	fs.BoolVar(&servers, "servers", false, "also render server blocks for upstreams with a server_name")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !knownFormat(format) {
+		return fmt.Errorf("unknown format %s", format)
+	}
+
+	var problems []string
+
+	targets, err := loadServices(servicesconfig, nginxconfig)
+
+	// Every problem of the file is printed, not only the first
+	var errs config.Errors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+		return fmt.Errorf("found %d problems", len(errs))
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		if t.Config == nginxconfig && t.Format == "" {
+			t.Format = format
+		}
+
+		problems = append(problems, validateTarget(t, servers)...)
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems", len(problems))
+	}
+
+	for _, t := range targets {
+		fmt.Printf("%s: %d upstreams ok\n", t.Config, len(t.Upstreams))
+	}
+
+	return nil
+}
+
+// validateTarget - The problems of a target: a missing directory for its config file, upstreams
+// sharing a name, or a rendered config nginx would refuse
+func validateTarget(t *target, servers bool) []string {
+
+	var problems []string
+
+	if info, err := os.Stat(filepath.Dir(t.Config)); err != nil || !info.IsDir() {
+		problems = append(problems, fmt.Sprintf("%s: directory %s does not exist", t.Config, filepath.Dir(t.Config)))
+	}
+
+	seen := make(map[string]bool)
+	for _, k := range t.Upstreams {
+		if seen[k.Upstream] {
+			problems = append(problems, fmt.Sprintf("%s: upstream %s is defined more than once", t.Config, k.Upstream))
+		}
+		seen[k.Upstream] = true
+	}
+
+	hosts := []render.Server{exampleServer}
+
+	if t.Format == formatTraefik {
+		buildTraefik(hosts, t.Upstreams, servers)
+		return problems
+	}
+
+	var rendered []string
+	switch t.Format {
+	case formatStream:
+		rendered = buildStream(hosts, t.Upstreams)
+	case formatVarnish:
+		rendered = buildVarnish(hosts, t.Upstreams)
+	default:
+		rendered = buildNginx(hosts, t.Upstreams, servers, "")
+	}
+
+	b := &targetBackend{t: t}
+	if err := b.Validate(context.Background(), rendered); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", t.Config, err))
+	}
+
+	return problems
+}
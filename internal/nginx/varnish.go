@@ -0,0 +1,86 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+)
+
+// varnishReload - Load the main VCL under a new name with varnishadm vcl.load and switch to it with
+// vcl.use, then discard the VCL loaded before.  Varnish compiles the VCL on vcl.load and keeps running
+// the previous one when it fails.
+func (r *reconciler) varnishReload(ctx context.Context) error {
+
+	name := fmt.Sprintf("kube_%d", time.Now().UnixNano())
+
+	log.Info().Msgf("loading %s as vcl %s", r.varnishVCL, name)
+
+	if err := r.varnishadmRun(ctx, "vcl.load", name, r.varnishVCL); err != nil {
+		return err
+	}
+
+	if err := r.varnishadmRun(ctx, "vcl.use", name); err != nil {
+		return err
+	}
+
+	// A VCL still used by a running request cannot be discarded yet, varnish makes it cold later
+	if r.vclLoaded != "" {
+		if err := r.varnishadmRun(ctx, "vcl.discard", r.vclLoaded); err != nil {
+			log.Warn().Err(err).Msgf("unable to discard vcl %s", r.vclLoaded)
+		}
+	}
+
+	r.vclLoaded = name
+
+	log.Info().Msgf("varnish is using vcl %s", name)
+
+	return nil
+}
+
+// varnishadmRun - Run a varnishadm command
+func (r *reconciler) varnishadmRun(ctx context.Context, args ...string) error {
+
+	command := strings.Fields(r.varnishadm)
+	if len(command) == 0 {
+		return fmt.Errorf("no varnishadm command given")
+	}
+
+	if err := hostexec.Run(hostexec.Command(ctx, command[0], append(command[1:], args...)...)).Err(); err != nil {
+		return fmt.Errorf("varnishadm %s failed: %w", args[0], err)
+	}
+
+	return nil
+}
+
+// validateVarnish - Every director gets at least one backend, varnish would answer every request of
+// a director without backends with a 503
+func validateVarnish(configs []string) error {
+
+	var directors []string
+	backends := make(map[string]int)
+
+	for _, line := range configs {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "new ") && strings.Contains(line, " = directors."):
+			name := strings.Fields(line)[1]
+			directors = append(directors, name)
+		case strings.Contains(line, ".add_backend("):
+			backends[line[:strings.Index(line, ".add_backend(")]]++
+		}
+	}
+
+	for _, name := range directors {
+		if backends[name] == 0 {
+			return fmt.Errorf("director %s has no backends", name)
+		}
+	}
+
+	return nil
+}
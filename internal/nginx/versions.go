@@ -0,0 +1,98 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+)
+
+// writeVersion - Write the config to a version of the config file named after its hash and flip the config file,
+// a link, over to it.  nginx reads the config file only on a reload, so the new version is tested with nginx -t
+// before anything loads it and the link is flipped back when the test fails.
+func (b *targetBackend) writeVersion(ctx context.Context, configs []string) error {
+
+	r, t := b.r, b.t
+
+	version, err := configfile.WriteVersion(ctx, t.Config, configs, t.Permissions.Or(configfile.Default))
+	if err != nil {
+		return fmt.Errorf("unable to write a version of %s: %w", t.Config, err)
+	}
+
+	previous, err := configfile.Link(t.Config, version)
+	if err != nil {
+		return fmt.Errorf("unable to link %s to %s: %w", t.Config, version, err)
+	}
+
+	if !b.testable() {
+		return nil
+	}
+
+	if err := r.testConfig(ctx); err != nil {
+		if previous != "" {
+			if _, linkErr := configfile.Link(t.Config, previous); linkErr != nil {
+				log.Error().Err(linkErr).Msgf("unable to link %s back to %s", t.Config, previous)
+			}
+		}
+		return fmt.Errorf("%s does not pass the config test: %w", version, err)
+	}
+
+	return nil
+}
+
+// testable - Whether nginx -t tests the target, a file of nginx reloaded by the nginx service
+func (b *targetBackend) testable() bool {
+	return b.r.nginxTest != "" && b.t.Reload == "" && (b.t.Format == "" || b.t.Format == formatNginx || b.t.Format == formatStream)
+}
+
+// testConfig - Run the config test command, nginx -t by default
+func (r *reconciler) testConfig(ctx context.Context) error {
+
+	command := strings.Fields(r.nginxTest)
+
+	if err := hostexec.Run(hostexec.Command(ctx, command[0], command[1:]...)).Err(); err != nil {
+		return fmt.Errorf("%s failed: %w", r.nginxTest, err)
+	}
+
+	return nil
+}
+
+// recordVersion - Remember the version nginx loaded, the one rolled back to, and prune the versions beyond those kept
+func (b *targetBackend) recordVersion() {
+
+	if b.r.versions == 0 {
+		return
+	}
+
+	b.t.loadedVersion = configfile.Current(b.t.Config)
+
+	if err := configfile.PruneVersions(b.t.Config, b.r.versions); err != nil {
+		log.Warn().Err(err).Msgf("unable to remove the old versions of %s", b.t.Config)
+	}
+}
+
+// rollbackVersion - Link the config file back to the version nginx last loaded, or to a version of the config
+// it was started with when it has not loaded one yet
+func (b *targetBackend) rollbackVersion(ctx context.Context) error {
+
+	t := b.t
+
+	version := t.loadedVersion
+	if version == "" {
+		var err error
+		version, err = configfile.WriteVersion(ctx, t.Config, t.loaded, t.Permissions.Or(configfile.Default))
+		if err != nil {
+			return fmt.Errorf("unable to write a version of %s: %w", t.Config, err)
+		}
+	}
+
+	if _, err := configfile.Link(t.Config, version); err != nil {
+		return fmt.Errorf("unable to link %s back to %s: %w", t.Config, version, err)
+	}
+
+	return nil
+}
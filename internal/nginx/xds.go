@@ -0,0 +1,112 @@
+package nginx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/xds"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// xdsBackend publishes the upstreams as Envoy clusters instead of writing nginx config files
+type xdsBackend struct {
+	server   *xds.Server
+	targets  []*target
+	drain    *drainer
+	auditLog *audit.Log
+
+	// The assignments rendered by the last Render, and those published before the last Apply
+	rendered []render.ClusterLoadAssignment
+	previous []render.ClusterLoadAssignment
+	lines    []string
+	ips      []net.IP
+	hosts    []render.Server
+
+	// The nodes of the last published assignments, to record what changed
+	applied []net.IP
+}
+
+// Name - Identifies the backend in logs and alerts
+func (b *xdsBackend) Name() string {
+	return "xds"
+}
+
+// Render - A cluster load assignment per upstream, one JSON line each
+func (b *xdsBackend) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	var upstreams []render.Upstream
+	for _, k := range allUpstreams(b.targets) {
+		upstreams = append(upstreams, render.Upstream{Name: k.Upstream, Port: k.Port, MaxServers: k.MaxServers, Pools: k.Pools, Static: k.static()})
+	}
+
+	hosts := b.drain.backends(nodes)
+	b.hosts = hosts
+	b.rendered = render.Envoy(upstreams, hosts)
+
+	b.ips = nil
+	for _, h := range hosts {
+		b.ips = append(b.ips, h.IP)
+	}
+
+	var lines []string
+	for _, a := range b.rendered {
+		line, err := json.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, string(line))
+	}
+
+	return lines, nil
+}
+
+// Validate - Envoy would drop all traffic of a cluster without endpoints
+func (b *xdsBackend) Validate(ctx context.Context, lines []string) error {
+
+	for _, a := range b.rendered {
+		endpoints := 0
+		for _, group := range a.Endpoints {
+			endpoints += len(group.LbEndpoints)
+		}
+		if endpoints == 0 {
+			return fmt.Errorf("cluster %s has no endpoints", a.ClusterName)
+		}
+	}
+
+	return nil
+}
+
+// Apply - Publish the assignments when they changed, they are pushed to the streams of the Envoys right away
+func (b *xdsBackend) Apply(ctx context.Context, lines []string) error {
+
+	if configfile.Equal(b.lines, lines) {
+		return nil
+	}
+
+	b.previous = b.server.Resources()
+	b.server.Set(b.rendered)
+	b.lines = lines
+
+	added, removed := audit.Diff(b.applied, b.ips)
+	b.auditLog.Write(audit.Record{Target: b.Name(), Added: added, Removed: removed, Hash: audit.Hash(lines)})
+
+	b.applied = b.ips
+
+	recordMembership(allUpstreams(b.targets), b.hosts)
+
+	return nil
+}
+
+// Rollback - Publish the assignments from before the last Apply again
+func (b *xdsBackend) Rollback(ctx context.Context) error {
+
+	b.server.Set(b.previous)
+	b.lines = nil
+
+	return nil
+}
@@ -0,0 +1,67 @@
+// Package notify sends alerts about failures that need an operator to a webhook.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Notifier posts alerts to a webhook.  The payload carries the message in "text" so it can be
+// sent straight to a Slack or Mattermost incoming webhook.  A nil Notifier only logs the alerts.
+type Notifier struct {
+	webhook string
+	tool    string
+	client  *http.Client
+}
+
+// New - A notifier for a tool posting to the webhook, nil when no webhook is given
+func New(webhook string, tool string) *Notifier {
+
+	if webhook == "" {
+		return nil
+	}
+
+	return &Notifier{webhook: webhook, tool: tool, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Alert - Log the alert and post it to the webhook
+func (n *Notifier) Alert(format string, args ...interface{}) {
+
+	message := fmt.Sprintf(format, args...)
+
+	log.Error().Msgf("alert: %s", message)
+
+	if n == nil {
+		return
+	}
+
+	host, _ := os.Hostname()
+
+	payload, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("[%s on %s] %s", n.tool, host, message),
+		"tool": n.tool,
+		"host": host,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("unable to encode alert")
+		return
+	}
+
+	resp, err := n.client.Post(n.webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Error().Err(err).Msg("unable to send alert")
+		return
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Error().Msgf("alert webhook returned status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,251 @@
+// Package objectstorage uploads the applied output of every backend and the audit log to a Linode Object
+// Storage bucket with versioning, an off-host history of the firewall and upstream changes for forensics.
+//
+// Object Storage speaks the S3 API, the requests are signed with AWS Signature Version 4.
+package objectstorage
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/status"
+)
+
+// Bucket is a bucket of an Object Storage cluster the outputs of the host are uploaded to under Prefix
+type Bucket struct {
+	// Endpoint is the cluster, for example https://us-east-1.linodeobjects.com
+	Endpoint  string
+	Name      string
+	AccessKey string
+	SecretKey string
+	Prefix    string
+
+	// SigningKey adds a detached ed25519 signature of every object as <key>.sig when set
+	SigningKey ed25519.PrivateKey
+
+	client *http.Client
+
+	// The sha256 of every object last uploaded, so unchanged outputs do not pile up versions
+	mu       sync.Mutex
+	uploaded map[string]string
+}
+
+// New - The bucket at the endpoint, the region used to sign the requests is the cluster of the endpoint
+func New(endpoint string, name string, accessKey string, secretKey string, prefix string) (*Bucket, error) {
+
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("object storage needs an access key and a secret key")
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid object storage endpoint %s", endpoint)
+	}
+
+	return &Bucket{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Name:      name,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Prefix:    strings.Trim(prefix, "/"),
+		client:    &http.Client{Timeout: time.Minute},
+		uploaded:  make(map[string]string),
+	}, nil
+}
+
+// EnableVersioning - Turn on the versioning of the bucket, so every upload keeps the object it replaces
+func (b *Bucket) EnableVersioning() error {
+
+	body := []byte(`<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>Enabled</Status></VersioningConfiguration>`)
+
+	return b.do(http.MethodPut, "", "versioning=", body)
+}
+
+// Upload - Upload the applied output of every backend of the tool that changed since the last upload, and
+// the audit log when given
+func (b *Bucket) Upload(tool string, auditLog string) error {
+
+	var failed []string
+
+	for name, rendered := range status.Configs() {
+		key := path.Join(b.Prefix, tool, strings.TrimPrefix(name, "/"))
+
+		if err := b.putChanged(key, []byte(strings.Join(rendered, "\n")+"\n")); err != nil {
+			log.Error().Err(err).Msgf("unable to upload %s", key)
+			failed = append(failed, key)
+		}
+	}
+
+	if auditLog != "" {
+		key := path.Join(b.Prefix, tool, "audit.jsonl")
+
+		data, err := os.ReadFile(auditLog)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err == nil {
+			if err := b.putChanged(key, data); err != nil {
+				log.Error().Err(err).Msgf("unable to upload %s", key)
+				failed = append(failed, key)
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to upload %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// putChanged - Upload an object unless the same content was the last one uploaded to the key
+func (b *Bucket) putChanged(key string, data []byte) error {
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	b.mu.Lock()
+	same := b.uploaded[key] == hash
+	b.mu.Unlock()
+
+	if same {
+		return nil
+	}
+
+	if err := b.do(http.MethodPut, key, "", data); err != nil {
+		return err
+	}
+
+	if b.SigningKey != nil {
+		if err := b.do(http.MethodPut, key+".sig", "", ed25519.Sign(b.SigningKey, data)); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Msgf("uploaded %s to bucket %s", key, b.Name)
+
+	b.mu.Lock()
+	b.uploaded[key] = hash
+	b.mu.Unlock()
+
+	return nil
+}
+
+// do - Send a signed request for a key of the bucket, or the bucket itself when key is empty
+func (b *Bucket) do(method string, key string, query string, body []byte) error {
+
+	uri := "/" + escapePath(b.Name)
+	if key != "" {
+		uri += "/" + escapePath(key)
+	}
+
+	target := b.Endpoint + uri
+	if query != "" {
+		target += "?" + query
+	}
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	b.sign(req, uri, query, body, time.Now().UTC())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("object storage %s %s: %s: %s", method, uri, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// sign - Add the AWS Signature Version 4 headers to a request, signing the host, the content hash and the date
+func (b *Bucket) sign(req *http.Request, uri string, query string, body []byte, now time.Time) {
+
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	region := b.region()
+
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	req.Header.Set("x-amz-date", amzDate)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{req.Method, uri, query, canonicalHeaders, signedHeaders, payloadHash}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", b.AccessKey, scope, signedHeaders, signature))
+}
+
+// region - The cluster of the endpoint, the first label of its host such as us-east-1
+func (b *Bucket) region() string {
+
+	u, err := url.Parse(b.Endpoint)
+	if err != nil {
+		return ""
+	}
+
+	return strings.SplitN(u.Hostname(), ".", 2)[0]
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// escapePath - A key URI encoded as S3 expects it, every byte but the unreserved characters and the slashes
+func escapePath(key string) string {
+
+	var sb strings.Builder
+
+	for _, c := range []byte(key) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~', c == '/':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,125 @@
+// Package pause holds whether the running tool is paused for maintenance, so the changes to the nodes are
+// held back and manual edits of the outputs are not overwritten until it is resumed or the pause times out.
+package pause
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/metrics"
+)
+
+// Timeout is how long a pause lasts unless given its own, 0 until resumed, set from -pause-timeout
+var Timeout time.Duration
+
+// File pauses reconciling while it exists and is younger than Timeout, set from -pause-file.  Its content,
+// when any, is the reason.
+var File string
+
+var (
+	mu     sync.Mutex
+	paused bool
+	reason string
+	since  time.Time
+	until  time.Time
+)
+
+// Pause - Hold back the changes to the nodes until Resume, or for d, Timeout when 0
+func Pause(why string, d time.Duration) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if d == 0 {
+		d = Timeout
+	}
+
+	now := time.Now().UTC()
+
+	if !paused {
+		since = now
+	}
+	paused = true
+	reason = why
+
+	until = time.Time{}
+	if d > 0 {
+		until = now.Add(d)
+		log.Warn().Msgf("reconciling paused until %s: %s", until.Format(time.RFC3339), why)
+	} else {
+		log.Warn().Msgf("reconciling paused until resumed: %s", why)
+	}
+}
+
+// Resume - Apply the changes to the nodes again
+func Resume() {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if paused {
+		log.Warn().Msgf("reconciling resumed after %s", time.Since(since).Round(time.Second))
+	}
+
+	paused = false
+	reason = ""
+	since = time.Time{}
+	until = time.Time{}
+}
+
+// State - Whether reconciling is paused, why and since when.  A pause past its timeout is resumed.
+func State() (bool, string, time.Time) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if paused && !until.IsZero() && time.Now().After(until) {
+		log.Warn().Msgf("reconciling resumed, the pause timed out after %s: %s", time.Since(since).Round(time.Second), reason)
+		paused = false
+		reason = ""
+		since = time.Time{}
+		until = time.Time{}
+	}
+
+	isPaused, why, from := paused, reason, since
+	if !isPaused {
+		isPaused, why, from = fileState()
+	}
+
+	value := 0.0
+	if isPaused {
+		value = 1
+	}
+	metrics.SetGauge("linode_tools_paused", "Whether reconciling is paused for maintenance", nil, value)
+
+	return isPaused, why, from
+}
+
+// fileState - Whether the pause file exists and has not timed out
+func fileState() (bool, string, time.Time) {
+
+	if File == "" {
+		return false, "", time.Time{}
+	}
+
+	info, err := os.Stat(File)
+	if err != nil {
+		return false, "", time.Time{}
+	}
+
+	if Timeout > 0 && time.Since(info.ModTime()) > Timeout {
+		log.Warn().Msgf("ignoring the pause file %s, it is older than %s", File, Timeout)
+		return false, "", time.Time{}
+	}
+
+	why := "the pause file " + File + " exists"
+	if data, err := os.ReadFile(File); err == nil && strings.TrimSpace(string(data)) != "" {
+		why = strings.TrimSpace(string(data))
+	}
+
+	return true, why, info.ModTime().UTC()
+}
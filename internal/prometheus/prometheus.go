@@ -0,0 +1,292 @@
+// Package prometheus keeps a Prometheus file_sd target list in sync with the nodes, so a Prometheus outside the
+// cluster scrapes the node exporters of new nodes without a change to its config.
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// labelName is the form Prometheus accepts for a label name
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// targetGroup is an entry of a file_sd file
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// targetFile keeps a file_sd file with a target group per node as a backend.Backend
+type targetFile struct {
+	path       string
+	port       int
+	nameLabel  string
+	labels     map[string]string
+	nodeLabels map[string]string
+	backups    int
+	auditLog   *audit.Log
+
+	// The file and nodes read and rendered by the last Render, nil when the file did not exist
+	existing []string
+	ips      []net.IP
+
+	// The nodes of the last applied file, to record what changed
+	applied []net.IP
+}
+
+// parseLabels - The labels of a comma separated list of name=value
+func parseLabels(value string) (map[string]string, error) {
+
+	labels := make(map[string]string)
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || !labelName.MatchString(parts[0]) {
+			return nil, fmt.Errorf("label %s is not name=value with a valid label name", item)
+		}
+
+		labels[parts[0]] = parts[1]
+	}
+
+	return labels, nil
+}
+
+// parseNodeLabels - The node labels copied to the targets, a comma separated list of node-label=target-label
+func parseNodeLabels(value string) (map[string]string, error) {
+
+	labels := make(map[string]string)
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || !labelName.MatchString(parts[1]) {
+			return nil, fmt.Errorf("node label %s is not node-label=target-label with a valid label name", item)
+		}
+
+		labels[parts[0]] = parts[1]
+	}
+
+	return labels, nil
+}
+
+// Name - The path of the file
+func (f *targetFile) Name() string {
+	return f.path
+}
+
+// Render - A target group per node in the order of their address: the node address and the port, labelled with
+// the node name, the static labels and the node labels copied to the target
+func (f *targetFile) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	if _, err := os.Stat(f.path); err == nil {
+		existing, err := configfile.Read(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", f.path, err)
+		}
+		f.existing = existing
+	} else {
+		f.existing = nil
+	}
+
+	groups := []targetGroup{}
+
+	for _, n := range nodes {
+		labels := make(map[string]string)
+		for k, v := range f.labels {
+			labels[k] = v
+		}
+		for from, to := range f.nodeLabels {
+			if v, ok := n.Labels[from]; ok {
+				labels[to] = v
+			}
+		}
+		if f.nameLabel != "" {
+			labels[f.nameLabel] = n.Name
+		}
+
+		groups = append(groups, targetGroup{Targets: []string{net.JoinHostPort(n.IP.String(), strconv.Itoa(f.port))}, Labels: labels})
+	}
+
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	f.ips = discovery.IPs(nodes)
+
+	return strings.Split(string(data), "\n"), nil
+}
+
+// Validate - The file is rendered from the nodes alone, there is nothing to check
+func (f *targetFile) Validate(ctx context.Context, rendered []string) error {
+	return nil
+}
+
+// Apply - Write the file when it changed.  It is written next to the file and renamed over it, as Prometheus
+// asks, so Prometheus never reads a file half written.
+func (f *targetFile) Apply(ctx context.Context, rendered []string) error {
+
+	if f.existing != nil && configfile.Equal(f.existing, rendered) {
+		log.Info().Msgf("no changes to %s", f.path)
+		f.applied = f.ips
+		return nil
+	}
+
+	if f.backups > 0 && f.existing != nil {
+		if err := configfile.Backup(f.path, f.backups); err != nil {
+			return fmt.Errorf("unable to backup %s: %w", f.path, err)
+		}
+	}
+
+	if err := writeAtomic(ctx, f.path, rendered); err != nil {
+		return fmt.Errorf("unable to write %s: %w", f.path, err)
+	}
+
+	log.Info().Msgf("wrote %d targets to %s", len(f.ips), f.path)
+
+	added, removed := audit.Diff(f.applied, f.ips)
+	f.auditLog.Write(audit.Record{Target: f.path, Added: added, Removed: removed, Hash: audit.Hash(rendered)})
+
+	f.applied = f.ips
+
+	return nil
+}
+
+// writeAtomic - Write the lines to a file next to the path and rename it over the path
+func writeAtomic(ctx context.Context, path string, lines []string) error {
+
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+
+	if err := configfile.Write(ctx, tmp, lines); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// Rollback - Put the previous file back, removing the file when there was none
+func (f *targetFile) Rollback(ctx context.Context) error {
+
+	f.auditLog.Write(audit.Record{Target: f.path, Reload: "rolled back"})
+
+	if f.existing == nil {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return writeAtomic(ctx, f.path, f.existing)
+}
+
+// Run - Run the prometheus subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("prometheus", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var path string
+	fs.StringVar(&path, "file", "/etc/prometheus/file_sd/linode-nodes.json", "file_sd file to maintain, read by a file_sd_configs entry of the Prometheus config")
+
+	var port int
+	fs.IntVar(&port, "port", 9100, "port scraped on every node, 9100 for the node exporter")
+
+	var nameLabel string
+	fs.StringVar(&nameLabel, "name-label", "node", "label holding the node name, empty for none")
+
+	var labels string
+	fs.StringVar(&labels, "labels", "", "(optional) comma separated name=value labels of every target, for example env=prod,cluster=lke-east")
+
+	var nodeLabels string
+	fs.StringVar(&nodeLabels, "node-labels", "", "(optional) comma separated node labels copied to the targets as node-label=target-label, for example lke.linode.com/pool-id=pool")
+
+	var backups int
+	fs.IntVar(&backups, "backups", 5, "number of timestamped backups of the file to keep, 0 disables backups")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("-port %d is not a port", port)
+	}
+
+	if nameLabel != "" && !labelName.MatchString(nameLabel) {
+		return fmt.Errorf("-name-label %s is not a valid label name", nameLabel)
+	}
+
+	static, err := parseLabels(labels)
+	if err != nil {
+		return err
+	}
+
+	copied, err := parseNodeLabels(nodeLabels)
+	if err != nil {
+		return err
+	}
+
+	common.Start(fs.Name())
+
+	log.Info().Msgf("using file_sd file %s", path)
+
+	f := &targetFile{
+		path:       path,
+		port:       port,
+		nameLabel:  nameLabel,
+		labels:     static,
+		nodeLabels: copied,
+		backups:    backups,
+	}
+
+	if common.Output != "" {
+		return common.Print([]backend.Backend{f})
+	}
+
+	f.auditLog = audit.Open(common.AuditLog, fs.Name())
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+
+	backends := common.Track([]backend.Backend{f})
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
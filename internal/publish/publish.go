@@ -0,0 +1,91 @@
+// Package publish writes what a host last applied, the nodes and the hash of every rendered output, into a
+// ConfigMap of the cluster, so cluster operators can see what the hosts outside the cluster believe the state is.
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rsvancara/linode-tools/internal/status"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// ConfigMap is where the state of the host is published, under a key of its own so the hosts sharing the
+// ConfigMap do not overwrite each other
+type ConfigMap struct {
+	Kubeconfig string
+	Namespace  string
+	Name       string
+	Key        string
+}
+
+// Parse - The ConfigMap of a namespace/name reference, published under key
+func Parse(ref string, kubeconfig string, key string) (ConfigMap, error) {
+
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ConfigMap{}, fmt.Errorf("configmap %s is not namespace/name", ref)
+	}
+
+	return ConfigMap{Kubeconfig: kubeconfig, Namespace: parts[0], Name: parts[1], Key: key}, nil
+}
+
+// Publish - Write the current nodes and the last reconcile of every output, with the hash of the applied
+// output, as JSON under Key, creating the ConfigMap when it does not exist
+func (c ConfigMap) Publish(tool string) error {
+
+	config, err := discovery.RESTConfig(c.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	return c.PublishTo(clientset, tool)
+}
+
+// PublishTo - Like Publish, through a clientset that is already built
+func (c ConfigMap) PublishTo(clientset kubernetes.Interface, tool string) error {
+
+	state, err := json.Marshal(struct {
+		Tool string `json:"tool"`
+		status.Summary
+	}{tool, status.Current()})
+	if err != nil {
+		return err
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(c.Namespace)
+
+	patch, err := json.Marshal(map[string]interface{}{"data": map[string]string{c.Key: string(state)}})
+	if err != nil {
+		return err
+	}
+
+	_, err = configMaps.Patch(context.Background(), c.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(context.Background(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: c.Name, Namespace: c.Namespace},
+			Data:       map[string]string{c.Key: string(state)},
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("publishing to configmap %s/%s: %w", c.Namespace, c.Name, err)
+	}
+
+	log.Debug().Msgf("published the applied state to configmap %s/%s key %s", c.Namespace, c.Name, c.Key)
+
+	return nil
+}
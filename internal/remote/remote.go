@@ -0,0 +1,119 @@
+// Package remote applies a rendered config file on other hosts over ssh, for setups where the
+// config is generated on a management node but the service runs on separate Linodes.
+package remote
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+)
+
+// SSH is how the remote hosts are reached
+type SSH struct {
+	SSH  string
+	SCP  string
+	User string
+	Key  string
+}
+
+// Result is the outcome of applying the config on one host
+type Result struct {
+	Host   string
+	Output string
+	Err    error
+}
+
+// Apply - Copy the local file to path on every host and run the reload command there, all hosts
+// in parallel.  When the reload fails on a host its previous file is put back and reloaded again.  scp and ssh are
+// killed when ctx is done.
+func (s SSH) Apply(ctx context.Context, hosts []string, local string, path string, reload string) []Result {
+
+	results := make([]Result, len(hosts))
+
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			results[i] = s.apply(ctx, host, local, path, reload)
+		}(i, host)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (s SSH) apply(ctx context.Context, host string, local string, path string, reload string) Result {
+
+	log.Info().Msgf("copying %s to %s:%s", local, host, path)
+
+	tmp := path + ".tmp"
+	prev := path + ".prev"
+
+	// scp and ssh run in the namespaces of the tools, where the key is, not through nsenter
+	res := hostexec.Run(exec.CommandContext(ctx, s.SCP, append(s.options(), local, s.target(host)+":"+tmp)...))
+	if err := res.Err(); err != nil {
+		return Result{Host: host, Output: res.Output(), Err: fmt.Errorf("scp failed: %w", err)}
+	}
+
+	// Keep the previous file until the new one has been reloaded successfully
+	script := fmt.Sprintf("cp -p %[1]s %[2]s 2>/dev/null; mv %[3]s %[1]s && if ! %[4]s; then if [ -f %[2]s ]; then mv %[2]s %[1]s && %[4]s; fi; exit 1; fi",
+		quote(path), quote(prev), quote(tmp), reload)
+
+	res = hostexec.Run(exec.CommandContext(ctx, s.SSH, append(s.options(), s.target(host), script)...))
+	if err := res.Err(); err != nil {
+		return Result{Host: host, Output: res.Output(), Err: fmt.Errorf("reload failed: %w", err)}
+	}
+
+	log.Info().Msgf("applied %s on %s", path, host)
+
+	return Result{Host: host, Output: res.Output()}
+}
+
+func (s SSH) options() []string {
+
+	options := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10"}
+	if s.Key != "" {
+		options = append(options, "-i", s.Key)
+	}
+
+	return options
+}
+
+func (s SSH) target(host string) string {
+
+	if s.User != "" {
+		return s.User + "@" + host
+	}
+
+	return host
+}
+
+// quote - Quote a path for the remote shell
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Summary - A one line summary of the results, and whether every host succeeded
+func Summary(results []Result) (string, bool) {
+
+	var parts []string
+	ok := true
+
+	for _, r := range results {
+		if r.Err != nil {
+			ok = false
+			parts = append(parts, fmt.Sprintf("%s: failed: %v", r.Host, r.Err))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s: ok", r.Host))
+		}
+	}
+
+	return strings.Join(parts, ", "), ok
+}
@@ -0,0 +1,124 @@
+// Package signature signs the configuration distributed to other hosts with ed25519 and verifies it before
+// it is applied, so a compromised transport cannot inject nodes or firewall rules.
+//
+// The keys are PEM files as written by openssl genpkey -algorithm ed25519 and openssl pkey -pubout.
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The headers, or gRPC metadata, of a signed request: the base64 signature of the time and the body, and the
+// time it was signed at
+const (
+	Header     = "X-Signature"
+	TimeHeader = "X-Signature-Time"
+)
+
+// MaxSkew is how far the time of a signed request may be from now, so a captured request cannot be replayed later
+const MaxSkew = 5 * time.Minute
+
+// LoadPrivateKey - The ed25519 private key of a PKCS #8 PEM file
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", path)
+	}
+
+	return private, nil
+}
+
+// LoadPublicKey - The ed25519 public key of a PKIX PEM file
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", path)
+	}
+
+	return public, nil
+}
+
+// readPEM - The bytes of the first PEM block of the type in a file
+func readPEM(path string, blockType string) ([]byte, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("no %s found in %s", blockType, path)
+	}
+
+	return block.Bytes, nil
+}
+
+// message - What is signed for a request: the time it was signed at and the body
+func message(at string, body []byte) []byte {
+	return append([]byte(at+"\n"), body...)
+}
+
+// SignRequest - The values of the signature headers of a request body signed now
+func SignRequest(key ed25519.PrivateKey, body []byte) (string, string) {
+
+	at := time.Now().UTC().Format(time.RFC3339)
+
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, message(at, body))), at
+}
+
+// VerifyRequest - Check the signature headers of a request body, and that it was signed within MaxSkew of now
+func VerifyRequest(key ed25519.PublicKey, body []byte, sig string, at string) error {
+
+	if sig == "" || at == "" {
+		return fmt.Errorf("the request is not signed")
+	}
+
+	signedAt, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", TimeHeader, err)
+	}
+
+	if skew := time.Since(signedAt); skew > MaxSkew || skew < -MaxSkew {
+		return fmt.Errorf("the request was signed at %s, too far from now", at)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", Header, err)
+	}
+
+	if !ed25519.Verify(key, message(at, body), raw) {
+		return fmt.Errorf("the signature of the request does not match")
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signAt - The signature headers of a body signed at a given time
+func signAt(key ed25519.PrivateKey, body []byte, at time.Time) (string, string) {
+
+	signedAt := at.UTC().Format(time.RFC3339)
+
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, message(signedAt, body))), signedAt
+}
+
+// TestVerifyRequest checks a request is only accepted with its own signature, signed with the key, within MaxSkew
+func TestVerifyRequest(t *testing.T) {
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"nodes": []}`)
+	sig, at := SignRequest(private, body)
+	oldSig, oldAt := signAt(private, body, time.Now().Add(-2*MaxSkew))
+	futureSig, futureAt := signAt(private, body, time.Now().Add(2*MaxSkew))
+	otherSig, otherAt := SignRequest(other, body)
+
+	tests := []struct {
+		name string
+		body []byte
+		sig  string
+		at   string
+		err  bool
+	}{
+		{name: "signed", body: body, sig: sig, at: at},
+		{name: "unsigned", body: body, err: true},
+		{name: "tampered body", body: []byte(`{"nodes": [{"ip": "203.0.113.1"}]}`), sig: sig, at: at, err: true},
+		{name: "replayed after the skew", body: body, sig: oldSig, at: oldAt, err: true},
+		{name: "replayed with a new time", body: body, sig: oldSig, at: at, err: true},
+		{name: "signed in the future", body: body, sig: futureSig, at: futureAt, err: true},
+		{name: "other key", body: body, sig: otherSig, at: otherAt, err: true},
+		{name: "invalid time", body: body, sig: sig, at: "yesterday", err: true},
+		{name: "invalid signature", body: body, sig: "not base64!", at: at, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyRequest(public, tt.body, tt.sig, tt.at); (err != nil) != tt.err {
+				t.Errorf("VerifyRequest returned %v", err)
+			}
+		})
+	}
+}
+
+// TestLoadKeys checks the PEM files of openssl are loaded and a key of the other kind is refused
+func TestLoadKeys(t *testing.T) {
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	write := func(name string, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	privatePath := write("signing.pem", "PRIVATE KEY", privateDER)
+	publicPath := write("signing.pub", "PUBLIC KEY", publicDER)
+
+	loadedPrivate, err := LoadPrivateKey(privatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedPublic, err := LoadPublicKey(publicPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, at := SignRequest(loadedPrivate, []byte("body"))
+	if err := VerifyRequest(loadedPublic, []byte("body"), sig, at); err != nil {
+		t.Errorf("a request signed with the loaded key was refused: %v", err)
+	}
+
+	if _, err := LoadPrivateKey(publicPath); err == nil {
+		t.Error("a public key was loaded as the private key")
+	}
+	if _, err := LoadPublicKey(privatePath); err == nil {
+		t.Error("a private key was loaded as the public key")
+	}
+}
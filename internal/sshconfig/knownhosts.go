@@ -0,0 +1,147 @@
+package sshconfig
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// knownHosts renders the managed block of a known_hosts file with the host keys of the nodes, named after their
+// alias and address.  A node is scanned once when it appears, its keys are kept for as long as it stays, so a
+// changed host key is noticed by ssh rather than trusted again.
+type knownHosts struct {
+	path    string
+	keyscan string
+	timeout int
+	options hostOptions
+	markers configfile.Markers
+
+	// The keys of the nodes by address, as type and key
+	keys map[string][]string
+}
+
+// load - The keys of the managed block of the file
+func (k *knownHosts) load() error {
+
+	k.keys = make(map[string][]string)
+
+	lines, err := configfile.Read(k.path)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", k.path, err)
+	}
+
+	block, err := k.markers.Block(lines)
+	if err != nil {
+		return fmt.Errorf("%s: %w", k.path, err)
+	}
+
+	for _, line := range block {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+
+		// The hosts are the alias and the address, or [address]:port
+		hosts := strings.Split(fields[0], ",")
+		ip := strings.TrimPrefix(hosts[len(hosts)-1], "[")
+		if i := strings.Index(ip, "]"); i >= 0 {
+			ip = ip[:i]
+		}
+
+		k.keys[ip] = append(k.keys[ip], fields[1]+" "+fields[2])
+	}
+
+	log.Info().Msgf("loaded the host keys of %d nodes from %s", len(k.keys), k.path)
+
+	return nil
+}
+
+// host - The host of an address in known_hosts, with the port when it is not 22
+func (k *knownHosts) host(ip string) string {
+
+	if k.options.port != 0 && k.options.port != 22 {
+		return fmt.Sprintf("[%s]:%d", ip, k.options.port)
+	}
+
+	return ip
+}
+
+// block - The keys of every node, scanning the nodes that appeared.  A node that cannot be scanned is left out
+// and scanned again on the next change of the nodes.
+func (k *knownHosts) block(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	if k.keys == nil {
+		k.keys = make(map[string][]string)
+	}
+
+	current := make(map[string]bool)
+	var lines []string
+
+	for _, n := range nodes {
+		ip := n.IP.String()
+		current[ip] = true
+
+		keys, ok := k.keys[ip]
+		if !ok {
+			scanned, err := k.scan(ctx, ip)
+			if err != nil {
+				log.Warn().Err(err).Msgf("unable to scan the host keys of %s, leaving it out of %s", n.Name, k.path)
+				continue
+			}
+			keys = scanned
+			k.keys[ip] = keys
+		}
+
+		for _, key := range keys {
+			lines = append(lines, fmt.Sprintf("%s,%s %s", k.options.alias(n), k.host(ip), key))
+		}
+	}
+
+	// The keys of the nodes that are gone are forgotten, a new node reusing the address is scanned
+	for ip := range k.keys {
+		if !current[ip] {
+			delete(k.keys, ip)
+		}
+	}
+
+	return lines, nil
+}
+
+// scan - The host keys of an address with ssh-keyscan, as type and key
+func (k *knownHosts) scan(ctx context.Context, ip string) ([]string, error) {
+
+	log.Info().Msgf("scanning the host keys of %s", ip)
+
+	args := []string{"-T", strconv.Itoa(k.timeout)}
+	if k.options.port != 0 && k.options.port != 22 {
+		args = append(args, "-p", strconv.Itoa(k.options.port))
+	}
+	args = append(args, ip)
+
+	result := hostexec.Run(hostexec.Command(ctx, k.keyscan, args...))
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		keys = append(keys, fields[1]+" "+fields[2])
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s returned no keys", k.keyscan)
+	}
+
+	return keys, nil
+}
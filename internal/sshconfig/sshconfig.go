@@ -0,0 +1,268 @@
+// Package sshconfig keeps a managed block of an ssh client config with a Host alias for every node, and
+// optionally of a known_hosts file with their host keys, for operators reaching the nodes from a bastion.
+package sshconfig
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/util/homedir"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+const (
+	beginMarker = "### BEGIN kube-ssh ###"
+	endMarker   = "### END kube-ssh ###"
+)
+
+// hostOptions are the options written under every Host alias
+type hostOptions struct {
+	prefix       string
+	user         string
+	port         int
+	identityFile string
+	proxyJump    string
+
+	// hostKeyAlias names the host keys of the nodes after their alias, as the known_hosts block does
+	hostKeyAlias bool
+}
+
+// alias - The Host alias of a node
+func (o hostOptions) alias(n discovery.Node) string {
+	return o.prefix + n.Name
+}
+
+// managedFile keeps the managed block of a file as a backend.Backend, the block rendered by a function of the nodes
+type managedFile struct {
+	path     string
+	backups  int
+	markers  configfile.Markers
+	auditLog *audit.Log
+	block    func(ctx context.Context, nodes []discovery.Node) ([]string, error)
+
+	// The file, block and nodes read and rendered by the last Render
+	existing []string
+	entries  []string
+	ips      []net.IP
+
+	// The nodes of the last applied file, to record what changed
+	applied []net.IP
+}
+
+// Name - The path of the file
+func (m *managedFile) Name() string {
+	return m.path
+}
+
+// Render - The file with the managed block holding the entries of the nodes
+func (m *managedFile) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	existing, err := configfile.Read(m.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", m.path, err)
+	}
+
+	entries, err := m.block(ctx, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	m.existing = existing
+	m.entries = entries
+	m.ips = discovery.IPs(nodes)
+
+	merged, err := m.markers.Merge(existing, entries)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.path, err)
+	}
+
+	return merged, nil
+}
+
+// Validate - The managed block is rendered from the nodes alone, there is nothing to check
+func (m *managedFile) Validate(ctx context.Context, rendered []string) error {
+	return nil
+}
+
+// Apply - Write the file when it changed, readable by its owner alone as ssh wants
+func (m *managedFile) Apply(ctx context.Context, rendered []string) error {
+
+	if configfile.Equal(m.existing, rendered) {
+		log.Info().Msgf("no changes to %s", m.path)
+		m.applied = m.ips
+		return nil
+	}
+
+	if m.backups > 0 {
+		if err := configfile.Backup(m.path, m.backups); err != nil {
+			return fmt.Errorf("unable to backup %s: %w", m.path, err)
+		}
+	}
+
+	if err := configfile.WriteWith(ctx, m.path, rendered, configfile.Permissions{Mode: "0600"}.Or(configfile.Default)); err != nil {
+		return fmt.Errorf("unable to write %s: %w", m.path, err)
+	}
+
+	added, removed := audit.Diff(m.applied, m.ips)
+	m.auditLog.Write(audit.Record{Target: m.path, Added: added, Removed: removed, Hash: audit.Hash(m.entries)})
+
+	m.applied = m.ips
+
+	return nil
+}
+
+// Rollback - Put the previous file back
+func (m *managedFile) Rollback(ctx context.Context) error {
+
+	if err := configfile.WriteWith(ctx, m.path, m.existing, configfile.Permissions{Mode: "0600"}.Or(configfile.Default)); err != nil {
+		return fmt.Errorf("unable to restore %s: %w", m.path, err)
+	}
+
+	m.auditLog.Write(audit.Record{Target: m.path, Reload: "rolled back"})
+
+	return nil
+}
+
+// hostBlock - A Host alias for every node, pointing at its address
+func hostBlock(options hostOptions) func(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	return func(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+		var lines []string
+
+		for _, n := range nodes {
+			lines = append(lines, "Host "+options.alias(n))
+			lines = append(lines, "    HostName "+n.IP.String())
+			if options.user != "" {
+				lines = append(lines, "    User "+options.user)
+			}
+			if options.port != 0 && options.port != 22 {
+				lines = append(lines, "    Port "+strconv.Itoa(options.port))
+			}
+			if options.identityFile != "" {
+				lines = append(lines, "    IdentityFile "+options.identityFile)
+			}
+			if options.proxyJump != "" {
+				lines = append(lines, "    ProxyJump "+options.proxyJump)
+			}
+			if options.hostKeyAlias {
+				lines = append(lines, "    HostKeyAlias "+options.alias(n))
+			}
+		}
+
+		return lines, nil
+	}
+}
+
+// Run - Run the ssh subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("ssh", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	config := ""
+	if home := homedir.HomeDir(); home != "" {
+		config = filepath.Join(home, ".ssh", "config")
+	}
+
+	var sshConfig string
+	fs.StringVar(&sshConfig, "ssh-config", config, "ssh client config whose managed block holds a Host alias for every node, empty to leave it alone")
+
+	var options hostOptions
+	fs.StringVar(&options.prefix, "host-prefix", "", "(optional) prefix of the Host aliases, which are the node names, for example lke-")
+	fs.StringVar(&options.user, "user", "", "(optional) User of the Host aliases")
+	fs.IntVar(&options.port, "port", 22, "Port of the Host aliases")
+	fs.StringVar(&options.identityFile, "identity-file", "", "(optional) IdentityFile of the Host aliases")
+	fs.StringVar(&options.proxyJump, "proxy-jump", "", "(optional) ProxyJump of the Host aliases, for example a bastion")
+
+	var keys knownHosts
+	fs.StringVar(&keys.path, "known-hosts", "", "(optional) known_hosts file whose managed block holds the host keys of the nodes, scanned with ssh-keyscan when a node appears")
+	fs.StringVar(&keys.keyscan, "keyscan", "ssh-keyscan", "ssh-keyscan executable command")
+	fs.IntVar(&keys.timeout, "keyscan-timeout", 5, "seconds ssh-keyscan waits for a node")
+
+	var backups int
+	fs.IntVar(&backups, "backups", 5, "number of timestamped backups of the files to keep, 0 disables backups")
+
+	markers := configfile.Markers{Begin: beginMarker, End: endMarker}
+	fs.StringVar(&markers.Begin, "begin-marker", beginMarker, "line starting the managed block of the files")
+	fs.StringVar(&markers.End, "end-marker", endMarker, "line ending the managed block of the files")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := markers.Validate(); err != nil {
+		return err
+	}
+
+	if sshConfig == "" && keys.path == "" {
+		return fmt.Errorf("nothing to maintain, give -ssh-config or -known-hosts")
+	}
+
+	common.Start(fs.Name())
+
+	options.hostKeyAlias = keys.path != ""
+	keys.options = options
+	keys.markers = markers
+
+	var files []*managedFile
+
+	if sshConfig != "" {
+		log.Info().Msgf("using ssh config %s", sshConfig)
+		files = append(files, &managedFile{path: sshConfig, backups: backups, markers: markers, block: hostBlock(options)})
+	}
+
+	if keys.path != "" {
+		log.Info().Msgf("using known hosts file %s", keys.path)
+		files = append(files, &managedFile{path: keys.path, backups: backups, markers: markers, block: keys.block})
+	}
+
+	var backends []backend.Backend
+	for _, f := range files {
+		backends = append(backends, f)
+	}
+
+	if common.Output != "" {
+		return common.Print(backends)
+	}
+
+	auditLog := audit.Open(common.AuditLog, fs.Name())
+	for _, f := range files {
+		f.auditLog = auditLog
+	}
+
+	// The keys of the nodes already in the file are kept rather than scanned again
+	if keys.path != "" {
+		if err := keys.load(); err != nil {
+			return err
+		}
+	}
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+
+	backends = common.Track(backends)
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
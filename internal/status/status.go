@@ -0,0 +1,239 @@
+// Package status remembers the recent reconciles of the running tool, the current nodes and the
+// applied configuration, and serves them read-only on the admin server.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// HistorySize is the number of reconciles kept for /history
+const HistorySize = 50
+
+// Reconcile is the outcome of rendering and applying one backend
+type Reconcile struct {
+	Time    time.Time `json:"time"`
+	Backend string    `json:"backend"`
+	Nodes   int       `json:"nodes"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+// Node is a node of the current node set
+type Node struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+var (
+	mu      sync.Mutex
+	history []Reconcile
+	nodes   []Node
+	updated time.Time
+	current = make(map[string][]string)
+)
+
+// record - Add a reconcile to the history, dropping the oldest beyond HistorySize
+func record(r Reconcile) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	r.Time = time.Now().UTC()
+
+	history = append(history, r)
+	if len(history) > HistorySize {
+		history = history[len(history)-HistorySize:]
+	}
+}
+
+// Track - Wrap the backends so their reconciles are recorded
+func Track(backends []backend.Backend) []backend.Backend {
+
+	var tracked []backend.Backend
+	for _, b := range backends {
+		tracked = append(tracked, &tracker{Backend: b})
+	}
+
+	return tracked
+}
+
+// tracker records the outcome of every step of a backend
+type tracker struct {
+	backend.Backend
+
+	nodes int
+}
+
+// Render - Remember the nodes the backend is rendered for
+func (t *tracker) Render(ctx context.Context, found []discovery.Node) ([]string, error) {
+
+	t.nodes = len(found)
+
+	mu.Lock()
+	nodes = nil
+	for _, n := range found {
+		nodes = append(nodes, Node{Name: n.Name, IP: n.IP.String()})
+	}
+	updated = time.Now().UTC()
+	mu.Unlock()
+
+	rendered, err := t.Backend.Render(ctx, found)
+	if err != nil {
+		record(Reconcile{Backend: t.Name(), Nodes: t.nodes, Result: "render failed", Error: err.Error()})
+	}
+
+	return rendered, err
+}
+
+// Validate - Record a rendered output that is refused
+func (t *tracker) Validate(ctx context.Context, rendered []string) error {
+
+	err := t.Backend.Validate(ctx, rendered)
+	if err != nil {
+		record(Reconcile{Backend: t.Name(), Nodes: t.nodes, Result: "invalid", Error: err.Error(), Hash: audit.Hash(rendered)})
+	}
+
+	return err
+}
+
+// Apply - Record the outcome and keep the output that is live
+func (t *tracker) Apply(ctx context.Context, rendered []string) error {
+
+	err := t.Backend.Apply(ctx, rendered)
+	if err != nil {
+		record(Reconcile{Backend: t.Name(), Nodes: t.nodes, Result: "failed", Error: err.Error(), Hash: audit.Hash(rendered)})
+		return err
+	}
+
+	mu.Lock()
+	current[t.Name()] = rendered
+	mu.Unlock()
+
+	record(Reconcile{Backend: t.Name(), Nodes: t.nodes, Result: "applied", Hash: audit.Hash(rendered)})
+
+	return nil
+}
+
+// Rollback - Record the rollback after a failed apply
+func (t *tracker) Rollback(ctx context.Context) error {
+
+	err := t.Backend.Rollback(ctx)
+	if err != nil {
+		record(Reconcile{Backend: t.Name(), Nodes: t.nodes, Result: "rollback failed", Error: err.Error()})
+	} else {
+		record(Reconcile{Backend: t.Name(), Nodes: t.nodes, Result: "rolled back"})
+	}
+
+	return err
+}
+
+// Register - Add the read-only endpoints to a mux: /status, /history, /current-config and /commands
+func Register(mux *http.ServeMux) {
+
+	mux.HandleFunc("/status", serveStatus)
+	mux.HandleFunc("/history", serveHistory)
+	mux.HandleFunc("/current-config", serveConfig)
+	mux.HandleFunc("/commands", serveCommands)
+}
+
+// serveStatus - The current nodes and the last reconcile of every backend
+func serveStatus(w http.ResponseWriter, req *http.Request) {
+
+	writeJSON(w, Current())
+}
+
+// Summary is the current nodes and the last reconcile of every backend
+type Summary struct {
+	Updated  time.Time            `json:"updated"`
+	Nodes    []Node               `json:"nodes"`
+	Backends map[string]Reconcile `json:"backends"`
+}
+
+// Current - The current nodes and the last reconcile of every backend
+func Current() Summary {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	last := make(map[string]Reconcile)
+	for _, r := range history {
+		last[r.Backend] = r
+	}
+
+	return Summary{Updated: updated, Nodes: append([]Node(nil), nodes...), Backends: last}
+}
+
+// Configs - The applied output of every backend
+func Configs() map[string][]string {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	configs := make(map[string][]string, len(current))
+	for name, rendered := range current {
+		configs[name] = rendered
+	}
+
+	return configs
+}
+
+// serveHistory - The last reconciles, newest first
+func serveHistory(w http.ResponseWriter, req *http.Request) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	recent := make([]Reconcile, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		recent = append(recent, history[i])
+	}
+
+	writeJSON(w, recent)
+}
+
+// serveConfig - The applied output of the backend named by ?backend=, or of every backend as JSON
+func serveConfig(w http.ResponseWriter, req *http.Request) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if name := req.URL.Query().Get("backend"); name != "" {
+		rendered, ok := current[name]
+		if !ok {
+			http.Error(w, "no applied config for "+name, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(strings.Join(rendered, "\n") + "\n"))
+		return
+	}
+
+	writeJSON(w, current)
+}
+
+// serveCommands - The results of the last commands run on the host, such as the reloads, newest first
+func serveCommands(w http.ResponseWriter, req *http.Request) {
+
+	writeJSON(w, hostexec.Recent())
+}
+
+// writeJSON - Write v as indented JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
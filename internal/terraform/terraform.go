@@ -0,0 +1,169 @@
+// Package terraform speaks the protocol of the Terraform external data source, so Linode firewalls and
+// NodeBalancers managed by Terraform can be driven by the live nodes of the cluster.
+package terraform
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// Run - Run the terraform subcommand.  The query Terraform writes on stdin is a JSON object of strings,
+// each key is taken as the flag of the same name with underscores for dashes, for example
+// {"linode_tag": "k8s-worker"}.  The result is written on stdout as a JSON object of strings, as the
+// protocol requires.
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("terraform", flag.ContinueOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	var nodePorts bool
+	fs.BoolVar(&nodePorts, "node-ports", false, "also return the node ports of the services in -namespaces")
+
+	var namespaces string
+	fs.StringVar(&namespaces, "namespaces", "", "(optional) comma separated namespaces the node ports are read from, every namespace when empty")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query, err := readQuery(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range query {
+		if err := fs.Set(strings.ReplaceAll(k, "_", "-"), v); err != nil {
+			return fmt.Errorf("query key %s: %w", k, err)
+		}
+	}
+
+	// Safety checks protect a running daemon, here the result is planned by terraform first
+	common.MinNodes = 0
+	common.MaxRemovePercent = 0
+
+	nodes, err := common.Source().Nodes(context.Background())
+	if err != nil {
+		return err
+	}
+
+	result := nodeResult(nodes)
+
+	if nodePorts {
+		var selected []string
+		for _, ns := range strings.Split(namespaces, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				selected = append(selected, ns)
+			}
+		}
+
+		services, err := discovery.Services(context.Background(), common.Kubeconfig, selected)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range nodePortResult(services) {
+			result[k] = v
+		}
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
+
+// readQuery - The query object, an empty stdin is an empty query
+func readQuery(r io.Reader) (map[string]string, error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	query := make(map[string]string)
+	if strings.TrimSpace(string(data)) == "" {
+		return query, nil
+	}
+
+	if err := json.Unmarshal(data, &query); err != nil {
+		return nil, fmt.Errorf("the query must be a JSON object of strings: %w", err)
+	}
+
+	return query, nil
+}
+
+// nodeResult - The addresses of the nodes as comma separated lists, and the address of each node by name
+func nodeResult(nodes []discovery.Node) map[string]string {
+
+	var ips, cidrs, names []string
+	byName := make(map[string]string)
+
+	for _, n := range nodes {
+		ips = append(ips, n.IP.String())
+		names = append(names, n.Name)
+		byName[n.Name] = n.IP.String()
+
+		if n.IP.To4() != nil {
+			cidrs = append(cidrs, n.IP.String()+"/32")
+		} else {
+			cidrs = append(cidrs, n.IP.String()+"/128")
+		}
+	}
+
+	data, _ := json.Marshal(byName)
+
+	return map[string]string{
+		"count": strconv.Itoa(len(nodes)),
+		"ips":   strings.Join(ips, ","),
+		"cidrs": strings.Join(cidrs, ","),
+		"names": strings.Join(names, ","),
+		"nodes": string(data),
+	}
+}
+
+// nodePortResult - The node ports as a comma separated list, and the node port of every service port
+// keyed namespace/service/port
+func nodePortResult(services []discovery.Service) map[string]string {
+
+	seen := make(map[int]bool)
+	var ports []int
+	byService := make(map[string]int)
+
+	for _, s := range services {
+		for _, p := range s.NodePorts {
+			key := s.Namespace + "/" + s.Name + "/" + strconv.Itoa(p.Port)
+			if p.Name != "" {
+				key = s.Namespace + "/" + s.Name + "/" + p.Name
+			}
+			byService[key] = p.NodePort
+
+			if !seen[p.NodePort] {
+				seen[p.NodePort] = true
+				ports = append(ports, p.NodePort)
+			}
+		}
+	}
+
+	sort.Ints(ports)
+
+	var list []string
+	for _, p := range ports {
+		list = append(list, strconv.Itoa(p))
+	}
+
+	data, _ := json.Marshal(byService)
+
+	return map[string]string{
+		"node_ports":         strings.Join(list, ","),
+		"service_node_ports": string(data),
+	}
+}
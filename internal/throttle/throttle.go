@@ -0,0 +1,134 @@
+// Package throttle limits how often a tool reloads and stops reloading after repeated failures.
+package throttle
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Throttle allows at most Budget reloads per Window, and opens a circuit breaker pausing every reload
+// after Failures reload failures in a row, until Cooldown has passed or Reset is called.  A zero Budget
+// or Failures disables that limit, a zero Cooldown keeps the breaker open until Reset.
+type Throttle struct {
+	Budget   int
+	Window   time.Duration
+	Failures int
+	Cooldown time.Duration
+
+	// OnReset is called after Reset, for example to run the reloads deferred while the breaker was open
+	OnReset func()
+
+	mu       sync.Mutex
+	reloads  []time.Time
+	failed   int
+	open     bool
+	openedAt time.Time
+}
+
+// Allow - Whether a reload may run now.  When it may not, the reason and the time it may run again,
+// zero when that waits for a Reset.
+func (t *Throttle) Allow() (bool, time.Time, string) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	if t.open {
+		if t.Cooldown == 0 {
+			return false, time.Time{}, fmt.Sprintf("circuit breaker open after %d failed reloads, waiting for a reset", t.failed)
+		}
+
+		retry := t.openedAt.Add(t.Cooldown)
+		if now.Before(retry) {
+			return false, retry, fmt.Sprintf("circuit breaker open after %d failed reloads until %s", t.failed, retry.Format(time.RFC3339))
+		}
+
+		// Half open, the next reload decides whether the breaker closes
+		log.Info().Msg("circuit breaker cooldown passed, trying a reload")
+		t.open = false
+		t.failed = t.Failures - 1
+	}
+
+	if t.Budget > 0 {
+		var recent []time.Time
+		for _, r := range t.reloads {
+			if now.Sub(r) < t.Window {
+				recent = append(recent, r)
+			}
+		}
+		t.reloads = recent
+
+		if len(recent) >= t.Budget {
+			retry := recent[0].Add(t.Window)
+			return false, retry, fmt.Sprintf("reload budget of %d per %s used up until %s", t.Budget, t.Window, retry.Format(time.RFC3339))
+		}
+	}
+
+	return true, time.Time{}, ""
+}
+
+// Done - Record the result of a reload allowed by Allow
+func (t *Throttle) Done(err error) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.reloads = append(t.reloads, time.Now())
+
+	if err == nil {
+		t.failed = 0
+		return
+	}
+
+	t.failed++
+
+	if t.Failures > 0 && t.failed >= t.Failures && !t.open {
+		log.Error().Msgf("opening the circuit breaker after %d failed reloads", t.failed)
+		t.open = true
+		t.openedAt = time.Now()
+	}
+}
+
+// Open - Whether the circuit breaker is open
+func (t *Throttle) Open() bool {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.open
+}
+
+// Reset - Close the circuit breaker and forget the failures, then call OnReset
+func (t *Throttle) Reset() {
+
+	t.mu.Lock()
+
+	log.Info().Msg("circuit breaker reset")
+
+	t.open = false
+	t.failed = 0
+
+	t.mu.Unlock()
+
+	if t.OnReset != nil {
+		t.OnReset()
+	}
+}
+
+// ServeHTTP - Reset the circuit breaker on a POST
+func (t *Throttle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t.Reset()
+
+	fmt.Fprintln(w, "circuit breaker reset")
+}
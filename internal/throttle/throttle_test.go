@@ -0,0 +1,162 @@
+package throttle
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var errReload = errors.New("reload failed")
+
+func TestAllow(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		throttle Throttle
+
+		// results are the results of the reloads run before the last Allow, and age how long ago they ran
+		results []error
+		age     time.Duration
+
+		allowed bool
+		retry   bool
+		open    bool
+	}{
+		{
+			name:     "no limits",
+			throttle: Throttle{},
+			results:  []error{nil, nil, errReload, errReload},
+			allowed:  true,
+		},
+		{
+			name:     "budget used up",
+			throttle: Throttle{Budget: 2, Window: time.Minute},
+			results:  []error{nil, nil},
+			retry:    true,
+		},
+		{
+			name:     "budget expired",
+			throttle: Throttle{Budget: 2, Window: time.Minute},
+			results:  []error{nil, nil},
+			age:      2 * time.Minute,
+			allowed:  true,
+		},
+		{
+			name:     "fewer failures than the breaker",
+			throttle: Throttle{Failures: 3, Cooldown: time.Minute},
+			results:  []error{errReload, errReload},
+			allowed:  true,
+		},
+		{
+			name:     "a success in between",
+			throttle: Throttle{Failures: 2, Cooldown: time.Minute},
+			results:  []error{errReload, nil, errReload},
+			allowed:  true,
+		},
+		{
+			name:     "breaker open",
+			throttle: Throttle{Failures: 2, Cooldown: time.Minute},
+			results:  []error{errReload, errReload},
+			retry:    true,
+			open:     true,
+		},
+		{
+			name:     "breaker open until reset",
+			throttle: Throttle{Failures: 2},
+			results:  []error{errReload, errReload},
+			age:      time.Hour,
+			open:     true,
+		},
+		{
+			name:     "half open after the cooldown",
+			throttle: Throttle{Failures: 2, Cooldown: time.Minute},
+			results:  []error{errReload, errReload},
+			age:      2 * time.Minute,
+			allowed:  true,
+		},
+	}
+
+	for i := range tests {
+		tt := &tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+
+			th := &tt.throttle
+
+			for _, err := range tt.results {
+				if ok, _, reason := th.Allow(); !ok {
+					t.Fatalf("a reload before the last was refused: %s", reason)
+				}
+				th.Done(err)
+			}
+
+			// Age the reloads and the breaker
+			for i := range th.reloads {
+				th.reloads[i] = th.reloads[i].Add(-tt.age)
+			}
+			th.openedAt = th.openedAt.Add(-tt.age)
+
+			ok, retry, reason := th.Allow()
+			if ok != tt.allowed {
+				t.Fatalf("allowed %t (%s), expected %t", ok, reason, tt.allowed)
+			}
+			if !retry.IsZero() != tt.retry {
+				t.Errorf("retry at %s, expected a retry time %t", retry, tt.retry)
+			}
+			if th.Open() != tt.open {
+				t.Errorf("breaker open %t, expected %t", th.Open(), tt.open)
+			}
+		})
+	}
+}
+
+// TestHalfOpen checks a failed reload after the cooldown opens the breaker again right away
+func TestHalfOpen(t *testing.T) {
+
+	th := &Throttle{Failures: 3, Cooldown: time.Minute}
+	for i := 0; i < 3; i++ {
+		th.Allow()
+		th.Done(errReload)
+	}
+
+	th.openedAt = th.openedAt.Add(-2 * time.Minute)
+
+	if ok, _, reason := th.Allow(); !ok {
+		t.Fatalf("refused after the cooldown: %s", reason)
+	}
+	th.Done(errReload)
+
+	if !th.Open() {
+		t.Error("the breaker did not open again after the reload of the half open breaker failed")
+	}
+}
+
+// TestReset checks a POST closes the breaker and calls OnReset, so the deferred reloads run
+func TestReset(t *testing.T) {
+
+	resets := 0
+	th := &Throttle{Failures: 1, OnReset: func() { resets++ }}
+
+	th.Allow()
+	th.Done(errReload)
+	if ok, _, _ := th.Allow(); ok {
+		t.Fatal("allowed with the breaker open")
+	}
+
+	w := httptest.NewRecorder()
+	th.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reload/reset", nil))
+	if w.Code != http.StatusMethodNotAllowed || resets != 0 {
+		t.Errorf("a GET answered %d with %d resets, expected 405 without a reset", w.Code, resets)
+	}
+
+	w = httptest.NewRecorder()
+	th.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reload/reset", nil))
+	if w.Code != http.StatusOK || resets != 1 {
+		t.Errorf("a POST answered %d with %d resets, expected 200 and a reset", w.Code, resets)
+	}
+
+	if ok, _, reason := th.Allow(); !ok || th.Open() {
+		t.Errorf("refused after the reset: %s", reason)
+	}
+}
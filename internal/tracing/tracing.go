@@ -0,0 +1,282 @@
+// Package tracing exports a trace of every reconcile to an OpenTelemetry collector, such as Tempo or
+// Jaeger, over OTLP/HTTP with the JSON encoding.  A reconcile is the root span, with a child span for
+// listing the nodes and for rendering, validating and applying each output, down to file writes and reloads.
+//
+// The reconcile loop of a command runs in a single goroutine, so the reconcile in progress is kept here
+// instead of being passed through every Backend call.  Without an endpoint every call is a no-op.
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Span is a timed operation of a reconcile
+type Span struct {
+	name     string
+	traceID  string
+	spanID   string
+	parentID string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      error
+
+	// root is the reconcile the span belongs to, the spans of a trace are exported together, and open
+	// the spans started and not ended yet, the innermost last, which the next span is a child of
+	root  *Span
+	spans []*Span
+	open  []*Span
+}
+
+var (
+	mu       sync.Mutex
+	endpoint string
+	service  string
+	client   = &http.Client{Timeout: 10 * time.Second}
+
+	// current is the reconcile in progress
+	current *Span
+)
+
+// Init - Export the traces of the service to the OTLP/HTTP endpoint of a collector, for example
+// http://tempo:4318, traces are posted to its /v1/traces
+func Init(url string, name string) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	endpoint = strings.TrimSuffix(url, "/")
+	service = name
+}
+
+// Begin - Start a reconcile at the given time, the spans started until it ends are its children.
+// Returns nil when tracing is off.
+func Begin(name string, start time.Time) *Span {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if endpoint == "" {
+		return nil
+	}
+
+	root := &Span{name: name, traceID: newID(16), spanID: newID(8), start: start, attrs: make(map[string]string)}
+	root.root = root
+	current = root
+
+	return root
+}
+
+// Start - Start a span of the reconcile in progress, nil when there is none
+func Start(name string) *Span {
+	return Record(name, time.Now(), time.Time{}, nil)
+}
+
+// Record - Add a span of the reconcile in progress, ended already when end is set.  Returns nil when
+// there is no reconcile in progress.
+func Record(name string, start time.Time, end time.Time, err error) *Span {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current == nil {
+		return nil
+	}
+
+	parent := current
+	if len(current.open) > 0 {
+		parent = current.open[len(current.open)-1]
+	}
+
+	s := &Span{name: name, traceID: current.traceID, spanID: newID(8), parentID: parent.spanID, start: start, end: end, err: err, attrs: make(map[string]string), root: current}
+	current.spans = append(current.spans, s)
+
+	if end.IsZero() {
+		current.open = append(current.open, s)
+	}
+
+	return s
+}
+
+// SetAttribute - Add an attribute to the span, safe to call on nil
+func (s *Span) SetAttribute(key string, value interface{}) {
+
+	if s == nil {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s.attrs[key] = fmt.Sprint(value)
+}
+
+// End - End the span, failed when err is set.  Ending a reconcile exports its trace.  Safe to call on nil.
+func (s *Span) End(err error) {
+
+	if s == nil {
+		return
+	}
+
+	mu.Lock()
+	s.end = time.Now()
+	s.err = err
+
+	if s.root != s {
+		open := s.root.open
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i] == s {
+				s.root.open = append(open[:i], open[i+1:]...)
+				break
+			}
+		}
+		mu.Unlock()
+		return
+	}
+
+	if current == s {
+		current = nil
+	}
+	spans := append([]*Span{s}, s.spans...)
+	body, encodeErr := encode(spans)
+	url := endpoint + "/v1/traces"
+	mu.Unlock()
+
+	if encodeErr != nil {
+		log.Error().Err(encodeErr).Msg("unable to encode trace")
+		return
+	}
+
+	go export(url, body)
+}
+
+// export - Post an encoded trace to the collector
+func export(url string, body []byte) {
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to export trace")
+		return
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Warn().Msgf("trace collector returned status %d", resp.StatusCode)
+	}
+}
+
+// The OTLP JSON encoding of a trace
+type (
+	keyValue struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            otlpStatus `json:"status"`
+	}
+)
+
+// The span kind and status codes of OTLP
+const (
+	kindInternal = 1
+	statusOK     = 1
+	statusError  = 2
+)
+
+// encode - The OTLP export request for the spans of a trace, called with mu held
+func encode(spans []*Span) ([]byte, error) {
+
+	var encoded []otlpSpan
+	for _, s := range spans {
+		end := s.end
+		if end.IsZero() {
+			// A span the reconcile did not wait for, such as a deferred reload
+			end = s.root.end
+		}
+
+		o := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              kindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+			Attributes:        attributes(s.attrs),
+			Status:            otlpStatus{Code: statusOK},
+		}
+		if s.err != nil {
+			o.Status = otlpStatus{Code: statusError, Message: s.err.Error()}
+		}
+
+		encoded = append(encoded, o)
+	}
+
+	request := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": attributes(map[string]string{"service.name": service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/rsvancara/linode-tools"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+
+	return json.Marshal(request)
+}
+
+// attributes - The attributes in the OTLP key value form
+func attributes(attrs map[string]string) []keyValue {
+
+	var kvs []keyValue
+	for k, v := range attrs {
+		kv := keyValue{Key: k}
+		kv.Value.StringValue = v
+		kvs = append(kvs, kv)
+	}
+
+	return kvs
+}
+
+// newID - A random trace or span id of n bytes, hex encoded
+func newID(n int) string {
+
+	id := make([]byte, n)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
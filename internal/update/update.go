@@ -0,0 +1,342 @@
+// Package update replaces the running binary with a release published on GitHub, so a fleet of hosts is
+// upgraded with one command per host instead of copying binaries by hand.
+//
+// A release carries a binary per platform named <tool>_<os>_<arch>, a SHA256SUMS file listing their sha256 as
+// written by sha256sum, and optionally SHA256SUMS.sig, the ed25519 signature of SHA256SUMS, see make release.
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/internal/signature"
+	"github.com/rsvancara/linode-tools/internal/version"
+)
+
+// The files of a release besides the binaries
+const (
+	checksums          = "SHA256SUMS"
+	checksumsSignature = "SHA256SUMS.sig"
+)
+
+// ErrAvailable is returned by Run with -check when another version is available, the command exits with 1
+var ErrAvailable = errors.New("another version is available")
+
+// release is a GitHub release and its files
+type release struct {
+	Tag    string  `json:"tag_name"`
+	Assets []asset `json:"assets"`
+}
+
+// asset is a file of a GitHub release
+type asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// find - The download url of the file of the release, empty when the release has none
+func (r release) find(name string) string {
+
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+
+	return ""
+}
+
+// client fetches the releases and their files
+type client struct {
+	http  *http.Client
+	api   string
+	repo  string
+	token string
+}
+
+// get - The body of a url, failing on any status but 200
+func (c client) get(ctx context.Context, url string, accept string) ([]byte, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", "linode-tools/"+version.Version)
+	if c.token != "" && strings.HasPrefix(url, c.api) {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// release - The latest release, or the release of the tag
+func (c client) release(ctx context.Context, tag string) (release, error) {
+
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", c.api, c.repo)
+	if tag != "" && tag != "latest" {
+		url = fmt.Sprintf("%s/repos/%s/releases/tags/%s", c.api, c.repo, tag)
+	}
+
+	var r release
+
+	data, err := c.get(ctx, url, "application/vnd.github+json")
+	if err != nil {
+		return r, err
+	}
+
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, fmt.Errorf("parsing the release: %w", err)
+	}
+
+	return r, nil
+}
+
+// parseChecksums - The sha256 of the files of a SHA256SUMS file, by file name
+func parseChecksums(data []byte) map[string]string {
+
+	sums := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// sha256sum marks the files read in binary mode with a *
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+
+	return sums
+}
+
+// verifySignature - Check the ed25519 signature of the checksums, raw or base64 as openssl and signify write it
+func verifySignature(key ed25519.PublicKey, data []byte, sig []byte) error {
+
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return fmt.Errorf("%s is neither a raw nor a base64 ed25519 signature", checksumsSignature)
+		}
+		sig = decoded
+	}
+
+	if !ed25519.Verify(key, data, sig) {
+		return fmt.Errorf("the signature of %s does not match the public key", checksums)
+	}
+
+	return nil
+}
+
+// replace - Write the binary next to the executable, check it runs and rename it over the executable.  The
+// running process keeps the binary it was started from.
+func replace(executable string, binary []byte) error {
+
+	info, err := os.Stat(executable)
+	if err != nil {
+		return err
+	}
+
+	tmp := fmt.Sprintf("%s.%d.new", executable, os.Getpid())
+
+	if err := os.WriteFile(tmp, binary, info.Mode().Perm()|0111); err != nil {
+		return err
+	}
+
+	// A binary of the wrong platform or a truncated one fails here, before it replaces anything
+	result := hostexec.Run(hostexec.Command(context.Background(), tmp, "version"))
+	if err := result.Err(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("the new binary does not run: %w", err)
+	}
+
+	log.Info().Msgf("new binary reports %s", result.Output())
+
+	if err := os.Rename(tmp, executable); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// Run - Run the update command: replace the running binary with a release when it is another version, and
+// restart the services running it
+func Run(args []string, tool string) error {
+
+	fs := flag.NewFlagSet(tool+" update", flag.ExitOnError)
+
+	var repo string
+	fs.StringVar(&repo, "repo", "rsvancara/linode-tools", "GitHub repository the releases are published in, as owner/name")
+
+	var api string
+	fs.StringVar(&api, "github-api", "https://api.github.com", "GitHub API, for GitHub Enterprise for example https://github.example.com/api/v3")
+
+	var token string
+	fs.StringVar(&token, "github-token", os.Getenv("GITHUB_TOKEN"), "(optional) GitHub token, for private repositories or the rate limit, defaults to $GITHUB_TOKEN")
+
+	var tag string
+	fs.StringVar(&tag, "release", "latest", "release to install, latest or a tag such as v1.4.0")
+
+	var publicKey string
+	fs.StringVar(&publicKey, "public-key", "", "(optional) ed25519 public key (PEM) "+checksumsSignature+" must be signed with, releases without a valid signature are refused")
+
+	var check bool
+	fs.BoolVar(&check, "check", false, "only tell whether another version is available, exiting with 1 when it is")
+
+	var force bool
+	fs.BoolVar(&force, "force", false, "install the release even when it is the running version")
+
+	var restart string
+	fs.StringVar(&restart, "restart", "", "(optional) comma separated systemd units restarted after the binary is replaced, for example linode-tools-nginx")
+
+	var systemctl string
+	fs.StringVar(&systemctl, "systemctl", "/bin/systemctl", "systemctl executable command")
+
+	var timeout time.Duration
+	fs.DurationVar(&timeout, "timeout", 5*time.Minute, "time the download may take")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var key ed25519.PublicKey
+	if publicKey != "" {
+		var err error
+		if key, err = signature.LoadPublicKey(publicKey); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c := client{http: &http.Client{}, api: strings.TrimSuffix(api, "/"), repo: repo, token: token}
+
+	r, err := c.release(ctx, tag)
+	if err != nil {
+		return err
+	}
+
+	current := version.Get().Version
+
+	if r.Tag == current && !force {
+		log.Info().Msgf("%s is up to date at %s", tool, current)
+		return nil
+	}
+
+	if check {
+		fmt.Printf("%s %s is available, running %s\n", tool, r.Tag, current)
+		return ErrAvailable
+	}
+
+	name := fmt.Sprintf("%s_%s_%s", tool, runtime.GOOS, runtime.GOARCH)
+
+	binaryURL := r.find(name)
+	if binaryURL == "" {
+		return fmt.Errorf("release %s has no %s", r.Tag, name)
+	}
+
+	sumsURL := r.find(checksums)
+	if sumsURL == "" {
+		return fmt.Errorf("release %s has no %s, the binary cannot be checked", r.Tag, checksums)
+	}
+
+	sums, err := c.get(ctx, sumsURL, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+
+	if key != nil {
+		sigURL := r.find(checksumsSignature)
+		if sigURL == "" {
+			return fmt.Errorf("release %s has no %s and -public-key is given", r.Tag, checksumsSignature)
+		}
+
+		sig, err := c.get(ctx, sigURL, "application/octet-stream")
+		if err != nil {
+			return err
+		}
+
+		if err := verifySignature(key, sums, sig); err != nil {
+			return err
+		}
+
+		log.Info().Msgf("%s of %s is signed by %s", checksums, r.Tag, publicKey)
+	}
+
+	want := parseChecksums(sums)[name]
+	if want == "" {
+		return fmt.Errorf("%s of release %s has no checksum for %s", checksums, r.Tag, name)
+	}
+
+	log.Info().Msgf("downloading %s of %s", name, r.Tag)
+
+	binary, err := c.get(ctx, binaryURL, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(binary)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("the sha256 of %s is %s, %s lists %s", name, got, checksums, want)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	if err := replace(executable, binary); err != nil {
+		return fmt.Errorf("unable to replace %s: %w", executable, err)
+	}
+
+	log.Info().Msgf("updated %s from %s to %s", executable, current, r.Tag)
+
+	for _, unit := range strings.Split(restart, ",") {
+		if unit = strings.TrimSpace(unit); unit == "" {
+			continue
+		}
+
+		log.Info().Msgf("restarting %s", unit)
+
+		if err := hostexec.Run(hostexec.Command(context.Background(), systemctl, "restart", unit)).Err(); err != nil {
+			return fmt.Errorf("restarting %s failed: %w", unit, err)
+		}
+	}
+
+	return nil
+}
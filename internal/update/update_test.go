@@ -0,0 +1,112 @@
+package update
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/rsvancara/linode-tools/internal/version"
+)
+
+// TestCheck checks -check compares the running version with the release and returns ErrAvailable when they differ
+func TestCheck(t *testing.T) {
+
+	running := version.Version
+	version.Version = "v1.4.0"
+	defer func() { version.Version = running }()
+
+	releases := map[string]string{
+		"/repos/rsvancara/linode-tools/releases/latest":      "v1.5.0",
+		"/repos/rsvancara/linode-tools/releases/tags/v1.4.0": "v1.4.0",
+		"/repos/rsvancara/linode-tools/releases/tags/v1.3.0": "v1.3.0",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tag, ok := releases[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprintf(w, `{"tag_name": %q, "assets": []}`, tag)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		release string
+		err     error
+		fails   bool
+	}{
+		{name: "newer latest release", release: "latest", err: ErrAvailable},
+		{name: "running release", release: "v1.4.0"},
+		{name: "older release", release: "v1.3.0", err: ErrAvailable},
+		{name: "missing release", release: "v9.9.9", fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := Run([]string{"-check", "-github-api", server.URL, "-release", tt.release}, "linode-tools")
+
+			if tt.fails {
+				if err == nil || errors.Is(err, ErrAvailable) {
+					t.Errorf("got %v for a missing release, expected the error of the request", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, expected %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// TestParseChecksums checks the text and binary mode lines of sha256sum are read and other lines skipped
+func TestParseChecksums(t *testing.T) {
+
+	data := []byte("ABC123  linode-tools_linux_amd64\ndef456 *linode-tools_linux_arm64\n\nnot a checksum line here\n")
+
+	expected := map[string]string{"linode-tools_linux_amd64": "abc123", "linode-tools_linux_arm64": "def456"}
+	if got := parseChecksums(data); !reflect.DeepEqual(got, expected) {
+		t.Errorf("parsed %v, expected %v", got, expected)
+	}
+}
+
+// TestVerifySignature checks raw and base64 signatures of the checksums, and refuses the others
+func TestVerifySignature(t *testing.T) {
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sums := []byte("abc123  linode-tools_linux_amd64\n")
+	sig := ed25519.Sign(private, sums)
+
+	tests := []struct {
+		name string
+		sums []byte
+		sig  []byte
+		err  bool
+	}{
+		{name: "raw", sums: sums, sig: sig},
+		{name: "base64", sums: sums, sig: []byte(base64.StdEncoding.EncodeToString(sig) + "\n")},
+		{name: "tampered checksums", sums: []byte("000000  linode-tools_linux_amd64\n"), sig: sig, err: true},
+		{name: "not a signature", sums: sums, sig: []byte("garbage"), err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifySignature(public, tt.sums, tt.sig); (err != nil) != tt.err {
+				t.Errorf("verifySignature returned %v", err)
+			}
+		})
+	}
+}
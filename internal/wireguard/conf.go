@@ -0,0 +1,129 @@
+package wireguard
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/configfile"
+	"github.com/rsvancara/linode-tools/internal/tracing"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// conf keeps the managed block of a WireGuard configuration file as a backend.Backend
+type conf struct {
+	path         string
+	iface        string
+	wg           string
+	wgQuick      string
+	backups      int
+	markers      configfile.Markers
+	port         int
+	keepalive    int
+	peerKey      string
+	peerEndpoint string
+	auditLog     *audit.Log
+
+	// The configuration file, peers and nodes read and rendered by the last Render
+	existing []string
+	peers    []render.Peer
+	ips      []net.IP
+
+	// The nodes of the last applied configuration, to record what changed
+	applied []net.IP
+}
+
+// Name - The path of the configuration file
+func (c *conf) Name() string {
+	return c.path
+}
+
+// Render - The configuration file with the managed block holding the peers
+func (c *conf) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	existing, err := configfile.Read(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", c.path, err)
+	}
+
+	c.existing = existing
+	c.peers = buildPeers(nodes, c.peerKey, c.peerEndpoint, c.port, c.keepalive)
+	c.ips = discovery.IPs(nodes)
+
+	merged, err := c.markers.Merge(existing, render.WireGuard(c.peers))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.path, err)
+	}
+
+	return merged, nil
+}
+
+// Validate - wg refuses a configuration with a malformed key or a key used by two peers
+func (c *conf) Validate(ctx context.Context, rendered []string) error {
+
+	seen := make(map[string]string)
+
+	for _, p := range c.peers {
+		key, err := base64.StdEncoding.DecodeString(p.PublicKey)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("peer %s has an invalid public key %q", p.Comment, p.PublicKey)
+		}
+
+		if other, ok := seen[p.PublicKey]; ok {
+			return fmt.Errorf("peers %s and %s have the same public key", other, p.Comment)
+		}
+		seen[p.PublicKey] = p.Comment
+	}
+
+	return nil
+}
+
+// Apply - Write the configuration file when it changed and sync the interface with it
+func (c *conf) Apply(ctx context.Context, rendered []string) error {
+
+	if configfile.Equal(c.existing, rendered) {
+		return nil
+	}
+
+	if c.backups > 0 {
+		if err := configfile.Backup(c.path, c.backups); err != nil {
+			return fmt.Errorf("unable to backup %s: %w", c.path, err)
+		}
+	}
+
+	if err := configfile.Write(ctx, c.path, rendered); err != nil {
+		return fmt.Errorf("unable to write %s: %w", c.path, err)
+	}
+
+	span := tracing.Start("syncconf " + c.iface)
+	syncErr := SyncConf(ctx, c.wg, c.wgQuick, c.iface, c.path)
+	span.End(syncErr)
+
+	record := audit.Record{Target: c.path, Hash: audit.Hash(rendered), Reload: audit.Result(syncErr)}
+	if syncErr != nil {
+		c.auditLog.Write(record)
+		return syncErr
+	}
+
+	record.Added, record.Removed = audit.Diff(c.applied, c.ips)
+	c.auditLog.Write(record)
+
+	c.applied = c.ips
+
+	return nil
+}
+
+// Rollback - Put the previous configuration file back and sync the interface with it
+func (c *conf) Rollback(ctx context.Context) error {
+
+	if err := configfile.Write(ctx, c.path, c.existing); err != nil {
+		return fmt.Errorf("unable to restore %s: %w", c.path, err)
+	}
+
+	c.auditLog.Write(audit.Record{Target: c.path, Reload: "rolled back"})
+
+	return SyncConf(ctx, c.wg, c.wgQuick, c.iface, c.path)
+}
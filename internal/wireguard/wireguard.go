@@ -0,0 +1,177 @@
+// Package wireguard keeps the peers of a WireGuard interface in sync with the cluster nodes.
+package wireguard
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/internal/audit"
+	"github.com/rsvancara/linode-tools/internal/cli"
+	"github.com/rsvancara/linode-tools/internal/diff"
+	"github.com/rsvancara/linode-tools/internal/hostexec"
+	"github.com/rsvancara/linode-tools/internal/notify"
+	"github.com/rsvancara/linode-tools/pkg/backend"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+const (
+	beginMarker = "### BEGIN kube-wireguard ###"
+	endMarker   = "### END kube-wireguard ###"
+)
+
+// The node annotations giving the WireGuard peer of a node
+const (
+	publicKeyAnnotation  = "kube-wireguard/public-key"
+	allowedIPsAnnotation = "kube-wireguard/allowed-ips"
+)
+
+// SyncConf - Apply the configuration file to the running interface without disturbing the sessions
+// of unchanged peers, as wg syncconf <iface> <(wg-quick strip <conf>)
+func SyncConf(ctx context.Context, wg string, wgQuick string, iface string, conf string) error {
+
+	log.Info().Msgf("syncing %s with %s", iface, conf)
+
+	stripped := hostexec.Run(hostexec.Command(ctx, wgQuick, "strip", hostexec.Path(conf)))
+	if err := stripped.Err(); err != nil {
+		return fmt.Errorf("stripping %s failed: %w", conf, err)
+	}
+
+	cmd := hostexec.Command(ctx, wg, "syncconf", iface, "/dev/stdin")
+	cmd.Stdin = strings.NewReader(stripped.Stdout)
+
+	if err := hostexec.Run(cmd).Err(); err != nil {
+		return fmt.Errorf("syncing %s failed: %w", iface, err)
+	}
+
+	return nil
+}
+
+// buildPeers - The peers for the nodes.  With a peer public key the nodes are the allowed addresses of
+// that single peer, otherwise every node carrying a public key annotation is its own peer.
+func buildPeers(nodes []discovery.Node, peerKey string, peerEndpoint string, port int, keepalive int) []render.Peer {
+
+	if peerKey != "" {
+		var allowed []string
+		for _, n := range nodes {
+			allowed = append(allowed, hostCIDR(n.IP))
+		}
+		return []render.Peer{{Comment: "cluster nodes", PublicKey: peerKey, Endpoint: peerEndpoint, AllowedIPs: allowed, PersistentKeepalive: keepalive}}
+	}
+
+	var peers []render.Peer
+
+	for _, n := range nodes {
+		key := n.Annotations[publicKeyAnnotation]
+		if key == "" {
+			log.Warn().Msgf("node %s has no %s annotation, skipping it", n.Name, publicKeyAnnotation)
+			continue
+		}
+
+		allowed := []string{hostCIDR(n.IP)}
+		for _, extra := range strings.Split(n.Annotations[allowedIPsAnnotation], ",") {
+			if extra = strings.TrimSpace(extra); extra != "" {
+				allowed = append(allowed, extra)
+			}
+		}
+
+		peers = append(peers, render.Peer{
+			Comment:             n.Name,
+			PublicKey:           key,
+			Endpoint:            net.JoinHostPort(n.IP.String(), fmt.Sprint(port)),
+			AllowedIPs:          allowed,
+			PersistentKeepalive: keepalive,
+		})
+	}
+
+	return peers
+}
+
+// hostCIDR - The address as a single host CIDR
+func hostCIDR(ip net.IP) string {
+
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+
+	return ip.String() + "/128"
+}
+
+// Run - Run the wireguard subcommand with the given command line arguments
+func Run(args []string) error {
+
+	fs := flag.NewFlagSet("wireguard", flag.ExitOnError)
+
+	var common cli.Common
+	common.Register(fs)
+
+	c := &conf{}
+
+	fs.StringVar(&c.path, "config", "/etc/wireguard/wg0.conf", "WireGuard configuration file whose managed block holds the peers")
+	fs.StringVar(&c.iface, "interface", "wg0", "WireGuard interface synced with the configuration file")
+	fs.StringVar(&c.wg, "wg", "wg", "wg executable command")
+	fs.StringVar(&c.wgQuick, "wg-quick", "wg-quick", "wg-quick executable command")
+	fs.IntVar(&c.backups, "backups", 5, "number of timestamped backups of the configuration file to keep, 0 disables backups")
+	fs.IntVar(&c.port, "port", 51820, "port the nodes listen on, used for the endpoint of each node peer")
+	fs.IntVar(&c.keepalive, "persistent-keepalive", 0, "(optional) persistent keepalive interval of the peers in seconds")
+	fs.StringVar(&c.peerKey, "peer-public-key", "", "(optional) public key of a single peer routing to every node, instead of a peer per node from the "+publicKeyAnnotation+" annotation")
+	fs.StringVar(&c.peerEndpoint, "peer-endpoint", "", "(optional) endpoint of the -peer-public-key peer")
+	fs.StringVar(&c.markers.Begin, "begin-marker", beginMarker, "line starting the managed block of the configuration file")
+	fs.StringVar(&c.markers.End, "end-marker", endMarker, "line ending the managed block of the configuration file")
+
+	var dryRun bool
+	fs.BoolVar(&dryRun, "dry-run", false, "print a unified diff of the changes to the configuration file and exit without writing it")
+
+	if err := cli.Parse(fs, args); err != nil {
+		return err
+	}
+
+	if err := c.markers.Validate(); err != nil {
+		return err
+	}
+
+	common.Start(fs.Name())
+
+	log.Info().Msgf("using wireguard config file %s", c.path)
+
+	if common.Output != "" {
+		return common.Print([]backend.Backend{c})
+	}
+
+	if dryRun {
+		nodes, err := common.Source().Nodes(context.Background())
+		if err != nil {
+			return err
+		}
+
+		rendered, err := c.Render(context.Background(), nodes)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(diff.Unified(c.path, c.path+" (rendered)", c.existing, rendered, 3))
+		return nil
+	}
+
+	c.auditLog = audit.Open(common.AuditLog, fs.Name())
+
+	notifier := notify.New(common.NotifyWebhook, fs.Name())
+
+	backends := common.Track([]backend.Backend{c})
+
+	force := make(chan struct{}, 1)
+	cli.OnDemand(force)
+
+	go common.Poll(force, notifier, func(ctx context.Context, nodes []discovery.Node) error {
+		return backend.Reconcile(ctx, backends, nodes, notifier)
+	})
+
+	cli.WaitForSignal()
+
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package xds serves cluster endpoints to Envoy over a gRPC stream of the endpoint discovery service of the
+// xDS protocol.
+//
+// Envoy opens a StreamEndpoints stream, or an aggregated discovery stream, and is sent the
+// ClusterLoadAssignments of the clusters it asks for whenever they change, which it applies without a reload.
+package xds
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// everyNode is the key the assignments are cached under, every Envoy is sent the same clusters
+const everyNode = "all"
+
+// allNodes hashes every Envoy node to the same key of the snapshot cache
+type allNodes struct{}
+
+func (allNodes) ID(node *corev3.Node) string {
+	return everyNode
+}
+
+// Server holds the current cluster load assignments and streams them to Envoy
+type Server struct {
+	mu        sync.Mutex
+	version   int
+	resources []render.ClusterLoadAssignment
+
+	cache cachev3.SnapshotCache
+}
+
+// New - A server without any assignments, Envoy is sent none until Set is called
+func New() *Server {
+	return &Server{cache: cachev3.NewSnapshotCache(false, allNodes{}, nil)}
+}
+
+// Set - Publish new assignments under a new version, pushed to the open streams right away
+func (s *Server) Set(resources []render.ClusterLoadAssignment) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.version++
+	s.resources = resources
+
+	var assignments []types.Resource
+	for _, a := range resources {
+		assignments = append(assignments, Assignment(a))
+	}
+
+	snapshot, err := cachev3.NewSnapshot(strconv.Itoa(s.version), map[resourcev3.Type][]types.Resource{resourcev3.EndpointType: assignments})
+	if err == nil {
+		err = s.cache.SetSnapshot(context.Background(), everyNode, snapshot)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("unable to publish version %d", s.version)
+		return
+	}
+
+	log.Info().Msgf("publishing version %d of %d clusters", s.version, len(resources))
+}
+
+// Resources - The assignments currently published
+func (s *Server) Resources() []render.ClusterLoadAssignment {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.resources
+}
+
+// Assignment - The xDS message of a rendered cluster load assignment
+func Assignment(a render.ClusterLoadAssignment) *endpointv3.ClusterLoadAssignment {
+
+	assignment := &endpointv3.ClusterLoadAssignment{ClusterName: a.ClusterName}
+
+	for _, group := range a.Endpoints {
+		locality := &endpointv3.LocalityLbEndpoints{Priority: uint32(group.Priority)}
+
+		for _, e := range group.LbEndpoints {
+			endpoint := &endpointv3.LbEndpoint{
+				HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+					Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+						Address:       e.Endpoint.Address.SocketAddress.Address,
+						PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(e.Endpoint.Address.SocketAddress.PortValue)},
+					}}},
+				}},
+				HealthStatus: corev3.HealthStatus(corev3.HealthStatus_value[e.HealthStatus]),
+			}
+			if e.LoadBalancingWeight > 0 {
+				endpoint.LoadBalancingWeight = wrapperspb.UInt32(uint32(e.LoadBalancingWeight))
+			}
+
+			locality.LbEndpoints = append(locality.LbEndpoints, endpoint)
+		}
+
+		assignment.Endpoints = append(assignment.Endpoints, locality)
+	}
+
+	return assignment
+}
+
+// rejections - Log the versions Envoy refused to apply
+func rejections() serverv3.Callbacks {
+	return serverv3.CallbackFuncs{
+		StreamRequestFunc: func(id int64, req *discoveryv3.DiscoveryRequest) error {
+			if req.ErrorDetail != nil {
+				log.Error().Msgf("envoy %s rejected version %s: %s", req.GetNode().GetId(), req.VersionInfo, req.ErrorDetail.Message)
+			}
+			return nil
+		},
+	}
+}
+
+// Register - Add the endpoint and aggregated discovery services to a gRPC server
+func (s *Server) Register(server *grpc.Server) {
+
+	xds := serverv3.NewServer(context.Background(), s.cache, rejections())
+
+	endpointservice.RegisterEndpointDiscoveryServiceServer(server, xds)
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(server, xds)
+}
+
+// Serve - Serve the discovery services over gRPC in the background
+func (s *Server) Serve(addr string) {
+
+	log.Info().Msgf("serving xds endpoints over grpc on %s", addr)
+
+	server := grpc.NewServer()
+	s.Register(server)
+
+	go func() {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Error().Err(err).Msgf("unable to listen on %s for xds", addr)
+			return
+		}
+		if err := server.Serve(listener); err != nil {
+			log.Error().Err(err).Msgf("xds server on %s stopped", addr)
+		}
+	}()
+}
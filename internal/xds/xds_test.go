@@ -0,0 +1,145 @@
+package xds
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/rsvancara/linode-tools/pkg/render"
+)
+
+// assignments - The rendered assignment of the web cluster with the nodes
+func assignments(ips ...string) []render.ClusterLoadAssignment {
+
+	var servers []render.Server
+	for _, ip := range ips {
+		servers = append(servers, render.Server{IP: net.ParseIP(ip), Weight: 1})
+	}
+
+	return render.Envoy([]render.Upstream{{Name: "web", Port: 30080}}, servers)
+}
+
+// stream - Serve s on a local port and open an endpoint discovery stream to it
+func stream(t *testing.T, s *Server) endpointservice.EndpointDiscoveryService_StreamEndpointsClient {
+
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer()
+	s.Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	client, err := endpointservice.NewEndpointDiscoveryServiceClient(conn).StreamEndpoints(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+// receive - The next response of the stream and its assignments
+func receive(t *testing.T, client endpointservice.EndpointDiscoveryService_StreamEndpointsClient) (*discoveryv3.DiscoveryResponse, []*endpointv3.ClusterLoadAssignment) {
+
+	t.Helper()
+
+	resp, err := client.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assignments []*endpointv3.ClusterLoadAssignment
+	for _, r := range resp.Resources {
+		var a endpointv3.ClusterLoadAssignment
+		if err := r.UnmarshalTo(&a); err != nil {
+			t.Fatal(err)
+		}
+		assignments = append(assignments, &a)
+	}
+
+	return resp, assignments
+}
+
+// TestStream checks Envoy is sent the assignments on its request and the new ones as soon as they are set
+func TestStream(t *testing.T) {
+
+	s := New()
+	s.Set(assignments("10.0.0.1"))
+
+	client := stream(t, s)
+
+	node := &corev3.Node{Id: "envoy-1", Cluster: "front"}
+	if err := client.Send(&discoveryv3.DiscoveryRequest{Node: node, TypeUrl: resourcev3.EndpointType, ResourceNames: []string{"web"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, got := receive(t, client)
+	if resp.VersionInfo != "1" || len(got) != 1 || len(got[0].Endpoints[0].LbEndpoints) != 1 {
+		t.Fatalf("version %s with %v, expected version 1 with one endpoint", resp.VersionInfo, got)
+	}
+
+	// Acknowledge the version, then the next one is pushed without another poll
+	if err := client.Send(&discoveryv3.DiscoveryRequest{Node: node, TypeUrl: resourcev3.EndpointType, ResourceNames: []string{"web"}, VersionInfo: resp.VersionInfo, ResponseNonce: resp.Nonce}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Set(assignments("10.0.0.1", "10.0.0.2"))
+
+	resp, got = receive(t, client)
+	if resp.VersionInfo != "2" || len(got) != 1 || len(got[0].Endpoints[0].LbEndpoints) != 2 {
+		t.Fatalf("version %s with %v, expected version 2 with two endpoints", resp.VersionInfo, got)
+	}
+}
+
+func TestAssignment(t *testing.T) {
+
+	servers := []render.Server{
+		{IP: net.ParseIP("10.0.0.1"), Weight: 2},
+		{IP: net.ParseIP("10.0.0.2"), Weight: 1, Down: true},
+		{IP: net.ParseIP("10.0.0.3"), Weight: 1, Backup: true},
+	}
+
+	a := Assignment(render.Envoy([]render.Upstream{{Name: "web", Port: 30080}}, servers)[0])
+
+	if a.ClusterName != "web" || len(a.Endpoints) != 2 || a.Endpoints[1].Priority != 1 {
+		t.Fatalf("unexpected assignment %v", a)
+	}
+
+	primary := a.Endpoints[0].LbEndpoints
+	if len(primary) != 2 {
+		t.Fatalf("%d primary endpoints, expected 2", len(primary))
+	}
+
+	first := primary[0]
+	if addr := first.GetEndpoint().GetAddress().GetSocketAddress(); addr.Address != "10.0.0.1" || addr.GetPortValue() != 30080 {
+		t.Errorf("first endpoint at %s:%d", addr.Address, addr.GetPortValue())
+	}
+	if first.LoadBalancingWeight.GetValue() != 2 || first.HealthStatus != corev3.HealthStatus_UNKNOWN {
+		t.Errorf("first endpoint has weight %d and status %s", first.LoadBalancingWeight.GetValue(), first.HealthStatus)
+	}
+	if primary[1].HealthStatus != corev3.HealthStatus_DRAINING {
+		t.Errorf("the down endpoint has status %s, expected DRAINING", primary[1].HealthStatus)
+	}
+}
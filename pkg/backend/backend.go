@@ -0,0 +1,88 @@
+// Package backend defines the outputs kept in sync with the node list and the loop applying them.
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// Backend - An output kept in sync with the nodes, such as a firewall chain or a config file.
+// New outputs implement Backend and are passed to Reconcile, the loop itself does not change.
+// The context is the one of the reconcile, the commands a backend runs are killed when it is done.
+type Backend interface {
+	// Name - Identifies the backend in logs and alerts
+	Name() string
+
+	// Render - The output for the nodes
+	Render(ctx context.Context, nodes []discovery.Node) ([]string, error)
+
+	// Validate - Check the rendered output before anything is changed
+	Validate(ctx context.Context, rendered []string) error
+
+	// Apply - Write the rendered output and make it live
+	Apply(ctx context.Context, rendered []string) error
+
+	// Rollback - Put back the output that was live before the last Apply
+	Rollback(ctx context.Context) error
+}
+
+// Alerter - Receives an alert when an apply fails
+type Alerter interface {
+	Alert(format string, args ...interface{})
+}
+
+// Reconcile - Render, validate and apply every backend for the nodes, rolling a backend back when
+// its apply fails.  A failing backend does not stop the others, the alerter may be nil.
+func Reconcile(ctx context.Context, backends []Backend, nodes []discovery.Node, alerter Alerter) error {
+
+	var failed []string
+
+	for _, b := range backends {
+		if err := reconcile(ctx, b, nodes, alerter); err != nil {
+			log.Error().Err(err).Msgf("unable to apply %s", b.Name())
+			failed = append(failed, b.Name())
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to apply %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func reconcile(ctx context.Context, b Backend, nodes []discovery.Node, alerter Alerter) error {
+
+	rendered, err := b.Render(ctx, nodes)
+	if err != nil {
+		return err
+	}
+
+	// Nothing has been changed yet, there is nothing to roll back
+	if err := b.Validate(ctx, rendered); err != nil {
+		return fmt.Errorf("%s is not valid: %w", b.Name(), err)
+	}
+
+	applyErr := b.Apply(ctx, rendered)
+	if applyErr == nil {
+		return nil
+	}
+
+	if err := b.Rollback(ctx); err != nil {
+		if alerter != nil {
+			alerter.Alert("applying %s failed (%v) and rolling back failed: %v", b.Name(), applyErr, err)
+		}
+		return applyErr
+	}
+
+	if alerter != nil {
+		alerter.Alert("applying %s failed (%v), rolled back to the previous version", b.Name(), applyErr)
+	}
+
+	return applyErr
+}
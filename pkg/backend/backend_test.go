@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// recorder is a Mock appending every call to a log shared between backends
+type recorder struct {
+	Mock
+	calls *[]string
+}
+
+func (r *recorder) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+	*r.calls = append(*r.calls, r.Label+" render")
+	return r.Mock.Render(ctx, nodes)
+}
+
+func (r *recorder) Validate(ctx context.Context, rendered []string) error {
+	*r.calls = append(*r.calls, r.Label+" validate")
+	return r.Mock.Validate(ctx, rendered)
+}
+
+func (r *recorder) Apply(ctx context.Context, rendered []string) error {
+	*r.calls = append(*r.calls, r.Label+" apply")
+	return r.Mock.Apply(ctx, rendered)
+}
+
+func (r *recorder) Rollback(ctx context.Context) error {
+	*r.calls = append(*r.calls, r.Label+" rollback")
+	return r.Mock.Rollback(ctx)
+}
+
+// alerts collects the alerts sent by Reconcile
+type alerts []string
+
+func (a *alerts) Alert(format string, args ...interface{}) {
+	*a = append(*a, fmt.Sprintf(format, args...))
+}
+
+func nodes(ips ...string) []discovery.Node {
+
+	var nodes []discovery.Node
+	for i, ip := range ips {
+		nodes = append(nodes, discovery.Node{Name: fmt.Sprintf("node-%d", i), IP: net.ParseIP(ip)})
+	}
+
+	return nodes
+}
+
+func TestReconcile(t *testing.T) {
+
+	failure := errors.New("failure")
+
+	tests := []struct {
+		name string
+		mock Mock
+
+		calls     []string
+		live      []string
+		rollbacks int
+		alerts    int
+		err       bool
+	}{
+		{
+			name:  "applied",
+			calls: []string{"a render", "a validate", "a apply"},
+			live:  []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:  "render fails",
+			mock:  Mock{RenderErr: failure},
+			calls: []string{"a render"},
+			live:  []string{"10.0.0.9"},
+			err:   true,
+		},
+		{
+			name:  "validate fails before anything is applied",
+			mock:  Mock{ValidateErr: failure},
+			calls: []string{"a render", "a validate"},
+			live:  []string{"10.0.0.9"},
+			err:   true,
+		},
+		{
+			name:      "apply fails and is rolled back",
+			mock:      Mock{ApplyErr: failure},
+			calls:     []string{"a render", "a validate", "a apply", "a rollback"},
+			live:      []string{"10.0.0.9"},
+			rollbacks: 1,
+			alerts:    1,
+			err:       true,
+		},
+		{
+			name:      "apply and rollback fail",
+			mock:      Mock{ApplyErr: failure, RollbackErr: failure},
+			calls:     []string{"a render", "a validate", "a apply", "a rollback"},
+			live:      []string{"10.0.0.9"},
+			rollbacks: 1,
+			alerts:    1,
+			err:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var calls []string
+			r := &recorder{Mock: tt.mock, calls: &calls}
+			r.Label = "a"
+			r.Live = []string{"10.0.0.9"}
+
+			var sent alerts
+			err := Reconcile(context.Background(), []Backend{r}, nodes("10.0.0.1", "10.0.0.2"), &sent)
+
+			if (err != nil) != tt.err {
+				t.Fatalf("Reconcile returned %v", err)
+			}
+			if !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("calls %v, expected %v", calls, tt.calls)
+			}
+			if !reflect.DeepEqual(r.Live, tt.live) {
+				t.Errorf("live %v, expected %v", r.Live, tt.live)
+			}
+			if r.Rollbacks != tt.rollbacks {
+				t.Errorf("%d rollbacks, expected %d", r.Rollbacks, tt.rollbacks)
+			}
+			if len(sent) != tt.alerts {
+				t.Errorf("alerts %v, expected %d", sent, tt.alerts)
+			}
+		})
+	}
+}
+
+// TestReconcileContinues checks a failing backend does not stop the others and is named in the error
+func TestReconcileContinues(t *testing.T) {
+
+	var calls []string
+	a := &recorder{Mock: Mock{Label: "a", ApplyErr: errors.New("failure")}, calls: &calls}
+	b := &recorder{Mock: Mock{Label: "b"}, calls: &calls}
+
+	err := Reconcile(context.Background(), []Backend{a, b}, nodes("10.0.0.1"), nil)
+	if err == nil || err.Error() != "unable to apply a" {
+		t.Fatalf("Reconcile returned %v, expected an error naming a only", err)
+	}
+
+	want := []string{"a render", "a validate", "a apply", "a rollback", "b render", "b validate", "b apply"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls %v, expected %v", calls, want)
+	}
+	if !reflect.DeepEqual(b.Live, []string{"10.0.0.1"}) {
+		t.Errorf("b holds %v", b.Live)
+	}
+}
+
+// TestReconcileRollbackRestores checks a rollback after a failed apply puts the last applied output back
+func TestReconcileRollbackRestores(t *testing.T) {
+
+	m := &Mock{Label: "a"}
+
+	if err := Reconcile(context.Background(), []Backend{m}, nodes("10.0.0.1"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	m.ApplyErr = errors.New("failure")
+	if err := Reconcile(context.Background(), []Backend{m}, nodes("10.0.0.1", "10.0.0.2"), nil); err == nil {
+		t.Fatal("Reconcile succeeded with a failing apply")
+	}
+
+	if !reflect.DeepEqual(m.Live, []string{"10.0.0.1"}) {
+		t.Errorf("live %v after the rollback, expected the first apply", m.Live)
+	}
+	if len(m.Applied) != 1 {
+		t.Errorf("%d applies recorded, expected 1", len(m.Applied))
+	}
+}
+
+// contextual is a Mock keeping the contexts it is given
+type contextual struct {
+	Mock
+	contexts []context.Context
+}
+
+func (c *contextual) Validate(ctx context.Context, rendered []string) error {
+	c.contexts = append(c.contexts, ctx)
+	return c.Mock.Validate(ctx, rendered)
+}
+
+func (c *contextual) Apply(ctx context.Context, rendered []string) error {
+	c.contexts = append(c.contexts, ctx)
+	return c.Mock.Apply(ctx, rendered)
+}
+
+// TestReconcileContext checks the backends are given the context of the reconcile, so the commands they run
+// end with it
+func TestReconcileContext(t *testing.T) {
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "reconcile")
+
+	c := &contextual{Mock: Mock{Label: "a"}}
+	if err := Reconcile(ctx, []Backend{c}, nodes("10.0.0.1"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.contexts) != 2 {
+		t.Fatalf("%d calls recorded, expected a validate and an apply", len(c.contexts))
+	}
+	for _, got := range c.contexts {
+		if got.Value(key{}) != "reconcile" {
+			t.Error("a backend was not given the context of the reconcile")
+		}
+	}
+}
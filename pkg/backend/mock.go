@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"context"
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// Mock - A backend recording every call, for exercising Reconcile without touching the host.
+// Set the errors to make a step fail.
+type Mock struct {
+	Label string
+
+	RenderErr   error
+	ValidateErr error
+	ApplyErr    error
+	RollbackErr error
+
+	// Rendered is the output of Render, one IP per node
+	Rendered [][]string
+	// Applied holds the output of every successful Apply
+	Applied [][]string
+	// Live is the output of the last successful Apply, or the one before it after a Rollback
+	Live      []string
+	previous  []string
+	Rollbacks int
+}
+
+// Name - The label of the mock
+func (m *Mock) Name() string {
+	return m.Label
+}
+
+// Render - One line per node holding its IP
+func (m *Mock) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+
+	if m.RenderErr != nil {
+		return nil, m.RenderErr
+	}
+
+	var lines []string
+	for _, n := range nodes {
+		lines = append(lines, n.IP.String())
+	}
+
+	m.Rendered = append(m.Rendered, lines)
+
+	return lines, nil
+}
+
+// Validate - Fails with ValidateErr when set
+func (m *Mock) Validate(ctx context.Context, rendered []string) error {
+	return m.ValidateErr
+}
+
+// Apply - Makes the rendered output live unless ApplyErr is set
+func (m *Mock) Apply(ctx context.Context, rendered []string) error {
+
+	m.previous = m.Live
+
+	if m.ApplyErr != nil {
+		return m.ApplyErr
+	}
+
+	m.Live = rendered
+	m.Applied = append(m.Applied, rendered)
+
+	return nil
+}
+
+// Rollback - Puts the output from before the last Apply back unless RollbackErr is set
+func (m *Mock) Rollback(ctx context.Context) error {
+
+	m.Rollbacks++
+
+	if m.RollbackErr != nil {
+		return m.RollbackErr
+	}
+
+	m.Live = m.previous
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+)
+
+// mockApply renders and validates a backend as usual but only logs what Apply would change
+type mockApply struct {
+	Backend
+	live []string
+}
+
+// MockApply - Wrap the backends so nothing is written or reloaded, for running the tools on a
+// development machine without iptables, systemd or nginx
+func MockApply(backends []Backend) []Backend {
+
+	var mocked []Backend
+	for _, b := range backends {
+		mocked = append(mocked, &mockApply{Backend: b})
+	}
+
+	return mocked
+}
+
+// Apply - Log the lines added and removed since the last Apply
+func (m *mockApply) Apply(ctx context.Context, rendered []string) error {
+
+	current := make(map[string]bool)
+	for _, line := range m.live {
+		current[line] = true
+	}
+
+	next := make(map[string]bool)
+	for _, line := range rendered {
+		next[line] = true
+		if !current[line] {
+			log.Info().Msgf("mock apply %s: + %s", m.Name(), line)
+		}
+	}
+
+	for _, line := range m.live {
+		if !next[line] {
+			log.Info().Msgf("mock apply %s: - %s", m.Name(), line)
+		}
+	}
+
+	m.live = rendered
+
+	return nil
+}
+
+// Rollback - Nothing was applied
+func (m *mockApply) Rollback(ctx context.Context) error {
+	return nil
+}
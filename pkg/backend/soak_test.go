@@ -0,0 +1,299 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// The annotations nodes are given an address with, chosen at random per node
+var annotations = []string{discovery.CalicoAnnotation, discovery.CiliumAnnotation}
+
+func init() {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+}
+
+// cluster is a fake cluster nodes join, leave and change in
+type cluster struct {
+	t         *testing.T
+	clientset *fake.Clientset
+	rand      *rand.Rand
+	next      int
+
+	// failing makes the node list fail, as an unreachable API server would
+	mu      sync.Mutex
+	failing bool
+}
+
+// newCluster - A fake cluster with the given number of nodes
+func newCluster(t *testing.T, nodes int, seed int64) *cluster {
+
+	c := &cluster{t: t, clientset: fake.NewSimpleClientset(), rand: rand.New(rand.NewSource(seed))}
+
+	c.clientset.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.failing {
+			return true, nil, errors.New("injected api error")
+		}
+		return false, nil, nil
+	})
+
+	for i := 0; i < nodes; i++ {
+		c.add()
+	}
+
+	return c
+}
+
+// setFailing - Make the node list fail or succeed
+func (c *cluster) setFailing(failing bool) {
+	c.mu.Lock()
+	c.failing = failing
+	c.mu.Unlock()
+}
+
+// add - Create a node with a new address in a random annotation
+func (c *cluster) add() {
+
+	c.next++
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        fmt.Sprintf("node-%d", c.next),
+		Annotations: map[string]string{annotations[c.rand.Intn(len(annotations))]: fmt.Sprintf("10.%d.%d.%d/32", c.next/65536%256, c.next/256%256, c.next%256)},
+	}}
+
+	if _, err := c.clientset.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{}); err != nil {
+		c.t.Fatalf("unable to create %s: %v", node.Name, err)
+	}
+}
+
+// update - Replace a node
+func (c *cluster) update(node corev1.Node) {
+	if _, err := c.clientset.CoreV1().Nodes().Update(context.TODO(), &node, metav1.UpdateOptions{}); err != nil {
+		c.t.Fatalf("unable to update %s: %v", node.Name, err)
+	}
+}
+
+// churn - Apply a random change: add, delete, cordon or re-annotate a node, or toggle API errors
+func (c *cluster) churn() {
+
+	nodes, err := c.clientset.Tracker().List(corev1.SchemeGroupVersion.WithResource("nodes"), corev1.SchemeGroupVersion.WithKind("Node"), "")
+	if err != nil {
+		c.t.Fatalf("unable to list the nodes: %v", err)
+	}
+	list := nodes.(*corev1.NodeList).Items
+
+	switch op := c.rand.Intn(10); {
+	case op < 3 || len(list) == 0:
+		c.add()
+	case op < 5:
+		n := list[c.rand.Intn(len(list))]
+		if err := c.clientset.CoreV1().Nodes().Delete(context.TODO(), n.Name, metav1.DeleteOptions{}); err != nil {
+			c.t.Fatalf("unable to delete %s: %v", n.Name, err)
+		}
+	case op < 7:
+		n := list[c.rand.Intn(len(list))]
+		n.Spec.Unschedulable = !n.Spec.Unschedulable
+		c.update(n)
+	case op < 9:
+		// Move the address to another annotation, or drop it so the node is skipped
+		n := list[c.rand.Intn(len(list))]
+		var address string
+		for _, a := range annotations {
+			if v, ok := n.Annotations[a]; ok {
+				address = v
+			}
+		}
+		n.Annotations = map[string]string{}
+		if address != "" && c.rand.Intn(4) > 0 {
+			n.Annotations[annotations[c.rand.Intn(len(annotations))]] = address
+		}
+		c.update(n)
+	default:
+		c.mu.Lock()
+		c.failing = !c.failing
+		c.mu.Unlock()
+	}
+}
+
+// source - The discovery source of the cluster
+func (c *cluster) source() discovery.Source {
+	return discovery.Client{Clientset: c.clientset, AddressAnnotations: annotations}
+}
+
+// expected - The addresses the backend should hold for the nodes of the cluster
+func (c *cluster) expected() []string {
+
+	nodes, err := c.source().Nodes(context.Background())
+	if err != nil {
+		c.t.Fatalf("unable to list the nodes: %v", err)
+	}
+
+	var ips []string
+	for _, n := range nodes {
+		ips = append(ips, n.IP.String())
+	}
+
+	return ips
+}
+
+// lockedMock serializes the calls to a Mock read by the test while the loop runs
+type lockedMock struct {
+	mu sync.Mutex
+	Mock
+}
+
+func (m *lockedMock) Render(ctx context.Context, nodes []discovery.Node) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mock.Render(ctx, nodes)
+}
+
+func (m *lockedMock) Apply(ctx context.Context, rendered []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mock.Apply(ctx, rendered)
+}
+
+func (m *lockedMock) Rollback(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Mock.Rollback(ctx)
+}
+
+// live - The output of the last successful apply
+func (m *lockedMock) live() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return strings.Join(m.Live, ",")
+}
+
+// watch - Run the watch loop reconciling the mock for the cluster until the returned stop is called
+func watch(t *testing.T, c *cluster, mock *lockedMock) (stop func()) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- discovery.Watch(ctx, c.source(), time.Millisecond, nil, func(oldNodes []discovery.Node, newNodes []discovery.Node) error {
+			return Reconcile(ctx, []Backend{mock}, newNodes, nil)
+		})
+	}()
+
+	return func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("the watch loop ended with %v", err)
+		}
+	}
+}
+
+// converge - Wait for the mock to hold the addresses of the nodes of the cluster
+func converge(t *testing.T, c *cluster, mock *lockedMock, seed int64) {
+
+	want := strings.Join(c.expected(), ",")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for mock.live() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("seed %d: the backend holds %q, expected %q", seed, mock.live(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestSoak churns a fake cluster while the watch loop reconciles a mock and checks the mock converges on
+// the final nodes.  Run it with -race to catch unsynchronized state in the loop.
+func TestSoak(t *testing.T) {
+
+	iterations := 2000
+	if testing.Short() {
+		iterations = 200
+	}
+
+	for _, seed := range []int64{1, 2, 3} {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+
+			c := newCluster(t, 20, seed)
+			mock := &lockedMock{Mock: Mock{Label: "soak"}}
+
+			stop := watch(t, c, mock)
+			defer stop()
+
+			for i := 0; i < iterations; i++ {
+				c.churn()
+				time.Sleep(50 * time.Microsecond)
+			}
+
+			// Let the loop catch up with the API reachable again
+			c.setFailing(false)
+
+			converge(t, c, mock, seed)
+		})
+	}
+}
+
+// TestSoakAPIErrors checks a failing node list keeps the last applied nodes and the loop picks the changes
+// made meanwhile up once the API is back
+func TestSoakAPIErrors(t *testing.T) {
+
+	c := newCluster(t, 3, 1)
+	mock := &lockedMock{Mock: Mock{Label: "soak"}}
+
+	stop := watch(t, c, mock)
+	defer stop()
+
+	converge(t, c, mock, 1)
+	before := mock.live()
+
+	c.setFailing(true)
+	c.add()
+	time.Sleep(20 * time.Millisecond)
+
+	if got := mock.live(); got != before {
+		t.Fatalf("the backend changed to %q while the API failed, expected %q", got, before)
+	}
+
+	c.setFailing(false)
+	converge(t, c, mock, 1)
+}
+
+// TestSoakAnnotations checks nodes are found through any of the annotations and skipped without one
+func TestSoakAnnotations(t *testing.T) {
+
+	c := newCluster(t, 0, 1)
+
+	for i, a := range []map[string]string{
+		{discovery.CalicoAnnotation: "10.0.0.1/32"},
+		{discovery.CiliumAnnotation: "10.0.0.2"},
+		{discovery.CalicoAnnotation: "10.0.0.3/32", discovery.CiliumAnnotation: "10.0.0.4"},
+		{discovery.CalicoAnnotation: ""},
+		{},
+	} {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i), Annotations: a}}
+		if _, err := c.clientset.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := strings.Join(c.expected(), ","), "10.0.0.1,10.0.0.2,10.0.0.3"; got != want {
+		t.Fatalf("found %q, expected %q", got, want)
+	}
+}
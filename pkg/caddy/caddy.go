@@ -0,0 +1,113 @@
+// Package caddy is a small client for the reverse proxy upstreams of the Caddy admin API.
+//
+// The reverse_proxy handlers are addressed by the "@id" given to them in the Caddy config, so the
+// upstreams of a handler with "@id": "diy" are at /id/diy/upstreams.
+package caddy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the default address of the Caddy admin API
+const DefaultURL = "http://localhost:2019"
+
+// Client talks to the admin API of a Caddy server
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient - A client for the Caddy admin API at the given URL
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Upstream is an upstream of a reverse_proxy handler
+type Upstream struct {
+	Dial string `json:"dial"`
+}
+
+// Error is an error returned by the Caddy admin API
+type Error struct {
+	Status  int
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string {
+
+	if e.Message == "" {
+		return fmt.Sprintf("caddy admin api returned status %d", e.Status)
+	}
+
+	return fmt.Sprintf("caddy admin api returned status %d: %s", e.Status, e.Message)
+}
+
+// do - Send a request to the admin API, decoding the JSON response into out when it is not nil
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{Status: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Upstreams - The upstreams of the reverse_proxy handler with the given @id
+func (c *Client) Upstreams(ctx context.Context, id string) ([]Upstream, error) {
+
+	var upstreams []Upstream
+	if err := c.do(ctx, http.MethodGet, "/id/"+id+"/upstreams", nil, &upstreams); err != nil {
+		return nil, err
+	}
+
+	return upstreams, nil
+}
+
+// SetUpstreams - Replace the upstreams of the reverse_proxy handler with the given @id.  Caddy applies
+// the change in place, without a reload.
+func (c *Client) SetUpstreams(ctx context.Context, id string, upstreams []Upstream) error {
+
+	if upstreams == nil {
+		upstreams = []Upstream{}
+	}
+
+	return c.do(ctx, http.MethodPatch, "/id/"+id+"/upstreams", upstreams, nil)
+}
@@ -0,0 +1,140 @@
+// Package cloudflare is a small client for the origin pools of the Cloudflare Load Balancing API.
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultURL is the base URL of the Cloudflare API
+const DefaultURL = "https://api.cloudflare.com/client/v4"
+
+// Client talks to the Cloudflare API with an API token
+type Client struct {
+	Token   string
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient - A client for the Cloudflare API using the given API token, which needs the Load Balancing:
+// Monitors and Pools edit permission of the account
+func NewClient(token string) *Client {
+	return &Client{Token: token, BaseURL: DefaultURL, HTTP: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Origin is an origin of a pool
+type Origin struct {
+	Name    string  `json:"name"`
+	Address string  `json:"address"`
+	Enabled bool    `json:"enabled"`
+	Weight  float64 `json:"weight"`
+
+	// Header is kept as it is, for example a Host header set in the dashboard
+	Header json.RawMessage `json:"header,omitempty"`
+}
+
+// Pool is an origin pool of the load balancers of an account
+type Pool struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Origins []Origin `json:"origins"`
+}
+
+// Error is an error returned by the Cloudflare API
+type Error struct {
+	Status int
+	Errors []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (e *Error) Error() string {
+
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("cloudflare api returned status %d", e.Status)
+	}
+
+	return fmt.Sprintf("cloudflare api returned status %d: %d: %s", e.Status, e.Errors[0].Code, e.Errors[0].Message)
+}
+
+// do - Send a request to the API, decoding the result of the response into out when it is not nil
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	// Every response is an envelope with success, errors and the result
+	var envelope struct {
+		Success bool            `json:"success"`
+		Result  json.RawMessage `json:"result"`
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, &envelope); err != nil || resp.StatusCode >= 300 || !envelope.Success {
+		apiErr := &Error{Status: resp.StatusCode}
+		json.Unmarshal(data, apiErr)
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(envelope.Result, out)
+}
+
+// Pool - The origin pool with the given id
+func (c *Client) Pool(ctx context.Context, accountID string, poolID string) (*Pool, error) {
+
+	var pool Pool
+	if err := c.do(ctx, http.MethodGet, "/accounts/"+accountID+"/load_balancers/pools/"+poolID, nil, &pool); err != nil {
+		return nil, err
+	}
+
+	return &pool, nil
+}
+
+// SetOrigins - Replace the origins of the pool, the other settings of the pool are kept
+func (c *Client) SetOrigins(ctx context.Context, accountID string, poolID string, origins []Origin) error {
+
+	if origins == nil {
+		origins = []Origin{}
+	}
+
+	body := map[string]interface{}{"origins": origins}
+
+	return c.do(ctx, http.MethodPatch, "/accounts/"+accountID+"/load_balancers/pools/"+poolID, body, nil)
+}
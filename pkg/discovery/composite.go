@@ -0,0 +1,106 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Set operations of the terms of a Composite
+const (
+	Union        = '+'
+	Intersection = '&'
+	Difference   = '-'
+)
+
+// Composite combines the nodes of several sources with set operations, for example the cluster nodes minus a
+// node pool plus the bastion hosts from DNS.  Nodes are the same node when they have the same address.
+type Composite struct {
+	// Sources are queried once per query, however many terms use them
+	Sources []Source
+
+	// Terms are applied in order, the operation of the first term is ignored
+	Terms []Term
+}
+
+// Term is a source of a Composite, narrowed by a filter, and how it combines with the terms before it
+type Term struct {
+	Op     byte
+	Source int
+
+	// Filter keeps the nodes of the source it returns true for, every node when nil
+	Filter func(Node) bool
+
+	// Name tells the term in the logs
+	Name string
+}
+
+// Nodes - The nodes of the terms combined in order.  Any source failing fails the query.
+func (c Composite) Nodes(ctx context.Context) ([]Node, error) {
+
+	results := make([][]Node, len(c.Sources))
+	queried := make([]bool, len(c.Sources))
+
+	var nodes []Node
+
+	for i, t := range c.Terms {
+		if t.Source < 0 || t.Source >= len(c.Sources) {
+			return nil, fmt.Errorf("term %s has no source", t.Name)
+		}
+
+		if !queried[t.Source] {
+			found, err := c.Sources[t.Source].Nodes(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", t.Name, err)
+			}
+			results[t.Source] = found
+			queried[t.Source] = true
+		}
+
+		var term []Node
+		for _, n := range results[t.Source] {
+			if t.Filter == nil || t.Filter(n) {
+				term = append(term, n)
+			}
+		}
+
+		if i == 0 {
+			nodes = term
+			continue
+		}
+
+		switch t.Op {
+		case Union:
+			nodes = append(nodes, keep(term, nodes, false)...)
+		case Intersection:
+			nodes = keep(nodes, term, true)
+		case Difference:
+			nodes = keep(nodes, term, false)
+		default:
+			return nil, fmt.Errorf("term %s has an unknown operation %c", t.Name, t.Op)
+		}
+
+		log.Debug().Msgf("%d nodes after %c %s", len(nodes), t.Op, t.Name)
+	}
+
+	return Normalize(nodes), nil
+}
+
+// keep - The nodes whose address is in the other nodes, or is not when in is false
+func keep(nodes []Node, other []Node, in bool) []Node {
+
+	addresses := make(map[string]bool)
+	for _, n := range other {
+		addresses[n.IP.String()] = true
+	}
+
+	var kept []Node
+	for _, n := range nodes {
+		if addresses[n.IP.String()] == in {
+			kept = append(kept, n)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,386 @@
+// Package discovery finds the Kubernetes nodes the linode tools keep their configuration in sync with.
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CalicoAnnotation is the annotation calico uses to publish the node address
+const CalicoAnnotation = "projectcalico.org/IPv4Address"
+
+// CiliumAnnotation is the annotation cilium uses to publish the node address
+const CiliumAnnotation = "io.cilium.network.ipv4"
+
+// Node is a cluster node that is available to receive traffic
+type Node struct {
+	Name string
+	IP   net.IP
+
+	// Unschedulable is set when the node is cordoned
+	Unschedulable bool
+	Taints        []Taint
+
+	Annotations map[string]string
+	Labels      map[string]string
+
+	// Addresses are every address of the node known to the source, and Networks the address of the
+	// node on each Linode network (public, private, vlan, vpc) when it is known
+	Addresses []net.IP
+	Networks  map[string]net.IP
+}
+
+// Taint is a taint on a node, for example a maintenance taint set before a node is drained
+type Taint struct {
+	Key    string
+	Value  string
+	Effect string
+}
+
+func (t Taint) String() string {
+	return t.Key + "=" + t.Value + ":" + t.Effect
+}
+
+// state - The parts of a node that are compared between queries
+func (n Node) state() string {
+
+	state := n.IP.String()
+	if n.Unschedulable {
+		state += " unschedulable"
+	}
+	for _, t := range n.Taints {
+		state += " " + t.String()
+	}
+
+	var keys []string
+	for k := range n.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		state += " " + k + "=" + n.Annotations[k]
+	}
+
+	keys = nil
+	for k := range n.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		state += " label " + k + "=" + n.Labels[k]
+	}
+
+	return state
+}
+
+// Source finds the nodes the tools keep their configuration in sync with.  The API calls of a query are
+// cancelled when ctx is done.
+type Source interface {
+	Nodes(ctx context.Context) ([]Node, error)
+}
+
+// Kubernetes discovers the nodes through the Kubernetes API using a kubeconfig file
+type Kubernetes struct {
+	Kubeconfig string
+
+	// PreferPrivate uses the private Linode address from the node addresses when the node has one
+	PreferPrivate bool
+
+	// AddressAnnotations are the annotations holding the node address, tried in order.  Nodes without
+	// any of them are skipped.  Defaults to the calico annotation.
+	AddressAnnotations []string
+}
+
+// Nodes - The nodes of the cluster that have an address assigned
+func (k Kubernetes) Nodes(ctx context.Context) ([]Node, error) {
+
+	// use the current context in kubeconfig
+	config, err := RESTConfig(k.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the clientset
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return kubeNodes(ctx, clientset, k.PreferPrivate, k.AddressAnnotations)
+}
+
+// Client discovers the nodes through a clientset that is already built, for example the fake clientset
+// of client-go when exercising the tools without a cluster
+type Client struct {
+	Clientset kubernetes.Interface
+
+	PreferPrivate      bool
+	AddressAnnotations []string
+}
+
+// Nodes - The nodes of the cluster that have an address assigned
+func (c Client) Nodes(ctx context.Context) ([]Node, error) {
+	return kubeNodes(ctx, c.Clientset, c.PreferPrivate, c.AddressAnnotations)
+}
+
+// KubeNodes - Query kubernetes for the nodes that have an address assigned
+func KubeNodes(ctx context.Context, kubeconfig string) ([]Node, error) {
+	return Kubernetes{Kubeconfig: kubeconfig}.Nodes(ctx)
+}
+
+// kubeNodes - Query kubernetes for the nodes, using the address from the first of the annotations
+// the node has or the private address of the node when private addresses are preferred
+func kubeNodes(ctx context.Context, clientset kubernetes.Interface, preferPrivate bool, annotations []string) ([]Node, error) {
+
+	if len(annotations) == 0 {
+		annotations = []string{CalicoAnnotation}
+	}
+
+	var results []Node
+
+	nodes, err := listNodes(ctx, clientset)
+	if err != nil {
+		return results, err
+	}
+
+	for _, val := range nodes {
+		if strIP, ok := addressAnnotation(val.Annotations, annotations); ok {
+
+			IPAddress := net.ParseIP(strings.Split(strIP, "/")[0])
+
+			if preferPrivate {
+				var ips []net.IP
+				for _, a := range val.Status.Addresses {
+					if ip := net.ParseIP(a.Address); ip != nil && linodePrivate.Contains(ip) {
+						ips = append(ips, ip)
+					}
+				}
+				if len(ips) > 0 {
+					IPAddress = ips[0]
+				}
+			}
+
+			log.Info().Msgf("found node: %s %s", val.Name, IPAddress.String())
+
+			results = append(results, fromKube(val, IPAddress))
+		}
+	}
+	log.Info().Msgf("There are %d nodes in the cluster, of which %d are available", len(nodes), len(results))
+
+	return Normalize(results), nil
+}
+
+// addressAnnotation - The value of the first of the keys set in the annotations
+func addressAnnotation(annotations map[string]string, keys []string) (string, bool) {
+
+	for _, key := range keys {
+		if value, ok := annotations[key]; ok && value != "" {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// listNodes - List every node of the cluster
+func listNodes(ctx context.Context, clientset kubernetes.Interface) ([]corev1.Node, error) {
+
+	log.Info().Msg("querying kubernetes for node list")
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes.Items, nil
+}
+
+// fromKube - The node for a kubernetes node reachable on the given address
+func fromKube(val corev1.Node, ip net.IP) Node {
+
+	node := Node{Name: val.Name, IP: ip, Unschedulable: val.Spec.Unschedulable, Annotations: val.Annotations, Labels: val.Labels}
+	node.addAddress("", ip)
+	node.addKubeAddresses(val.Status.Addresses)
+	for _, t := range val.Spec.Taints {
+		node.Taints = append(node.Taints, Taint{Key: t.Key, Value: t.Value, Effect: string(t.Effect)})
+	}
+
+	return node
+}
+
+// Normalize - Put the nodes in a canonical form so the order the API returns them in does not matter:
+// addresses are stored in their shortest form, nodes without a valid address are dropped and the
+// nodes are sorted by address with duplicate addresses removed
+func Normalize(nodes []Node) []Node {
+
+	var results []Node
+
+	for _, n := range nodes {
+		if n.IP == nil {
+			log.Warn().Msgf("node %s has no valid address, skipping it", n.Name)
+			continue
+		}
+		if ip4 := n.IP.To4(); ip4 != nil {
+			n.IP = ip4
+		}
+		results = append(results, n)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if c := bytes.Compare(results[i].IP.To16(), results[j].IP.To16()); c != 0 {
+			return c < 0
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	var unique []Node
+	for _, n := range results {
+		if len(unique) > 0 && unique[len(unique)-1].IP.Equal(n.IP) {
+			log.Warn().Msgf("node %s has the same address %s as node %s, skipping it", n.Name, n.IP, unique[len(unique)-1].Name)
+			continue
+		}
+		unique = append(unique, n)
+	}
+
+	return unique
+}
+
+// IPs - The addresses of the nodes
+func IPs(nodes []Node) []net.IP {
+
+	var ips []net.IP
+	for _, n := range nodes {
+		ips = append(ips, n.IP)
+	}
+
+	return ips
+}
+
+// Changed - Check if any node was added, removed, changed address, was cordoned or tainted, or had its
+// annotations or labels changed since the last query
+func Changed(oldNodes []Node, newNodes []Node) bool {
+
+	log.Info().Msg("checking if differences exist from last node query")
+
+	if len(oldNodes) != len(newNodes) {
+		log.Info().Msgf("node count changed from %d to %d", len(oldNodes), len(newNodes))
+		return true
+	}
+
+	known := make(map[string]string)
+	for _, n := range oldNodes {
+		known[n.Name] = n.state()
+	}
+
+	for _, n := range newNodes {
+		if state, ok := known[n.Name]; !ok || state != n.state() {
+			log.Info().Msgf("node %s changed", n.Name)
+			return true
+		}
+	}
+
+	log.Info().Msg("no changes detected in kubernetes nodes")
+
+	return false
+}
+
+// Diff - The nodes added, removed, or changed in address, scheduling, taints, annotations or labels since
+// the last query, matched by name
+func Diff(oldNodes []Node, newNodes []Node) (added []Node, removed []Node, updated []Node) {
+
+	known := make(map[string]Node)
+	for _, n := range oldNodes {
+		known[n.Name] = n
+	}
+
+	seen := make(map[string]bool)
+	for _, n := range newNodes {
+		seen[n.Name] = true
+
+		old, ok := known[n.Name]
+		switch {
+		case !ok:
+			added = append(added, n)
+		case old.state() != n.state():
+			updated = append(updated, n)
+		}
+	}
+
+	for _, n := range oldNodes {
+		if !seen[n.Name] {
+			removed = append(removed, n)
+		}
+	}
+
+	return added, removed, updated
+}
+
+// Poll - Query the source for nodes every interval and call apply whenever the nodes changed, or
+// when something is sent on force.  Sending on force also ends the wait for the next interval, so the
+// nodes are queried and applied right away.  When apply fails the nodes are applied again on the next
+// poll.
+func Poll(source Source, interval time.Duration, force <-chan struct{}, apply func([]Node) error) {
+
+	Watch(context.Background(), source, interval, force, func(oldNodes []Node, newNodes []Node) error {
+		return apply(newNodes)
+	})
+}
+
+// Watch - Like Poll, but apply is given the nodes of the last successful apply along with the new
+// nodes, and the loop ends when the context is done.  The queries of the source are cancelled with it.
+func Watch(ctx context.Context, source Source, interval time.Duration, force <-chan struct{}, apply func(oldNodes []Node, newNodes []Node) error) error {
+
+	// Track changes in the list
+	var oldNodes []Node
+
+	forced := false
+
+	for {
+
+		newNodes, err := source.Nodes(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to query kubernetes nodes")
+		} else {
+
+			select {
+			case <-force:
+				forced = true
+			default:
+			}
+
+			if Changed(oldNodes, newNodes) || forced {
+				forced = false
+
+				if err := apply(oldNodes, newNodes); err != nil {
+					log.Error().Err(err).Msg("unable to apply node changes")
+				} else {
+					// Reset for the next iteration
+					oldNodes = newNodes
+				}
+			} else {
+				oldNodes = newNodes
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-force:
+			log.Info().Msg("reconcile requested")
+			forced = true
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChanged(t *testing.T) {
+
+	base := func() Node {
+		return Node{
+			Name:        "node-1",
+			IP:          net.ParseIP("10.0.0.1"),
+			Annotations: map[string]string{CalicoAnnotation: "10.0.0.1/32"},
+			Labels:      map[string]string{"lke.linode.com/pool-id": "1"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(n *Node)
+		changed bool
+	}{
+		{name: "unchanged", change: func(n *Node) {}},
+		{name: "label maps rebuilt", change: func(n *Node) { n.Labels = map[string]string{"lke.linode.com/pool-id": "1"} }},
+		{name: "address", change: func(n *Node) { n.IP = net.ParseIP("10.0.0.2") }, changed: true},
+		{name: "cordoned", change: func(n *Node) { n.Unschedulable = true }, changed: true},
+		{name: "tainted", change: func(n *Node) { n.Taints = []Taint{{Key: "maintenance", Effect: "NoSchedule"}} }, changed: true},
+		{name: "annotation", change: func(n *Node) { n.Annotations["weight"] = "2" }, changed: true},
+		{name: "canary label added", change: func(n *Node) { n.Labels["canary"] = "true" }, changed: true},
+		{name: "pool label changed", change: func(n *Node) { n.Labels["lke.linode.com/pool-id"] = "2" }, changed: true},
+		{name: "label removed", change: func(n *Node) { n.Labels = nil }, changed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			updated := base()
+			tt.change(&updated)
+
+			if got := Changed([]Node{base()}, []Node{updated}); got != tt.changed {
+				t.Errorf("Changed returned %v, expected %v", got, tt.changed)
+			}
+
+			_, _, diff := Diff([]Node{base()}, []Node{updated})
+			if (len(diff) > 0) != tt.changed {
+				t.Errorf("Diff updated %d nodes", len(diff))
+			}
+		})
+	}
+}
+
+// TestNodesContext checks a query is cancelled with its context instead of waiting for a hung endpoint
+func TestNodesContext(t *testing.T) {
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := (&Guard{Source: &Static{Location: server.URL}}).Nodes(ctx); err == nil {
+		t.Fatal("the query of a hung endpoint succeeded")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the query returned after %s, expected it to be cancelled", elapsed)
+	}
+}
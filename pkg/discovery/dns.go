@@ -0,0 +1,138 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DNS discovers hosts tracked in DNS rather than in a cluster, such as the members of a replica set, by
+// resolving names on every query.  A name is resolved to its A and AAAA records, or to the records of a type
+// given before it as in a:db.example.com.  A name starting with an underscore, or given as
+// srv:_mongodb._tcp.example.com, is an SRV record whose targets are resolved in turn.
+type DNS struct {
+	Names []string
+
+	// Resolver resolves the names, the resolver of the host when nil
+	Resolver *net.Resolver
+
+	// Timeout bounds the lookups of a query, 10 seconds when zero
+	Timeout time.Duration
+}
+
+// ParseDNSNames - The names of a comma separated list, checking the record types given
+func ParseDNSNames(value string) ([]string, error) {
+
+	var names []string
+
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		if i := strings.Index(name, ":"); i >= 0 {
+			switch strings.ToLower(name[:i]) {
+			case "a", "aaaa", "srv":
+			default:
+				return nil, fmt.Errorf("dns name %s has an unknown record type, use a, aaaa or srv", name)
+			}
+		}
+
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no dns names given")
+	}
+
+	return names, nil
+}
+
+// Nodes - A node for every address the names resolve to, named after the host it belongs to.  A host with
+// several addresses has a node per address, named after the host and the address.  Any name failing to
+// resolve fails the query, so a DNS outage does not remove the hosts.
+func (d DNS) Nodes(ctx context.Context) ([]Node, error) {
+
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	var results []Node
+
+	for _, name := range d.Names {
+		kind, host := "", name
+		if i := strings.Index(name, ":"); i >= 0 {
+			kind, host = strings.ToLower(name[:i]), name[i+1:]
+		} else if strings.HasPrefix(name, "_") {
+			kind = "srv"
+		}
+
+		hosts := []string{host}
+
+		if kind == "srv" {
+			_, records, err := resolver.LookupSRV(ctx, "", "", host)
+			if err != nil {
+				return nil, fmt.Errorf("resolving the srv records of %s: %w", host, err)
+			}
+
+			hosts = nil
+			for _, r := range records {
+				hosts = append(hosts, strings.TrimSuffix(r.Target, "."))
+			}
+			kind = ""
+		}
+
+		for _, h := range hosts {
+			ips, err := lookupHost(ctx, resolver, kind, h)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, ip := range ips {
+				node := Node{Name: h, IP: ip}
+				if len(ips) > 1 {
+					node.Name = h + "-" + ip.String()
+				}
+
+				log.Info().Msgf("found node: %s %s", node.Name, ip)
+				results = append(results, node)
+			}
+		}
+	}
+
+	log.Info().Msgf("There are %d addresses behind %d dns names", len(results), len(d.Names))
+
+	return Normalize(results), nil
+}
+
+// lookupHost - The addresses of a host, of the A or AAAA records alone when the kind is a or aaaa
+func lookupHost(ctx context.Context, resolver *net.Resolver, kind string, host string) ([]net.IP, error) {
+
+	network := "ip"
+	switch kind {
+	case "a":
+		network = "ip4"
+	case "aaaa":
+		network = "ip6"
+	}
+
+	ips, err := resolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", host, err)
+	}
+
+	return ips, nil
+}
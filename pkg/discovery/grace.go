@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Grace keeps a node that is missing from the nodes of the source until it has been missing from Polls
+// queries in a row, to ride out flapping such as the calico annotation disappearing while the CNI restarts
+type Grace struct {
+	Source Source
+
+	// Polls is the number of queries in a row a node must be missing from before it is removed, 1 or
+	// less removes it right away
+	Polls int
+
+	last    []Node
+	missing map[string]int
+}
+
+// Nodes - The nodes of the source, with the nodes missing for fewer than Polls queries kept as they were
+func (g *Grace) Nodes(ctx context.Context) ([]Node, error) {
+
+	nodes, err := g.Source.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.missing == nil {
+		g.missing = make(map[string]int)
+	}
+
+	seen := make(map[string]bool)
+	for _, n := range nodes {
+		seen[n.Name] = true
+		delete(g.missing, n.Name)
+	}
+
+	for _, n := range g.last {
+		if seen[n.Name] {
+			continue
+		}
+
+		g.missing[n.Name]++
+		if g.missing[n.Name] >= g.Polls {
+			log.Info().Msgf("node %s has been missing for %d queries, removing it", n.Name, g.missing[n.Name])
+			delete(g.missing, n.Name)
+			continue
+		}
+
+		log.Info().Msgf("node %s is missing, keeping it until it has been missing for %d queries (%d so far)", n.Name, g.Polls, g.missing[n.Name])
+		nodes = append(nodes, n)
+	}
+
+	g.last = nodes
+
+	return nodes, nil
+}
@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Guard protects against a source that briefly returns too few nodes, for example an empty node
+// list from an API hiccup or an RBAC error, which would strip every address and lock out the cluster.
+// A query is refused when it has fewer than MinNodes nodes, or when it removes more than
+// MaxRemovePercent of the nodes of the last accepted query.  Force accepts every query.
+type Guard struct {
+	Source Source
+
+	MinNodes         int
+	MaxRemovePercent int
+	Force            bool
+
+	last []Node
+}
+
+// Nodes - The nodes of the source, or an error when the query looks unsafe to apply
+func (g *Guard) Nodes(ctx context.Context) ([]Node, error) {
+
+	nodes, err := g.Source.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.check(nodes); err != nil {
+		if !g.Force {
+			return nil, err
+		}
+		log.Warn().Err(err).Msg("applying the nodes anyway as -force is set")
+	}
+
+	g.last = nodes
+
+	return nodes, nil
+}
+
+// check - Whether the nodes are safe to apply after the last accepted nodes
+func (g *Guard) check(nodes []Node) error {
+
+	if len(nodes) < g.MinNodes {
+		return fmt.Errorf("refusing %d nodes, fewer than the minimum of %d", len(nodes), g.MinNodes)
+	}
+
+	if len(g.last) == 0 || g.MaxRemovePercent <= 0 {
+		return nil
+	}
+
+	_, removed, _ := Diff(g.last, nodes)
+	if percent := len(removed) * 100 / len(g.last); percent > g.MaxRemovePercent {
+		return fmt.Errorf("refusing to remove %d of %d nodes (%d%%) at once, more than %d%%", len(removed), len(g.last), percent, g.MaxRemovePercent)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package discovery
+
+import (
+	"context"
+	"net"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/pkg/linode"
+)
+
+// LinodeTag discovers the Linodes carrying a tag through the Linode API, so the tools work
+// without access to the Kubernetes API
+type LinodeTag struct {
+	Client *linode.Client
+	Tag    string
+
+	// PreferPrivate uses the private address of the Linodes, falling back to the public one
+	PreferPrivate bool
+
+	// Interfaces fetches the VLAN and VPC addresses of the Linodes, one API call per Linode
+	Interfaces bool
+}
+
+// Nodes - The Linodes with the tag, named after their label
+func (t LinodeTag) Nodes(ctx context.Context) ([]Node, error) {
+
+	log.Info().Msgf("querying linode for instances tagged %s", t.Tag)
+
+	instances, err := t.Client.Instances(ctx, map[string]interface{}{"tags": t.Tag})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Node
+
+	for _, instance := range instances {
+		ip := instanceIP(instance, t.PreferPrivate)
+		if ip == nil {
+			log.Warn().Msgf("linode %s has no ipv4 address", instance.Label)
+			continue
+		}
+
+		log.Info().Msgf("found node: %s %s", instance.Label, ip)
+		node := Node{Name: instance.Label, IP: ip}
+		node.addInstanceAddresses(instance, instanceConfigs(ctx, t.Client, instance, t.Interfaces))
+		results = append(results, node)
+	}
+
+	log.Info().Msgf("There are %d linodes tagged %s", len(results), t.Tag)
+
+	return Normalize(results), nil
+}
+
+// linodePrivate is the network Linode assigns private addresses from
+var linodePrivate = &net.IPNet{IP: net.IPv4(192, 168, 128, 0), Mask: net.CIDRMask(17, 32)}
+
+// instanceIP - The address of a Linode, public unless private addresses are preferred
+func instanceIP(instance linode.Instance, preferPrivate bool) net.IP {
+
+	var ips []net.IP
+	for _, v := range instance.IPv4 {
+		if ip := net.ParseIP(v); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return pickIP(ips, preferPrivate)
+}
+
+// pickIP - The first public address, or the first private address when private addresses are preferred.
+// Falls back to the first address when none is on the preferred network.
+func pickIP(ips []net.IP, preferPrivate bool) net.IP {
+
+	for _, ip := range ips {
+		if linodePrivate.Contains(ip) == preferPrivate {
+			return ip
+		}
+	}
+
+	if len(ips) > 0 {
+		return ips[0]
+	}
+
+	return nil
+}
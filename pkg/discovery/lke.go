@@ -0,0 +1,124 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rsvancara/linode-tools/pkg/linode"
+)
+
+// LKE discovers the nodes of an LKE cluster through the Linode API, without a kubeconfig file.
+// The addresses are taken from the Linodes in the node pools of the cluster.  The kubeconfig is
+// fetched from the API as well to add the taints and annotations of the nodes.
+type LKE struct {
+	Client    *linode.Client
+	ClusterID int
+
+	// PreferPrivate uses the private address of the Linodes, falling back to the public one
+	PreferPrivate bool
+
+	// Interfaces fetches the VLAN and VPC addresses of the Linodes, one API call per Linode
+	Interfaces bool
+
+	clientset kubernetes.Interface
+}
+
+// Nodes - The Linodes in the node pools of the cluster
+func (l *LKE) Nodes(ctx context.Context) ([]Node, error) {
+
+	log.Info().Msgf("querying linode for the nodes of lke cluster %d", l.ClusterID)
+
+	pools, err := l.Client.LKEPools(ctx, l.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := l.Client.Instances(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]linode.Instance)
+	for _, i := range instances {
+		byID[i.ID] = i
+	}
+
+	var results []Node
+
+	for _, pool := range pools {
+		for _, n := range pool.Nodes {
+			instance, ok := byID[n.InstanceID]
+			if !ok {
+				log.Warn().Msgf("node %s of pool %d has no linode yet", n.ID, pool.ID)
+				continue
+			}
+
+			ip := instanceIP(instance, l.PreferPrivate)
+			if ip == nil {
+				log.Warn().Msgf("linode %s has no ipv4 address", instance.Label)
+				continue
+			}
+
+			log.Info().Msgf("found node: %s %s", instance.Label, ip)
+			node := Node{Name: instance.Label, IP: ip}
+			node.addInstanceAddresses(instance, instanceConfigs(ctx, l.Client, instance, l.Interfaces))
+			results = append(results, node)
+		}
+	}
+
+	if err := l.addKubeDetails(ctx, results); err != nil {
+		log.Warn().Err(err).Msgf("unable to read the node details of lke cluster %d, using the linode addresses only", l.ClusterID)
+	}
+
+	log.Info().Msgf("There are %d nodes in lke cluster %d", len(results), l.ClusterID)
+
+	return Normalize(results), nil
+}
+
+// addKubeDetails - Add the taints and annotations of the kubernetes nodes, the nodes are named after their Linode
+func (l *LKE) addKubeDetails(ctx context.Context, nodes []Node) error {
+
+	if l.clientset == nil {
+		kubeconfig, err := l.Client.LKEKubeconfig(ctx, l.ClusterID)
+		if err != nil {
+			return fmt.Errorf("fetching kubeconfig: %w", err)
+		}
+
+		config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+		if err != nil {
+			return fmt.Errorf("parsing kubeconfig: %w", err)
+		}
+
+		clientset, err := kubernetes.NewForConfig(withClientSettings(config))
+		if err != nil {
+			return err
+		}
+
+		l.clientset = clientset
+	}
+
+	kubeNodes, err := listNodes(ctx, l.clientset)
+	if err != nil {
+		return err
+	}
+
+	for i, n := range nodes {
+		for _, val := range kubeNodes {
+			if val.Name == n.Name {
+				// Keep the addresses of the Linode, including its VLAN and VPC interfaces
+				merged := fromKube(val, n.IP)
+				merged.Addresses, merged.Networks = n.Addresses, n.Networks
+				merged.addKubeAddresses(val.Status.Addresses)
+				nodes[i] = merged
+				break
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LoadBalancers discovers the external ingress addresses of the LoadBalancer services instead of the
+// nodes, such as the Linode NodeBalancers created for the cluster, so they can be allowed to reach
+// backend databases.  Each address is a node named service.namespace, with the service annotations.
+type LoadBalancers struct {
+	Kubeconfig string
+
+	// Namespaces are listed one by one, every namespace when empty
+	Namespaces []string
+}
+
+// Nodes - The ingress addresses of the LoadBalancer services
+func (l LoadBalancers) Nodes(ctx context.Context) ([]Node, error) {
+
+	config, err := RESTConfig(l.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := l.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var results []Node
+
+	for _, ns := range namespaces {
+		services, err := clientset.CoreV1().Services(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, svc := range services.Items {
+			results = append(results, ingressNodes(svc)...)
+		}
+	}
+
+	log.Info().Msgf("There are %d load balancer ingress addresses", len(results))
+
+	return Normalize(results), nil
+}
+
+// ingressNodes - A node per ingress address of a LoadBalancer service, a service with several addresses
+// gets a numbered name for each address after the first
+func ingressNodes(svc corev1.Service) []Node {
+
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return nil
+	}
+
+	var nodes []Node
+
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		ip := net.ParseIP(ingress.IP)
+		if ip == nil {
+			if ingress.Hostname != "" {
+				log.Warn().Msgf("load balancer %s/%s has a hostname ingress %s, only addresses are used", svc.Namespace, svc.Name, ingress.Hostname)
+			}
+			continue
+		}
+
+		name := svc.Name + "." + svc.Namespace
+		if len(nodes) > 0 {
+			name = fmt.Sprintf("%s-%d", name, len(nodes)+1)
+		}
+
+		log.Info().Msgf("found load balancer: %s %s", name, ip)
+
+		nodes = append(nodes, Node{Name: name, IP: ip, Annotations: svc.Annotations})
+	}
+
+	return nodes
+}
@@ -0,0 +1,132 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Multi merges the nodes of several clusters.  Every cluster is queried by its own goroutine and the
+// nodes are the union of the last known nodes of each cluster, so a cluster that cannot be reached
+// keeps its nodes instead of having them removed.
+type Multi struct {
+	mu       sync.Mutex
+	clusters []*cluster
+	started  bool
+}
+
+// cluster is one source of a Multi with its last known nodes
+type cluster struct {
+	name   string
+	source Source
+
+	nodes []Node
+	err   error
+	known bool
+}
+
+// NewMulti - Merge the nodes of the named sources
+func NewMulti(sources map[string]Source) *Multi {
+
+	m := &Multi{}
+	for name, source := range sources {
+		m.clusters = append(m.clusters, &cluster{name: name, source: source})
+	}
+
+	return m
+}
+
+// Start - Query every cluster once, then keep querying each in its own goroutine at the interval
+// until the context is done
+func (m *Multi) Start(ctx context.Context, interval time.Duration) {
+
+	m.queryAll(ctx)
+
+	m.mu.Lock()
+	m.started = true
+	m.mu.Unlock()
+
+	for _, c := range m.clusters {
+		go func(c *cluster) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(interval):
+				}
+
+				m.query(ctx, c)
+			}
+		}(c)
+	}
+}
+
+// queryAll - Query every cluster concurrently and wait for the answers
+func (m *Multi) queryAll(ctx context.Context) {
+
+	var wg sync.WaitGroup
+	for _, c := range m.clusters {
+		wg.Add(1)
+		go func(c *cluster) {
+			defer wg.Done()
+			m.query(ctx, c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// query - Refresh the nodes of a cluster, keeping the last known nodes when the cluster fails
+func (m *Multi) query(ctx context.Context, c *cluster) {
+
+	nodes, err := c.source.Nodes(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		if c.known {
+			log.Warn().Err(err).Msgf("unable to query cluster %s, keeping its %d last known nodes", c.name, len(c.nodes))
+		} else {
+			log.Error().Err(err).Msgf("unable to query cluster %s", c.name)
+		}
+		c.err = err
+		return
+	}
+
+	c.nodes, c.err, c.known = nodes, nil, true
+}
+
+// Nodes - The union of the last known nodes of every cluster.  Without Start the clusters are queried
+// in place.  It fails only until every cluster has answered once, so a cluster that was never reached
+// cannot drop its nodes from the configuration.
+func (m *Multi) Nodes(ctx context.Context) ([]Node, error) {
+
+	m.mu.Lock()
+	started := m.started
+	m.mu.Unlock()
+
+	if !started {
+		m.queryAll(ctx)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var nodes []Node
+	for _, c := range m.clusters {
+		if !c.known {
+			if c.err != nil {
+				return nil, fmt.Errorf("cluster %s has not been reached yet: %w", c.name, c.err)
+			}
+			return nil, fmt.Errorf("cluster %s has not been reached yet", c.name)
+		}
+		nodes = append(nodes, c.nodes...)
+	}
+
+	log.Info().Msgf("There are %d nodes in %d clusters", len(nodes), len(m.clusters))
+
+	return Normalize(nodes), nil
+}
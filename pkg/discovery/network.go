@@ -0,0 +1,195 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rsvancara/linode-tools/pkg/linode"
+)
+
+// The Linode networks a node address can be selected from
+const (
+	NetworkPublic  = "public"
+	NetworkPrivate = "private"
+	NetworkVLAN    = "vlan"
+	NetworkVPC     = "vpc"
+)
+
+// Network selects the address of each node on one network, so that for example a firewall allows the
+// public addresses while the nginx upstreams use the VLAN addresses of the same nodes.  The network is
+// public, private, vlan, vpc or a CIDR the address must be in.  A node without an address on the
+// network keeps the address of the source.
+type Network struct {
+	Source
+	Network string
+}
+
+// Nodes - The nodes of the source with their address on the network
+func (n Network) Nodes(ctx context.Context) ([]Node, error) {
+
+	nodes, err := n.Source.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, cidr, _ := net.ParseCIDR(n.Network)
+
+	var results []Node
+	for _, node := range nodes {
+		var ip net.IP
+		if cidr != nil {
+			for _, a := range node.Addresses {
+				if cidr.Contains(a) {
+					ip = a
+					break
+				}
+			}
+		} else {
+			ip = node.Networks[n.Network]
+		}
+
+		if ip == nil {
+			log.Warn().Msgf("node %s has no address on %s, using %s", node.Name, n.Network, node.IP)
+		} else {
+			node.IP = ip
+		}
+
+		results = append(results, node)
+	}
+
+	return Normalize(results), nil
+}
+
+// ValidNetwork - Check a network is one of the Linode networks or a CIDR
+func ValidNetwork(network string) error {
+
+	switch network {
+	case NetworkPublic, NetworkPrivate, NetworkVLAN, NetworkVPC:
+		return nil
+	}
+
+	if _, _, err := net.ParseCIDR(network); err != nil {
+		return fmt.Errorf("unknown network %s, use public, private, vlan, vpc or a CIDR", network)
+	}
+
+	return nil
+}
+
+// NeedsInterfaces - Whether selecting the network needs the interfaces of the Linodes, which cost an
+// API call per Linode
+func NeedsInterfaces(network string) bool {
+	return network != "" && network != NetworkPublic && network != NetworkPrivate
+}
+
+// addAddress - Record an address of a node, on the network when it is known
+func (n *Node) addAddress(network string, ip net.IP) {
+
+	if ip == nil {
+		return
+	}
+
+	if !containsIP(n.Addresses, ip) {
+		n.Addresses = append(n.Addresses, ip)
+	}
+
+	if network == "" {
+		if linodePrivate.Contains(ip) {
+			network = NetworkPrivate
+		} else if !isPrivate(ip) {
+			network = NetworkPublic
+		}
+	}
+
+	if network != "" {
+		if n.Networks == nil {
+			n.Networks = make(map[string]net.IP)
+		}
+		if _, ok := n.Networks[network]; !ok {
+			n.Networks[network] = ip
+		}
+	}
+}
+
+// addKubeAddresses - Record the addresses kubernetes reports for a node
+func (n *Node) addKubeAddresses(addresses []corev1.NodeAddress) {
+
+	for _, a := range addresses {
+		if a.Type == corev1.NodeInternalIP || a.Type == corev1.NodeExternalIP {
+			n.addAddress("", net.ParseIP(a.Address))
+		}
+	}
+}
+
+// addInstanceAddresses - Record the addresses of a Linode, with those of its VLAN and VPC interfaces when
+// the configs are given
+func (n *Node) addInstanceAddresses(instance linode.Instance, configs []linode.Config) {
+
+	for _, v := range instance.IPv4 {
+		n.addAddress("", net.ParseIP(v))
+	}
+
+	for _, c := range configs {
+		for _, i := range c.Interfaces {
+			switch i.Purpose {
+			case NetworkVLAN:
+				n.addAddress(NetworkVLAN, net.ParseIP(strings.Split(i.IPAMAddress, "/")[0]))
+			case NetworkVPC:
+				n.addAddress(NetworkVPC, net.ParseIP(i.IPv4.VPC))
+			}
+		}
+	}
+}
+
+// instanceConfigs - The configs of a Linode when its interfaces are needed, nil when they are not or
+// cannot be fetched
+func instanceConfigs(ctx context.Context, client *linode.Client, instance linode.Instance, interfaces bool) []linode.Config {
+
+	if !interfaces {
+		return nil
+	}
+
+	configs, err := client.InstanceConfigs(ctx, instance.ID)
+	if err != nil {
+		log.Warn().Err(err).Msgf("unable to fetch the interfaces of linode %s", instance.Label)
+		return nil
+	}
+
+	return configs
+}
+
+// containsIP - Whether the address is in the list
+func containsIP(ips []net.IP, ip net.IP) bool {
+
+	for _, a := range ips {
+		if a.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RFC 1918 networks, neither public nor on the Linode private network
+var rfc1918 = []*net.IPNet{
+	{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)},
+	{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)},
+}
+
+// isPrivate - Whether the address is in an RFC 1918 network
+func isPrivate(ip net.IP) bool {
+
+	for _, n := range rfc1918 {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
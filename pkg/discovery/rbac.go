@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RBAC - The least privileged RBAC objects for a service account of the tools: a ClusterRole to list
+// the nodes, and with services set a Role to list and watch the services in each namespace, or a
+// ClusterRole rule when no namespaces are given.
+func RBAC(name string, serviceAccount string, namespace string, services bool, namespaces []string) string {
+
+	var docs []string
+
+	subject := fmt.Sprintf(`subjects:
+  - kind: ServiceAccount
+    name: %s
+    namespace: %s`, serviceAccount, namespace)
+
+	docs = append(docs, fmt.Sprintf(`apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: %[1]s
+rules:
+  - apiGroups: [""]
+    resources: ["nodes"]
+    verbs: ["list"]`, name))
+
+	if services && len(namespaces) == 0 {
+		docs[0] += `
+  - apiGroups: [""]
+    resources: ["services"]
+    verbs: ["list", "watch"]`
+	}
+
+	docs = append(docs, fmt.Sprintf(`apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: %[1]s
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: %[1]s
+%[2]s`, name, subject))
+
+	if services {
+		for _, ns := range namespaces {
+			docs = append(docs, fmt.Sprintf(`apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: %[1]s
+  namespace: %[2]s
+rules:
+  - apiGroups: [""]
+    resources: ["services"]
+    verbs: ["list", "watch"]`, name, ns))
+
+			docs = append(docs, fmt.Sprintf(`apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: %[1]s
+  namespace: %[2]s
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: %[1]s
+%[3]s`, name, ns, subject))
+		}
+	}
+
+	return strings.Join(docs, "\n---\n") + "\n"
+}
@@ -0,0 +1,159 @@
+package discovery
+
+import (
+	"context"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Resource is a custom resource configuring the tools, such as an UpstreamMapping
+type Resource struct {
+	Namespace string
+	Name      string
+
+	// Spec is the spec of the resource as decoded from JSON
+	Spec map[string]interface{}
+}
+
+// The custom resources the tools are configured with, see deploy/crds.yaml
+var (
+	UpstreamMappings = schema.GroupVersionResource{Group: "kube-nginx.io", Version: "v1alpha1", Resource: "upstreammappings"}
+	FirewallPolicies = schema.GroupVersionResource{Group: "kube-linode.io", Version: "v1alpha1", Resource: "firewallpolicies"}
+)
+
+// Resources - The custom resources of the given kind in the given namespaces, or in every namespace when
+// none are given
+func Resources(ctx context.Context, kubeconfig string, gvr schema.GroupVersionResource, namespaces []string) ([]Resource, error) {
+
+	config, err := RESTConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var results []Resource
+
+	for _, ns := range namespaces {
+		list, err := client.Resource(gvr).Namespace(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range list.Items {
+			results = append(results, fromUnstructured(&list.Items[i]))
+		}
+	}
+
+	sortResources(results)
+
+	return results, nil
+}
+
+// WatchResources - Call onChange with the custom resources of the given kind once they are known, and again
+// whenever one in the given namespaces, or in every namespace when none are given, is added, changed or
+// removed.  Cluster scoped resources are watched with no namespaces.  Blocks until the context is done.
+func WatchResources(ctx context.Context, kubeconfig string, gvr schema.GroupVersionResource, namespaces []string, onChange func([]Resource)) error {
+
+	config, err := RESTConfig(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	// Events arrive on the informer goroutines, they are coalesced and handled here
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	}
+
+	var informers []cache.SharedIndexInformer
+	var synced []cache.InformerSynced
+
+	for _, ns := range namespaces {
+		factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 0, ns, nil)
+		informer := factory.ForResource(gvr).Informer()
+
+		informer.AddEventHandler(handler)
+		informers = append(informers, informer)
+		synced = append(synced, informer.HasSynced)
+
+		factory.Start(ctx.Done())
+	}
+
+	log.Info().Msgf("watching %s", gvr.GroupResource())
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return ctx.Err()
+	}
+
+	for {
+		var results []Resource
+		for _, informer := range informers {
+			for _, obj := range informer.GetIndexer().List() {
+				if u, ok := obj.(*unstructured.Unstructured); ok {
+					results = append(results, fromUnstructured(u))
+				}
+			}
+		}
+
+		sortResources(results)
+		onChange(results)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// fromUnstructured - The resource of an object of the dynamic client
+func fromUnstructured(u *unstructured.Unstructured) Resource {
+
+	spec, _ := u.Object["spec"].(map[string]interface{})
+
+	return Resource{Namespace: u.GetNamespace(), Name: u.GetName(), Spec: spec}
+}
+
+// sortResources - Sort the resources by namespace and name
+func sortResources(resources []Resource) {
+
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Namespace != resources[j].Namespace {
+			return resources[i].Namespace < resources[j].Namespace
+		}
+		return resources[i].Name < resources[j].Name
+	})
+}
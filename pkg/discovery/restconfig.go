@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// The settings of every client of the Kubernetes API, so cluster admins can throttle the daemons polling
+// it from many hosts and tell them apart in the audit log and the API priority and fairness metrics
+var (
+	// ClientQPS and ClientBurst limit the requests of the process, shared by all its clients since they are
+	// built anew for every query.  The client-go defaults of 5 and 10 per client when 0.
+	ClientQPS   float32
+	ClientBurst int
+
+	// ClientTimeout bounds every request to the API, no timeout when 0
+	ClientTimeout time.Duration
+
+	// UserAgent is sent with every request, the client-go default when empty
+	UserAgent string
+
+	limiterOnce sync.Once
+	limiter     flowcontrol.RateLimiter
+)
+
+// RESTConfig - The client config of the kubeconfig, or of the in-cluster service account when it is empty,
+// with the client settings applied
+func RESTConfig(kubeconfig string) (*rest.Config, error) {
+
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return withClientSettings(config), nil
+}
+
+// withClientSettings - Apply the rate limits, the timeout and the user agent to a client config
+func withClientSettings(config *rest.Config) *rest.Config {
+
+	if ClientQPS > 0 {
+		limiterOnce.Do(func() {
+			burst := ClientBurst
+			if burst < 1 {
+				burst = 1
+			}
+			limiter = flowcontrol.NewTokenBucketRateLimiter(ClientQPS, burst)
+		})
+
+		config.QPS = ClientQPS
+		config.Burst = ClientBurst
+		config.RateLimiter = limiter
+	}
+
+	if ClientTimeout > 0 {
+		config.Timeout = ClientTimeout
+	}
+
+	if UserAgent != "" {
+		config.UserAgent = UserAgent
+	}
+
+	return config
+}
@@ -0,0 +1,175 @@
+package discovery
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Service is a Kubernetes service reachable on a port of every node
+type Service struct {
+	Namespace   string
+	Name        string
+	Annotations map[string]string
+	NodePorts   []NodePort
+}
+
+// NodePort is a port of a service and the node port it is exposed on
+type NodePort struct {
+	Name     string
+	Port     int
+	NodePort int
+}
+
+// Services - The services exposed on node ports in the given namespaces, or in every namespace when
+// none are given.  Each namespace is listed on its own, so only a Role allowing to list services in
+// those namespaces is needed instead of a ClusterRole.
+func Services(ctx context.Context, kubeconfig string, namespaces []string) ([]Service, error) {
+
+	config, err := RESTConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var results []Service
+
+	for _, ns := range namespaces {
+		services, err := clientset.CoreV1().Services(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, svc := range services.Items {
+			if s, ok := fromService(svc); ok {
+				results = append(results, s)
+			}
+		}
+	}
+
+	sortServices(results)
+
+	log.Info().Msgf("found %d services exposed on node ports", len(results))
+
+	return results, nil
+}
+
+// WatchServices - Call onChange with the services exposed on node ports once they are known, and again
+// whenever a service in the given namespaces, or in every namespace when none are given, is added,
+// changed or removed.  An informer is started per namespace, so only a Role allowing to list and watch
+// services in those namespaces is needed.  Blocks until the context is done.
+func WatchServices(ctx context.Context, kubeconfig string, namespaces []string, onChange func([]Service)) error {
+
+	config, err := RESTConfig(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	// Events arrive on the informer goroutines, they are coalesced and handled here
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	}
+
+	var listers []corelisters.ServiceLister
+	var synced []cache.InformerSynced
+
+	for _, ns := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(ns))
+		informer := factory.Core().V1().Services()
+
+		informer.Informer().AddEventHandler(handler)
+		listers = append(listers, informer.Lister())
+		synced = append(synced, informer.Informer().HasSynced)
+
+		factory.Start(ctx.Done())
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return ctx.Err()
+	}
+
+	for {
+		var results []Service
+		for _, lister := range listers {
+			services, err := lister.List(labels.Everything())
+			if err != nil {
+				return err
+			}
+			for _, svc := range services {
+				if s, ok := fromService(*svc); ok {
+					results = append(results, s)
+				}
+			}
+		}
+
+		sortServices(results)
+		onChange(results)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// sortServices - Sort the services by namespace and name
+func sortServices(services []Service) {
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+}
+
+// fromService - The service when it has node ports
+func fromService(svc corev1.Service) (Service, bool) {
+
+	s := Service{Namespace: svc.Namespace, Name: svc.Name, Annotations: svc.Annotations}
+
+	for _, p := range svc.Spec.Ports {
+		if p.NodePort != 0 {
+			s.NodePorts = append(s.NodePorts, NodePort{Name: p.Name, Port: int(p.Port), NodePort: int(p.NodePort)})
+		}
+	}
+
+	return s, len(s.NodePorts) > 0
+}
@@ -0,0 +1,230 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Static reads the nodes from a local file or an HTTP endpoint, for hosts without access to any API or for
+// node lists written by a script.  The content is either JSON, the output of -output json or a list of its
+// nodes, or text with an address, or a name and an address, on every line.
+//
+// The content is only parsed again when it changes: the endpoint is asked with the ETag of the last answer and
+// the file is checked for a new modification time or size.
+type Static struct {
+	Location string
+
+	// Client fetches the endpoint, a client with a 30 second timeout when nil
+	Client *http.Client
+
+	mu      sync.Mutex
+	version string
+	nodes   []Node
+	fetched bool
+}
+
+// staticNode is a node of the JSON content
+type staticNode struct {
+	Name          string            `json:"name"`
+	IP            string            `json:"ip"`
+	Unschedulable bool              `json:"unschedulable"`
+	Annotations   map[string]string `json:"annotations"`
+	Labels        map[string]string `json:"labels"`
+}
+
+// Nodes - The nodes of the file or endpoint, those of the last read when it did not change
+func (s *Static) Nodes(ctx context.Context) ([]Node, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data []byte
+	var version string
+	var err error
+
+	if strings.HasPrefix(s.Location, "http://") || strings.HasPrefix(s.Location, "https://") {
+		data, version, err = s.fetch(ctx)
+	} else {
+		data, version, err = s.read()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		log.Debug().Msgf("%s did not change", s.Location)
+		return s.nodes, nil
+	}
+
+	nodes, err := parseStatic(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.Location, err)
+	}
+
+	s.nodes = Normalize(nodes)
+	s.version = version
+	s.fetched = true
+
+	log.Info().Msgf("There are %d nodes in %s", len(s.nodes), s.Location)
+
+	return s.nodes, nil
+}
+
+// fetch - The content of the endpoint and its ETag, nil content when the ETag still matches
+func (s *Static) fetch(ctx context.Context) ([]byte, string, error) {
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.Location, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if s.fetched && s.version != "" {
+		req.Header.Set("If-None-Match", s.version)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, s.version, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetching %s: %s", s.Location, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// read - The content of the file and its modification time and size, nil content when they did not change
+func (s *Static) read() ([]byte, string, error) {
+
+	info, err := os.Stat(s.Location)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version := fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	if s.fetched && version == s.version {
+		return nil, version, nil
+	}
+
+	data, err := os.ReadFile(s.Location)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, version, nil
+}
+
+// parseStatic - The nodes of JSON or text content
+func parseStatic(data []byte) ([]Node, error) {
+
+	trimmed := bytes.TrimSpace(data)
+
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return parseStaticJSON(trimmed)
+	}
+
+	var nodes []Node
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+
+		fields := strings.Fields(text)
+
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			fields = []string{fields[0], fields[0]}
+		case 2:
+		default:
+			return nil, fmt.Errorf("line %d is not an address or a name and an address", line)
+		}
+
+		ip := net.ParseIP(fields[1])
+		if ip == nil {
+			return nil, fmt.Errorf("line %d has an invalid address %s", line, fields[1])
+		}
+
+		nodes = append(nodes, Node{Name: fields[0], IP: ip})
+	}
+
+	return nodes, scanner.Err()
+}
+
+// parseStaticJSON - The nodes of the output of -output json, or of a list of its nodes
+func parseStaticJSON(data []byte) ([]Node, error) {
+
+	var listed []staticNode
+
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &listed); err != nil {
+			return nil, err
+		}
+	} else {
+		var output struct {
+			Nodes []staticNode `json:"nodes"`
+		}
+		if err := json.Unmarshal(data, &output); err != nil {
+			return nil, err
+		}
+		listed = output.Nodes
+	}
+
+	var nodes []Node
+
+	for i, n := range listed {
+		ip := net.ParseIP(n.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("node %d has an invalid address %q", i, n.IP)
+		}
+
+		name := n.Name
+		if name == "" {
+			name = n.IP
+		}
+
+		nodes = append(nodes, Node{Name: name, IP: ip, Unschedulable: n.Unschedulable, Annotations: n.Annotations, Labels: n.Labels})
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,290 @@
+// Package linode is a small client for the parts of the Linode API v4 the linode tools use.
+package linode
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultURL is the base URL of the Linode API
+const DefaultURL = "https://api.linode.com/v4"
+
+// Client talks to the Linode API with a personal access token
+type Client struct {
+	Token   string
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient - A client for the Linode API using the given personal access token
+func NewClient(token string) *Client {
+	return &Client{Token: token, BaseURL: DefaultURL, HTTP: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Instance is a Linode
+type Instance struct {
+	ID     int      `json:"id"`
+	Label  string   `json:"label"`
+	Region string   `json:"region"`
+	Status string   `json:"status"`
+	Tags   []string `json:"tags"`
+	IPv4   []string `json:"ipv4"`
+}
+
+// LKEPool is a node pool of an LKE cluster
+type LKEPool struct {
+	ID    int           `json:"id"`
+	Type  string        `json:"type"`
+	Count int           `json:"count"`
+	Nodes []LKEPoolNode `json:"nodes"`
+}
+
+// LKEPoolNode is a node of an LKE node pool
+type LKEPoolNode struct {
+	ID         string `json:"id"`
+	InstanceID int    `json:"instance_id"`
+	Status     string `json:"status"`
+}
+
+// Error is an error returned by the Linode API
+type Error struct {
+	Status int
+	Errors []struct {
+		Field  string `json:"field"`
+		Reason string `json:"reason"`
+	} `json:"errors"`
+}
+
+func (e *Error) Error() string {
+
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("linode api returned status %d", e.Status)
+	}
+
+	if e.Errors[0].Field != "" {
+		return fmt.Sprintf("linode api returned status %d: %s: %s", e.Status, e.Errors[0].Field, e.Errors[0].Reason)
+	}
+
+	return fmt.Sprintf("linode api returned status %d: %s", e.Status, e.Errors[0].Reason)
+}
+
+// Do - Send a request to the API, decoding the JSON response into out when it is not nil
+func (c *Client) Do(ctx context.Context, method string, path string, headers map[string]string, body io.Reader, out interface{}) error {
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{Status: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// list - Fetch every page of a paginated collection, calling add with the raw data of each page
+func (c *Client) list(ctx context.Context, path string, headers map[string]string, add func(json.RawMessage) error) error {
+
+	for page := 1; ; page++ {
+
+		var result struct {
+			Data  json.RawMessage `json:"data"`
+			Page  int             `json:"page"`
+			Pages int             `json:"pages"`
+		}
+
+		query := url.Values{"page": {strconv.Itoa(page)}, "page_size": {"500"}}
+		if err := c.Do(ctx, http.MethodGet, path+"?"+query.Encode(), headers, nil, &result); err != nil {
+			return err
+		}
+
+		if err := add(result.Data); err != nil {
+			return err
+		}
+
+		if result.Page >= result.Pages {
+			return nil
+		}
+	}
+}
+
+// Instance - Fetch a Linode
+func (c *Client) Instance(ctx context.Context, id int) (Instance, error) {
+
+	var instance Instance
+	err := c.Do(ctx, http.MethodGet, fmt.Sprintf("/linode/instances/%d", id), nil, nil, &instance)
+
+	return instance, err
+}
+
+// LKEKubeconfig - Fetch the kubeconfig of an LKE cluster
+func (c *Client) LKEKubeconfig(ctx context.Context, clusterID int) ([]byte, error) {
+
+	var result struct {
+		Kubeconfig string `json:"kubeconfig"`
+	}
+
+	if err := c.Do(ctx, http.MethodGet, fmt.Sprintf("/lke/clusters/%d/kubeconfig", clusterID), nil, nil, &result); err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(result.Kubeconfig)
+}
+
+// LKEPools - Fetch the node pools of an LKE cluster
+func (c *Client) LKEPools(ctx context.Context, clusterID int) ([]LKEPool, error) {
+
+	var pools []LKEPool
+
+	err := c.list(ctx, fmt.Sprintf("/lke/clusters/%d/pools", clusterID), nil, func(data json.RawMessage) error {
+		var page []LKEPool
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		pools = append(pools, page...)
+		return nil
+	})
+
+	return pools, err
+}
+
+// Instances - Fetch the Linodes matching the filter, for example {"tags": "k8s-worker"}.  A nil
+// filter fetches every Linode on the account.
+func (c *Client) Instances(ctx context.Context, filter map[string]interface{}) ([]Instance, error) {
+
+	var headers map[string]string
+	if filter != nil {
+		data, err := json.Marshal(filter)
+		if err != nil {
+			return nil, err
+		}
+		headers = map[string]string{"X-Filter": string(data)}
+	}
+
+	var instances []Instance
+
+	err := c.list(ctx, "/linode/instances", headers, func(data json.RawMessage) error {
+		var page []Instance
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		instances = append(instances, page...)
+		return nil
+	})
+
+	return instances, err
+}
+
+// Config is a configuration profile of a Linode, holding its network interfaces
+type Config struct {
+	ID         int         `json:"id"`
+	Label      string      `json:"label"`
+	Interfaces []Interface `json:"interfaces"`
+}
+
+// Interface is a network interface of a configuration profile
+type Interface struct {
+	// Purpose is public, vlan or vpc
+	Purpose string `json:"purpose"`
+	Label   string `json:"label"`
+
+	// IPAMAddress is the address of a VLAN interface with its prefix length, such as 10.0.0.1/24
+	IPAMAddress string `json:"ipam_address"`
+
+	// IPv4 holds the address of a VPC interface
+	IPv4 struct {
+		VPC string `json:"vpc"`
+	} `json:"ipv4"`
+}
+
+// InstanceConfigs - Fetch the configuration profiles of a Linode
+func (c *Client) InstanceConfigs(ctx context.Context, id int) ([]Config, error) {
+
+	var configs []Config
+
+	err := c.list(ctx, fmt.Sprintf("/linode/instances/%d/configs", id), nil, func(data json.RawMessage) error {
+		var page []Config
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		configs = append(configs, page...)
+		return nil
+	})
+
+	return configs, err
+}
+
+// FirewallRules are the rules of a Cloud Firewall, the traffic no rule matches gets the policy
+type FirewallRules struct {
+	Inbound        []FirewallRule `json:"inbound"`
+	InboundPolicy  string         `json:"inbound_policy"`
+	Outbound       []FirewallRule `json:"outbound"`
+	OutboundPolicy string         `json:"outbound_policy"`
+}
+
+// FirewallRule is a rule of a Cloud Firewall
+type FirewallRule struct {
+	Action      string            `json:"action"`
+	Protocol    string            `json:"protocol"`
+	Ports       string            `json:"ports,omitempty"`
+	Addresses   FirewallAddresses `json:"addresses"`
+	Label       string            `json:"label,omitempty"`
+	Description string            `json:"description,omitempty"`
+}
+
+// FirewallAddresses are the addresses a rule matches, as CIDRs
+type FirewallAddresses struct {
+	IPv4 []string `json:"ipv4,omitempty"`
+	IPv6 []string `json:"ipv6,omitempty"`
+}
+
+// FirewallRules - Fetch the rules of a Cloud Firewall
+func (c *Client) FirewallRules(ctx context.Context, id int) (FirewallRules, error) {
+
+	var rules FirewallRules
+	err := c.Do(ctx, http.MethodGet, fmt.Sprintf("/networking/firewalls/%d/rules", id), nil, nil, &rules)
+
+	return rules, err
+}
+
+// SetFirewallRules - Replace every rule and the policies of a Cloud Firewall
+func (c *Client) SetFirewallRules(ctx context.Context, id int, rules FirewallRules) error {
+
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	return c.Do(ctx, http.MethodPut, fmt.Sprintf("/networking/firewalls/%d/rules", id), nil, bytes.NewReader(data), nil)
+}
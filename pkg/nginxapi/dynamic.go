@@ -0,0 +1,138 @@
+package nginxapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Dynamic talks to the ngx_dynamic_upstream module, for example http://127.0.0.1:8080/dynamic
+type Dynamic struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewDynamic - A client for the dynamic upstream module at the location it is served on
+func NewDynamic(baseURL string) *Dynamic {
+	return &Dynamic{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: defaultHTTP()}
+}
+
+// do - Send a request with the given query, returning the body of the response
+func (d *Dynamic) do(ctx context.Context, query url.Values) (string, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.BaseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := d.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode >= 300 {
+		return "", &APIError{Status: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+
+	return string(body), nil
+}
+
+// parseServers - Parse the listing of the module, a server directive per line such as
+// "server 10.0.0.1:32016 weight=100 max_fails=1 fail_timeout=10 backup down;"
+func parseServers(listing string) map[string]Server {
+
+	servers := make(map[string]Server)
+
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(line), ";"))
+		if len(fields) < 2 || fields[0] != "server" {
+			continue
+		}
+
+		s := Server{Addr: fields[1], Weight: 1}
+		for _, f := range fields[2:] {
+			switch {
+			case strings.HasPrefix(f, "weight="):
+				s.Weight, _ = strconv.Atoi(strings.TrimPrefix(f, "weight="))
+			case f == "backup":
+				s.Backup = true
+			case f == "down":
+				s.Down = true
+			}
+		}
+
+		servers[s.Addr] = s
+	}
+
+	return servers
+}
+
+// Sync - Add, update and remove servers of the upstream until it has exactly the given servers
+func (d *Dynamic) Sync(ctx context.Context, upstream string, servers []Server) error {
+
+	listing, err := d.do(ctx, url.Values{"upstream": {upstream}, "verbose": {""}})
+	if err != nil {
+		return fmt.Errorf("unable to list the servers of %s: %w", upstream, err)
+	}
+
+	existing := parseServers(listing)
+	wanted := make(map[string]bool)
+
+	for _, s := range servers {
+		wanted[s.Addr] = true
+
+		have, ok := existing[s.Addr]
+
+		// The backup flag can only be given when a server is added
+		if ok && have.Backup != s.Backup {
+			if _, err := d.do(ctx, url.Values{"upstream": {upstream}, "server": {s.Addr}, "remove": {""}}); err != nil {
+				return fmt.Errorf("unable to remove %s from %s: %w", s.Addr, upstream, err)
+			}
+			ok = false
+		}
+
+		if !ok {
+			query := url.Values{"upstream": {upstream}, "server": {s.Addr}, "add": {""}, "weight": {strconv.Itoa(s.Weight)}}
+			if s.Backup {
+				query.Set("backup", "")
+			}
+			if _, err := d.do(ctx, query); err != nil {
+				return fmt.Errorf("unable to add %s to %s: %w", s.Addr, upstream, err)
+			}
+			have = Server{Addr: s.Addr, Weight: s.Weight, Backup: s.Backup}
+		}
+
+		if have.Weight != s.Weight || have.Down != s.Down {
+			query := url.Values{"upstream": {upstream}, "server": {s.Addr}, "weight": {strconv.Itoa(s.Weight)}}
+			if s.Down {
+				query.Set("down", "")
+			} else {
+				query.Set("up", "")
+			}
+			if _, err := d.do(ctx, query); err != nil {
+				return fmt.Errorf("unable to update %s in %s: %w", s.Addr, upstream, err)
+			}
+		}
+	}
+
+	for addr := range existing {
+		if !wanted[addr] {
+			if _, err := d.do(ctx, url.Values{"upstream": {upstream}, "server": {addr}, "remove": {""}}); err != nil {
+				return fmt.Errorf("unable to remove %s from %s: %w", addr, upstream, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+// Package nginxapi changes the servers of nginx upstreams at runtime, without a reload, through the
+// nginx Plus API or the API of the open source ngx_dynamic_upstream module.
+//
+// The upstreams need a shared memory zone in the nginx config for either API to change them.
+package nginxapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Server is a server of an upstream as the APIs see it
+type Server struct {
+	Addr   string
+	Weight int
+	Backup bool
+	Down   bool
+}
+
+// Updater - Replaces the servers of an upstream with the given servers
+type Updater interface {
+	Sync(ctx context.Context, upstream string, servers []Server) error
+}
+
+// APIError is an error status returned by an API
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+
+	if e.Message == "" {
+		return fmt.Sprintf("nginx api returned status %d", e.Status)
+	}
+
+	return fmt.Sprintf("nginx api returned status %d: %s", e.Status, e.Message)
+}
+
+func defaultHTTP() *http.Client {
+	return &http.Client{Timeout: 30 * time.Second}
+}
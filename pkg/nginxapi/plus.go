@@ -0,0 +1,131 @@
+package nginxapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Plus talks to the nginx Plus API, for example http://127.0.0.1:8080/api/8
+type Plus struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewPlus - A client for the nginx Plus API at the given URL, including the API version
+func NewPlus(baseURL string) *Plus {
+	return &Plus{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: defaultHTTP()}
+}
+
+type plusServer struct {
+	ID     int    `json:"id,omitempty"`
+	Server string `json:"server"`
+	Weight int    `json:"weight,omitempty"`
+	Backup bool   `json:"backup"`
+	Down   bool   `json:"down"`
+}
+
+// do - Send a request to the API, decoding the JSON response into out when it is not nil
+func (p *Plus) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Text string `json:"text"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return &APIError{Status: resp.StatusCode, Message: apiErr.Error.Text}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Sync - Add, update and delete servers of the upstream until it has exactly the given servers
+func (p *Plus) Sync(ctx context.Context, upstream string, servers []Server) error {
+
+	path := "/http/upstreams/" + upstream + "/servers"
+
+	var current []plusServer
+	if err := p.do(ctx, http.MethodGet, path, nil, &current); err != nil {
+		return fmt.Errorf("unable to list the servers of %s: %w", upstream, err)
+	}
+
+	existing := make(map[string]plusServer)
+	for _, s := range current {
+		existing[s.Server] = s
+	}
+
+	wanted := make(map[string]bool)
+
+	for _, s := range servers {
+		wanted[s.Addr] = true
+
+		want := plusServer{Server: s.Addr, Weight: s.Weight, Backup: s.Backup, Down: s.Down}
+
+		have, ok := existing[s.Addr]
+
+		// The backup flag can only be given when a server is added
+		if ok && have.Backup != s.Backup {
+			if err := p.do(ctx, http.MethodDelete, fmt.Sprintf("%s/%d", path, have.ID), nil, nil); err != nil {
+				return fmt.Errorf("unable to delete %s from %s: %w", s.Addr, upstream, err)
+			}
+			ok = false
+		}
+
+		switch {
+		case !ok:
+			if err := p.do(ctx, http.MethodPost, path, want, nil); err != nil {
+				return fmt.Errorf("unable to add %s to %s: %w", s.Addr, upstream, err)
+			}
+		case have.Weight != s.Weight || have.Down != s.Down:
+			patch := map[string]interface{}{"weight": s.Weight, "down": s.Down}
+			if err := p.do(ctx, http.MethodPatch, fmt.Sprintf("%s/%d", path, have.ID), patch, nil); err != nil {
+				return fmt.Errorf("unable to update %s in %s: %w", s.Addr, upstream, err)
+			}
+		}
+	}
+
+	for _, s := range current {
+		if !wanted[s.Server] {
+			if err := p.do(ctx, http.MethodDelete, fmt.Sprintf("%s/%d", path, s.ID), nil, nil); err != nil {
+				return fmt.Errorf("unable to delete %s from %s: %w", s.Server, upstream, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package render
+
+import (
+	"net"
+	"strconv"
+)
+
+// EndpointType is the xDS type URL of the cluster load assignments
+const EndpointType = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+
+// ClusterLoadAssignment is the endpoints of an Envoy cluster in the JSON form of the xDS v3 API
+type ClusterLoadAssignment struct {
+	Type        string                `json:"@type"`
+	ClusterName string                `json:"cluster_name"`
+	Endpoints   []LocalityLbEndpoints `json:"endpoints"`
+}
+
+// LocalityLbEndpoints is a priority group of endpoints, backups are in priority 1
+type LocalityLbEndpoints struct {
+	LbEndpoints []LbEndpoint `json:"lb_endpoints"`
+	Priority    int          `json:"priority,omitempty"`
+}
+
+// LbEndpoint is a node as an endpoint of a cluster
+type LbEndpoint struct {
+	Endpoint            Endpoint `json:"endpoint"`
+	HealthStatus        string   `json:"health_status,omitempty"`
+	LoadBalancingWeight int      `json:"load_balancing_weight,omitempty"`
+}
+
+// Endpoint is the address of an endpoint
+type Endpoint struct {
+	Address Address `json:"address"`
+}
+
+// Address wraps the socket address of an endpoint
+type Address struct {
+	SocketAddress SocketAddress `json:"socket_address"`
+}
+
+// SocketAddress is the IP and port of an endpoint
+type SocketAddress struct {
+	Address   string `json:"address"`
+	PortValue int    `json:"port_value"`
+}
+
+// Envoy - Render a cluster load assignment for every upstream with an endpoint per node of its subset, see
+// Subset, followed by the static servers.  Down nodes are marked DRAINING so Envoy stops sending them new
+// requests.  Envoy does not resolve the endpoints, static servers must be given by address.
+func Envoy(upstreams []Upstream, servers []Server) []ClusterLoadAssignment {
+
+	var assignments []ClusterLoadAssignment
+
+	servers = sortServers(servers)
+
+	for _, k := range upstreams {
+
+		primary := LocalityLbEndpoints{LbEndpoints: []LbEndpoint{}}
+		backup := LocalityLbEndpoints{LbEndpoints: []LbEndpoint{}, Priority: 1}
+
+		for _, s := range Subset(InPools(servers, k.Pools), k.Name, k.MaxServers) {
+			e := LbEndpoint{
+				Endpoint:            Endpoint{Address: Address{SocketAddress: SocketAddress{Address: s.IP.String(), PortValue: k.Port}}},
+				LoadBalancingWeight: s.Weight,
+			}
+			if s.Down {
+				e.HealthStatus = "DRAINING"
+			}
+
+			if s.Backup {
+				backup.LbEndpoints = append(backup.LbEndpoints, e)
+			} else {
+				primary.LbEndpoints = append(primary.LbEndpoints, e)
+			}
+		}
+
+		for _, s := range k.Static {
+			host, port, _ := net.SplitHostPort(StaticAddress(s, k.Port))
+			portValue, _ := strconv.Atoi(port)
+
+			e := LbEndpoint{
+				Endpoint:            Endpoint{Address: Address{SocketAddress: SocketAddress{Address: host, PortValue: portValue}}},
+				LoadBalancingWeight: s.Weight,
+			}
+
+			if s.Backup {
+				backup.LbEndpoints = append(backup.LbEndpoints, e)
+			} else {
+				primary.LbEndpoints = append(primary.LbEndpoints, e)
+			}
+		}
+
+		a := ClusterLoadAssignment{Type: EndpointType, ClusterName: k.Name, Endpoints: []LocalityLbEndpoints{primary}}
+		if len(backup.LbEndpoints) > 0 {
+			a.Endpoints = append(a.Endpoints, backup)
+		}
+
+		assignments = append(assignments, a)
+	}
+
+	return assignments
+}
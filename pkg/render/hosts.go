@@ -0,0 +1,36 @@
+package render
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// Host is a name to address mapping in a hosts file
+type Host struct {
+	Name string
+	IP   net.IP
+}
+
+// Hosts - A hosts file entry for every host sorted by name, adding the fully qualified name when
+// a domain is given
+func Hosts(hosts []Host, domain string) []string {
+
+	var totalConfig []string
+
+	sorted := make([]Host, len(hosts))
+	copy(sorted, hosts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	for _, n := range sorted {
+		if domain != "" {
+			totalConfig = append(totalConfig, fmt.Sprintf("%s\t%s.%s %s", n.IP, n.Name, domain, n.Name))
+		} else {
+			totalConfig = append(totalConfig, fmt.Sprintf("%s\t%s", n.IP, n.Name))
+		}
+	}
+
+	return totalConfig
+}
@@ -0,0 +1,210 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// IPTablesMatch narrows the rules of a chain to a direction, interface and local address
+type IPTablesMatch struct {
+	// Direction is in, out or forward.  The addresses are the source of in and forward rules, and
+	// the destination of out rules.  Empty is in.
+	Direction string
+
+	// Interface is matched with -i for in and forward rules, and -o for out rules
+	Interface string
+
+	// OutInterface is the interface forwarded traffic leaves through, matched with -o for forward rules
+	OutInterface string
+
+	// Address is the local address, matched with -d for in and forward rules, and -s for out rules
+	Address string
+
+	// Ports are ports or first:last ranges matched instead of the single port when set
+	Ports []string
+
+	// Protocol is tcp, udp or both, which renders a rule per protocol.  Empty is tcp.
+	Protocol string
+}
+
+// protocols - The protocols the rules of a match are rendered for
+func (m IPTablesMatch) protocols() []string {
+
+	switch m.Protocol {
+	case "udp":
+		return []string{"udp"}
+	case "both":
+		return []string{"tcp", "udp"}
+	default:
+		return []string{"tcp"}
+	}
+}
+
+// outInterface - The match of the interface forwarded traffic leaves through, as ufw route rules have
+func (m IPTablesMatch) outInterface() []string {
+
+	if m.Direction != "forward" || m.OutInterface == "" {
+		return nil
+	}
+
+	return []string{"-o", m.OutInterface}
+}
+
+// IPTablesRules - The rule specs accepting tcp traffic to port from every address, one rule per address
+func IPTablesRules(ips []net.IP, port int) [][]string {
+	return IPTablesRulesMatching(ips, port, IPTablesMatch{})
+}
+
+// IPTablesRulesMatching - The rule specs accepting traffic to port between every address and the local
+// end given by match, one rule per address and protocol.  The address of the node is always the first match.
+func IPTablesRulesMatching(ips []net.IP, port int, match IPTablesMatch) [][]string {
+
+	var addresses []string
+	for _, i := range SortIPs(ips) {
+		addresses = append(addresses, i.String())
+	}
+
+	return addressRules(addresses, port, match, "ACCEPT")
+}
+
+// IPTablesCIDRRules - The rule specs jumping to target, ACCEPT or DROP, for traffic to port between every
+// address or CIDR and the local end given by match, in the order given
+func IPTablesCIDRRules(cidrs []string, port int, match IPTablesMatch, target string) [][]string {
+	return addressRules(cidrs, port, match, target)
+}
+
+// addressRules - The rule specs jumping to target for traffic between every address and the local end
+// given by match, one rule per address and protocol
+func addressRules(addresses []string, port int, match IPTablesMatch, target string) [][]string {
+
+	node, local, iface := "-s", "-d", "-i"
+	if match.Direction == "out" {
+		node, local, iface = "-d", "-s", "-o"
+	}
+
+	var rules [][]string
+
+	for _, address := range addresses {
+		for _, proto := range match.protocols() {
+			//-s 1.2.3.4 -p tcp -m tcp --dport 27017
+			rule := []string{node, address}
+			if match.Address != "" {
+				rule = append(rule, local, match.Address)
+			}
+			if match.Interface != "" {
+				rule = append(rule, iface, match.Interface)
+			}
+			rule = append(rule, match.outInterface()...)
+			rule = append(rule, "-p", proto)
+			rule = append(rule, dports(proto, port, match.Ports)...)
+			rule = append(rule, "-j", target)
+
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// IPTablesSetRules - The rule specs jumping to target, ACCEPT or DROP, for traffic to port between the
+// addresses in an ipset and the local end given by match, one rule per protocol.  The set is matched as
+// the source of in and forward rules, and as the destination of out rules.
+func IPTablesSetRules(set string, port int, match IPTablesMatch, target string) [][]string {
+
+	dir, local, iface := "src", "-d", "-i"
+	if match.Direction == "out" {
+		dir, local, iface = "dst", "-s", "-o"
+	}
+
+	var rules [][]string
+
+	for _, proto := range match.protocols() {
+		rule := []string{"-m", "set", "--match-set", set, dir}
+		if match.Address != "" {
+			rule = append(rule, local, match.Address)
+		}
+		if match.Interface != "" {
+			rule = append(rule, iface, match.Interface)
+		}
+		rule = append(rule, match.outInterface()...)
+		rule = append(rule, "-p", proto)
+		rule = append(rule, dports(proto, port, match.Ports)...)
+		rule = append(rule, "-j", target)
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// IPSetRestore - Replace the members of a set of the given type, hash:ip or hash:net, in the ipset restore
+// format.  The members are loaded into a temporary set that is swapped with the set, so the set is never
+// partially filled.
+func IPSetRestore(set string, kind string, members []string) string {
+
+	tmp := set + "-new"
+
+	var restore bytes.Buffer
+
+	fmt.Fprintf(&restore, "create %s %s -exist\n", set, kind)
+	fmt.Fprintf(&restore, "create %s %s -exist maxelem %d\n", tmp, kind, maxElem(len(members)))
+	fmt.Fprintf(&restore, "flush %s\n", tmp)
+	for _, m := range members {
+		fmt.Fprintf(&restore, "add %s %s\n", tmp, m)
+	}
+	fmt.Fprintf(&restore, "swap %s %s\n", tmp, set)
+	fmt.Fprintf(&restore, "destroy %s\n", tmp)
+
+	return restore.String()
+}
+
+// maxElem - The size of a set holding the members, at least the ipset default of 65536
+func maxElem(members int) int {
+
+	size := 65536
+	for size < members {
+		size *= 2
+	}
+
+	return size
+}
+
+// dports - The match of the destination ports: the protocol match for a single port or range, the
+// multiport match for a list
+func dports(proto string, port int, ports []string) []string {
+
+	switch len(ports) {
+	case 0:
+		return []string{"-m", proto, "--dport", strconv.Itoa(port)}
+	case 1:
+		return []string{"-m", proto, "--dport", ports[0]}
+	default:
+		return []string{"-m", "multiport", "--dports", strings.Join(ports, ",")}
+	}
+}
+
+// IPTablesRestore - The rules of a filter chain in the iptables-restore format, the fields holding spaces,
+// such as comments, quoted
+func IPTablesRestore(chain string, rules [][]string) string {
+
+	var ruleset bytes.Buffer
+
+	fmt.Fprintln(&ruleset, "*filter")
+	fmt.Fprintf(&ruleset, ":%s - [0:0]\n", chain)
+	for _, rule := range rules {
+		var fields []string
+		for _, f := range rule {
+			if strings.ContainsAny(f, " \t\"") {
+				f = strconv.Quote(f)
+			}
+			fields = append(fields, f)
+		}
+		fmt.Fprintf(&ruleset, "-A %s %s\n", chain, strings.Join(fields, " "))
+	}
+	fmt.Fprintln(&ruleset, "COMMIT")
+
+	return ruleset.String()
+}
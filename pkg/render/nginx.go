@@ -0,0 +1,343 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Server is a node rendered as a server directive of every upstream
+type Server struct {
+	IP     net.IP
+	Weight int
+	Backup bool
+	Down   bool
+
+	// Name and Pool are the node name and its node pool, such as the LKE pool id, noted next to the server
+	// directive when the pool is known
+	Name string
+	Pool string
+}
+
+// Static is a server outside the cluster, such as a legacy VM, rendered in an upstream along with the nodes
+type Static struct {
+	// Address is host:port, or a host taking the port of the upstream
+	Address string
+	Weight  int
+	Backup  bool
+}
+
+// TLS are the certificate paths of a server block served over https
+type TLS struct {
+	Certificate string
+	Key         string
+}
+
+// Upstream is an nginx upstream and the server block proxying to it
+type Upstream struct {
+	Name string
+	Port int
+
+	// Zone is the shared memory zone of the upstream, needed to change its servers at runtime
+	Zone string
+
+	// Balance is the load balancing method, such as least_conn, ip_hash or hash $remote_addr consistent,
+	// round robin when empty
+	Balance string
+
+	// Keepalive is the number of idle connections to the servers kept open by each worker, and
+	// KeepaliveRequests the number of requests sent over one of them before it is closed
+	Keepalive         int
+	KeepaliveRequests int
+
+	// SlowStart is the time a server takes to recover its weight after it comes back, for example 30s
+	SlowStart string
+
+	// MaxServers caps the servers of the upstream to a subset of the nodes that is stable across
+	// reconciles, every node when 0
+	MaxServers int
+
+	// Pools keeps the servers of the upstream to the nodes of these node pools, every node when empty
+	Pools []string
+
+	// Static are the servers outside the cluster rendered after the nodes, never left out by MaxServers
+	Static []Static
+
+	// The server block is only rendered for upstreams with server names
+	ServerName []string
+	Listen     string
+
+	// ListenAddresses are the local addresses the server block listens on, every address when empty
+	ListenAddresses []string
+
+	// ProxyBind is the local address the connections to the upstream are made from
+	ProxyBind string
+
+	// TLS is nil when the server block is served over plain http
+	TLS *TLS
+
+	// ACMEWebroot answers the ACME http-01 challenge from this webroot when set
+	ACMEWebroot string
+}
+
+// Nginx - Render an upstream block for every upstream with a server directive per node, followed
+// by the server blocks when serverBlocks is set
+func Nginx(upstreams []Upstream, servers []Server, serverBlocks bool) []string {
+
+	var totalConfig []string
+
+	servers = sortServers(servers)
+
+	for _, k := range upstreams {
+		totalConfig = append(totalConfig, fmt.Sprintf("upstream %s {", k.Name))
+		if k.Balance != "" {
+			totalConfig = append(totalConfig, k.Balance+";")
+		}
+		if k.Zone != "" {
+			totalConfig = append(totalConfig, fmt.Sprintf("zone %s 64k;", k.Zone))
+		}
+		for _, i := range Subset(InPools(servers, k.Pools), k.Name, k.MaxServers) {
+			line := ServerLine(i, k.Port)
+			if k.SlowStart != "" {
+				line = strings.TrimSuffix(line, ";") + " slow_start=" + k.SlowStart + ";"
+			}
+			totalConfig = append(totalConfig, line+poolComment(i))
+		}
+		for _, s := range k.Static {
+			totalConfig = append(totalConfig, StaticLine(s, k.Port))
+		}
+		if k.Keepalive > 0 {
+			totalConfig = append(totalConfig, fmt.Sprintf("keepalive %d;", k.Keepalive))
+		}
+		if k.KeepaliveRequests > 0 {
+			totalConfig = append(totalConfig, fmt.Sprintf("keepalive_requests %d;", k.KeepaliveRequests))
+		}
+		totalConfig = append(totalConfig, "}")
+	}
+
+	if serverBlocks {
+		for _, k := range upstreams {
+			totalConfig = append(totalConfig, ServerBlock(k)...)
+		}
+	}
+
+	return totalConfig
+}
+
+// NginxStream - Render the upstreams for the stream module, proxying TCP or UDP: an upstream block for
+// every upstream with a server directive per node, and a server block proxying the listen port of every
+// upstream with one, such as 27017 or 53 udp
+func NginxStream(upstreams []Upstream, servers []Server) []string {
+
+	var totalConfig []string
+
+	servers = sortServers(servers)
+
+	for _, k := range upstreams {
+		totalConfig = append(totalConfig, fmt.Sprintf("upstream %s {", k.Name))
+		if k.Balance != "" {
+			totalConfig = append(totalConfig, k.Balance+";")
+		}
+		if k.Zone != "" {
+			totalConfig = append(totalConfig, fmt.Sprintf("zone %s 64k;", k.Zone))
+		}
+		for _, i := range Subset(InPools(servers, k.Pools), k.Name, k.MaxServers) {
+			totalConfig = append(totalConfig, ServerLine(i, k.Port)+poolComment(i))
+		}
+		for _, s := range k.Static {
+			totalConfig = append(totalConfig, StaticLine(s, k.Port))
+		}
+		totalConfig = append(totalConfig, "}")
+	}
+
+	for _, k := range upstreams {
+		if k.Listen == "" {
+			continue
+		}
+
+		totalConfig = append(totalConfig, "server {")
+		totalConfig = append(totalConfig, listenLines(k.ListenAddresses, k.Listen)...)
+		totalConfig = append(totalConfig, fmt.Sprintf("proxy_pass %s;", k.Name))
+		if k.ProxyBind != "" {
+			totalConfig = append(totalConfig, fmt.Sprintf("proxy_bind %s;", k.ProxyBind))
+		}
+		totalConfig = append(totalConfig, "}")
+	}
+
+	return totalConfig
+}
+
+// ServerLine - The server directive of a node in an upstream
+func ServerLine(b Server, port int) string {
+
+	line := fmt.Sprintf("server %s:%d weight=%d", b.IP, port, b.Weight)
+	if b.Backup {
+		line += " backup"
+	}
+	if b.Down {
+		line += " down"
+	}
+
+	return line + ";"
+}
+
+// poolComment - A comment naming the node and its pool after the server directive of a node, empty when the
+// pool is not known
+func poolComment(b Server) string {
+
+	if b.Pool == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" # %s pool %s", b.Name, b.Pool)
+}
+
+// InPools - The servers of the node pools, every server when pools is empty
+func InPools(servers []Server, pools []string) []Server {
+
+	if len(pools) == 0 {
+		return servers
+	}
+
+	wanted := make(map[string]bool)
+	for _, p := range pools {
+		wanted[p] = true
+	}
+
+	var kept []Server
+	for _, s := range servers {
+		if wanted[s.Pool] {
+			kept = append(kept, s)
+		}
+	}
+
+	return kept
+}
+
+// StaticLine - The server directive of a static server in an upstream
+func StaticLine(s Static, port int) string {
+
+	line := fmt.Sprintf("server %s weight=%d", StaticAddress(s, port), s.Weight)
+	if s.Backup {
+		line += " backup"
+	}
+
+	return line + ";"
+}
+
+// StaticAddress - The host:port of a static server, with the port of the upstream when it has none
+func StaticAddress(s Static, port int) string {
+
+	if _, _, err := net.SplitHostPort(s.Address); err == nil {
+		return s.Address
+	}
+
+	return net.JoinHostPort(s.Address, fmt.Sprint(port))
+}
+
+// ServerBlock - The server block that proxies the server names of an upstream to it.  Upstreams
+// without a server_name do not get a server block.
+func ServerBlock(k Upstream) []string {
+
+	var server []string
+
+	if len(k.ServerName) == 0 {
+		return server
+	}
+
+	names := strings.Join(k.ServerName, " ")
+
+	listen := k.Listen
+	if listen == "" {
+		listen = "80"
+		if k.TLS != nil {
+			listen = "443 ssl"
+		}
+	}
+
+	// Send plain http to https when the upstream is served over TLS
+	if k.TLS != nil && k.Listen == "" {
+		server = append(server, "server {")
+		server = append(server, listenLines(k.ListenAddresses, "80")...)
+		server = append(server, fmt.Sprintf("server_name %s;", names))
+		if k.ACMEWebroot != "" {
+			server = append(server, acmeChallenge(k.ACMEWebroot)...)
+		}
+		server = append(server, "location / {")
+		server = append(server, "return 301 https://$host$request_uri;")
+		server = append(server, "}")
+		server = append(server, "}")
+	}
+
+	server = append(server, "server {")
+	server = append(server, listenLines(k.ListenAddresses, listen)...)
+	server = append(server, fmt.Sprintf("server_name %s;", names))
+	if k.TLS != nil {
+		server = append(server, fmt.Sprintf("ssl_certificate %s;", k.TLS.Certificate))
+		server = append(server, fmt.Sprintf("ssl_certificate_key %s;", k.TLS.Key))
+	} else if k.ACMEWebroot != "" {
+		// Certificate is still pending, answer the challenge over plain http
+		server = append(server, acmeChallenge(k.ACMEWebroot)...)
+	}
+	server = append(server, "location / {")
+	server = append(server, fmt.Sprintf("proxy_pass http://%s;", k.Name))
+	if k.Keepalive > 0 {
+		// Connections to the servers are only kept open with http/1.1 and without the close header
+		server = append(server, "proxy_http_version 1.1;")
+		server = append(server, "proxy_set_header Connection \"\";")
+	}
+	if k.ProxyBind != "" {
+		server = append(server, fmt.Sprintf("proxy_bind %s;", k.ProxyBind))
+	}
+	server = append(server, "proxy_set_header Host $host;")
+	server = append(server, "proxy_set_header X-Real-IP $remote_addr;")
+	server = append(server, "proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;")
+	server = append(server, "proxy_set_header X-Forwarded-Proto $scheme;")
+	server = append(server, "}")
+	server = append(server, "}")
+
+	return server
+}
+
+// listenLines - A listen directive for the port and parameters on every address, or on the port
+// alone when there are no addresses
+func listenLines(addresses []string, listen string) []string {
+
+	if len(addresses) == 0 {
+		return []string{fmt.Sprintf("listen %s;", listen)}
+	}
+
+	var lines []string
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+			address = "[" + address + "]"
+		}
+		lines = append(lines, fmt.Sprintf("listen %s:%s;", address, listen))
+	}
+
+	return lines
+}
+
+// acmeChallenge - Location answering the ACME http-01 challenge from the certbot webroot
+func acmeChallenge(webroot string) []string {
+	return []string{
+		"location /.well-known/acme-challenge/ {",
+		fmt.Sprintf("root %s;", webroot),
+		"}",
+	}
+}
+
+// sortServers - A copy of the servers sorted by address
+func sortServers(servers []Server) []Server {
+
+	sorted := make([]Server, len(servers))
+	copy(sorted, servers)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].IP.To16(), sorted[j].IP.To16()) < 0
+	})
+
+	return sorted
+}
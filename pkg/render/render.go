@@ -0,0 +1,23 @@
+// Package render turns the discovered nodes into the configuration of the services the linode
+// tools manage.  The output only depends on the set of nodes: addresses are sorted before they
+// are rendered, so the order the API returns the nodes in never causes a rewrite or reload.
+package render
+
+import (
+	"bytes"
+	"net"
+	"sort"
+)
+
+// SortIPs - A sorted copy of the addresses
+func SortIPs(ips []net.IP) []net.IP {
+
+	sorted := make([]net.IP, len(ips))
+	copy(sorted, ips)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].To16(), sorted[j].To16()) < 0
+	})
+
+	return sorted
+}
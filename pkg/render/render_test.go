@@ -0,0 +1,420 @@
+package render
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// update rewrites the golden files with the current output: go test ./pkg/render -update
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// golden - Compare the output with testdata/<name>.golden, or write it there with -update
+func golden(t *testing.T, name string, lines []string) {
+
+	t.Helper()
+
+	got := strings.Join(lines, "\n") + "\n"
+	path := filepath.Join("testdata", name+".golden")
+
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run go test -update to create it", err)
+	}
+
+	if got != string(want) {
+		t.Errorf("%s differs from %s, run go test -update if the change is intended:\n%s", name, path, got)
+	}
+}
+
+// servers - The nodes of the tests, in no particular order so the output is checked to be sorted
+func servers() []Server {
+	return []Server{
+		{IP: net.ParseIP("10.0.0.3"), Weight: 1, Name: "node-3", Pool: "2"},
+		{IP: net.ParseIP("10.0.0.1"), Weight: 1, Name: "node-1", Pool: "1"},
+		{IP: net.ParseIP("10.0.0.10"), Weight: 1, Backup: true, Name: "node-10", Pool: "1"},
+		{IP: net.ParseIP("10.0.0.2"), Weight: 2, Name: "node-2", Pool: "2"},
+		{IP: net.ParseIP("10.0.0.4"), Weight: 1, Down: true, Name: "node-4", Pool: "1"},
+	}
+}
+
+// reversed - The servers in reverse order
+func reversed(servers []Server) []Server {
+
+	r := make([]Server, len(servers))
+	for i, s := range servers {
+		r[len(servers)-1-i] = s
+	}
+
+	return r
+}
+
+func TestNginx(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		upstreams    []Upstream
+		serverBlocks bool
+	}{
+		{
+			name:      "nginx_upstream",
+			upstreams: []Upstream{{Name: "web", Port: 30080}},
+		},
+		{
+			name: "nginx_options",
+			upstreams: []Upstream{{
+				Name: "web", Port: 30080, Zone: "web", Balance: "least_conn", Keepalive: 16, KeepaliveRequests: 100,
+				SlowStart: "30s", Static: []Static{{Address: "192.168.1.5", Weight: 1, Backup: true}, {Address: "192.168.1.6:8080", Weight: 3}},
+			}},
+		},
+		{
+			name:      "nginx_pools",
+			upstreams: []Upstream{{Name: "pool1", Port: 30080, Pools: []string{"1"}}, {Name: "subset", Port: 30081, MaxServers: 2}},
+		},
+		{
+			name:         "nginx_server_blocks",
+			serverBlocks: true,
+			upstreams: []Upstream{
+				{Name: "plain", Port: 30080, ServerName: []string{"example.com", "www.example.com"}, Keepalive: 8, ProxyBind: "10.1.0.1"},
+				{Name: "tls", Port: 30443, ServerName: []string{"secure.example.com"}, TLS: &TLS{Certificate: "/etc/ssl/secure.pem", Key: "/etc/ssl/secure.key"}, ACMEWebroot: "/var/www/acme"},
+				{Name: "pending", Port: 30444, ServerName: []string{"new.example.com"}, ACMEWebroot: "/var/www/acme", ListenAddresses: []string{"192.0.2.1", "2001:db8::1"}},
+				{Name: "internal", Port: 30081},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			lines := Nginx(tt.upstreams, servers(), tt.serverBlocks)
+
+			if strings.Join(Nginx(tt.upstreams, reversed(servers()), tt.serverBlocks), "\n") != strings.Join(lines, "\n") {
+				t.Error("the output depends on the order of the servers")
+			}
+
+			golden(t, tt.name, lines)
+		})
+	}
+}
+
+func TestNginxStream(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		upstreams []Upstream
+	}{
+		{
+			name:      "stream_upstream",
+			upstreams: []Upstream{{Name: "mongo", Port: 32017}},
+		},
+		{
+			name: "stream_listen",
+			upstreams: []Upstream{
+				{Name: "mongo", Port: 32017, Listen: "27017", Balance: "hash $remote_addr consistent", ProxyBind: "10.1.0.1"},
+				{Name: "dns", Port: 30053, Listen: "53 udp", ListenAddresses: []string{"192.0.2.1"}, Static: []Static{{Address: "192.168.1.53", Weight: 1}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			lines := NginxStream(tt.upstreams, servers())
+
+			if strings.Join(NginxStream(tt.upstreams, reversed(servers())), "\n") != strings.Join(lines, "\n") {
+				t.Error("the output depends on the order of the servers")
+			}
+
+			golden(t, tt.name, lines)
+		})
+	}
+}
+
+func TestIPTables(t *testing.T) {
+
+	ips := []net.IP{net.ParseIP("10.0.0.3"), net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}
+
+	tests := []struct {
+		name  string
+		rules [][]string
+	}{
+		{
+			name:  "iptables_rules",
+			rules: IPTablesRules(ips, 27017),
+		},
+		{
+			name:  "iptables_match",
+			rules: IPTablesRulesMatching(ips, 0, IPTablesMatch{Direction: "out", Interface: "eth1", Address: "10.1.0.1", Ports: []string{"80", "443", "8000:8100"}, Protocol: "both"}),
+		},
+		{
+			name:  "iptables_forward",
+			rules: IPTablesRulesMatching(ips, 5432, IPTablesMatch{Direction: "forward", Interface: "eth0", OutInterface: "wg0", Ports: []string{"5432:5433"}}),
+		},
+		{
+			name:  "iptables_cidrs",
+			rules: IPTablesCIDRRules([]string{"192.168.0.0/16", "10.0.0.5"}, 22, IPTablesMatch{Protocol: "udp"}, "DROP"),
+		},
+		{
+			name:  "iptables_set",
+			rules: IPTablesSetRules("nodes", 27017, IPTablesMatch{Interface: "eth1", Address: "10.1.0.1", Protocol: "both"}, "ACCEPT"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := append(tt.rules, []string{"-m", "comment", "--comment", "added by \"hand\"", "-j", "RETURN"})
+			golden(t, tt.name, strings.Split(strings.TrimSuffix(IPTablesRestore("MONGO", rules), "\n"), "\n"))
+		})
+	}
+
+	t.Run("iptables_sorted", func(t *testing.T) {
+		reversed := []net.IP{ips[2], ips[1], ips[0]}
+		if IPTablesRestore("MONGO", IPTablesRules(reversed, 27017)) != IPTablesRestore("MONGO", IPTablesRules(ips, 27017)) {
+			t.Error("the output depends on the order of the addresses")
+		}
+	})
+
+	t.Run("ipset_restore", func(t *testing.T) {
+		golden(t, "ipset_restore", strings.Split(strings.TrimSuffix(IPSetRestore("nodes", "hash:ip", []string{"10.0.0.1", "10.0.0.2"}), "\n"), "\n"))
+	})
+}
+
+func TestHosts(t *testing.T) {
+
+	hosts := []Host{
+		{Name: "node-b", IP: net.ParseIP("10.0.0.2")},
+		{Name: "node-a", IP: net.ParseIP("10.0.0.1")},
+		{Name: "node-c", IP: net.ParseIP("2001:db8::3")},
+	}
+
+	tests := []struct {
+		name   string
+		domain string
+	}{
+		{name: "hosts"},
+		{name: "hosts_domain", domain: "cluster.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			golden(t, tt.name, Hosts(hosts, tt.domain))
+		})
+	}
+}
+
+func TestTraefik(t *testing.T) {
+
+	upstreams := []Upstream{
+		{Name: "plain", Port: 30080, ServerName: []string{"example.com", "www.example.com"}, Static: []Static{{Address: "192.168.1.5", Backup: true}, {Address: "192.168.1.6:8080"}}},
+		{Name: "tls", Port: 30443, ServerName: []string{"secure.example.com"}, TLS: &TLS{Certificate: "/etc/ssl/secure.pem", Key: "/etc/ssl/secure.key"}},
+		{Name: "pool2", Port: 30081, Pools: []string{"2"}},
+	}
+
+	backupOnly := []Server{
+		{IP: net.ParseIP("10.0.0.2"), Weight: 1, Backup: true},
+		{IP: net.ParseIP("10.0.0.1"), Weight: 1, Down: true},
+	}
+
+	tests := []struct {
+		name    string
+		servers []Server
+		routers bool
+	}{
+		{name: "traefik_services", servers: servers()},
+		{name: "traefik_routers", servers: servers(), routers: true},
+		{name: "traefik_backup_only", servers: backupOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			lines := Traefik(upstreams, tt.servers, tt.routers)
+
+			if strings.Join(Traefik(upstreams, reversed(tt.servers), tt.routers), "\n") != strings.Join(lines, "\n") {
+				t.Error("the output depends on the order of the servers")
+			}
+
+			golden(t, tt.name, lines)
+		})
+	}
+}
+
+func TestVarnish(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		upstreams []Upstream
+	}{
+		{
+			name:      "varnish_round_robin",
+			upstreams: []Upstream{{Name: "web", Port: 30080}},
+		},
+		{
+			name: "varnish_random",
+			upstreams: []Upstream{
+				{Name: "web", Port: 30080, Balance: "random", Static: []Static{{Address: "192.168.1.5", Weight: 1, Backup: true}}},
+				{Name: "pool2", Port: 30081, Pools: []string{"2"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			lines := Varnish(tt.upstreams, servers())
+
+			if strings.Join(Varnish(tt.upstreams, reversed(servers())), "\n") != strings.Join(lines, "\n") {
+				t.Error("the output depends on the order of the servers")
+			}
+
+			golden(t, tt.name, lines)
+		})
+	}
+}
+
+// TestSubset checks the subset of an upstream does not depend on the order of the nodes, and that a node
+// joining or leaving moves at most one server in or out of it
+func TestSubset(t *testing.T) {
+
+	var nodes []Server
+	for i := 1; i <= 20; i++ {
+		nodes = append(nodes, Server{IP: net.IPv4(10, 0, 0, byte(i)), Weight: 1})
+	}
+
+	addresses := func(servers []Server) map[string]bool {
+		set := make(map[string]bool)
+		for _, s := range servers {
+			set[s.IP.String()] = true
+		}
+		return set
+	}
+
+	moved := func(before, after map[string]bool) int {
+		n := 0
+		for ip := range after {
+			if !before[ip] {
+				n++
+			}
+		}
+		return n
+	}
+
+	subset := Subset(nodes, "web", 5)
+	if len(subset) != 5 {
+		t.Fatalf("%d servers in the subset, expected 5", len(subset))
+	}
+	if n := moved(addresses(subset), addresses(Subset(reversed(nodes), "web", 5))); n != 0 {
+		t.Error("the subset depends on the order of the nodes")
+	}
+	if other := addresses(Subset(nodes, "api", 5)); moved(addresses(subset), other) == 0 {
+		t.Error("two upstreams have the same subset, the nodes are not spread over the upstreams")
+	}
+
+	before := addresses(subset)
+
+	joined := Subset(append(nodes, Server{IP: net.ParseIP("10.0.0.21"), Weight: 1}), "web", 5)
+	if n := moved(before, addresses(joined)); n > 1 {
+		t.Errorf("a node joining moved %d servers into the subset, expected at most 1", n)
+	}
+
+	// The node leaving is one of the subset, it is replaced by exactly one other node
+	var left []Server
+	for _, s := range nodes {
+		if !s.IP.Equal(subset[0].IP) {
+			left = append(left, s)
+		}
+	}
+	after := addresses(Subset(left, "web", 5))
+	if len(after) != 5 || moved(before, after) != 1 {
+		t.Errorf("a node of the subset leaving moved %d servers into the subset, expected 1", moved(before, after))
+	}
+
+	// A node outside the subset leaving changes nothing
+	var outside []Server
+	for i, s := range nodes {
+		if !before[s.IP.String()] {
+			outside = append(append([]Server{}, nodes[:i]...), nodes[i+1:]...)
+			break
+		}
+	}
+	if n := moved(before, addresses(Subset(outside, "web", 5))); n != 0 {
+		t.Errorf("a node outside the subset leaving moved %d servers into the subset, expected none", n)
+	}
+
+	if got := Subset(nodes, "web", 0); len(got) != len(nodes) {
+		t.Errorf("%d servers without max_servers, expected every node", len(got))
+	}
+}
+
+// TestMaxServers checks every output caps its servers to the same subset of the nodes
+func TestMaxServers(t *testing.T) {
+
+	var nodes []Server
+	for i := 1; i <= 10; i++ {
+		nodes = append(nodes, Server{IP: net.IPv4(10, 0, 0, byte(i)), Weight: 1})
+	}
+
+	upstreams := []Upstream{{Name: "web", Port: 30080, MaxServers: 3}}
+
+	want := make(map[string]bool)
+	for _, s := range Subset(nodes, "web", 3) {
+		want[fmt.Sprintf("%s:30080", s.IP)] = true
+	}
+
+	check := func(output string, got []string) {
+		if len(got) != 3 {
+			t.Errorf("%s has %d servers, expected 3", output, len(got))
+		}
+		for _, addr := range got {
+			if !want[addr] {
+				t.Errorf("%s has %s, which is not in the subset", output, addr)
+			}
+		}
+	}
+
+	var envoy []string
+	for _, e := range Envoy(upstreams, nodes)[0].Endpoints[0].LbEndpoints {
+		envoy = append(envoy, fmt.Sprintf("%s:%d", e.Endpoint.Address.SocketAddress.Address, e.Endpoint.Address.SocketAddress.PortValue))
+	}
+	check("envoy", envoy)
+
+	var traefik []string
+	for _, line := range Traefik(upstreams, nodes, false) {
+		if strings.Contains(line, "url:") {
+			traefik = append(traefik, strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(line), `- url: "http://`), `"`))
+		}
+	}
+	check("traefik", traefik)
+}
+
+// TestEnvoyStatic checks the static servers are endpoints of the cluster, the backup ones at priority 1
+func TestEnvoyStatic(t *testing.T) {
+
+	upstreams := []Upstream{{Name: "shop", Port: 30080, Static: []Static{{Address: "192.168.1.5", Weight: 100}, {Address: "192.168.1.6:8080", Weight: 50, Backup: true}}}}
+
+	a := Envoy(upstreams, servers())[0]
+	if len(a.Endpoints) != 2 {
+		t.Fatalf("%d priorities, expected the primary and the backup endpoints", len(a.Endpoints))
+	}
+
+	primary := a.Endpoints[0].LbEndpoints
+	if last := primary[len(primary)-1]; last.Endpoint.Address.SocketAddress != (SocketAddress{Address: "192.168.1.5", PortValue: 30080}) || last.LoadBalancingWeight != 100 {
+		t.Errorf("the last primary endpoint is %+v, expected the static server 192.168.1.5:30080", last)
+	}
+
+	backup := a.Endpoints[1].LbEndpoints
+	if last := backup[len(backup)-1]; last.Endpoint.Address.SocketAddress != (SocketAddress{Address: "192.168.1.6", PortValue: 8080}) || last.LoadBalancingWeight != 50 {
+		t.Errorf("the last backup endpoint is %+v, expected the static server 192.168.1.6:8080", last)
+	}
+}
@@ -0,0 +1,39 @@
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+// Subset - At most max of the servers, chosen by rendezvous hashing on the key, such as the upstream
+// name.  The choice only depends on the key and the addresses, so it is the same on every reconcile and
+// a node joining or leaving only moves that node in or out.  Every server is returned when max is 0.
+func Subset(servers []Server, key string, max int) []Server {
+
+	if max <= 0 || len(servers) <= max {
+		return servers
+	}
+
+	type scored struct {
+		server Server
+		score  uint64
+	}
+
+	ranked := make([]scored, len(servers))
+	for i, s := range servers {
+		sum := sha256.Sum256([]byte(key + "/" + s.IP.String()))
+		ranked[i] = scored{server: s, score: binary.BigEndian.Uint64(sum[:8])}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+
+	var subset []Server
+	for _, r := range ranked[:max] {
+		subset = append(subset, r.server)
+	}
+
+	return sortServers(subset)
+}
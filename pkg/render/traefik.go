@@ -0,0 +1,101 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Active - The servers for proxies without backup or down servers: down servers are left out and
+// backup servers are only used when there is no other server
+func Active(servers []Server) []Server {
+
+	var primary, backup []Server
+	for _, s := range sortServers(servers) {
+		switch {
+		case s.Down:
+		case s.Backup:
+			backup = append(backup, s)
+		default:
+			primary = append(primary, s)
+		}
+	}
+
+	if len(primary) == 0 {
+		return backup
+	}
+
+	return primary
+}
+
+// Traefik - Render a Traefik dynamic configuration for the file provider with a service per upstream
+// and a server per node of its subset, see Subset and Active.  With routers set, a router is rendered for every upstream with
+// server names.
+func Traefik(upstreams []Upstream, servers []Server, routers bool) []string {
+
+	config := []string{"http:"}
+
+	if routers {
+		var lines []string
+		for _, k := range upstreams {
+			if len(k.ServerName) == 0 {
+				continue
+			}
+
+			var hosts []string
+			for _, name := range k.ServerName {
+				hosts = append(hosts, fmt.Sprintf("Host(`%s`)", name))
+			}
+
+			lines = append(lines,
+				fmt.Sprintf("    %s:", k.Name),
+				fmt.Sprintf("      rule: %q", strings.Join(hosts, " || ")),
+				fmt.Sprintf("      service: %s", k.Name),
+			)
+			if k.TLS != nil {
+				lines = append(lines, "      tls: {}")
+			}
+		}
+
+		if len(lines) > 0 {
+			config = append(config, "  routers:")
+			config = append(config, lines...)
+		}
+	}
+
+	config = append(config, "  services:")
+	for _, k := range upstreams {
+		config = append(config,
+			fmt.Sprintf("    %s:", k.Name),
+			"      loadBalancer:",
+			"        servers:",
+		)
+		for _, s := range Active(Subset(InPools(servers, k.Pools), k.Name, k.MaxServers)) {
+			config = append(config, fmt.Sprintf("          - url: \"http://%s:%d\"", s.IP, k.Port))
+		}
+		// Traefik has no backup servers, only the primary static servers are balanced to
+		for _, s := range k.Static {
+			if !s.Backup {
+				config = append(config, fmt.Sprintf("          - url: \"http://%s\"", StaticAddress(s, k.Port)))
+			}
+		}
+	}
+
+	if routers {
+		var certificates []string
+		for _, k := range upstreams {
+			if len(k.ServerName) > 0 && k.TLS != nil && k.TLS.Certificate != "" {
+				certificates = append(certificates,
+					fmt.Sprintf("    - certFile: %s", k.TLS.Certificate),
+					fmt.Sprintf("      keyFile: %s", k.TLS.Key),
+				)
+			}
+		}
+
+		if len(certificates) > 0 {
+			config = append(config, "tls:", "  certificates:")
+			config = append(config, certificates...)
+		}
+	}
+
+	return config
+}
@@ -0,0 +1,118 @@
+package render
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Varnish - Render a VCL file to include from the main VCL with a backend per node and a director per
+// upstream named after it, for example set req.backend_hint = shop.backend().  The director is a round
+// robin, or a weighted random one with the random balance.  Backup servers are only used when every
+// other server is sick, through a fallback director, and down servers are left out.
+func Varnish(upstreams []Upstream, servers []Server) []string {
+
+	servers = sortServers(servers)
+
+	config := []string{"import directors;", ""}
+	var init []string
+
+	for _, k := range upstreams {
+		var primary, backup []string
+
+		for _, s := range Subset(InPools(servers, k.Pools), k.Name, k.MaxServers) {
+			if s.Down {
+				continue
+			}
+
+			name := varnishName(k.Name + "_" + s.IP.String())
+			config = append(config, varnishBackend(name, s.IP.String(), fmt.Sprint(k.Port))...)
+
+			if s.Backup {
+				backup = append(backup, varnishAdd(name, s.Weight, k.Balance))
+			} else {
+				primary = append(primary, varnishAdd(name, s.Weight, k.Balance))
+			}
+		}
+
+		for i, s := range k.Static {
+			host, port, _ := net.SplitHostPort(StaticAddress(s, k.Port))
+
+			name := varnishName(fmt.Sprintf("%s_static_%d", k.Name, i))
+			config = append(config, varnishBackend(name, host, port)...)
+
+			if s.Backup {
+				backup = append(backup, varnishAdd(name, s.Weight, k.Balance))
+			} else {
+				primary = append(primary, varnishAdd(name, s.Weight, k.Balance))
+			}
+		}
+
+		if len(backup) == 0 {
+			init = append(init, varnishDirector(k.Name, k.Balance, primary)...)
+			continue
+		}
+
+		// The primary servers first, the backup servers once none of them is healthy
+		var fallback []string
+		if len(primary) > 0 {
+			init = append(init, varnishDirector(k.Name+"_primary", k.Balance, primary)...)
+			fallback = append(fallback, k.Name+"_primary.backend()")
+		}
+		init = append(init, varnishDirector(k.Name+"_backup", k.Balance, backup)...)
+		fallback = append(fallback, k.Name+"_backup.backend()")
+
+		init = append(init, fmt.Sprintf("    new %s = directors.fallback();", k.Name))
+		for _, b := range fallback {
+			init = append(init, fmt.Sprintf("    %s.add_backend(%s);", k.Name, b))
+		}
+	}
+
+	config = append(config, "sub vcl_init {")
+	config = append(config, init...)
+	config = append(config, "}")
+
+	return config
+}
+
+// varnishBackend - The backend definition of a server
+func varnishBackend(name string, host string, port string) []string {
+	return []string{
+		fmt.Sprintf("backend %s {", name),
+		fmt.Sprintf("    .host = %q;", host),
+		fmt.Sprintf("    .port = %q;", port),
+		"}",
+		"",
+	}
+}
+
+// varnishAdd - The argument of add_backend for a backend, with its weight for the random director
+func varnishAdd(name string, weight int, balance string) string {
+
+	if balance == "random" {
+		return fmt.Sprintf("%s, %d", name, weight)
+	}
+
+	return name
+}
+
+// varnishDirector - The lines of vcl_init creating a director with the backends
+func varnishDirector(name string, balance string, backends []string) []string {
+
+	director := "round_robin"
+	if balance == "random" {
+		director = "random"
+	}
+
+	lines := []string{fmt.Sprintf("    new %s = directors.%s();", name, director)}
+	for _, b := range backends {
+		lines = append(lines, fmt.Sprintf("    %s.add_backend(%s);", name, b))
+	}
+
+	return lines
+}
+
+// varnishName - A VCL identifier for a backend, the dots and colons of the address replaced
+func varnishName(name string) string {
+	return strings.NewReplacer(".", "_", ":", "_").Replace(name)
+}
@@ -0,0 +1,51 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Peer is a WireGuard peer
+type Peer struct {
+	// Comment is written above the peer, usually the node name
+	Comment    string
+	PublicKey  string
+	Endpoint   string
+	AllowedIPs []string
+
+	// PersistentKeepalive is the keepalive interval in seconds, off when 0
+	PersistentKeepalive int
+}
+
+// WireGuard - A [Peer] section for every peer sorted by public key, in the wg-quick configuration format
+func WireGuard(peers []Peer) []string {
+
+	var totalConfig []string
+
+	sorted := make([]Peer, len(peers))
+	copy(sorted, peers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].PublicKey < sorted[j].PublicKey
+	})
+
+	for i, p := range sorted {
+		if i > 0 {
+			totalConfig = append(totalConfig, "")
+		}
+		totalConfig = append(totalConfig, "[Peer]")
+		if p.Comment != "" {
+			totalConfig = append(totalConfig, "# "+p.Comment)
+		}
+		totalConfig = append(totalConfig, "PublicKey = "+p.PublicKey)
+		if p.Endpoint != "" {
+			totalConfig = append(totalConfig, "Endpoint = "+p.Endpoint)
+		}
+		totalConfig = append(totalConfig, "AllowedIPs = "+strings.Join(p.AllowedIPs, ", "))
+		if p.PersistentKeepalive > 0 {
+			totalConfig = append(totalConfig, fmt.Sprintf("PersistentKeepalive = %d", p.PersistentKeepalive))
+		}
+	}
+
+	return totalConfig
+}
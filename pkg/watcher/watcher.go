@@ -0,0 +1,75 @@
+// Package watcher lets other daemons follow the node changes the linode tools react to.
+//
+//	w := watcher.NewWatcher(watcher.Options{Source: discovery.Kubernetes{Kubeconfig: path}})
+//	err := w.Run(ctx, func(diff watcher.NodeDiff) error {
+//		for _, n := range diff.Added {
+//			...
+//		}
+//		return nil
+//	})
+package watcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// DefaultInterval is how often the source is queried when Options.Interval is not set
+const DefaultInterval = 5 * time.Second
+
+// Options configures a Watcher
+type Options struct {
+	// Source is queried for the nodes, for example discovery.Kubernetes, discovery.LKE or discovery.LinodeTag
+	Source discovery.Source
+
+	// Interval between two queries of the source
+	Interval time.Duration
+
+	// Force calls the callback on the next query even when nothing changed
+	Force <-chan struct{}
+}
+
+// NodeDiff is a change of the nodes.  Nodes holds every current node; Added, Removed and Updated the
+// nodes that changed since the last successful callback, matched by name.
+type NodeDiff struct {
+	Nodes   []discovery.Node
+	Added   []discovery.Node
+	Removed []discovery.Node
+	Updated []discovery.Node
+}
+
+// Empty - Whether no node changed, as for a forced callback
+func (d NodeDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Updated) == 0
+}
+
+// Watcher calls back whenever the nodes of its source change
+type Watcher struct {
+	opts Options
+}
+
+// NewWatcher - A watcher for the given options
+func NewWatcher(opts Options) *Watcher {
+
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+
+	return &Watcher{opts: opts}
+}
+
+// Run - Query the source every interval and call fn with the changes until the context is done.  The
+// first callback has every node as added.  When fn returns an error the same changes, and any made
+// since, are passed again on the next query.
+func (w *Watcher) Run(ctx context.Context, fn func(diff NodeDiff) error) error {
+
+	return discovery.Watch(ctx, w.opts.Source, w.opts.Interval, w.opts.Force, func(oldNodes []discovery.Node, newNodes []discovery.Node) error {
+
+		diff := NodeDiff{Nodes: newNodes}
+		diff.Added, diff.Removed, diff.Updated = discovery.Diff(oldNodes, newNodes)
+
+		return fn(diff)
+	})
+}
@@ -0,0 +1,107 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rsvancara/linode-tools/pkg/discovery"
+)
+
+// scripted returns its node lists in order, then cancels the watch
+type scripted struct {
+	lists  [][]discovery.Node
+	cancel context.CancelFunc
+}
+
+func (s *scripted) Nodes(ctx context.Context) ([]discovery.Node, error) {
+
+	if len(s.lists) == 0 {
+		s.cancel()
+		return nil, errors.New("no more node lists")
+	}
+
+	nodes := s.lists[0]
+	s.lists = s.lists[1:]
+
+	return nodes, nil
+}
+
+// names - The names of nodes
+func names(nodes []discovery.Node) []string {
+
+	var result []string
+	for _, n := range nodes {
+		result = append(result, n.Name)
+	}
+
+	return result
+}
+
+// TestRun checks the callback gets every node as added first, is only called on changes, and is passed the same
+// changes again after it failed
+func TestRun(t *testing.T) {
+
+	node1 := discovery.Node{Name: "node-1", IP: net.ParseIP("10.0.0.1")}
+	node2 := discovery.Node{Name: "node-2", IP: net.ParseIP("10.0.0.2")}
+	moved := discovery.Node{Name: "node-2", IP: net.ParseIP("10.0.0.3")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	source := &scripted{cancel: cancel, lists: [][]discovery.Node{
+		{node1},
+		{node1},
+		{node1, node2},
+		{node1, node2},
+		{moved},
+	}}
+
+	type call struct {
+		added, removed, updated []string
+	}
+
+	var calls []call
+	failed := false
+
+	w := NewWatcher(Options{Source: source, Interval: time.Millisecond})
+	err := w.Run(ctx, func(diff NodeDiff) error {
+
+		calls = append(calls, call{names(diff.Added), names(diff.Removed), names(diff.Updated)})
+
+		// The first change adding node-2 fails, it is passed again on the next query
+		if len(diff.Added) == 1 && diff.Added[0].Name == "node-2" && !failed {
+			failed = true
+			return errors.New("unable to apply")
+		}
+
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run returned %v, expected the cancellation", err)
+	}
+
+	expected := []call{
+		{added: []string{"node-1"}},
+		{added: []string{"node-2"}},
+		{added: []string{"node-2"}},
+		{removed: []string{"node-1"}, updated: []string{"node-2"}},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("called back with %+v, expected %+v", calls, expected)
+	}
+}
+
+// TestEmpty checks a diff without changes is empty
+func TestEmpty(t *testing.T) {
+
+	if !(NodeDiff{Nodes: []discovery.Node{{Name: "node-1"}}}).Empty() {
+		t.Error("a diff with nodes but no changes is not empty")
+	}
+	if (NodeDiff{Removed: []discovery.Node{{Name: "node-1"}}}).Empty() {
+		t.Error("a diff removing a node is empty")
+	}
+}